@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// --- Agents ---
+// An Agent is the coding tool the bot drives: the Claude CLI by default, or any
+// shell command (aider, an in-house agent, a scripted agent for tests).
+//
+// Agent specs (CB_AGENT, CB_REPO_AGENTS):
+//   claude             Claude Code CLI
+//   shell:<cmd>        run <cmd> via sh -c, prompt on stdin
+//   shell-file:<cmd>   run <cmd> via sh -c, prompt in a file ($CB_PROMPT_FILE, or {prompt_file} in <cmd>)
+
+type Agent interface {
+	Run(ctx context.Context, req AgentRequest) error
+}
+
+// AgentRequest is one agent invocation.
+type AgentRequest struct {
+	Prompt   string
+	Dir      string   // working directory (the worktree); "" for one-shot replies
+	MaxTurns int      // 0 = agent default
+	Model    string   // "" = agent default
+	Tools    []string // tools the agent may use; empty = no tools
+	Denied   []string // tools it may not use, even if Tools allows them
+	MCP      string   // MCP server config file; "" = none
+	Args     []string // extra command-line flags
+	Output   io.Writer
+	Sandbox  *sandboxRun // nil = run on the host
+	Usage    *Usage      // if set, filled in by agents that report usage
+}
+
+func parseAgent(spec string) (Agent, error) {
+	kind, cmd, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "", "claude":
+		return claudeAgent{}, nil
+	case "shell", "shell-file":
+		if strings.TrimSpace(cmd) == "" {
+			return nil, fmt.Errorf("agent %q: missing command", spec)
+		}
+		return shellAgent{Command: cmd, PromptFile: kind == "shell-file"}, nil
+	}
+	return nil, fmt.Errorf("unknown agent %q (want claude, shell:<cmd> or shell-file:<cmd>)", spec)
+}
+
+// claudeAgent runs the Claude Code CLI in print mode. Its JSON result record
+// is unpacked: the reply goes to Output and the usage to req.Usage.
+type claudeAgent struct{}
+
+// noToolsDenied is denied when a request allows no tools: without
+// --allowedTools the CLI falls back to its own permission settings, which may
+// allow more.
+var noToolsDenied = []string{"Bash", "Edit", "MultiEdit", "Write", "NotebookEdit", "WebFetch"}
+
+func (claudeAgent) Run(ctx context.Context, req AgentRequest) error {
+	args := []string{"-p", req.Prompt, "--output-format", "json"}
+	denied := req.Denied
+	if len(req.Tools) > 0 {
+		args = append(args, "--allowedTools", strings.Join(req.Tools, ","))
+	} else {
+		denied = append(slices.Clone(denied), noToolsDenied...)
+	}
+	if req.MaxTurns > 0 {
+		args = append(args, "--max-turns", strconv.Itoa(req.MaxTurns))
+	}
+	if req.Model != "" {
+		args = append(args, "--model", req.Model)
+	}
+	if len(denied) > 0 {
+		args = append(args, "--disallowedTools", strings.Join(denied, ","))
+	}
+	if req.MCP != "" {
+		args = append(args, "--mcp-config", req.MCP)
+	}
+	args = append(args, req.Args...)
+
+	cmd := exec.CommandContext(ctx, "claude", args...)
+	cmd.Dir = req.Dir
+	// Clear CLAUDECODE env var so claude doesn't think it's nested
+	cmd.Env = filterEnv(os.Environ(), "CLAUDECODE")
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = req.Output
+	// The CLI keeps its login and session state in ~/.claude and ~/.claude.json
+	home, _ := os.UserHomeDir()
+	var readOnly []string
+	if req.MCP != "" {
+		readOnly = append(readOnly, req.MCP)
+	}
+	cleanup, err := req.Sandbox.wrap(cmd, []string{filepath.Join(home, ".claude"), filepath.Join(home, ".claude.json")}, readOnly)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	err = cmd.Run()
+
+	reply, usage, parseErr := parseClaudeResult(stdout.Bytes())
+	if parseErr != nil {
+		// Killed, or a CLI too old for JSON output: keep whatever it printed
+		req.Output.Write(stdout.Bytes())
+		return err
+	}
+	fmt.Fprintln(req.Output, reply)
+	if req.Usage != nil {
+		*req.Usage = usage
+	}
+	return err
+}
+
+// shellAgent runs an arbitrary command with the worktree as its cwd.
+// The request is also exported as CB_PROMPT_FILE, CB_MAX_TURNS, CB_MODEL,
+// CB_ALLOWED_TOOLS, CB_DISALLOWED_TOOLS, CB_MCP_CONFIG and CB_AGENT_ARGS (each
+// flag shell-quoted, for eval).
+type shellAgent struct {
+	Command    string
+	PromptFile bool // prompt in a file instead of on stdin
+}
+
+func (a shellAgent) Run(ctx context.Context, req AgentRequest) error {
+	f, err := os.CreateTemp("", "claude-bot-prompt-*.md")
+	if err != nil {
+		return fmt.Errorf("creating prompt file: %w", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(req.Prompt); err != nil {
+		f.Close()
+		return fmt.Errorf("writing prompt file: %w", err)
+	}
+	f.Close()
+	promptFile, _ := filepath.Abs(f.Name())
+
+	command := strings.ReplaceAll(a.Command, "{prompt_file}", shellQuote(promptFile))
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Dir = req.Dir
+	cmd.Env = append(filterEnv(os.Environ(), "CLAUDECODE"), req.shellEnv(promptFile)...)
+	if !a.PromptFile {
+		cmd.Stdin = strings.NewReader(req.Prompt)
+	}
+	cmd.Stdout = req.Output
+	cmd.Stderr = req.Output
+	readOnly := []string{promptFile}
+	if req.MCP != "" {
+		readOnly = append(readOnly, req.MCP)
+	}
+	cleanup, err := req.Sandbox.wrap(cmd, nil, readOnly)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	return cmd.Run()
+}
+
+// shellEnv is how a shell agent gets the request, besides the prompt. The
+// sandbox passes these through (see sandboxEnvKeep).
+func (req AgentRequest) shellEnv(promptFile string) []string {
+	return []string{
+		"CB_PROMPT_FILE=" + promptFile,
+		"CB_MAX_TURNS=" + strconv.Itoa(req.MaxTurns),
+		"CB_MODEL=" + req.Model,
+		"CB_ALLOWED_TOOLS=" + strings.Join(req.Tools, ","),
+		"CB_DISALLOWED_TOOLS=" + strings.Join(req.Denied, ","),
+		"CB_MCP_CONFIG=" + req.MCP,
+		"CB_AGENT_ARGS=" + shellJoin(req.Args),
+	}
+}
+
+// shellQuote single-quotes s for sh.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// shellJoin quotes each of args and joins them with spaces.
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = shellQuote(a)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// agentFor returns the agent configured for repo (CB_REPO_AGENTS, then the
+// repo's config file section, then CB_AGENT). Specs are validated in
+// loadConfig, so parse errors fall back to Claude.
+func (cfg Config) agentFor(repo string) Agent {
+	spec := cfg.forRepo(repo).Agent
+	if s, ok := cfg.RepoAgents[repo]; ok {
+		spec = s
+	}
+	a, err := parseAgent(spec)
+	if err != nil {
+		return claudeAgent{}
+	}
+	return a
+}
+
+// usesClaude reports whether any repo runs the Claude CLI.
+func (cfg Config) usesClaude() bool {
+	for _, r := range cfg.Repos {
+		if _, ok := cfg.agentFor(r).(claudeAgent); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// askAgent runs a single-turn, tool-less prompt and returns the agent's reply.
+func askAgent(ctx context.Context, agent Agent, prompt string) (string, error) {
+	var out bytes.Buffer
+	err := agent.Run(ctx, AgentRequest{Prompt: prompt, MaxTurns: 1, Output: &out})
+	return strings.TrimSpace(out.String()), err
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseAgent(t *testing.T) {
+	if a, err := parseAgent("claude"); err != nil || a != (claudeAgent{}) {
+		t.Errorf("claude = %v, %v", a, err)
+	}
+	a, err := parseAgent("shell-file:aider --message-file {prompt_file}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sa := a.(shellAgent); !sa.PromptFile || sa.Command != "aider --message-file {prompt_file}" {
+		t.Errorf("shell-file = %+v", sa)
+	}
+	for _, bad := range []string{"shell:", "codex"} {
+		if _, err := parseAgent(bad); err == nil {
+			t.Errorf("parseAgent(%q) should fail", bad)
+		}
+	}
+}
+
+func TestShellAgentStdin(t *testing.T) {
+	dir := t.TempDir()
+	agent := shellAgent{Command: "cat > prompt.txt && echo done"}
+	var out bytes.Buffer
+	err := agent.Run(context.Background(), AgentRequest{Prompt: "fix the bug", Dir: dir, Output: &out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile(filepath.Join(dir, "prompt.txt"))
+	if string(got) != "fix the bug" {
+		t.Errorf("prompt on stdin = %q", got)
+	}
+	if strings.TrimSpace(out.String()) != "done" {
+		t.Errorf("output = %q", out.String())
+	}
+}
+
+func TestShellAgentPromptFile(t *testing.T) {
+	dir := t.TempDir()
+	agent := shellAgent{Command: `cp {prompt_file} a.txt && cp "$CB_PROMPT_FILE" b.txt && echo "$CB_MAX_TURNS" > turns.txt`, PromptFile: true}
+	if err := agent.Run(context.Background(), AgentRequest{Prompt: "add tests", Dir: dir, MaxTurns: 7, Output: &bytes.Buffer{}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if got, _ := os.ReadFile(filepath.Join(dir, name)); string(got) != "add tests" {
+			t.Errorf("%s = %q", name, got)
+		}
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "turns.txt")); strings.TrimSpace(string(got)) != "7" {
+		t.Errorf("CB_MAX_TURNS = %q", got)
+	}
+}
+
+func TestAgentFor(t *testing.T) {
+	cfg := Config{
+		Repos:      []string{"owner/app", "owner/sandbox"},
+		Agent:      "claude",
+		RepoAgents: map[string]string{"owner/sandbox": "shell:./scripted.sh"},
+	}
+	if _, ok := cfg.agentFor("owner/app").(claudeAgent); !ok {
+		t.Error("owner/app should use claude")
+	}
+	if _, ok := cfg.agentFor("owner/sandbox").(shellAgent); !ok {
+		t.Error("owner/sandbox should use the shell agent")
+	}
+	if !cfg.usesClaude() {
+		t.Error("usesClaude should be true")
+	}
+	cfg.Agent = "shell:true"
+	if cfg.usesClaude() {
+		t.Error("usesClaude should be false with no claude repos")
+	}
+}
+
+func TestClaudeAgentArgs(t *testing.T) {
+	// A stand-in claude that records its arguments
+	bin := t.TempDir()
+	script := "#!/bin/sh\nprintf '%s\\n' \"$@\" > \"$ARGS_FILE\"\necho '{\"type\":\"result\",\"result\":\"ok\"}'\n"
+	if err := os.WriteFile(filepath.Join(bin, "claude"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	argsFile := filepath.Join(t.TempDir(), "args")
+	t.Setenv("ARGS_FILE", argsFile)
+
+	err := claudeAgent{}.Run(context.Background(), AgentRequest{
+		Prompt: "fix it", Dir: t.TempDir(), Output: &bytes.Buffer{},
+		Tools:  []string{"Read", "WebFetch"},
+		Denied: []string{"Bash(git push:*)"},
+		MCP:    "/etc/claude-bot/mcp.json",
+		Args:   []string{"--permission-mode", "acceptEdits"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile(argsFile)
+	for _, want := range []string{
+		"--allowedTools\nRead,WebFetch\n",
+		"--disallowedTools\nBash(git push:*)\n",
+		"--mcp-config\n/etc/claude-bot/mcp.json\n",
+		"--permission-mode\nacceptEdits\n",
+	} {
+		if !strings.Contains(string(got), want) {
+			t.Errorf("args missing %q:\n%s", want, got)
+		}
+	}
+
+	// No tools means none, whatever the CLI's own settings allow
+	err = claudeAgent{}.Run(context.Background(), AgentRequest{Prompt: "reply", Output: &bytes.Buffer{}, Denied: []string{"WebSearch"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ = os.ReadFile(argsFile)
+	if strings.Contains(string(got), "--allowedTools") || !strings.Contains(string(got), "--disallowedTools\nWebSearch,Bash,Edit,MultiEdit,Write,NotebookEdit,WebFetch\n") {
+		t.Errorf("args without tools:\n%s", got)
+	}
+}
+
+func TestShellAgentEnv(t *testing.T) {
+	dir := t.TempDir()
+	agent := shellAgent{Command: `eval "set -- $CB_AGENT_ARGS"; printf '%s|' "$CB_DISALLOWED_TOOLS" "$CB_MCP_CONFIG" "$#" "$2" > env.txt`}
+	req := AgentRequest{Dir: dir, Output: &bytes.Buffer{}, Denied: []string{"WebFetch"}, MCP: "mcp.json", Args: []string{"--note", "it's fine"}}
+	if err := agent.Run(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "env.txt")); string(got) != "WebFetch|mcp.json|2|it's fine|" {
+		t.Errorf("env = %q", got)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// --- Best of N ---
+// With CB_ATTEMPTS=N (attempts per repo) the agent takes N independent runs
+// at an issue, side by side in worktrees next to the job's own
+// (issue-42-fix-login-a, -b, ...). Each candidate is committed on a local
+// branch that's never pushed, then checked with the repo's checks (see
+// verify.go) and scored: the fewest failing build and test commands, then the
+// fewest failing lint commands, then the smallest diff. Breaking
+// forbidden_paths or max_diff_lines outweighs all of that. The winner's
+// changes are squashed into the job's worktree and go on to repairs, commit
+// and PR like a single run's; the PR body lists the alternatives that lost.
+
+// maxAttempts caps CB_ATTEMPTS; candidates are named a, b, c, ...
+const maxAttempts = 10
+
+// candidate is one of the agent's runs at an issue.
+type candidate struct {
+	Name    string // "a", "b", ...
+	Branch  string
+	Dir     string
+	Usage   *Usage
+	Err     error        // the run failed
+	Changes []fileChange // empty if it changed nothing
+	Steps   []verifyStep // the checks, if there were any to run
+	RuleErr error        // what it breaks of forbidden_paths and max_diff_lines
+}
+
+func (c candidate) diffLines() int {
+	n := 0
+	for _, f := range c.Changes {
+		n += f.Added + f.Deleted
+	}
+	return n
+}
+
+// failures counts c's failing checks, lint commands apart.
+func (c candidate) failures(lint []string) (checks, lints int) {
+	for _, s := range failedSteps(c.Steps) {
+		if slices.Contains(lint, s.Cmd) {
+			lints++
+		} else {
+			checks++
+		}
+	}
+	return checks, lints
+}
+
+// rankCandidates sorts cs best first. Runs that failed or changed nothing go
+// last; ties keep the order the candidates were started in.
+func rankCandidates(cs []candidate, lint []string) {
+	unusable := func(c candidate) bool { return c.Err != nil || len(c.Changes) == 0 }
+	slices.SortStableFunc(cs, func(a, b candidate) int {
+		if d := compareBool(unusable(a), unusable(b)); d != 0 {
+			return d
+		}
+		if d := compareBool(a.Err != nil, b.Err != nil); d != 0 {
+			return d
+		}
+		if d := compareBool(a.RuleErr != nil, b.RuleErr != nil); d != 0 {
+			return d
+		}
+		ac, al := a.failures(lint)
+		bc, bl := b.failures(lint)
+		return cmp.Or(cmp.Compare(ac, bc), cmp.Compare(al, bl), cmp.Compare(a.diffLines(), b.diffLines()))
+	})
+}
+
+// compareBool orders false before true.
+func compareBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	}
+	return -1
+}
+
+// bestOf runs cfg.Attempts candidates for issue from the same prompt and
+// squashes the best one's changes into wtDir, uncommitted. It returns the
+// candidates best first, with their worktrees gone. If none of them changed
+// anything wtDir is left as it was; if they all failed, so does bestOf.
+func bestOf(ctx context.Context, cfg Config, settings repoSettings, t *tracker, attemptID string, issue Issue, repoDir, wtDir, branch, base, prompt, logFile string) ([]candidate, error) {
+	lg := logger(ctx)
+	cs := make([]candidate, min(cfg.Attempts, maxAttempts))
+	defer func() {
+		cleanupCtx := context.WithoutCancel(ctx)
+		for _, c := range cs {
+			if c.Dir != "" {
+				cleanupWorktree(cleanupCtx, repoDir, c.Dir, c.Branch)
+			}
+		}
+	}()
+
+	// One at a time, so the worktree adds don't contend for git's locks.
+	// Leftovers from an interrupted job are started over.
+	for i := range cs {
+		name := string(rune('a' + i))
+		cs[i] = candidate{Name: name, Branch: branch + "-" + name, Dir: wtDir + "-" + name}
+		cleanupWorktree(ctx, repoDir, cs[i].Dir, cs[i].Branch)
+		if err := ensureWorktree(ctx, repoDir, cs[i].Dir, cs[i].Branch, base); err != nil {
+			return nil, fmt.Errorf("creating worktree for attempt %s: %w", name, err)
+		}
+	}
+
+	lg.Info("running attempts", "attempts", len(cs))
+	logBase := strings.TrimSuffix(logFile, ".log")
+	var wg sync.WaitGroup
+	for i := range cs {
+		wg.Go(func() {
+			runCandidate(ctx, cfg, settings, t, attemptID, issue, base, prompt, logBase, &cs[i])
+		})
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	rankCandidates(cs, settings.Lint)
+	best := cs[0]
+	switch {
+	case best.Err != nil:
+		return nil, fmt.Errorf("all %d attempts failed; attempt %s: %w", len(cs), best.Name, best.Err)
+	case len(best.Changes) == 0:
+		lg.Info("no attempt changed anything")
+		return cs, nil
+	}
+	lg.Info("picked attempt", "attempt", best.Name, "lines", best.diffLines(), "failed_checks", len(failedSteps(best.Steps)))
+	if _, err := run(ctx, wtDir, "git", "merge", "--squash", best.Branch); err != nil {
+		return nil, fmt.Errorf("taking attempt %s's changes: %w", best.Name, err)
+	}
+	return cs, nil
+}
+
+// runCandidate runs the agent in c's worktree, commits what it changed and
+// scores it. Logs go to <issue log>-<name>.log and -<name>-verify.log.
+func runCandidate(ctx context.Context, cfg Config, settings repoSettings, t *tracker, attemptID string, issue Issue, base, prompt, logBase string, c *candidate) {
+	lg := logger(ctx).With("attempt", c.Name)
+	c.Usage, c.Err = runAgent(ctx, cfg, settings, issue, prompt, c.Dir, logBase+"-"+c.Name+".log")
+	// A failed run still cost something
+	if c.Usage != nil {
+		_ = t.addUsage(attemptID, *c.Usage)
+	}
+	if c.Err != nil {
+		lg.Warn("attempt failed", "err", c.Err)
+		return
+	}
+	if c.Err = commitChanges(ctx, c.Dir, prTitle(issue)); c.Err != nil {
+		return
+	}
+	if c.Changes, c.Err = branchChanges(ctx, c.Dir, base); c.Err != nil || len(c.Changes) == 0 {
+		lg.Info("attempt made no changes", "err", c.Err)
+		return
+	}
+	c.RuleErr = settings.checkChangesAllowed(c.Changes)
+
+	if cmds := verifyCommands(settings, c.Dir); cfg.Verify && len(cmds) > 0 {
+		var w io.Writer = io.Discard
+		if f, err := os.Create(logBase + "-" + c.Name + "-verify.log"); err == nil {
+			defer f.Close()
+			w = f
+		}
+		c.Steps = runChecks(ctx, cfg, cmds, c.Dir, w)
+	}
+	lg.Info("attempt finished", "lines", c.diffLines(), "failed_checks", len(failedSteps(c.Steps)))
+}
+
+// alternativesMarkdown describes the candidates that lost to cs[0], for the
+// PR body. It's empty unless there were any.
+func alternativesMarkdown(cs []candidate) string {
+	if len(cs) < 2 {
+		return ""
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "## Alternatives\nThe agent made %d attempts at this issue; this PR is attempt %s (%s). The others:\n",
+		len(cs), cs[0].Name, cs[0].outcome())
+	for _, c := range cs[1:] {
+		fmt.Fprintf(&b, "- attempt %s: %s", c.Name, c.outcome())
+		if c.Usage != nil {
+			fmt.Fprintf(&b, ", $%.2f", c.Usage.CostUSD)
+		}
+		b.WriteString("\n")
+	}
+	return b.String() + "\n"
+}
+
+// outcome sums up how c did, e.g.
+// "+40 -3 lines in 2 files, 1 of 3 checks failed: `go test ./...`".
+func (c candidate) outcome() string {
+	if c.Err != nil {
+		msg, _, _ := strings.Cut(c.Err.Error(), "\n")
+		return "failed: " + msg
+	}
+	if len(c.Changes) == 0 {
+		return "no changes"
+	}
+	added, deleted := 0, 0
+	for _, f := range c.Changes {
+		added += f.Added
+		deleted += f.Deleted
+	}
+	s := fmt.Sprintf("+%d -%d lines in %d file(s)", added, deleted, len(c.Changes))
+	if c.RuleErr != nil {
+		msg, _, _ := strings.Cut(c.RuleErr.Error(), "\n")
+		s += ", " + msg
+	}
+	failed := failedSteps(c.Steps)
+	switch {
+	case len(c.Steps) == 0:
+	case len(failed) == 0:
+		s += ", checks passed"
+	default:
+		var cmds []string
+		for _, f := range failed {
+			cmds = append(cmds, "`"+f.Cmd+"`")
+		}
+		s += fmt.Sprintf(", %d of %d checks failed: %s", len(failed), len(c.Steps), strings.Join(cmds, ", "))
+	}
+	return s
+}
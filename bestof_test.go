@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRankCandidates(t *testing.T) {
+	failing := []verifyStep{{Cmd: "go test ./..."}, {Cmd: "golangci-lint run", Err: errors.New("exit status 1")}}
+	changes := func(lines int) []fileChange { return []fileChange{{Path: "x.go", Added: lines}} }
+	cs := []candidate{
+		{Name: "a", Err: errors.New("agent timed out")},
+		{Name: "b"}, // no changes
+		{Name: "c", Changes: changes(5), Steps: []verifyStep{{Cmd: "go test ./...", Err: errors.New("exit status 1")}}},
+		{Name: "d", Changes: changes(50), Steps: failing},
+		{Name: "e", Changes: changes(80)},
+		{Name: "f", Changes: changes(10)},
+		{Name: "g", Changes: changes(1), RuleErr: errors.New("changes touch .github/ci.yml")},
+	}
+	rankCandidates(cs, []string{"golangci-lint run"})
+	var got []string
+	for _, c := range cs {
+		got = append(got, c.Name)
+	}
+	if strings.Join(got, "") != "fedcgba" {
+		t.Errorf("ranked %q", got)
+	}
+}
+
+func TestBestOf(t *testing.T) {
+	ctx := context.Background()
+	clone := gitRepo(t, map[string]string{"code.txt": "old\n"})
+	issue := Issue{Repo: "owner/repo", Number: 9, Title: "Fix code"}
+	settings := repoSettings{Test: stringList{"grep -q fixed code.txt"}}
+	// Each attempt does something different, by its worktree's name
+	cfg := Config{AgentTimeout: time.Minute, VerifyTimeout: time.Minute, Verify: true, Attempts: 4, Agent: `shell:case "$(pwd)" in
+*-a) echo broken > code.txt ;;
+*-b) echo fixed > code.txt ;;
+*-c) printf 'fixed\nand more\n' > code.txt ;;
+esac`}
+
+	setup := func(t *testing.T) (string, string, *tracker, string) {
+		t.Helper()
+		wtDir := filepath.Join(t.TempDir(), "issue-9-fix-code")
+		if err := ensureWorktree(ctx, clone, wtDir, "issue-9-fix-code", "main"); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { cleanupWorktree(ctx, clone, wtDir, "issue-9-fix-code") })
+		tr := newTracker()
+		a, _ := tr.begin(Attempt{Key: issue.key()})
+		return wtDir, filepath.Join(t.TempDir(), "owner-repo-9.log"), tr, a.ID
+	}
+
+	t.Run("picks the best", func(t *testing.T) {
+		wtDir, logFile, tr, id := setup(t)
+		cs, err := bestOf(ctx, cfg, settings, tr, id, issue, clone, wtDir, "issue-9-fix-code", "main", "fix it", logFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var order []string
+		for _, c := range cs {
+			order = append(order, c.Name)
+		}
+		if strings.Join(order, "") != "bcad" {
+			t.Fatalf("candidates ranked %q", order)
+		}
+		// The winner's changes are in the job's worktree, not committed yet
+		if data, _ := os.ReadFile(filepath.Join(wtDir, "code.txt")); string(data) != "fixed\n" {
+			t.Errorf("code.txt = %q", data)
+		}
+		if changed, _ := checkChanges(ctx, wtDir); !changed {
+			t.Error("no changes in the worktree")
+		}
+		// The candidates are gone
+		for _, c := range cs {
+			if _, err := os.Stat(c.Dir); !os.IsNotExist(err) {
+				t.Errorf("%s still there", c.Dir)
+			}
+			if _, err := run(ctx, clone, "git", "rev-parse", "--verify", "refs/heads/"+c.Branch); err == nil {
+				t.Errorf("branch %s still there", c.Branch)
+			}
+		}
+		if _, err := os.Stat(strings.TrimSuffix(logFile, ".log") + "-a-verify.log"); err != nil {
+			t.Errorf("attempt log: %v", err)
+		}
+
+		md := alternativesMarkdown(cs)
+		for _, want := range []string{
+			"this PR is attempt b (+1 -1 lines in 1 file(s), checks passed)",
+			"- attempt c: +2 -1 lines in 1 file(s), checks passed\n",
+			"- attempt a: +1 -1 lines in 1 file(s), 1 of 1 checks failed: `grep -q fixed code.txt`\n",
+			"- attempt d: no changes\n",
+		} {
+			if !strings.Contains(md, want) {
+				t.Errorf("alternatives missing %q:\n%s", want, md)
+			}
+		}
+	})
+
+	t.Run("all failed", func(t *testing.T) {
+		wtDir, logFile, tr, id := setup(t)
+		cfg := cfg
+		cfg.Attempts, cfg.Agent = 2, "shell:exit 3"
+		_, err := bestOf(ctx, cfg, settings, tr, id, issue, clone, wtDir, "issue-9-fix-code", "main", "fix it", logFile)
+		if err == nil || !strings.Contains(err.Error(), "all 2 attempts failed; attempt a:") {
+			t.Errorf("err = %v", err)
+		}
+	})
+}
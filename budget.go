@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// --- Budgets ---
+// Spend caps per UTC day, using the cost the agent reported (see usage.go)
+// for attempts started that day:
+//
+//	CB_DAILY_BUDGET_USD    all repos together
+//	daily_budget_usd       one repo ([repo."owner/repo"] section of the config file)
+//	CB_ISSUE_BUDGET_USD    one issue (issue_budget_usd in the config file, per repo too)
+//
+// Once a budget is used up, poll stops queueing the issues it covers and
+// workers don't start ones already queued; running jobs finish. Each
+// deferred issue gets one comment per window and keeps its label, so it's
+// picked up again when the next window opens.
+
+// budgetWindow returns the UTC day containing now.
+func budgetWindow(now time.Time) (start, end time.Time) {
+	y, m, d := now.UTC().Date()
+	start = time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 0, 1)
+}
+
+// spend sums the reported cost of attempts started since since that match.
+func (t *tracker) spend(since time.Time, match func(Attempt) bool) float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	total := 0.0
+	for _, a := range t.attempts {
+		if a.Usage != nil && !a.Start.Before(since) && match(*a) {
+			total += a.Usage.CostUSD
+		}
+	}
+	return total
+}
+
+// overBudget returns why issue can't run now, or "" if every budget
+// covering it has room left. cfg is the issue's repo config.
+func overBudget(cfg Config, t *tracker, issue Issue, now time.Time) string {
+	start, _ := budgetWindow(now)
+	for _, b := range []struct {
+		limit float64
+		scope string
+		match func(Attempt) bool
+	}{
+		{cfg.DailyBudgetUSD, "daily budget for all repos", func(Attempt) bool { return true }},
+		{cfg.RepoBudgetUSD, "daily budget for " + issue.Repo, func(a Attempt) bool { return a.Repo == issue.Repo }},
+		{cfg.IssueBudgetUSD, "daily budget for this issue", func(a Attempt) bool { return a.Key == issue.key() }},
+	} {
+		if b.limit <= 0 {
+			continue
+		}
+		if spent := t.spend(start, b.match); spent >= b.limit {
+			return fmt.Sprintf("the %s ($%.2f) is used up: $%.2f spent today", b.scope, b.limit, spent)
+		}
+	}
+	return ""
+}
+
+// noteDeferred records that key was deferred until resume and reports
+// whether that's news (the first time this window).
+func (t *tracker) noteDeferred(key string, resume time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.deferred[key].Equal(resume) {
+		return false
+	}
+	t.deferred[key] = resume
+	return true
+}
+
+// deferIssue tells the issue why it's waiting, once per budget window.
+func deferIssue(ctx context.Context, t *tracker, issue Issue, reason string, now time.Time) {
+	_, resume := budgetWindow(now)
+	if !t.noteDeferred(issue.key(), resume) {
+		return
+	}
+	logger(ctx).Warn("deferred issue: over budget", "key", issue.key(), "reason", reason, "resume", resume)
+	jobsTotal.inc(issue.Repo, "deferred")
+	// The resume time makes the comment unique per window, so a restart doesn't repeat it
+	until := resume.Format("2006-01-02 15:04 MST")
+	if lastCommentContains(ctx, issue, until) {
+		return
+	}
+	_ = commentOnIssue(ctx, issue, fmt.Sprintf("claude-bot deferred this issue: %s. It will be picked up again after %s.", reason, until))
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBudgetWindow(t *testing.T) {
+	now := time.Date(2026, 3, 14, 23, 30, 0, 0, time.FixedZone("PDT", -7*3600)) // already the 15th in UTC
+	start, end := budgetWindow(now)
+	if !start.Equal(time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC)) || !end.Equal(start.AddDate(0, 0, 1)) {
+		t.Errorf("window = %s – %s", start, end)
+	}
+}
+
+func TestOverBudget(t *testing.T) {
+	tr := newTracker()
+	spend := func(key, repo string, cost float64) {
+		a, _ := tr.begin(Attempt{Key: key, Repo: repo})
+		tr.setUsage(a.ID, Usage{CostUSD: cost})
+		tr.finish(a.ID, resultError, nil, "")
+	}
+	spend("acme/app#1", "acme/app", 3)
+	spend("acme/app#2", "acme/app", 4)
+	spend("acme/lib#9", "acme/lib", 2)
+	// Spend from a previous window doesn't count
+	old, _ := tr.begin(Attempt{Key: "acme/app#1", Repo: "acme/app"})
+	tr.setUsage(old.ID, Usage{CostUSD: 100})
+	tr.byID[old.ID].Start = time.Now().Add(-48 * time.Hour)
+
+	issue := Issue{Repo: "acme/app", Number: 1}
+	now := time.Now()
+	tests := []struct {
+		name string
+		cfg  Config
+		want string // substring of the reason; "" = may run
+	}{
+		{"no budgets", Config{}, ""},
+		{"room left", Config{DailyBudgetUSD: 20, RepoBudgetUSD: 10, IssueBudgetUSD: 5}, ""},
+		{"all repos", Config{DailyBudgetUSD: 9}, "daily budget for all repos ($9.00) is used up: $9.00 spent"},
+		{"this repo", Config{RepoBudgetUSD: 7}, "daily budget for acme/app"},
+		{"this issue", Config{IssueBudgetUSD: 3}, "this issue ($3.00)"},
+	}
+	for _, tt := range tests {
+		got := overBudget(tt.cfg, tr, issue, now)
+		if (tt.want == "") != (got == "") || !strings.Contains(got, tt.want) {
+			t.Errorf("%s: overBudget = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	// Another issue in the same repo still has its own issue budget
+	if got := overBudget(Config{IssueBudgetUSD: 3}, tr, Issue{Repo: "acme/app", Number: 5}, now); got != "" {
+		t.Errorf("fresh issue = %q", got)
+	}
+}
+
+func TestNoteDeferred(t *testing.T) {
+	tr := newTracker()
+	_, today := budgetWindow(time.Now())
+	if !tr.noteDeferred("acme/app#1", today) {
+		t.Error("first deferral should be noted")
+	}
+	if tr.noteDeferred("acme/app#1", today) {
+		t.Error("a second deferral in the same window should be quiet")
+	}
+	if !tr.noteDeferred("acme/app#1", today.AddDate(0, 0, 1)) {
+		t.Error("the next window should be noted again")
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// --- CI ---
+// A new PR isn't done until its checks pass. The worker waits (up to
+// CB_CI_WAIT) for the checks on the PR's head commit; when one fails, the
+// agent gets the failing jobs' logs in the same worktree and its fix is pushed
+// as another commit. After CB_CI_FIX_ROUNDS such rounds the issue is labelled
+// ci-failed instead of done. Repos where no checks show up within ciGrace are
+// taken to have no CI. The worker stays on the job while CI runs, so size
+// CB_WORKERS with that in mind.
+
+// Check states.
+const (
+	checkPending = "pending"
+	checkPassed  = "passed"
+	checkFailed  = "failed"
+)
+
+var (
+	// ciPollInterval is how often a PR's checks are re-read.
+	ciPollInterval = 30 * time.Second
+	// ciGrace is how long a pushed commit may go without any checks before
+	// the repo is taken to have no CI.
+	ciGrace = 2 * time.Minute
+)
+
+// maxCILog caps how much of each failed job's log (its end) goes into the prompt.
+const maxCILog = 16 << 10
+
+// ciFailure is a failed check and the tail of its log, if the forge has one.
+type ciFailure struct {
+	Check
+	Log string
+}
+
+func checksIn(checks []Check, state string) []Check {
+	var out []Check
+	for _, c := range checks {
+		if c.State == state {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// waitForChecks polls the checks on sha until none are pending. It returns
+// no checks if none showed up within ciGrace.
+func waitForChecks(ctx context.Context, cfg Config, repo, sha string) ([]Check, error) {
+	start := time.Now()
+	for {
+		checks, err := forgeFor(repo).Checks(ctx, repo, sha)
+		if err != nil {
+			return nil, fmt.Errorf("reading checks: %w", err)
+		}
+		waited := time.Since(start)
+		switch {
+		case len(checks) == 0 && (waited >= ciGrace || waited >= cfg.CIWait):
+			return nil, nil
+		case len(checks) > 0 && len(checksIn(checks, checkPending)) == 0:
+			return checks, nil
+		case waited >= cfg.CIWait:
+			return nil, fmt.Errorf("checks still running after %s: %s", cfg.CIWait, checkNames(checksIn(checks, checkPending)))
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(ciPollInterval):
+		}
+	}
+}
+
+func checkNames(checks []Check) string {
+	names := make([]string, len(checks))
+	for i, c := range checks {
+		names[i] = c.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// watchCI waits for CI on the PR branch checked out in wtDir and lets the
+// agent fix failures, up to cfg.CIFixRounds times. It returns "" once the
+// checks pass, or why the PR isn't green. The error is only set when ctx ends.
+func watchCI(ctx context.Context, cfg Config, settings repoSettings, t *tracker, attemptID string, issue Issue, wtDir, branch, base, logFile string) (string, error) {
+	lg := logger(ctx)
+	forge := forgeFor(issue.Repo)
+	notGreen := func(format string, args ...any) (string, error) {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf(format, args...), nil
+	}
+
+	for round := 1; ; round++ {
+		sha, err := run(ctx, wtDir, "git", "rev-parse", "HEAD")
+		if err != nil {
+			return notGreen("couldn't read the PR head: %v", err)
+		}
+		sha = strings.TrimSpace(sha)
+		lg.Info("waiting for CI", "sha", sha)
+		checks, err := waitForChecks(ctx, cfg, issue.Repo, sha)
+		if err != nil {
+			return notGreen("%v", err)
+		}
+		failed := checksIn(checks, checkFailed)
+		if len(failed) == 0 {
+			lg.Info("CI passed", "checks", len(checks))
+			return "", nil
+		}
+		lg.Info("CI failed", "checks", checkNames(failed), "round", round)
+		if round > cfg.CIFixRounds {
+			return notGreen("%s still failing after %d fix attempt(s)", checkNames(failed), cfg.CIFixRounds)
+		}
+		if why := overBudget(cfg, t, issue, time.Now()); why != "" {
+			return notGreen("%s failing, and %s", checkNames(failed), why)
+		}
+
+		failures := make([]ciFailure, len(failed))
+		for i, c := range failed {
+			failures[i].Check = c
+			log, err := forge.CheckLog(ctx, issue.Repo, c)
+			if err != nil {
+				lg.Warn("couldn't fetch check log", "check", c.Name, "err", err)
+			}
+			failures[i].Log = tailLog(log, maxCILog)
+		}
+
+		roundLog := strings.TrimSuffix(logFile, ".log") + fmt.Sprintf("-ci%d.log", round)
+		usage, err := runAgent(ctx, cfg, settings, issue, buildCIFixPrompt(issue, branch, failures), wtDir, roundLog)
+		if usage != nil {
+			_ = t.addUsage(attemptID, *usage)
+		}
+		if err != nil {
+			return notGreen("fixing CI: %v", err)
+		}
+		changed, err := checkChanges(ctx, wtDir)
+		if err != nil {
+			return notGreen("fixing CI: %v", err)
+		}
+		if !changed {
+			return notGreen("%s failing, and the agent found nothing to fix", checkNames(failed))
+		}
+		if err := commitChanges(ctx, wtDir, fmt.Sprintf("fix: make CI pass for #%d", issue.Number)); err != nil {
+			return notGreen("committing CI fix: %v", err)
+		}
+		if err := settings.checkBranch(ctx, wtDir, base); err != nil {
+			return notGreen("CI fix rejected: %v", err)
+		}
+		if _, err := run(ctx, wtDir, "git", "push", "origin", branch); err != nil {
+			return notGreen("pushing CI fix: %v", err)
+		}
+		lg.Info("pushed CI fix", "round", round)
+	}
+}
+
+// tailLog keeps the last max bytes of a log, starting at a line boundary.
+// Failures are nearly always reported at the end.
+func tailLog(s string, max int) string {
+	s = strings.TrimSpace(s)
+	if len(s) <= max {
+		return s
+	}
+	s = s[len(s)-max:]
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[i+1:]
+	}
+	return "[... earlier output trimmed ...]\n" + s
+}
+
+// buildCIFixPrompt asks the agent to fix the failing checks on its PR.
+func buildCIFixPrompt(issue Issue, branch string, failures []ciFailure) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "You are working on a codebase. You opened a pull request (branch %s) for issue #%d, and CI failed on it. Fix the failures.\n\n", branch, issue.Number)
+	fmt.Fprintf(&b, "## Issue #%d\n%s\n\n", issue.Number, untrusted(issue.Title, issue.Body))
+
+	b.WriteString("## Failing checks:\n")
+	for _, f := range failures {
+		fmt.Fprintf(&b, "### %s\n", f.Name)
+		if f.Desc != "" {
+			fmt.Fprintf(&b, "%s\n", f.Desc)
+		}
+		if f.URL != "" {
+			fmt.Fprintf(&b, "%s\n", f.URL)
+		}
+		if f.Log != "" {
+			fmt.Fprintf(&b, "```\n%s\n```\n", f.Log)
+		} else {
+			b.WriteString("(no log available — reproduce the check locally)\n")
+		}
+		b.WriteString("\n")
+	}
+
+	b.WriteString(`## Instructions:
+- Read CLAUDE.md in the repo root for project-specific instructions
+- The branch already has your changes for this issue; keep what they fix
+- Find the cause of each failure and fix it with minimal, focused changes
+- Don't disable, skip or loosen checks or tests to make them pass
+- Run the failing checks locally if you can
+- Do NOT commit — just make the file changes
+` + untrustedNote + "\n")
+
+	return b.String()
+}
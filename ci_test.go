@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTailLog(t *testing.T) {
+	if got := tailLog("  short log\n", 100); got != "short log" {
+		t.Errorf("tailLog(short) = %q", got)
+	}
+	long := strings.Repeat("noise noise noise\n", 100) + "FAIL: TestLogin\n"
+	got := tailLog(long, 40)
+	if !strings.HasPrefix(got, "[... earlier output trimmed ...]\n") || !strings.HasSuffix(got, "FAIL: TestLogin") {
+		t.Errorf("tailLog(long) = %q", got)
+	}
+	// Cut at a line boundary, never mid-line
+	if lines := strings.Split(got, "\n"); lines[1] != "noise noise noise" {
+		t.Errorf("tailLog kept a partial line: %q", got)
+	}
+}
+
+// ciForge serves canned checks per commit (and per call, for checks that
+// change while the worker waits).
+type ciForge struct {
+	unknownForge
+	mu     sync.Mutex
+	checks func(sha string, call int) []Check
+	calls  int
+	logs   map[string]string // job id → log
+}
+
+func (f *ciForge) Checks(_ context.Context, _, sha string) ([]Check, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	return f.checks(sha, f.calls), nil
+}
+
+func (f *ciForge) CheckLog(_ context.Context, _ string, c Check) (string, error) {
+	return f.logs[c.JobID], nil
+}
+
+func TestWatchCI(t *testing.T) {
+	oldPoll, oldGrace := ciPollInterval, ciGrace
+	ciPollInterval, ciGrace = time.Millisecond, 50*time.Millisecond
+	t.Cleanup(func() { ciPollInterval, ciGrace = oldPoll, oldGrace })
+
+	ctx := context.Background()
+	repo := "gitea:ci.test/owner/repo"
+	f := &ciForge{logs: map[string]string{"7": "main.go:3: undefined: helper\nFAIL"}}
+	forgesMu.Lock()
+	forges["gitea:ci.test"] = f
+	forgesMu.Unlock()
+	t.Cleanup(func() {
+		forgesMu.Lock()
+		delete(forges, "gitea:ci.test")
+		forgesMu.Unlock()
+	})
+
+	// setup returns a worktree on the PR branch, already pushed
+	setup := func(t *testing.T) (wtDir, origin, sha string) {
+		clone := gitRepo(t, map[string]string{"main.go": "package main\n"})
+		git := func(args ...string) string {
+			t.Helper()
+			out, err := run(ctx, clone, "git", args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return strings.TrimSpace(out)
+		}
+		git("checkout", "-q", "-b", "issue-5-login")
+		os.WriteFile(filepath.Join(clone, "login.go"), []byte("package main\n"), 0644)
+		git("add", "-A")
+		git("commit", "-q", "-m", "fix: resolve #5 — Login")
+		git("push", "-q", "origin", "issue-5-login")
+		return clone, git("remote", "get-url", "origin"), git("rev-parse", "HEAD")
+	}
+	cfg := Config{
+		LogDir:       t.TempDir(),
+		AgentTimeout: time.Minute,
+		CIWait:       time.Minute,
+		CIFixRounds:  2,
+	}
+	issue := Issue{Repo: repo, Number: 5, Title: "Login"}
+	watch := func(t *testing.T, cfg Config, wtDir string) string {
+		t.Helper()
+		tr := newTracker()
+		a, _ := tr.begin(Attempt{Key: issue.key()})
+		reason, err := watchCI(ctx, cfg, repoSettings{}, tr, a.ID, issue, wtDir, "issue-5-login", "main", filepath.Join(cfg.LogDir, "job.log"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return reason
+	}
+
+	t.Run("fixed", func(t *testing.T) {
+		wtDir, origin, first := setup(t)
+		f.checks = func(sha string, call int) []Check {
+			switch {
+			case call == 1:
+				return nil // CI hasn't picked the commit up yet
+			case sha == first && call == 2:
+				return []Check{{Name: "build", State: checkPending}}
+			case sha == first:
+				return []Check{{Name: "build", State: checkFailed, JobID: "7"}, {Name: "lint", State: checkPassed}}
+			}
+			return []Check{{Name: "build", State: checkPassed}, {Name: "lint", State: checkPassed}}
+		}
+		f.calls = 0
+		cfg := cfg
+		cfg.Agent = `shell:grep -q "undefined: helper" && printf 'package main\n\nfunc helper() {}\n' > helper.go`
+
+		if reason := watch(t, cfg, wtDir); reason != "" {
+			t.Fatalf("watchCI = %q, want green", reason)
+		}
+		out, _ := run(ctx, origin, "git", "log", "-1", "--format=%s", "issue-5-login")
+		if got := strings.TrimSpace(out); got != "fix: make CI pass for #5" {
+			t.Errorf("head of PR branch = %q", got)
+		}
+		if _, err := os.Stat(filepath.Join(cfg.LogDir, "job-ci1.log")); err != nil {
+			t.Errorf("fix round log: %v", err)
+		}
+	})
+
+	t.Run("out of rounds", func(t *testing.T) {
+		wtDir, _, _ := setup(t)
+		f.checks = func(string, int) []Check {
+			return []Check{{Name: "build", State: checkFailed, JobID: "7"}}
+		}
+		cfg := cfg
+		cfg.CIFixRounds = 1
+		cfg.Agent = `shell:echo attempt >> notes.txt`
+
+		reason := watch(t, cfg, wtDir)
+		if !strings.Contains(reason, "build still failing after 1 fix attempt(s)") {
+			t.Errorf("watchCI = %q", reason)
+		}
+		out, _ := run(ctx, wtDir, "git", "rev-list", "--count", "main..HEAD")
+		if got := strings.TrimSpace(out); got != "2" {
+			t.Errorf("PR branch has %s commits, want the original plus one fix", got)
+		}
+	})
+
+	t.Run("no CI", func(t *testing.T) {
+		wtDir, _, _ := setup(t)
+		f.checks = func(string, int) []Check { return nil }
+		if reason := watch(t, cfg, wtDir); reason != "" {
+			t.Errorf("a repo without checks should count as green: %q", reason)
+		}
+	})
+
+	t.Run("never finishes", func(t *testing.T) {
+		wtDir, _, _ := setup(t)
+		f.checks = func(string, int) []Check { return []Check{{Name: "e2e", State: checkPending}} }
+		cfg := cfg
+		cfg.CIWait = 20 * time.Millisecond
+		if reason := watch(t, cfg, wtDir); !strings.Contains(reason, "still running after 20ms: e2e") {
+			t.Errorf("watchCI = %q", reason)
+		}
+	})
+}
+
+func TestBuildCIFixPrompt(t *testing.T) {
+	prompt := buildCIFixPrompt(Issue{Number: 5, Title: "Login", Body: "users can't log in"}, "issue-5-login", []ciFailure{
+		{Check: Check{Name: "build", URL: "https://ci/1"}, Log: "undefined: helper"},
+		{Check: Check{Name: "deploy-preview", Desc: "Deploy failed"}},
+	})
+	for _, want := range []string{
+		"issue #5", "issue-5-login", "users can't log in",
+		"### build", "https://ci/1", "undefined: helper",
+		"### deploy-preview", "Deploy failed", "no log available",
+		"Don't disable", "Do NOT commit",
+	} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("prompt missing %q:\n%s", want, prompt)
+		}
+	}
+}
+
+func TestGitHubChecks(t *testing.T) {
+	// A stand-in gh serving check runs, commit statuses and a job log
+	bin := t.TempDir()
+	script := `#!/bin/sh
+case "$*" in
+"api --hostname github.com repos/owner/repo/commits/abc/check-runs?per_page=100")
+  echo '{"check_runs":[
+    {"id":11,"name":"test","status":"completed","conclusion":"failure","html_url":"https://gh/11","app":{"slug":"github-actions"}},
+    {"id":12,"name":"lint","status":"completed","conclusion":"skipped","app":{"slug":"github-actions"}},
+    {"id":13,"name":"codecov","status":"in_progress","app":{"slug":"codecov"}}]}' ;;
+"api --hostname github.com repos/owner/repo/commits/abc/status")
+  echo '{"state":"failure","statuses":[{"context":"ci/jenkins","state":"error","target_url":"https://jenkins/1","description":"Build broke"}]}' ;;
+"api --hostname github.com repos/owner/repo/actions/jobs/11/logs")
+  echo 'FAIL TestLogin' ;;
+*) echo "unexpected: gh $*" >&2; exit 1 ;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(bin, "gh"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	ctx := context.Background()
+	g := &githubForge{}
+	checks, err := g.Checks(ctx, "owner/repo", "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, c := range checks {
+		got = append(got, c.Name+"="+c.State)
+	}
+	if s := strings.Join(got, " "); s != "test=failed lint=passed codecov=pending ci/jenkins=failed" {
+		t.Errorf("checks = %s", s)
+	}
+	if checks[2].JobID != "" || checks[3].Desc != "Build broke" {
+		t.Errorf("checks = %+v", checks)
+	}
+
+	if log, err := g.CheckLog(ctx, "owner/repo", checks[0]); err != nil || strings.TrimSpace(log) != "FAIL TestLogin" {
+		t.Errorf("CheckLog = %q, %v", log, err)
+	}
+	// Statuses from external CI have no log to fetch
+	if log, err := g.CheckLog(ctx, "owner/repo", checks[3]); err != nil || log != "" {
+		t.Errorf("CheckLog(status) = %q, %v", log, err)
+	}
+}
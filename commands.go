@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Slash commands ---
+// Users listed in CB_COMMAND_USERS can steer the bot from issue comments, and
+// from the conversation on the bot's PRs, with lines like "/bot retry":
+//
+//	/bot retry           clear the retry counter and queue the issue again
+//	/bot cancel          stop the running job and take the issue off the queue
+//	/bot plan            queue a run that posts a plan instead of changing code
+//	/bot model <name>    use this model for the issue's runs ("default" to unset)
+//	/bot max-turns <n>   allow the agent n turns on the issue's runs
+//	/bot reset           clear the retry counter and the options set above
+//	/bot approve         approve an issue from outside the org (see policy.go),
+//	                     or the plan posted above it (see plan.go)
+//
+// Each command comment is handled once (the tracker remembers its id) and
+// acknowledged with a 👍, or 😕 if any command in it was invalid. Comments
+// older than commandMaxAge are ignored, so enabling commands doesn't replay
+// old ones. Comments from anyone else are ignored, except that policy.go reads
+// approvals from maintainers wherever they come from.
+
+// commandMaxAge is how old a command comment may be and still be acted on.
+var commandMaxAge = 24 * time.Hour
+
+// botCommand is one "/bot <verb> [arg]" line.
+type botCommand struct {
+	Verb string
+	Arg  string
+}
+
+func (c botCommand) String() string {
+	return strings.TrimSpace("/bot " + c.Verb + " " + c.Arg)
+}
+
+var (
+	commandLine = regexp.MustCompile(`(?m)^[ \t]*/bot[ \t]+(\S+)(?:[ \t]+(\S+))?[ \t]*$`)
+	modelName   = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:\[\]-]*$`)
+)
+
+// parseCommands returns the commands in a comment body, one per line.
+func parseCommands(body string) []botCommand {
+	var cmds []botCommand
+	for _, m := range commandLine.FindAllStringSubmatch(body, -1) {
+		cmds = append(cmds, botCommand{Verb: strings.ToLower(m[1]), Arg: m[2]})
+	}
+	return cmds
+}
+
+// issueOptions are per-issue settings changed with /bot commands.
+type issueOptions struct {
+	Model    string `json:"model,omitempty"`
+	MaxTurns int    `json:"max_turns,omitempty"`
+	Plan     bool   `json:"plan,omitempty"` // the next run posts a plan instead of changes
+}
+
+// withOptions applies an issue's options on top of its repo's config.
+func (cfg Config) withOptions(o issueOptions) Config {
+	if o.Model != "" {
+		cfg.Model = o.Model
+	}
+	if o.MaxTurns > 0 {
+		cfg.MaxTurns = o.MaxTurns
+	}
+	return cfg
+}
+
+// commandAllowed reports whether login may give the bot commands. GitHub
+// logins are case-insensitive.
+func (cfg Config) commandAllowed(login string) bool {
+	return slices.ContainsFunc(cfg.CommandUsers, func(u string) bool { return strings.EqualFold(u, login) })
+}
+
+// commandID identifies a comment across repos; comment ids are per forge.
+func commandID(repo string, c Comment) string {
+	return repo + ":" + c.ID
+}
+
+// pollCommands handles new commands on the repo's open issues.
+func pollCommands(ctx context.Context, cfg Config, repo string, t *tracker) {
+	issues, err := fetchIssues(ctx, repo, "")
+	if err != nil {
+		slog.Error("fetching issues failed", "component", "commands", "repo", repo, "err", err)
+		pollErrors.inc(repo)
+		return
+	}
+	for _, issue := range issues {
+		handleCommands(ctx, cfg, t, issue, issue.Comments)
+	}
+}
+
+// handleCommands applies the new commands among comments to issue. For the
+// bot's PRs, comments is the PR conversation and issue the one it resolves.
+func handleCommands(ctx context.Context, cfg Config, t *tracker, issue Issue, comments []Comment) {
+	for _, c := range comments {
+		if c.ID == "" || isBotComment(c) {
+			continue
+		}
+		cmds := parseCommands(c.Body)
+		if len(cmds) == 0 || t.commandHandled(commandID(issue.Repo, c)) {
+			continue
+		}
+		if at, err := time.Parse(time.RFC3339, c.CreatedAt); err == nil && time.Since(at) > commandMaxAge {
+			continue
+		}
+
+		lg := slog.Default().With("component", "commands", "repo", issue.Repo, "key", issue.key(), "user", c.Author.Login)
+		if !cfg.commandAllowed(c.Author.Login) {
+			if !slices.ContainsFunc(cmds, func(cmd botCommand) bool { return cmd.Verb != "approve" }) {
+				continue // approvals are checked against the policy instead
+			}
+			lg.Warn("ignoring command from unauthorized user", "command", cmds[0].String())
+			_ = t.markCommandHandled(commandID(issue.Repo, c))
+			continue
+		}
+		reaction := reactionOK
+		for _, cmd := range cmds {
+			if err := applyCommand(ctx, cfg, t, issue, cmd); err != nil {
+				lg.Warn("command failed", "command", cmd.String(), "err", err)
+				reaction = reactionConfused
+				continue
+			}
+			lg.Info("applied command", "command", cmd.String())
+		}
+		// Recorded before reacting: a lost reaction beats running a command twice
+		if err := t.markCommandHandled(commandID(issue.Repo, c)); err != nil {
+			lg.Warn("couldn't record command", "err", err)
+		}
+		if err := sourceFor(issue.Repo).React(ctx, issue.Repo, c.ID, reaction); err != nil {
+			lg.Warn("couldn't react to command", "err", err)
+		}
+	}
+}
+
+// applyCommand carries out one command on issue.
+func applyCommand(ctx context.Context, cfg Config, t *tracker, issue Issue, cmd botCommand) error {
+	key := issue.key()
+	opts := t.options(key)
+	switch cmd.Verb {
+	case "retry", "cancel", "plan", "reset", "approve":
+		if cmd.Arg != "" {
+			return fmt.Errorf("%s takes no argument", cmd.Verb)
+		}
+	}
+	switch cmd.Verb {
+	case "retry":
+		return requeueIssue(ctx, cfg, t, issue)
+	case "cancel":
+		t.cancel(key)
+		return removeLabel(ctx, issue, cfg.IssueLabel)
+	case "plan":
+		opts.Plan = true
+		if err := t.setOptions(key, opts); err != nil {
+			return err
+		}
+		return requeueIssue(ctx, cfg, t, issue)
+	case "model":
+		switch {
+		case cmd.Arg == "":
+			return fmt.Errorf("model needs a name")
+		case cmd.Arg == "default":
+			opts.Model = ""
+		case !modelName.MatchString(cmd.Arg):
+			return fmt.Errorf("invalid model name %q", cmd.Arg)
+		default:
+			opts.Model = cmd.Arg
+		}
+		return t.setOptions(key, opts)
+	case "max-turns":
+		n, err := strconv.Atoi(cmd.Arg)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("max-turns needs a positive number, got %q", cmd.Arg)
+		}
+		opts.MaxTurns = n
+		return t.setOptions(key, opts)
+	case "reset":
+		if err := t.resetRetries(key); err != nil {
+			return err
+		}
+		return t.setOptions(key, issueOptions{})
+	case "approve":
+		return nil // read from the comments by the policy and plans when next polled
+	}
+	return fmt.Errorf("unknown command %q", cmd.Verb)
+}
+
+// requeueIssue puts an issue back in the queue with a clean retry counter.
+func requeueIssue(ctx context.Context, cfg Config, t *tracker, issue Issue) error {
+	_ = t.resetRetries(issue.key())
+	for _, l := range []string{cfg.FailedLabel, cfg.CIFailedLabel, cfg.NeedsInfoLabel, cfg.DoneLabel} {
+		_ = removeLabel(ctx, issue, l)
+	}
+	return addLabel(ctx, issue, cfg.IssueLabel)
+}
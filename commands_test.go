@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseCommands(t *testing.T) {
+	tests := []struct {
+		body string
+		want []botCommand
+	}{
+		{"/bot retry", []botCommand{{Verb: "retry"}}},
+		{"Looks wrong.\n\n/bot model opus\n  /bot MAX-TURNS 80  \nthanks", []botCommand{{"model", "opus"}, {"max-turns", "80"}}},
+		{"please run `/bot retry` later", nil},
+		{"/bot", nil},
+		{"/bot model opus extra", nil},
+		{"/botretry", nil},
+	}
+	for _, tt := range tests {
+		if got := parseCommands(tt.body); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseCommands(%q) = %+v, want %+v", tt.body, got, tt.want)
+		}
+	}
+}
+
+// cmdForge records label changes, comments and reactions.
+type cmdForge struct {
+	unknownForge
+	mu        sync.Mutex
+	origin    string
+	issues    []Issue
+	labels    []string // "+name" or "-name", in order
+	comments  []string
+	reactions map[string]string // comment id → reaction
+}
+
+func (f *cmdForge) ListIssues(context.Context, string, string) ([]Issue, error) {
+	return f.issues, nil
+}
+func (f *cmdForge) Comments(context.Context, Issue) ([]Comment, error) { return nil, nil }
+func (f *cmdForge) AddLabel(_ context.Context, _ Issue, label string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.labels = append(f.labels, "+"+label)
+	return nil
+}
+func (f *cmdForge) RemoveLabel(_ context.Context, _ Issue, label string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.labels = append(f.labels, "-"+label)
+	return nil
+}
+func (f *cmdForge) Comment(_ context.Context, _ Issue, body string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.comments = append(f.comments, body)
+	return nil
+}
+func (f *cmdForge) React(_ context.Context, _, id, reaction string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reactions[id] = reaction
+	return nil
+}
+func (f *cmdForge) CloneURL(string) string { return f.origin }
+
+func useForge(t *testing.T, host string, f Forge) {
+	forgesMu.Lock()
+	forges[host] = f
+	forgesMu.Unlock()
+	t.Cleanup(func() {
+		forgesMu.Lock()
+		delete(forges, host)
+		forgesMu.Unlock()
+	})
+}
+
+func TestHandleCommands(t *testing.T) {
+	ctx := context.Background()
+	repo := "gitea:cmd.test/owner/repo"
+	f := &cmdForge{reactions: map[string]string{}}
+	useForge(t, "gitea:cmd.test", f)
+
+	now := time.Now().UTC()
+	at := func(ago time.Duration) string { return now.Add(-ago).Format(time.RFC3339) }
+	cmd := func(id, author, body, createdAt string) Comment {
+		c := comment(author, body, createdAt)
+		c.ID = id
+		return c
+	}
+	issue := Issue{Repo: repo, Number: 3, Comments: []Comment{
+		cmd("1", "alice", "/bot model opus\n/bot max-turns 80", at(time.Hour)),
+		cmd("2", "mallory", "/bot retry", at(time.Hour)),
+		cmd("3", "Alice", "/bot max-turns lots", at(time.Hour)),
+		cmd("4", "alice", "/bot retry", at(48*time.Hour)),
+		cmd("5", "bot", "comment `/bot retry` to go on\n/bot retry\n"+botCommentMarker, at(time.Minute)),
+		cmd("6", "alice", "no commands here", at(time.Minute)),
+	}}
+	f.issues = []Issue{issue}
+	cfg := Config{IssueLabel: "todo", FailedLabel: "failed", CIFailedLabel: "ci-failed", NeedsInfoLabel: "needs-info", DoneLabel: "done", CommandUsers: []string{"alice"}}
+	tr := newTracker()
+
+	pollCommands(ctx, cfg, repo, tr)
+	if want := map[string]string{"1": reactionOK, "3": reactionConfused}; !reflect.DeepEqual(f.reactions, want) {
+		t.Errorf("reactions = %v, want %v", f.reactions, want)
+	}
+	if got := tr.options(issue.key()); got != (issueOptions{Model: "opus", MaxTurns: 80}) {
+		t.Errorf("options = %+v", got)
+	}
+	if len(f.labels) != 0 {
+		t.Errorf("only mallory's and the stale comment asked for a retry, got labels %v", f.labels)
+	}
+	if !tr.commandHandled(commandID(repo, issue.Comments[1])) {
+		t.Error("unauthorized commands should be recorded, so they're only logged once")
+	}
+
+	// Handled comments aren't applied again
+	clear(f.reactions)
+	pollCommands(ctx, cfg, repo, tr)
+	if len(f.reactions) != 0 {
+		t.Errorf("second poll reacted again: %v", f.reactions)
+	}
+
+	// A retry requeues with a clean counter: the old error comment stops counting
+	issue.Comments = append(issue.Comments,
+		comment("claude-bot", "claude-bot encountered an error:\n```\nx\n```", at(time.Minute)),
+		cmd("7", "alice", "/bot retry", at(0)))
+	handleCommands(ctx, cfg, tr, issue, issue.Comments)
+	if want := []string{"-failed", "-ci-failed", "-needs-info", "-done", "+todo"}; !reflect.DeepEqual(f.labels, want) {
+		t.Errorf("labels = %v, want %v", f.labels, want)
+	}
+	if n := retryCount(tr, issue); n != 0 {
+		t.Errorf("retryCount after retry = %d", n)
+	}
+
+	// Cancel stops the running job and dequeues
+	var cancelled bool
+	tr.setCancel(issue.key(), func(error) { cancelled = true })
+	f.labels = nil
+	handleCommands(ctx, cfg, tr, issue, []Comment{cmd("8", "alice", "/bot cancel", at(0))})
+	if !cancelled || !slices.Equal(f.labels, []string{"-todo"}) {
+		t.Errorf("cancelled = %v, labels = %v", cancelled, f.labels)
+	}
+
+	// Plan sets the option and queues the planning run; reset clears options
+	handleCommands(ctx, cfg, tr, issue, []Comment{cmd("9", "alice", "/bot plan", at(0))})
+	if o := tr.options(issue.key()); !o.Plan || o.Model != "opus" {
+		t.Errorf("options after plan = %+v", o)
+	}
+	handleCommands(ctx, cfg, tr, issue, []Comment{cmd("10", "alice", "/bot reset", at(0))})
+	if o := tr.options(issue.key()); o != (issueOptions{}) {
+		t.Errorf("options after reset = %+v", o)
+	}
+
+	// Approvals are for the policy: acknowledged from command users, and left
+	// alone from anyone else rather than logged as unauthorized
+	clear(f.reactions)
+	handleCommands(ctx, cfg, tr, issue, []Comment{cmd("11", "alice", "/bot approve", at(0)), cmd("12", "bob", "/bot approve", at(0))})
+	if want := map[string]string{"11": reactionOK}; !reflect.DeepEqual(f.reactions, want) {
+		t.Errorf("reactions = %v, want %v", f.reactions, want)
+	}
+}
+
+func TestWithOptions(t *testing.T) {
+	cfg := Config{MaxTurns: 50}
+	if got := cfg.withOptions(issueOptions{}); got.MaxTurns != 50 || got.Model != "" {
+		t.Errorf("no options changed the config: %+v", got)
+	}
+	if got := cfg.withOptions(issueOptions{Model: "opus", MaxTurns: 80}); got.MaxTurns != 80 || got.Model != "opus" {
+		t.Errorf("withOptions = %+v", got)
+	}
+}
@@ -0,0 +1,646 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Config File ---
+// Optional claude-bot.toml or claude-bot.json (or CB_CONFIG=path). Top-level
+// keys set bot-wide defaults; CB_* env vars still win over them. Each
+// [repo."owner/repo"] section overrides settings for that repo only:
+//
+//	repos = ["acme/prod", "acme/sandbox"]
+//	max_turns = 50
+//
+//	[repo."acme/prod"]
+//	max_turns = 20
+//	max_retries = 1
+//	agent_timeout = "5m"
+//	allowed_tools = ["Read", "Edit"]
+//	base_branch = "release"
+//	prompt_template = "prompts/prod.tmpl"
+//	prompt_dir = "prompts/prod"
+//	daily_budget_usd = 20.0
+//	ci_fix_rounds = 1
+//	command_users = ["alice", "bob"]
+//	allowed_teams = ["acme/maintainers"]
+//	min_permission = "write"
+//	model = "opus"
+//
+//	[repo."acme/prod".labels]
+//	issue = "bot-fix"
+//
+//	[repo."acme/prod".profile."bot:readonly"]
+//	allowed_tools = ["Read", "Glob", "Grep"]
+//
+// The TOML reader covers what this file needs — tables, strings, integers,
+// booleans and arrays — not the whole spec.
+
+// repoOverride holds the settings that can differ per repo. Zero values mean
+// "inherit".
+type repoOverride struct {
+	agentProfile                            // tools, model, MCP servers and agent flags (see profiles.go)
+	Profiles        map[string]agentProfile `json:"profile"` // label → profile
+	Labels          labelNames              `json:"labels"`
+	MaxTurns        int                     `json:"max_turns"`
+	MaxRetries      int                     `json:"max_retries"`
+	AgentTimeout    duration                `json:"agent_timeout"`
+	Triage          *bool                   `json:"triage"`
+	PlanFirst       *bool                   `json:"plan_first"`
+	BaseBranch      string                  `json:"base_branch"`
+	PromptTemplate  string                  `json:"prompt_template"`
+	PromptDir       string                  `json:"prompt_dir"`
+	IssueSource     string                  `json:"issue_source"`
+	JQL             string                  `json:"jql"`
+	Transitions     labelNames              `json:"transitions"` // label → Jira or Linear workflow status
+	MembersOnly     *bool                   `json:"members_only"`
+	Agent           string                  `json:"agent"`
+	DailyBudgetUSD  float64                 `json:"daily_budget_usd"` // at the top level, shadowed by fileConfig's
+	IssueBudgetUSD  float64                 `json:"issue_budget_usd"`
+	Reviews         *bool                   `json:"reviews"`
+	CIWait          *duration               `json:"ci_wait"` // "0s" turns CI watching off
+	CIFixRounds     *int                    `json:"ci_fix_rounds"`
+	Verify          *bool                   `json:"verify"`
+	VerifyRounds    *int                    `json:"verify_rounds"`
+	VerifyTimeout   duration                `json:"verify_timeout"`
+	Attempts        int                     `json:"attempts"`
+	CommandUsers    []string                `json:"command_users"`
+	AllowedUsers    []string                `json:"allowed_users"`
+	AllowedTeams    []string                `json:"allowed_teams"`
+	MinPermission   string                  `json:"min_permission"`
+	OutsideApproval *bool                   `json:"outside_approval"`
+	InjectionReview *bool                   `json:"injection_review"`
+	OutsiderTools   []string                `json:"outsider_tools"`
+}
+
+type labelNames struct {
+	Issue            string `json:"issue"`
+	WIP              string `json:"wip"`
+	Done             string `json:"done"`
+	NeedsInfo        string `json:"needs_info"`
+	Failed           string `json:"failed"`
+	CIFailed         string `json:"ci_failed"`
+	Triage           string `json:"triage"`
+	NeedsReview      string `json:"needs_review"`
+	Plan             string `json:"plan"`
+	AwaitingApproval string `json:"awaiting_approval"`
+}
+
+// merge copies o's non-empty names onto l.
+func (l *labelNames) merge(o labelNames) {
+	for _, n := range []struct {
+		dst *string
+		v   string
+	}{
+		{&l.Issue, o.Issue},
+		{&l.WIP, o.WIP},
+		{&l.Done, o.Done},
+		{&l.NeedsInfo, o.NeedsInfo},
+		{&l.Failed, o.Failed},
+		{&l.CIFailed, o.CIFailed},
+		{&l.Triage, o.Triage},
+		{&l.NeedsReview, o.NeedsReview},
+		{&l.Plan, o.Plan},
+		{&l.AwaitingApproval, o.AwaitingApproval},
+	} {
+		if n.v != "" {
+			*n.dst = n.v
+		}
+	}
+}
+
+// fileConfig is the config file schema: repo-level defaults plus bot-wide settings.
+type fileConfig struct {
+	repoOverride
+	Repos             []string                `json:"repos"`
+	PollInterval      duration                `json:"poll_interval"`
+	ReconcileInterval duration                `json:"reconcile_interval"`
+	Workers           int                     `json:"workers"`
+	TriageDiscussions *bool                   `json:"triage_discussions"`
+	WorktreeDir       string                  `json:"worktree_dir"`
+	RepoDir           string                  `json:"repo_dir"`
+	LogDir            string                  `json:"log_dir"`
+	StateDir          string                  `json:"state_dir"`
+	HTTPAddr          string                  `json:"http_addr"`
+	DailyBudgetUSD    float64                 `json:"daily_budget_usd"` // all repos together
+	Repo              map[string]repoOverride `json:"repo"`
+}
+
+// duration accepts Go duration strings ("90s", "10m").
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"10m\"")
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+// findConfigFile returns CB_CONFIG, or the first of claude-bot.toml and
+// claude-bot.json in the working directory, or "".
+func findConfigFile() string {
+	if p := os.Getenv("CB_CONFIG"); p != "" {
+		return expandHome(p)
+	}
+	for _, p := range []string{"claude-bot.toml", "claude-bot.json"} {
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+// loadConfigFile reads and validates a TOML or JSON config file. Relative
+// prompt template paths and dirs and MCP configs are resolved against the
+// file's directory.
+func loadConfigFile(path string) (fileConfig, error) {
+	var fc fileConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fc, err
+	}
+	if strings.HasSuffix(path, ".toml") {
+		m, err := parseTOML(string(data))
+		if err != nil {
+			return fc, fmt.Errorf("%s: %w", path, err)
+		}
+		if data, err = json.Marshal(m); err != nil {
+			return fc, err
+		}
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&fc); err != nil {
+		return fc, fmt.Errorf("%s: %w", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	resolve := func(o *repoOverride, where string) error {
+		if o.Agent != "" {
+			if _, err := parseAgent(o.Agent); err != nil {
+				return fmt.Errorf("%s: %s: %w", path, where, err)
+			}
+		}
+		if o.MinPermission != "" {
+			if _, err := parsePermission(o.MinPermission); err != nil {
+				return fmt.Errorf("%s: %s: min_permission: %w", path, where, err)
+			}
+		}
+		paths := []*string{&o.PromptTemplate, &o.PromptDir, &o.MCPConfig}
+		for name, p := range o.Profiles {
+			if p.MCPConfig != "" && !filepath.IsAbs(p.MCPConfig) {
+				p.MCPConfig = filepath.Join(dir, expandHome(p.MCPConfig))
+				o.Profiles[name] = p
+			}
+		}
+		for _, p := range paths {
+			if *p != "" && !filepath.IsAbs(*p) {
+				*p = filepath.Join(dir, expandHome(*p))
+			}
+		}
+		if kind, p, ok := strings.Cut(o.IssueSource, ":"); ok && (kind == "markdown" || kind == "jsonl") && !filepath.IsAbs(p) {
+			o.IssueSource = kind + ":" + filepath.Join(dir, expandHome(p))
+		}
+		return nil
+	}
+	if err := resolve(&fc.repoOverride, "top level"); err != nil {
+		return fc, err
+	}
+	for repo, o := range fc.Repo {
+		if err := resolve(&o, fmt.Sprintf("repo %q", repo)); err != nil {
+			return fc, err
+		}
+		fc.Repo[repo] = o
+	}
+	return fc, nil
+}
+
+// applyFile layers a config file over the defaults (env vars are applied after).
+func (cfg *Config) applyFile(fc fileConfig) {
+	cfg.apply(fc.repoOverride)
+	if len(fc.Repos) > 0 {
+		cfg.Repos = fc.Repos
+	}
+	if fc.PollInterval > 0 {
+		cfg.PollInterval = time.Duration(fc.PollInterval)
+	}
+	if fc.ReconcileInterval > 0 {
+		cfg.ReconcileInterval = time.Duration(fc.ReconcileInterval)
+	}
+	if fc.Workers > 0 {
+		cfg.Workers = fc.Workers
+	}
+	if fc.TriageDiscussions != nil {
+		cfg.TriageDiscussions = *fc.TriageDiscussions
+	}
+	for _, d := range []struct {
+		dst *string
+		v   string
+	}{
+		{&cfg.WorktreeDir, fc.WorktreeDir},
+		{&cfg.RepoDir, fc.RepoDir},
+		{&cfg.LogDir, fc.LogDir},
+		{&cfg.StateDir, fc.StateDir},
+	} {
+		if d.v != "" {
+			*d.dst = expandHome(d.v)
+		}
+	}
+	if fc.HTTPAddr != "" {
+		cfg.HTTPAddr = fc.HTTPAddr
+	}
+	if fc.DailyBudgetUSD > 0 {
+		cfg.DailyBudgetUSD = fc.DailyBudgetUSD
+	}
+	cfg.RepoOverrides = fc.Repo
+}
+
+// apply copies the non-zero fields of o onto cfg.
+func (cfg *Config) apply(o repoOverride) {
+	for _, l := range []struct {
+		dst *string
+		v   string
+	}{
+		{&cfg.IssueLabel, o.Labels.Issue},
+		{&cfg.WIPLabel, o.Labels.WIP},
+		{&cfg.DoneLabel, o.Labels.Done},
+		{&cfg.NeedsInfoLabel, o.Labels.NeedsInfo},
+		{&cfg.FailedLabel, o.Labels.Failed},
+		{&cfg.CIFailedLabel, o.Labels.CIFailed},
+		{&cfg.TriageLabel, o.Labels.Triage},
+		{&cfg.NeedsReviewLabel, o.Labels.NeedsReview},
+		{&cfg.PlanLabel, o.Labels.Plan},
+		{&cfg.AwaitingApprovalLabel, o.Labels.AwaitingApproval},
+		{&cfg.BaseBranch, o.BaseBranch},
+		{&cfg.PromptTemplate, o.PromptTemplate},
+		{&cfg.PromptDir, o.PromptDir},
+		{&cfg.IssueSource, o.IssueSource},
+		{&cfg.JQL, o.JQL},
+		{&cfg.Agent, o.Agent},
+	} {
+		if l.v != "" {
+			*l.dst = l.v
+		}
+	}
+	if o.MaxTurns > 0 {
+		cfg.MaxTurns = o.MaxTurns
+	}
+	if o.MaxRetries > 0 {
+		cfg.MaxRetries = o.MaxRetries
+	}
+	if o.AgentTimeout > 0 {
+		cfg.AgentTimeout = time.Duration(o.AgentTimeout)
+	}
+	cfg.Transitions.merge(o.Transitions)
+	cfg.applyProfile(o.agentProfile)
+	cfg.addProfiles(o.Profiles)
+	if len(o.CommandUsers) > 0 {
+		cfg.CommandUsers = slices.Clone(o.CommandUsers)
+	}
+	if len(o.AllowedUsers) > 0 {
+		cfg.AllowedUsers = slices.Clone(o.AllowedUsers)
+	}
+	if len(o.AllowedTeams) > 0 {
+		cfg.AllowedTeams = slices.Clone(o.AllowedTeams)
+	}
+	if p, err := parsePermission(o.MinPermission); err == nil && o.MinPermission != "" {
+		cfg.MinPermission = p
+	}
+	if o.MembersOnly != nil {
+		cfg.MembersOnly = *o.MembersOnly
+	}
+	if o.OutsideApproval != nil {
+		cfg.OutsideApproval = *o.OutsideApproval
+	}
+	if o.InjectionReview != nil {
+		cfg.InjectionReview = *o.InjectionReview
+	}
+	if len(o.OutsiderTools) > 0 {
+		cfg.OutsiderTools = slices.Clone(o.OutsiderTools)
+	}
+	if o.Triage != nil {
+		cfg.Triage = *o.Triage
+	}
+	if o.PlanFirst != nil {
+		cfg.PlanFirst = *o.PlanFirst
+	}
+	if o.Reviews != nil {
+		cfg.Reviews = *o.Reviews
+	}
+	if o.Verify != nil {
+		cfg.Verify = *o.Verify
+	}
+	if o.VerifyRounds != nil && *o.VerifyRounds >= 0 {
+		cfg.VerifyRounds = *o.VerifyRounds
+	}
+	if o.VerifyTimeout > 0 {
+		cfg.VerifyTimeout = time.Duration(o.VerifyTimeout)
+	}
+	if o.Attempts >= 1 && o.Attempts <= maxAttempts {
+		cfg.Attempts = o.Attempts
+	}
+	if o.CIWait != nil && *o.CIWait >= 0 {
+		cfg.CIWait = time.Duration(*o.CIWait)
+	}
+	if o.CIFixRounds != nil && *o.CIFixRounds >= 0 {
+		cfg.CIFixRounds = *o.CIFixRounds
+	}
+	if o.DailyBudgetUSD > 0 {
+		cfg.RepoBudgetUSD = o.DailyBudgetUSD
+	}
+	if o.IssueBudgetUSD > 0 {
+		cfg.IssueBudgetUSD = o.IssueBudgetUSD
+	}
+}
+
+// forRepo returns the effective config for one repo: the bot-wide settings
+// with that repo's config file section applied on top.
+func (cfg Config) forRepo(repo string) Config {
+	if o, ok := cfg.RepoOverrides[repo]; ok {
+		cfg.apply(o)
+	}
+	// Local repos have no CI to wait for or PRs to review (see forge_local.go)
+	if parseRepo(repo).Kind == "local" {
+		cfg.CIWait, cfg.Reviews = 0, false
+	}
+	return cfg
+}
+
+// --- TOML subset ---
+
+// parseTOML parses tables ([a."b".c]), dotted keys, basic and literal strings,
+// integers, floats, booleans and (possibly multi-line) arrays into nested maps.
+func parseTOML(src string) (map[string]any, error) {
+	root := map[string]any{}
+	table := root
+	lines := strings.Split(src, "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNo := i + 1
+		line := strings.TrimSpace(stripTOMLComment(lines[i]))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") || strings.HasPrefix(line, "[[") {
+				return nil, fmt.Errorf("line %d: unsupported table header %q", lineNo, line)
+			}
+			path, err := splitTOMLKey(line[1 : len(line)-1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			if table, err = tomlTable(root, path); err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			continue
+		}
+
+		k, v, ok := cutTOMLAssign(line)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNo)
+		}
+		// Multi-line arrays: keep reading until the brackets balance
+		for strings.HasPrefix(v, "[") && !tomlBalanced(v) && i+1 < len(lines) {
+			i++
+			v += " " + strings.TrimSpace(stripTOMLComment(lines[i]))
+		}
+		path, err := splitTOMLKey(k)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		val, rest, err := parseTOMLValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		if strings.TrimSpace(rest) != "" {
+			return nil, fmt.Errorf("line %d: unexpected %q after value", lineNo, rest)
+		}
+		parent, err := tomlTable(table, path[:len(path)-1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		last := path[len(path)-1]
+		if _, dup := parent[last]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %q", lineNo, k)
+		}
+		parent[last] = val
+	}
+	return root, nil
+}
+
+// tomlTable walks (creating as needed) nested tables along path.
+func tomlTable(m map[string]any, path []string) (map[string]any, error) {
+	for _, p := range path {
+		next, ok := m[p]
+		if !ok {
+			t := map[string]any{}
+			m[p] = t
+			m = t
+			continue
+		}
+		t, ok := next.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%q is not a table", p)
+		}
+		m = t
+	}
+	return m, nil
+}
+
+// splitTOMLKey splits a dotted key, honouring quoted segments: repo."a/b".labels.
+func splitTOMLKey(s string) ([]string, error) {
+	var parts []string
+	s = strings.TrimSpace(s)
+	for s != "" {
+		var part string
+		if s[0] == '"' || s[0] == '\'' {
+			v, rest, err := parseTOMLString(s)
+			if err != nil {
+				return nil, err
+			}
+			part, s = v, strings.TrimSpace(rest)
+		} else {
+			end := strings.IndexByte(s, '.')
+			if end < 0 {
+				end = len(s)
+			}
+			part, s = strings.TrimSpace(s[:end]), s[end:]
+			if part == "" {
+				return nil, fmt.Errorf("empty key segment")
+			}
+		}
+		parts = append(parts, part)
+		if s != "" {
+			if s[0] != '.' {
+				return nil, fmt.Errorf("invalid key near %q", s)
+			}
+			s = strings.TrimSpace(s[1:])
+		}
+	}
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("empty key")
+	}
+	return parts, nil
+}
+
+// cutTOMLAssign splits "key = value" at the first '=' outside quotes.
+func cutTOMLAssign(line string) (key, value string, ok bool) {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '=':
+			return strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+// stripTOMLComment drops a trailing # comment that isn't inside a string.
+func stripTOMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func tomlBalanced(s string) bool {
+	depth, quote := 0, byte(0)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		}
+	}
+	return depth == 0
+}
+
+// parseTOMLValue parses one value from the front of s and returns the remainder.
+func parseTOMLValue(s string) (any, string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, "", fmt.Errorf("missing value")
+	}
+	switch s[0] {
+	case '"', '\'':
+		return parseTOMLString(s)
+	case '[':
+		var arr []any
+		s = strings.TrimSpace(s[1:])
+		for {
+			if strings.HasPrefix(s, "]") {
+				return arr, s[1:], nil
+			}
+			v, rest, err := parseTOMLValue(s)
+			if err != nil {
+				return nil, "", err
+			}
+			arr = append(arr, v)
+			s = strings.TrimSpace(rest)
+			if strings.HasPrefix(s, ",") {
+				s = strings.TrimSpace(s[1:])
+			} else if !strings.HasPrefix(s, "]") {
+				return nil, "", fmt.Errorf("expected , or ] in array")
+			}
+		}
+	}
+
+	end := strings.IndexAny(s, ",]")
+	if end < 0 {
+		end = len(s)
+	}
+	tok, rest := strings.TrimSpace(s[:end]), s[end:]
+	switch tok {
+	case "true":
+		return true, rest, nil
+	case "false":
+		return false, rest, nil
+	}
+	num := strings.ReplaceAll(tok, "_", "")
+	if n, err := strconv.ParseInt(num, 10, 64); err == nil {
+		return n, rest, nil
+	}
+	if f, err := strconv.ParseFloat(num, 64); err == nil {
+		return f, rest, nil
+	}
+	return nil, "", fmt.Errorf("invalid value %q", tok)
+}
+
+// parseTOMLString parses a basic ("...") or literal ('...') string.
+func parseTOMLString(s string) (string, string, error) {
+	q := s[0]
+	if q == '\'' {
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return "", "", fmt.Errorf("unterminated string")
+		}
+		return s[1 : end+1], s[end+2:], nil
+	}
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"':
+			return b.String(), s[i+1:], nil
+		case c == '\\' && i+1 < len(s):
+			i++
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case '"', '\\':
+				b.WriteByte(s[i])
+			default:
+				return "", "", fmt.Errorf("unsupported escape \\%c", s[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", "", fmt.Errorf("unterminated string")
+}
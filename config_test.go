@@ -0,0 +1,198 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testConfigTOML = `
+# bot-wide
+repos = ["acme/prod", "acme/sandbox"]   # both watched
+workers = 2
+max_turns = 40
+agent_timeout = "15m"
+allowed_tools = [
+  "Bash",
+  "Read", 'Write',
+  "Edit",
+]
+daily_budget_usd = 50.0   # all repos together
+issue_budget_usd = 5
+command_users = ["alice"]
+
+[labels]
+issue = "claude"
+
+[repo."acme/prod"]
+max_turns = 20
+max_retries = 1
+agent_timeout = "5m"
+allowed_tools = ["Read", "Edit"]
+triage = false
+base_branch = "release"
+prompt_template = "prompts/prod.tmpl"
+daily_budget_usd = 10
+issue_budget_usd = 2.5
+ci_fix_rounds = 0
+verify_timeout = "20m"
+allowed_teams = ["acme/maintainers"]
+min_permission = "write"
+
+[repo."acme/prod".labels]
+issue = "bot-fix"
+failed = "bot-failed"
+
+[repo."acme/sandbox"]
+triage = true
+reviews = false
+ci_wait = "0s"
+verify = false
+command_users = ["alice", "bob"]
+agent = "shell:./agent.sh"
+injection_review = true
+outsider_tools = ["Read", "Grep"]
+`
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseTOML(t *testing.T) {
+	m, err := parseTOML(testConfigTOML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m["allowed_tools"]; !reflect.DeepEqual(got, []any{"Bash", "Read", "Write", "Edit"}) {
+		t.Errorf("allowed_tools = %#v", got)
+	}
+	prod := m["repo"].(map[string]any)["acme/prod"].(map[string]any)
+	if prod["max_turns"] != int64(20) || prod["triage"] != false {
+		t.Errorf("acme/prod = %#v", prod)
+	}
+	if got := prod["labels"].(map[string]any)["issue"]; got != "bot-fix" {
+		t.Errorf("acme/prod labels.issue = %v", got)
+	}
+
+	for _, bad := range []string{
+		"a = 1\na = 2",
+		"a = nope",
+		`a = "unterminated`,
+		"[[array.of.tables]]",
+		"a = 1\n[a]",
+		"just a line",
+	} {
+		if _, err := parseTOML(bad); err == nil {
+			t.Errorf("parseTOML(%q) should fail", bad)
+		}
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	t.Setenv("CB_CONFIG", writeConfig(t, "claude-bot.toml", testConfigTOML))
+	for _, key := range []string{"CB_REPOS", "CB_WORKERS", "CB_MAX_TURNS", "CB_AGENT", "CB_ISSUE_LABEL", "CB_CI_WAIT", "CB_CI_FIX_ROUNDS", "CB_VERIFY", "CB_VERIFY_TIMEOUT", "CB_COMMAND_USERS", "CB_ALLOWED_TEAMS", "CB_MIN_PERMISSION", "CB_INJECTION_REVIEW", "CB_OUTSIDER_TOOLS"} {
+		t.Setenv(key, "")
+	}
+	t.Setenv("CB_MAX_RETRIES", "4") // env beats the file's top level
+
+	cfg := loadConfig()
+	if len(cfg.Repos) != 2 || cfg.Workers != 2 || cfg.MaxTurns != 40 || cfg.IssueLabel != "claude" {
+		t.Fatalf("bot-wide config = %+v", cfg)
+	}
+	if cfg.MaxRetries != 4 || cfg.AgentTimeout != 15*time.Minute {
+		t.Errorf("MaxRetries = %d, AgentTimeout = %s", cfg.MaxRetries, cfg.AgentTimeout)
+	}
+
+	prod := cfg.forRepo("acme/prod")
+	if prod.MaxTurns != 20 || prod.MaxRetries != 1 || prod.AgentTimeout != 5*time.Minute {
+		t.Errorf("acme/prod limits = %d/%d/%s", prod.MaxTurns, prod.MaxRetries, prod.AgentTimeout)
+	}
+	if prod.IssueLabel != "bot-fix" || prod.FailedLabel != "bot-failed" || prod.WIPLabel != "in-progress" {
+		t.Errorf("acme/prod labels = %q %q %q", prod.IssueLabel, prod.FailedLabel, prod.WIPLabel)
+	}
+	if !reflect.DeepEqual(prod.AllowedTools, []string{"Read", "Edit"}) || prod.BaseBranch != "release" || prod.Triage {
+		t.Errorf("acme/prod = %+v", prod)
+	}
+	if prod.DailyBudgetUSD != 50 || prod.RepoBudgetUSD != 10 || prod.IssueBudgetUSD != 2.5 {
+		t.Errorf("acme/prod budgets = %v/%v/%v", prod.DailyBudgetUSD, prod.RepoBudgetUSD, prod.IssueBudgetUSD)
+	}
+	if !filepath.IsAbs(prod.PromptTemplate) || !strings.HasSuffix(prod.PromptTemplate, "prompts/prod.tmpl") {
+		t.Errorf("prompt template should resolve next to the config file, got %q", prod.PromptTemplate)
+	}
+
+	sandbox := cfg.forRepo("acme/sandbox")
+	if !prod.Reviews || sandbox.Reviews {
+		t.Errorf("reviews = %v for acme/prod, %v for acme/sandbox", prod.Reviews, sandbox.Reviews)
+	}
+	if !prod.Verify || prod.VerifyTimeout != 20*time.Minute || sandbox.Verify || sandbox.VerifyTimeout != 10*time.Minute {
+		t.Errorf("verify: acme/prod %v %s, acme/sandbox %v %s", prod.Verify, prod.VerifyTimeout, sandbox.Verify, sandbox.VerifyTimeout)
+	}
+	// Explicit zeros turn CI fixing and watching off, rather than inheriting
+	if prod.CIWait != 30*time.Minute || prod.CIFixRounds != 0 || sandbox.CIWait != 0 || sandbox.CIFixRounds != 2 {
+		t.Errorf("CI: acme/prod waits %s, %d rounds; acme/sandbox waits %s, %d rounds", prod.CIWait, prod.CIFixRounds, sandbox.CIWait, sandbox.CIFixRounds)
+	}
+	if !sandbox.Triage || sandbox.MaxTurns != 40 || sandbox.IssueLabel != "claude" || sandbox.RepoBudgetUSD != 0 || sandbox.IssueBudgetUSD != 5 {
+		t.Errorf("acme/sandbox = %+v", sandbox)
+	}
+	if !prod.commandAllowed("Alice") || prod.commandAllowed("bob") || !sandbox.commandAllowed("bob") {
+		t.Errorf("command users: acme/prod %q, acme/sandbox %q", prod.CommandUsers, sandbox.CommandUsers)
+	}
+	if !reflect.DeepEqual(prod.AllowedTeams, []string{"acme/maintainers"}) || prod.MinPermission != permWrite || sandbox.policyEnabled() {
+		t.Errorf("policy: acme/prod %q %s, acme/sandbox %+v", prod.AllowedTeams, prod.MinPermission, sandbox)
+	}
+	if prod.InjectionReview || !sandbox.InjectionReview || !reflect.DeepEqual(sandbox.outsiderTools(), []string{"Read", "Grep"}) {
+		t.Errorf("injection: acme/prod %v, acme/sandbox %v %q", prod.InjectionReview, sandbox.InjectionReview, sandbox.OutsiderTools)
+	}
+	if _, ok := cfg.agentFor("acme/sandbox").(shellAgent); !ok {
+		t.Errorf("acme/sandbox agent = %T", cfg.agentFor("acme/sandbox"))
+	}
+	if _, ok := cfg.agentFor("acme/prod").(claudeAgent); !ok {
+		t.Errorf("acme/prod agent = %T", cfg.agentFor("acme/prod"))
+	}
+}
+
+func TestLoadConfigFileJSON(t *testing.T) {
+	path := writeConfig(t, "claude-bot.json", `{
+		"repos": ["acme/prod"],
+		"transitions": {"issue": "To Do", "wip": "In Progress"},
+		"repo": {"acme/prod": {"max_turns": 7, "labels": {"done": "shipped"}, "issue_source": "jira:jira.acme.test/PROD", "jql": "component = api", "transitions": {"wip": "Doing", "done": "In Review"}, "members_only": true}}
+	}`)
+	fc, err := loadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := Config{MaxTurns: 50, IssueLabel: "todo", WIPLabel: "in-progress", DoneLabel: "done"}
+	cfg.applyFile(fc)
+	got := cfg.forRepo("acme/prod")
+	if got.MaxTurns != 7 || got.DoneLabel != "shipped" || got.IssueSource != "jira:jira.acme.test/PROD" || got.JQL != "component = api" {
+		t.Errorf("forRepo = %+v", got)
+	}
+	if w := got.workflow(); len(w) != 3 || w.status("todo") != "To Do" || w.status("in-progress") != "Doing" || w.label("in review") != "shipped" || w.status("needs-info") != "" {
+		t.Errorf("workflow = %+v", w)
+	}
+	if !got.MembersOnly || cfg.MembersOnly {
+		t.Errorf("members_only = %v, bot-wide %v", got.MembersOnly, cfg.MembersOnly)
+	}
+	if cfg.MaxTurns != 50 {
+		t.Error("a repo section must not change the bot-wide config")
+	}
+
+	for name, content := range map[string]string{
+		"typo.json":  `{"max_turn": 5}`,
+		"agent.json": `{"repo": {"a/b": {"agent": "telepathy"}}}`,
+		"dur.toml":   `agent_timeout = "soon"`,
+		"perm.toml":  `min_permission = "owner"`,
+	} {
+		if _, err := loadConfigFile(writeConfig(t, name, content)); err == nil {
+			t.Errorf("%s should be rejected", name)
+		}
+	}
+}
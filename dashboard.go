@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Dashboard ---
+// Served on CB_HTTP_ADDR from the binary itself (see ADR-003): issues grouped
+// by bot label, in-flight jobs, per-worker status, recent completions, live
+// log tails, and retry / cancel / fail actions, behind HTTP basic auth with
+// CB_DASHBOARD_PASSWORD.
+
+//go:embed web
+var webFS embed.FS
+
+// issueCacheTTL bounds how often the dashboard lists issues from the forges.
+const issueCacheTTL = 30 * time.Second
+
+type dashboard struct {
+	cfg Config
+	t   *tracker
+
+	mu        sync.Mutex
+	groups    []repoGroups
+	fetchedAt time.Time
+}
+
+type repoGroups struct {
+	Repo   string                    `json:"repo"`
+	Groups map[string][]issueSummary `json:"groups"` // label → issues
+	Error  string                    `json:"error,omitempty"`
+}
+
+type issueSummary struct {
+	Key    string `json:"key"`
+	Number int    `json:"number"`
+	Title  string `json:"title"`
+	URL    string `json:"url"`
+}
+
+type workerStatus struct {
+	ID      int      `json:"id"`
+	Busy    bool     `json:"busy"`
+	Attempt *Attempt `json:"attempt,omitempty"`
+}
+
+type dashboardState struct {
+	Labels  []string       `json:"labels"`
+	Repos   []repoGroups   `json:"repos"`
+	Workers []workerStatus `json:"workers"`
+	Queued  []string       `json:"queued"` // held by the tracker but not yet running
+	Recent  []Attempt      `json:"recent"`
+	Usage   []usageTotal   `json:"usage"` // per repo, all recorded runs
+}
+
+func newDashboard(cfg Config, t *tracker) *dashboard {
+	return &dashboard{cfg: cfg, t: t}
+}
+
+// register mounts the dashboard routes on mux.
+func (d *dashboard) register(mux *http.ServeMux) {
+	static, _ := fs.Sub(webFS, "web")
+	mux.Handle("GET /", d.auth(http.FileServerFS(static)))
+	mux.Handle("GET /api/state", d.auth(http.HandlerFunc(d.handleState)))
+	mux.Handle("GET /api/log", d.auth(http.HandlerFunc(d.handleLog)))
+	mux.Handle("POST /api/issues/{action}", d.auth(http.HandlerFunc(d.handleAction)))
+}
+
+// auth enforces basic auth with CB_DASHBOARD_PASSWORD (any username). main
+// refuses to serve the dashboard without one; tests leave it open.
+func (d *dashboard) auth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if d.cfg.DashboardPassword != "" {
+			_, pass, ok := r.BasicAuth()
+			if !ok || subtle.ConstantTimeCompare([]byte(pass), []byte(d.cfg.DashboardPassword)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="claude-bot"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (d *dashboard) labels() []string {
+	return botLabels(d.cfg)
+}
+
+func botLabels(cfg Config) []string {
+	return []string{cfg.IssueLabel, cfg.WIPLabel, cfg.NeedsInfoLabel, cfg.FailedLabel, cfg.CIFailedLabel, cfg.DoneLabel}
+}
+
+// issueGroups lists each repo's issues per bot label, cached for issueCacheTTL.
+// Repos with their own label names are grouped under the bot-wide names.
+func (d *dashboard) issueGroups(ctx context.Context) []repoGroups {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.groups != nil && time.Since(d.fetchedAt) < issueCacheTTL {
+		return d.groups
+	}
+
+	var out []repoGroups
+	for _, repo := range d.cfg.Repos {
+		rg := repoGroups{Repo: repo, Groups: map[string][]issueSummary{}}
+		repoLabels := botLabels(d.cfg.forRepo(repo))
+		for i, label := range d.labels() {
+			issues, err := fetchIssues(ctx, repo, repoLabels[i])
+			if err != nil {
+				rg.Error = err.Error()
+				break
+			}
+			for _, i := range issues {
+				rg.Groups[label] = append(rg.Groups[label], issueSummary{Key: i.key(), Number: i.Number, Title: i.Title, URL: i.URL})
+			}
+		}
+		out = append(out, rg)
+	}
+	d.groups, d.fetchedAt = out, time.Now()
+	return out
+}
+
+// invalidate forces the next state request to re-list issues.
+func (d *dashboard) invalidate() {
+	d.mu.Lock()
+	d.groups = nil
+	d.mu.Unlock()
+}
+
+func (d *dashboard) state(ctx context.Context) dashboardState {
+	st := dashboardState{Labels: d.labels(), Repos: d.issueGroups(ctx)}
+
+	running := map[string]bool{}
+	byWorker := map[int]Attempt{}
+	for _, a := range d.t.running() {
+		running[a.Key] = true
+		byWorker[a.Worker] = a
+	}
+	for id := 0; id < d.cfg.Workers; id++ {
+		ws := workerStatus{ID: id}
+		if a, ok := byWorker[id]; ok {
+			ws.Busy, ws.Attempt = true, &a
+		}
+		st.Workers = append(st.Workers, ws)
+	}
+	for _, key := range d.t.inflightKeys() {
+		if !running[key] {
+			st.Queued = append(st.Queued, key)
+		}
+	}
+	st.Recent = d.t.recent(20)
+	st.Usage = d.t.usageByRepo()
+	return st
+}
+
+func (d *dashboard) handleState(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d.state(r.Context()))
+}
+
+// parseIssueKey splits "repo#N" and checks the repo is one we watch.
+func (d *dashboard) parseIssueKey(key string) (Issue, error) {
+	issue, err := parseIssueKey(key)
+	if err != nil {
+		return Issue{}, err
+	}
+	if !slices.Contains(d.cfg.Repos, issue.Repo) {
+		return Issue{}, fmt.Errorf("repo %q is not watched", issue.Repo)
+	}
+	return issue, nil
+}
+
+// handleAction applies retry, cancel or fail to an issue.
+// The custom header requirement stops cross-site form posts.
+func (d *dashboard) handleAction(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Claude-Bot") == "" {
+		http.Error(w, "missing X-Claude-Bot header", http.StatusForbidden)
+		return
+	}
+	issue, err := d.parseIssueKey(r.URL.Query().Get("key"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	cfg := d.cfg.forRepo(issue.Repo)
+	switch r.PathValue("action") {
+	case "retry":
+		err = requeueIssue(ctx, cfg, d.t, issue)
+	case "cancel":
+		if !d.t.cancel(issue.key()) {
+			http.Error(w, "not running", http.StatusConflict)
+			return
+		}
+		err = removeLabel(ctx, issue, cfg.IssueLabel)
+	case "fail":
+		d.t.cancel(issue.key())
+		_ = removeLabel(ctx, issue, cfg.IssueLabel)
+		_ = removeLabel(ctx, issue, cfg.WIPLabel)
+		err = addLabel(ctx, issue, cfg.FailedLabel)
+	default:
+		http.Error(w, "unknown action", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	slog.Info("issue action", "component", "dashboard", "action", r.PathValue("action"), "key", issue.key())
+	d.invalidate()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// logPath resolves an issue key to its log file. Paths are never taken from the
+// request, so only files under LogDir can be served.
+func (d *dashboard) logPath(issue Issue) string {
+	return filepath.Join(d.cfg.LogDir, fmt.Sprintf("%s-%d.log", slugify(issue.Repo), issue.Number))
+}
+
+// handleLog streams an issue's log as server-sent events: the last 16KB, then
+// new output as it's written. A truncated file (new attempt) restarts the tail.
+func (d *dashboard) handleLog(w http.ResponseWriter, r *http.Request) {
+	issue, err := d.parseIssueKey(r.URL.Query().Get("key"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	path := d.logPath(issue)
+	var offset int64 = -1
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		if info, err := os.Stat(path); err == nil {
+			size := info.Size()
+			if offset < 0 || size < offset {
+				offset = max(0, size-16<<10)
+			}
+			if size > offset {
+				chunk, err := readRange(path, offset, size)
+				if err == nil {
+					writeEvent(w, chunk)
+					flusher.Flush()
+					offset = size
+				}
+			}
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func readRange(path string, from, to int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	buf := make([]byte, to-from)
+	_, err = f.ReadAt(buf, from)
+	if err == io.EOF {
+		err = nil
+	}
+	return buf, err
+}
+
+// writeEvent sends data as one SSE message, one data: field per line.
+func writeEvent(w io.Writer, data []byte) {
+	for _, line := range strings.Split(string(data), "\n") {
+		fmt.Fprintf(w, "data: %s\n", line)
+	}
+	fmt.Fprint(w, "\n")
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testDashboard(t *testing.T) (*dashboard, *http.ServeMux) {
+	t.Helper()
+	cfg := Config{
+		Repos:      []string{"owner/repo"},
+		Workers:    2,
+		IssueLabel: "todo",
+		LogDir:     t.TempDir(),
+	}
+	d := newDashboard(cfg, newTracker())
+	d.groups, d.fetchedAt = []repoGroups{{Repo: "owner/repo", Groups: map[string][]issueSummary{}}}, time.Now()
+	mux := http.NewServeMux()
+	d.register(mux)
+	mux.Handle("POST /webhook", webhookHandler(cfg, make(chan string, 1)))
+	return d, mux
+}
+
+func TestDashboardState(t *testing.T) {
+	d, mux := testDashboard(t)
+	d.t.tryAcquire("owner/repo#1")
+	d.t.tryAcquire("owner/repo#2")
+	d.t.begin(Attempt{Key: "owner/repo#1", Worker: 1, Branch: "issue-1-fix"})
+	done, _ := d.t.begin(Attempt{Key: "owner/repo#3", Repo: "owner/repo"})
+	d.t.setUsage(done.ID, Usage{Turns: 4, CostUSD: 0.2})
+	d.t.finish(done.ID, resultDone, nil, "https://github.com/owner/repo/pull/9")
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/state", nil))
+	var st dashboardState
+	if err := json.Unmarshal(rec.Body.Bytes(), &st); err != nil {
+		t.Fatalf("decoding state: %v\n%s", err, rec.Body)
+	}
+
+	if len(st.Workers) != 2 || st.Workers[0].Busy || !st.Workers[1].Busy || st.Workers[1].Attempt.Key != "owner/repo#1" {
+		t.Errorf("workers = %+v", st.Workers)
+	}
+	if len(st.Queued) != 1 || st.Queued[0] != "owner/repo#2" {
+		t.Errorf("queued = %v", st.Queued)
+	}
+	if len(st.Recent) != 1 || st.Recent[0].PRURL == "" {
+		t.Errorf("recent = %+v", st.Recent)
+	}
+	if len(st.Usage) != 1 || st.Usage[0].Name != "owner/repo" || st.Usage[0].Usage.Turns != 4 {
+		t.Errorf("usage = %+v", st.Usage)
+	}
+}
+
+func TestDashboardIndexAndAuth(t *testing.T) {
+	d, mux := testDashboard(t)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "claude-bot") {
+		t.Fatalf("index = %d", rec.Code)
+	}
+
+	d.cfg.DashboardPassword = "hunter2"
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/state", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("no credentials = %d", rec.Code)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/state", nil)
+	req.SetBasicAuth("me", "hunter2")
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("with credentials = %d", rec.Code)
+	}
+}
+
+func TestDashboardActionValidation(t *testing.T) {
+	_, mux := testDashboard(t)
+	post := func(url string, header bool) int {
+		req := httptest.NewRequest(http.MethodPost, url, nil)
+		if header {
+			req.Header.Set("X-Claude-Bot", "1")
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := post("/api/issues/retry?key=owner/repo%231", false); code != http.StatusForbidden {
+		t.Errorf("missing header = %d", code)
+	}
+	if code := post("/api/issues/retry?key=other/repo%231", true); code != http.StatusBadRequest {
+		t.Errorf("unwatched repo = %d", code)
+	}
+	if code := post("/api/issues/cancel?key=owner/repo%231", true); code != http.StatusConflict {
+		t.Errorf("cancel of idle issue = %d", code)
+	}
+}
+
+func TestDashboardCancel(t *testing.T) {
+	d, _ := testDashboard(t)
+	ctx, cancel := context.WithCancelCause(context.Background())
+	d.t.setCancel("owner/repo#1", cancel)
+	if !d.t.cancel("owner/repo#1") {
+		t.Fatal("cancel should find the running job")
+	}
+	if !jobCancelled(ctx) {
+		t.Error("job context should carry errJobCancelled")
+	}
+}
+
+func TestDashboardLogTail(t *testing.T) {
+	d, mux := testDashboard(t)
+	os.WriteFile(filepath.Join(d.cfg.LogDir, "owner-repo-1.log"), []byte("line one\nline two\n"), 0644)
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/api/log?key=owner/repo%231", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	sc := bufio.NewScanner(resp.Body)
+	var got []string
+	for sc.Scan() && len(got) < 2 {
+		if line, ok := strings.CutPrefix(sc.Text(), "data: "); ok {
+			got = append(got, line)
+		}
+	}
+	if strings.Join(got, "|") != "line one|line two" {
+		t.Errorf("tail = %q", got)
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// --- Offline evaluation ---
+// "claude-bot --eval corpus.jsonl [report]" measures the bot on a fixed set
+// of tasks, so prompt, model and settings changes can be compared by numbers.
+// Each line of the corpus is a case:
+//
+//	{"name": "login-space", "repo": "repos/app", "commit": "3f2a1c9",
+//	 "title": "Login fails with a space in the password", "body": "...",
+//	 "test": "go test ./auth -run TestPasswordSpace", "test_patch": "patches/login-space.diff"}
+//
+// Each case runs the worker's pipeline on a detached worktree of the local
+// repo at the commit: the issue prompt, the agent, and the repo's checks with
+// repairs (see verify.go), with the repo's .claude-bot.yml as of the commit.
+// Then the hidden acceptance test runs: test_patch, if given, is applied and
+// the test command must pass. The agent sees neither. Nothing is fetched,
+// pushed or posted. Relative paths are resolved against the corpus file.
+//
+// The report (pass rate, turns, duration, cost and diff size, per case and in
+// total) goes to <report>.json and <report>.md, by default
+// CB_LOG_DIR/eval-<time>, and the Markdown is printed.
+
+type evalCase struct {
+	Name      string   `json:"name"` // defaults to case-<line>
+	Repo      string   `json:"repo"` // a local git repo
+	Commit    string   `json:"commit"`
+	Title     string   `json:"title"`
+	Body      string   `json:"body"`
+	Labels    []string `json:"labels"`     // select agent profiles (see profiles.go)
+	Test      string   `json:"test"`       // the acceptance test command
+	TestPatch string   `json:"test_patch"` // applied before the test, e.g. to add the test
+}
+
+type evalResult struct {
+	Name    string  `json:"name"`
+	Passed  bool    `json:"passed"`
+	Error   string  `json:"error,omitempty"` // why it didn't pass
+	Turns   int     `json:"turns"`
+	CostUSD float64 `json:"cost_usd"`
+	Seconds float64 `json:"duration_seconds"`
+	Files   int     `json:"files_changed"`
+	Added   int     `json:"lines_added"`
+	Deleted int     `json:"lines_deleted"`
+	Repairs int     `json:"repairs"`
+	LogPath string  `json:"log"`
+}
+
+type evalReport struct {
+	Corpus   string       `json:"corpus"`
+	Started  time.Time    `json:"started"`
+	Agent    string       `json:"agent"`
+	Model    string       `json:"model,omitempty"`
+	Prompt   string       `json:"prompt"` // where the issue template came from
+	Cases    []evalResult `json:"cases"`
+	Passed   int          `json:"passed"`
+	PassRate float64      `json:"pass_rate"`
+	Turns    int          `json:"turns"`
+	CostUSD  float64      `json:"cost_usd"`
+	Seconds  float64      `json:"duration_seconds"`
+	Lines    int          `json:"lines_changed"`
+}
+
+// loadCorpus reads and checks an eval corpus.
+func loadCorpus(path string) ([]evalCase, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(path)
+	resolve := func(p string) string {
+		if p != "" && !filepath.IsAbs(p) {
+			return filepath.Join(dir, p)
+		}
+		return p
+	}
+
+	var cases []evalCase
+	seen := map[string]bool{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, 16<<20)
+	for n := 1; sc.Scan(); n++ {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		var c evalCase
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&c); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		c.Name = slugify(cmp.Or(c.Name, fmt.Sprintf("case-%d", n)))
+		switch {
+		case c.Repo == "" || c.Commit == "" || c.Title == "" || c.Test == "":
+			return nil, fmt.Errorf("%s:%d: repo, commit, title and test are required", path, n)
+		case seen[c.Name]:
+			return nil, fmt.Errorf("%s:%d: duplicate case %q", path, n, c.Name)
+		}
+		seen[c.Name] = true
+		c.Repo, c.TestPatch = resolve(c.Repo), resolve(c.TestPatch)
+		cases = append(cases, c)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(cases) == 0 {
+		return nil, fmt.Errorf("%s has no cases", path)
+	}
+	return cases, nil
+}
+
+// runEval runs every case in the corpus, one at a time, writes the report
+// files next to out ("" for the default) and prints the Markdown to w.
+func runEval(ctx context.Context, cfg Config, corpus, out string, w io.Writer) error {
+	cases, err := loadCorpus(corpus)
+	if err != nil {
+		return err
+	}
+	for _, dir := range []string{filepath.Join(cfg.WorktreeDir, "eval"), filepath.Join(cfg.LogDir, "eval")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	report := evalReport{Corpus: corpus, Started: time.Now().UTC(), Agent: cfg.Agent, Model: cfg.Model, Prompt: "built-in"}
+	if _, source, err := loadPromptTemplate(ctx, cfg, "", "", promptIssue); err == nil {
+		report.Prompt = source
+	}
+	if out == "" {
+		out = filepath.Join(cfg.LogDir, "eval-"+report.Started.Format("20060102-150405"))
+	}
+
+	lg := slog.Default().With("component", "eval")
+	for i, c := range cases {
+		lg.Info("running case", "case", c.Name, "n", i+1, "of", len(cases))
+		res := evalOne(withLogger(ctx, lg.With("case", c.Name)), cfg, c, i+1)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		lg.Info("case finished", "case", c.Name, "passed", res.Passed, "err", firstLine(res.Error))
+		report.add(res)
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(out+".json", append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	md := report.markdown()
+	if err := os.WriteFile(out+".md", []byte(md), 0644); err != nil {
+		return err
+	}
+	lg.Info("wrote report", "json", out+".json", "markdown", out+".md")
+	_, err = io.WriteString(w, md)
+	return err
+}
+
+// evalOne runs one case through the pipeline and its acceptance test.
+func evalOne(ctx context.Context, cfg Config, c evalCase, number int) (res evalResult) {
+	start := time.Now()
+	issue := Issue{Repo: "eval/" + c.Name, Number: number, Title: c.Title, Body: c.Body}
+	for _, l := range c.Labels {
+		issue.Labels = append(issue.Labels, Label{Name: l})
+	}
+	cfg = cfg.forIssue(issue)
+	wtDir := filepath.Join(cfg.WorktreeDir, "eval", c.Name)
+	logFile := filepath.Join(cfg.LogDir, "eval", c.Name+".log")
+	res = evalResult{Name: c.Name, LogPath: logFile}
+
+	// Agent and repair runs are tallied on a throwaway attempt
+	t := newTracker()
+	attempt, _ := t.begin(Attempt{Key: issue.key(), Repo: issue.Repo, Number: number, LogPath: logFile})
+	defer func() {
+		if a, ok := t.lastAttempt(issue.key()); ok && a.Usage != nil {
+			res.Turns, res.CostUSD = a.Usage.Turns, a.Usage.CostUSD
+		}
+		res.Seconds = time.Since(start).Round(time.Second).Seconds()
+	}()
+	fail := func(format string, args ...any) evalResult {
+		res.Error = fmt.Sprintf(format, args...)
+		return res
+	}
+
+	cleanupWorktree(ctx, c.Repo, wtDir, "")
+	if _, err := run(ctx, c.Repo, "git", "worktree", "add", "--detach", wtDir, c.Commit); err != nil {
+		return fail("creating worktree: %v", err)
+	}
+	defer cleanupWorktree(context.WithoutCancel(ctx), c.Repo, wtDir, "")
+
+	settings, err := loadRepoSettingsAt(ctx, c.Repo, c.Commit)
+	if err != nil {
+		return fail("reading repo settings: %v", err)
+	}
+	cfg.AllowedTools = settings.allowedTools(cfg.AllowedTools)
+	prompt, err := renderPrompt(ctx, cfg, "", "", promptIssue, newPromptData(cfg, nil, settings, "", issue))
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	usage, err := runAgent(ctx, cfg, settings, issue, prompt, wtDir, logFile)
+	if usage != nil {
+		_ = t.setUsage(attempt.ID, *usage)
+	}
+	if err != nil {
+		return fail("running agent: %v", err)
+	}
+	if changed, err := checkChanges(ctx, wtDir); err != nil || !changed {
+		return fail("no changes")
+	}
+	if cfg.Verify {
+		report, err := verifyChanges(ctx, cfg, settings, t, attempt.ID, issue, wtDir, logFile)
+		if report != nil {
+			res.Repairs = report.Repairs
+		}
+		if err != nil {
+			return fail("%v", err)
+		}
+	}
+
+	// What the PR would have had
+	if _, err := run(ctx, wtDir, "git", "add", "-A"); err != nil {
+		return fail("staging changes: %v", err)
+	}
+	numstat, err := run(ctx, wtDir, "git", "diff", "--cached", "--numstat", "--no-renames", "HEAD")
+	if err != nil {
+		return fail("diffing: %v", err)
+	}
+	changes := parseNumstat(numstat)
+	res.Files = len(changes)
+	for _, f := range changes {
+		res.Added += f.Added
+		res.Deleted += f.Deleted
+	}
+	if err := settings.checkChangesAllowed(changes); err != nil {
+		return fail("%v", err)
+	}
+
+	if c.TestPatch != "" {
+		if _, err := run(ctx, wtDir, "git", "apply", c.TestPatch); err != nil {
+			return fail("applying test patch: %v", err)
+		}
+	}
+	f, err := os.Create(strings.TrimSuffix(logFile, ".log") + "-test.log")
+	if err != nil {
+		return fail("creating test log: %v", err)
+	}
+	defer f.Close()
+	step := runChecks(ctx, cfg, []string{c.Test}, wtDir, f)[0]
+	if step.Err != nil {
+		return fail("acceptance test failed: %v\n%s", step.Err, tailLog(step.Output, 1<<10))
+	}
+	res.Passed = true
+	return res
+}
+
+func (r *evalReport) add(res evalResult) {
+	r.Cases = append(r.Cases, res)
+	if res.Passed {
+		r.Passed++
+	}
+	r.PassRate = float64(r.Passed) / float64(len(r.Cases))
+	r.Turns += res.Turns
+	r.CostUSD += res.CostUSD
+	r.Seconds += res.Seconds
+	r.Lines += res.Added + res.Deleted
+}
+
+// markdown renders the report as a summary line and a table of cases.
+func (r *evalReport) markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Eval: %s\n\n", filepath.Base(r.Corpus))
+	fmt.Fprintf(&b, "Agent `%s`", r.Agent)
+	if r.Model != "" {
+		fmt.Fprintf(&b, ", model `%s`", r.Model)
+	}
+	fmt.Fprintf(&b, ", prompt %s, started %s.\n\n", r.Prompt, r.Started.Format(time.DateTime))
+	fmt.Fprintf(&b, "**%d/%d passed (%.0f%%)**, %d turns, $%.2f, %s, %d lines changed\n\n",
+		r.Passed, len(r.Cases), r.PassRate*100, r.Turns, r.CostUSD, time.Duration(r.Seconds)*time.Second, r.Lines)
+
+	b.WriteString("| Case | Result | Turns | Duration | Cost | Diff | Repairs |\n")
+	b.WriteString("|---|---|---|---|---|---|---|\n")
+	for _, c := range r.Cases {
+		result := "pass"
+		if !c.Passed {
+			result = "fail: " + strings.ReplaceAll(firstLine(c.Error), "|", `\|`)
+		}
+		fmt.Fprintf(&b, "| %s | %s | %d | %s | $%.2f | +%d -%d in %d file(s) | %d |\n",
+			c.Name, result, c.Turns, time.Duration(c.Seconds)*time.Second, c.CostUSD, c.Added, c.Deleted, c.Files, c.Repairs)
+	}
+	return b.String()
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadCorpus(t *testing.T) {
+	path := writeConfig(t, "corpus.jsonl", `{"name": "Login Space", "repo": "repos/app", "commit": "abc", "title": "Fix login", "test": "go test ./...", "test_patch": "/tmp/p.diff"}
+
+# a comment
+{"repo": "/abs/app", "commit": "def", "title": "Crash", "test": "make check"}
+`)
+	cases, err := loadCorpus(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cases) != 2 || cases[0].Name != "login-space" || cases[0].Repo != filepath.Join(filepath.Dir(path), "repos/app") ||
+		cases[0].TestPatch != "/tmp/p.diff" || cases[1].Name != "case-4" || cases[1].Repo != "/abs/app" {
+		t.Errorf("cases = %+v", cases)
+	}
+
+	for _, bad := range []string{
+		`{"repo": "app", "commit": "abc", "title": "No test"}`,
+		`{"repo": "app", "commit": "abc", "title": "x", "test": "true", "tset": "typo"}`,
+		"{\"name\": \"a\", \"repo\": \"app\", \"commit\": \"abc\", \"title\": \"x\", \"test\": \"true\"}\n{\"name\": \"A\", \"repo\": \"app\", \"commit\": \"abc\", \"title\": \"y\", \"test\": \"true\"}",
+		"",
+	} {
+		if _, err := loadCorpus(writeConfig(t, "bad.jsonl", bad)); err == nil {
+			t.Errorf("corpus %q should fail", bad)
+		}
+	}
+}
+
+func TestRunEval(t *testing.T) {
+	repo := gitRepo(t, map[string]string{"code.txt": "old\n"})
+	commit, err := run(context.Background(), repo, "git", "rev-parse", "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "check.diff"), []byte("diff --git a/check.txt b/check.txt\nnew file mode 100644\n--- /dev/null\n+++ b/check.txt\n@@ -0,0 +1 @@\n+ok\n"), 0644)
+	var corpus strings.Builder
+	for _, c := range []evalCase{
+		{Name: "fix", Title: "Fix code", Test: "grep -q fixed code.txt"},
+		{Name: "hidden-test", Title: "Fix code again", Test: "test -f check.txt && grep -q fixed code.txt", TestPatch: "check.diff"},
+		{Name: "wrong", Title: "Improve code", Test: "grep -q fixed code.txt"},
+		{Name: "nothing", Title: "Nothing to do", Test: "true"},
+	} {
+		c.Repo, c.Commit = repo, strings.TrimSpace(commit)
+		line, _ := json.Marshal(c)
+		corpus.Write(append(line, '\n'))
+	}
+	os.WriteFile(filepath.Join(dir, "corpus.jsonl"), []byte(corpus.String()), 0644)
+
+	// The agent only sees the issue: the acceptance test and its patch stay hidden
+	cfg := Config{
+		WorktreeDir: t.TempDir(), LogDir: t.TempDir(), AgentTimeout: time.Minute, VerifyTimeout: time.Minute, Verify: true,
+		Agent: `shell:p=$(cat); case "$p" in *check.txt*|*grep*) exit 1 ;; *Nothing*) ;; *"Fix code"*) echo fixed > code.txt ;; *) echo wrong > code.txt ;; esac`,
+	}
+	var out strings.Builder
+	if err := runEval(context.Background(), cfg, filepath.Join(dir, "corpus.jsonl"), filepath.Join(dir, "report"), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "report.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report evalReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Passed != 2 || report.PassRate != 0.5 || len(report.Cases) != 4 || report.Lines != 6 || report.Prompt != "built-in" {
+		t.Errorf("report = %+v", report)
+	}
+	for i, want := range []string{"", "", "acceptance test failed", "no changes"} {
+		if c := report.Cases[i]; c.Passed != (want == "") || !strings.HasPrefix(c.Error, want) {
+			t.Errorf("case %s: passed %v, error %q", c.Name, c.Passed, c.Error)
+		}
+	}
+	if c := report.Cases[0]; c.Files != 1 || c.Added != 1 || c.Deleted != 1 {
+		t.Errorf("diff size = %+v", c)
+	}
+
+	md, _ := os.ReadFile(filepath.Join(dir, "report.md"))
+	if string(md) != out.String() {
+		t.Error("printed report differs from report.md")
+	}
+	for _, want := range []string{"# Eval: corpus.jsonl", "**2/4 passed (50%)**", "| fix | pass |", "| wrong | fail: acceptance test failed: exit status 1 |", "| nothing | fail: no changes |"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report missing %q:\n%s", want, out.String())
+		}
+	}
+	// The worktrees are gone and the repo is untouched
+	if entries, _ := os.ReadDir(filepath.Join(cfg.WorktreeDir, "eval")); len(entries) != 0 {
+		t.Errorf("worktrees left: %v", entries)
+	}
+	if status, _ := run(context.Background(), repo, "git", "status", "--porcelain"); status != "" {
+		t.Errorf("repo changed: %s", status)
+	}
+}
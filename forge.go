@@ -0,0 +1,450 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// --- Forge ---
+// A Forge is the code host a repo lives on. Every issue, label, comment and
+// pull/merge request operation goes through one, so the same worker logic can
+// drive GitHub (via gh), Gitea/Forgejo and GitLab (via their REST APIs), and
+// plain git repos on this machine (see forge_local.go).
+
+// IssueSource is where a repo's issues come from, and where the bot's labels,
+// comments and reactions on them go. A forge is the issue source for its own
+// repos unless the repo's config names another (see sources.go).
+type IssueSource interface {
+	// ListIssues returns open issues, filtered by label if label is non-empty.
+	// Comments must be populated — retry counting and prompts rely on them.
+	ListIssues(ctx context.Context, repo, label string) ([]Issue, error)
+	// GetIssue returns one issue, open or closed, with its comments.
+	GetIssue(ctx context.Context, repo string, number int) (Issue, error)
+	// Comments returns the current comments of a single issue, oldest first.
+	Comments(ctx context.Context, issue Issue) ([]Comment, error)
+	AddLabel(ctx context.Context, issue Issue, label string) error
+	RemoveLabel(ctx context.Context, issue Issue, label string) error
+	Comment(ctx context.Context, issue Issue, body string) error
+	// EnsureLabel creates a label if missing. Reports whether it was created.
+	EnsureLabel(ctx context.Context, repo, name, color, desc string) (bool, error)
+	// React adds reactionOK or reactionConfused to a comment, by its Comment.ID.
+	React(ctx context.Context, repo, commentID, reaction string) error
+	// Labeler returns who last added label to the issue, or "" if the source
+	// has no record of it.
+	Labeler(ctx context.Context, issue Issue, label string) (string, error)
+}
+
+type Forge interface {
+	IssueSource
+	// FindPR returns the URL of an open PR/MR for branch, or "" if there is none.
+	FindPR(ctx context.Context, repo, branch string) (string, error)
+	CreatePR(ctx context.Context, repo string, pr PullRequest) (string, error)
+	// ListPRs returns open PRs/MRs.
+	ListPRs(ctx context.Context, repo string) ([]PR, error)
+	// ReviewThreads returns a PR's feedback: one thread per inline discussion,
+	// plus the top-level conversation as a thread with no ID or Path.
+	ReviewThreads(ctx context.Context, repo string, pr PR) ([]ReviewThread, error)
+	// Reply answers a thread; the conversation gets a top-level comment.
+	Reply(ctx context.Context, repo string, pr PR, thread ReviewThread, body string) error
+	// Checks returns the CI results reported for a commit.
+	Checks(ctx context.Context, repo, sha string) ([]Check, error)
+	// CheckLog returns a check's job output, or "" if the forge has none to give.
+	CheckLog(ctx context.Context, repo string, check Check) (string, error)
+	// Permission returns a user's access to the repo; permNone if they have none.
+	Permission(ctx context.Context, repo, user string) (permission, error)
+	// OrgMember reports whether user belongs to the org (or top-level group)
+	// that owns repo. On a personal repo only its owner does.
+	OrgMember(ctx context.Context, repo, user string) (bool, error)
+	// TeamMember reports whether user is in team: "org/team-slug", or a group
+	// path on GitLab.
+	TeamMember(ctx context.Context, repo, team, user string) (bool, error)
+	// Whoami returns the login the bot acts as on repo's host.
+	Whoami(ctx context.Context, repo string) (string, error)
+	CloneURL(repo string) string
+	// CloneConfig returns git config ("key=value") the bot's clone of repo
+	// needs, such as a credential helper for the forge's token.
+	CloneConfig(repo string) []string
+}
+
+// PullRequest is what the worker asks a forge to open.
+// Reviewers are requested best-effort: an unknown user doesn't fail the PR.
+type PullRequest struct {
+	Title     string
+	Body      string
+	Head      string
+	Base      string
+	Reviewers []string
+}
+
+// PR is an open pull/merge request.
+type PR struct {
+	Number int
+	URL    string
+	Title  string
+	Body   string
+	Branch string // head branch
+}
+
+// ReviewThread is one discussion on a PR, oldest comment first. Inline
+// threads are anchored to a file (and line, when the forge reports one).
+type ReviewThread struct {
+	ID       string // forge-specific; "" for the top-level conversation
+	Path     string
+	Line     int
+	Resolved bool
+	Comments []Comment
+}
+
+// Check is one CI result on a commit: a check run, job or commit status.
+type Check struct {
+	Name  string
+	State string // checkPending, checkPassed or checkFailed
+	URL   string
+	Desc  string // the CI's one-line summary, if any
+	JobID string // forge-specific; "" when CheckLog has nothing to fetch
+}
+
+// Reactions the bot leaves on comments, named as on GitHub's REST API; each
+// forge maps them to its own.
+const (
+	reactionOK       = "+1"
+	reactionConfused = "confused"
+)
+
+// permission is a user's access to a repo, in GitHub's terms. Other forges'
+// roles are mapped onto these.
+type permission int
+
+const (
+	permNone permission = iota
+	permRead
+	permTriage
+	permWrite
+	permMaintain
+	permAdmin
+)
+
+var permNames = []string{"none", "read", "triage", "write", "maintain", "admin"}
+
+func (p permission) String() string { return permNames[p] }
+
+func parsePermission(s string) (permission, error) {
+	if i := slices.Index(permNames, strings.ToLower(s)); i >= 0 {
+		return permission(i), nil
+	}
+	return permNone, fmt.Errorf("unknown permission %q (want read, triage, write, maintain or admin)", s)
+}
+
+// --- Repo specs ---
+// CB_REPOS entries are "owner/repo" (GitHub) or "<forge>:host/path", e.g.
+// "gitea:git.example.com/owner/repo" or "gitlab:gitlab.com/group/sub/project".
+// GitHub Enterprise is "github:ghe.example.com/owner/repo", and a git repo on
+// this machine is "local:/srv/git/app.git".
+
+type repoRef struct {
+	Kind string // github, gitea, gitlab, local
+	Host string
+	Path string // owner/repo, group/.../project on GitLab, or the local repo's path
+}
+
+func parseRepo(spec string) repoRef {
+	kind, rest, ok := strings.Cut(spec, ":")
+	if !ok {
+		kind, rest = "github", spec
+	}
+	if kind == "forgejo" {
+		kind = "gitea" // Forgejo is API-compatible with Gitea
+	}
+
+	parts := strings.Split(rest, "/")
+	switch {
+	case kind == "local":
+		return repoRef{Kind: kind, Path: rest}
+	case kind == "github" && len(parts) <= 2:
+		return repoRef{Kind: kind, Host: "github.com", Path: rest}
+	case len(parts) >= 3:
+		return repoRef{Kind: kind, Host: parts[0], Path: strings.Join(parts[1:], "/")}
+	default:
+		// No host given — leave it empty so the forge reports a useful error.
+		return repoRef{Kind: kind, Path: rest}
+	}
+}
+
+// owner and name split Path into its namespace and final segment.
+func (r repoRef) owner() string {
+	if i := strings.LastIndex(r.Path, "/"); i >= 0 {
+		return r.Path[:i]
+	}
+	return ""
+}
+
+func (r repoRef) name() string {
+	return r.Path[strings.LastIndex(r.Path, "/")+1:]
+}
+
+// repoLocalDir is where a repo's clone and worktrees live under a base dir.
+// github.com repos keep the plain owner/repo layout so existing clones are reused.
+func repoLocalDir(base, spec string) string {
+	r := parseRepo(spec)
+	switch {
+	case r.Kind == "github" && r.Host == "github.com":
+		return filepath.Join(base, filepath.FromSlash(r.Path))
+	case r.Kind == "local":
+		return filepath.Join(base, "local", filepath.FromSlash(r.Path))
+	}
+	return filepath.Join(base, r.Host, filepath.FromSlash(r.Path))
+}
+
+var (
+	forgesMu sync.Mutex
+	forges   = map[string]Forge{}
+)
+
+// forgeFor returns the Forge for a repo spec. Instances are cached per kind+host
+// so label caches and HTTP clients are shared between repos on the same server.
+func forgeFor(spec string) Forge {
+	r := parseRepo(spec)
+	cacheKey := r.Kind + ":" + r.Host
+
+	forgesMu.Lock()
+	defer forgesMu.Unlock()
+	if f, ok := forges[cacheKey]; ok {
+		return f
+	}
+
+	var f Forge
+	switch r.Kind {
+	case "gitea":
+		f = newGiteaForge(r.Host, firstEnv("CB_GITEA_TOKEN", "GITEA_TOKEN"))
+	case "gitlab":
+		f = newGitLabForge(r.Host, firstEnv("CB_GITLAB_TOKEN", "GITLAB_TOKEN"))
+	case "github":
+		f = &githubForge{}
+	case "local":
+		f = localForge{unknownForge{kind: r.Kind, reason: "local repos take their issues from an issue_source"}}
+	default:
+		f = unknownForge{kind: r.Kind}
+	}
+	forges[cacheKey] = f
+	return f
+}
+
+// usesGitHub reports whether any configured repo needs the gh CLI.
+func usesGitHub(repos []string) bool {
+	for _, r := range repos {
+		if parseRepo(r).Kind == "github" {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenHelper is git config for a credential helper that answers with the
+// first of keys set in the environment, as firstEnv would. The shell reads it
+// when git asks, so the token isn't written to the clone's config.
+func tokenHelper(keys ...string) string {
+	token := "$" + keys[len(keys)-1]
+	for i := len(keys) - 2; i >= 0; i-- {
+		token = "${" + keys[i] + ":-" + token + "}"
+	}
+	return `credential.helper=!f() { test "$1" = get && echo username=oauth2 && echo "password=` + token + `"; }; f`
+}
+
+func firstEnv(keys ...string) string {
+	for _, k := range keys {
+		if v := os.Getenv(k); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// unknownForge fails every call, so a typo in CB_REPOS shows up in the logs
+// instead of crashing the bot. Forges that only do part of the job embed it,
+// with the reason.
+type unknownForge struct{ kind, reason string }
+
+func (u unknownForge) err() error {
+	if u.reason != "" {
+		return errors.New(u.reason)
+	}
+	return fmt.Errorf("unknown forge %q", u.kind)
+}
+
+func (u unknownForge) ListIssues(context.Context, string, string) ([]Issue, error) {
+	return nil, u.err()
+}
+func (u unknownForge) GetIssue(context.Context, string, int) (Issue, error) { return Issue{}, u.err() }
+func (u unknownForge) Comments(context.Context, Issue) ([]Comment, error)   { return nil, u.err() }
+func (u unknownForge) AddLabel(context.Context, Issue, string) error        { return u.err() }
+func (u unknownForge) RemoveLabel(context.Context, Issue, string) error     { return u.err() }
+func (u unknownForge) Comment(context.Context, Issue, string) error         { return u.err() }
+func (u unknownForge) EnsureLabel(context.Context, string, string, string, string) (bool, error) {
+	return false, u.err()
+}
+func (u unknownForge) FindPR(context.Context, string, string) (string, error) { return "", u.err() }
+func (u unknownForge) CreatePR(context.Context, string, PullRequest) (string, error) {
+	return "", u.err()
+}
+func (u unknownForge) ListPRs(context.Context, string) ([]PR, error) { return nil, u.err() }
+func (u unknownForge) ReviewThreads(context.Context, string, PR) ([]ReviewThread, error) {
+	return nil, u.err()
+}
+func (u unknownForge) Reply(context.Context, string, PR, ReviewThread, string) error { return u.err() }
+func (u unknownForge) Checks(context.Context, string, string) ([]Check, error)       { return nil, u.err() }
+func (u unknownForge) CheckLog(context.Context, string, Check) (string, error)       { return "", u.err() }
+func (u unknownForge) React(context.Context, string, string, string) error           { return u.err() }
+func (u unknownForge) Labeler(context.Context, Issue, string) (string, error)        { return "", u.err() }
+func (u unknownForge) Permission(context.Context, string, string) (permission, error) {
+	return permNone, u.err()
+}
+func (u unknownForge) OrgMember(context.Context, string, string) (bool, error) { return false, u.err() }
+func (u unknownForge) TeamMember(context.Context, string, string, string) (bool, error) {
+	return false, u.err()
+}
+func (u unknownForge) Whoami(context.Context, string) (string, error) { return "", u.err() }
+func (u unknownForge) CloneURL(string) string                         { return "" }
+func (u unknownForge) CloneConfig(string) []string                    { return nil }
+
+// --- REST client (shared by the Gitea and GitLab backends) ---
+
+type restClient struct {
+	base   string // e.g. https://git.example.com/api/v1
+	header string // auth header name
+	token  string // auth header value
+	http   *http.Client
+}
+
+// do sends a JSON request and decodes a JSON response into out (if non-nil).
+// A *string out receives the body as is, for plain-text endpoints like job logs.
+// Returns an *apiError for non-2xx responses.
+func (c *restClient) do(ctx context.Context, method, path string, in, out any) error {
+	_, err := c.send(ctx, method, path, in, out)
+	return err
+}
+
+// getAll GETs every page of a list, following the rel="next" links Gitea and
+// GitLab put in the Link header. Only a link's path and query are used, so the
+// token never goes to another host.
+func getAll[T any](ctx context.Context, c *restClient, path string) ([]T, error) {
+	base, err := url.Parse(c.base)
+	if err != nil {
+		return nil, err
+	}
+	var all []T
+	for path != "" {
+		var page []T
+		header, err := c.send(ctx, http.MethodGet, path, nil, &page)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, page...)
+		path = ""
+		if next := nextLink(header.Get("Link")); next != nil {
+			path = strings.TrimPrefix(next.RequestURI(), base.Path)
+		}
+	}
+	return all, nil
+}
+
+// nextLink returns the rel="next" URL of a Link header, or nil.
+func nextLink(header string) *url.URL {
+	for _, link := range strings.Split(header, ",") {
+		target, params, _ := strings.Cut(link, ";")
+		if !strings.Contains(params, `rel="next"`) {
+			continue
+		}
+		u, err := url.Parse(strings.Trim(strings.TrimSpace(target), "<>"))
+		if err != nil {
+			return nil
+		}
+		return u
+	}
+	return nil
+}
+
+// send is do, also returning the response's headers.
+func (c *restClient) send(ctx context.Context, method, path string, in, out any) (http.Header, error) {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, body)
+	if err != nil {
+		return nil, err
+	}
+	text, isText := out.(*string)
+	if isText {
+		req.Header.Set("Accept", "*/*")
+	} else {
+		req.Header.Set("Accept", "application/json")
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set(c.header, c.token)
+	}
+
+	client := c.http
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, &apiError{Method: method, Path: path, Status: resp.StatusCode, Body: strings.TrimSpace(string(data))}
+	}
+	if isText {
+		*text = string(data)
+		return resp.Header, nil
+	}
+	if out == nil || len(data) == 0 {
+		return resp.Header, nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return nil, fmt.Errorf("%s %s: parsing response: %w", method, path, err)
+	}
+	return resp.Header, nil
+}
+
+// notFound reports whether a REST call failed with a 404.
+func notFound(err error) bool {
+	var ae *apiError
+	return errors.As(err, &ae) && ae.Status == http.StatusNotFound
+}
+
+type apiError struct {
+	Method string
+	Path   string
+	Status int
+	Body   string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%s %s: HTTP %d: %s", e.Method, e.Path, e.Status, e.Body)
+}
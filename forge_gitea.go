@@ -0,0 +1,488 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// giteaForge drives Gitea and Forgejo through their REST API (/api/v1).
+// Auth: CB_GITEA_TOKEN (or GITEA_TOKEN), sent as "Authorization: token ...".
+type giteaForge struct {
+	host string
+	api  *restClient
+
+	mu     sync.Mutex
+	labels map[string]map[string]int64 // repo path → label name → id
+}
+
+func newGiteaForge(host, token string) *giteaForge {
+	auth := ""
+	if token != "" {
+		auth = "token " + token
+	}
+	return &giteaForge{
+		host: host,
+		api:  &restClient{base: "https://" + host + "/api/v1", header: "Authorization", token: auth},
+	}
+}
+
+type giteaUser struct {
+	Login string `json:"login"`
+}
+
+type giteaLabel struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+type giteaIssue struct {
+	Number   int          `json:"number"`
+	Title    string       `json:"title"`
+	Body     string       `json:"body"`
+	HTMLURL  string       `json:"html_url"`
+	Labels   []giteaLabel `json:"labels"`
+	User     giteaUser    `json:"user"`
+	Comments int          `json:"comments"`
+}
+
+type giteaComment struct {
+	ID        int64     `json:"id"`
+	Body      string    `json:"body"`
+	User      giteaUser `json:"user"`
+	CreatedAt string    `json:"created_at"`
+}
+
+func (g *giteaForge) repoPath(repo string) string {
+	r := parseRepo(repo)
+	return "/repos/" + r.owner() + "/" + r.name()
+}
+
+func (g *giteaForge) ListIssues(ctx context.Context, repo, label string) ([]Issue, error) {
+	q := url.Values{"state": {"open"}, "type": {"issues"}, "limit": {"50"}}
+	if label != "" {
+		q.Set("labels", label)
+	}
+	raw, err := getAll[giteaIssue](ctx, g.api, g.repoPath(repo)+"/issues?"+q.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	issues := make([]Issue, 0, len(raw))
+	for _, ri := range raw {
+		issue, err := g.issue(ctx, repo, ri)
+		if err != nil {
+			return nil, err
+		}
+		issues = append(issues, issue)
+	}
+	return issues, nil
+}
+
+func (g *giteaForge) GetIssue(ctx context.Context, repo string, number int) (Issue, error) {
+	var ri giteaIssue
+	if err := g.api.do(ctx, http.MethodGet, fmt.Sprintf("%s/issues/%d", g.repoPath(repo), number), nil, &ri); err != nil {
+		return Issue{}, err
+	}
+	return g.issue(ctx, repo, ri)
+}
+
+// issue converts an API issue. The API only carries a comment count, so
+// bodies are fetched when there are any.
+func (g *giteaForge) issue(ctx context.Context, repo string, ri giteaIssue) (Issue, error) {
+	issue := Issue{Number: ri.Number, Title: ri.Title, Body: ri.Body, URL: ri.HTMLURL, Repo: repo}
+	issue.Author.Login = ri.User.Login
+	for _, l := range ri.Labels {
+		issue.Labels = append(issue.Labels, Label{Name: l.Name})
+	}
+	if ri.Comments > 0 {
+		comments, err := g.Comments(ctx, issue)
+		if err != nil {
+			return Issue{}, err
+		}
+		issue.Comments = comments
+	}
+	return issue, nil
+}
+
+func (g *giteaForge) Comments(ctx context.Context, issue Issue) ([]Comment, error) {
+	var raw []giteaComment
+	path := fmt.Sprintf("%s/issues/%d/comments", g.repoPath(issue.Repo), issue.Number)
+	if err := g.api.do(ctx, http.MethodGet, path, nil, &raw); err != nil {
+		return nil, err
+	}
+	comments := make([]Comment, 0, len(raw))
+	for _, rc := range raw {
+		c := Comment{ID: strconv.FormatInt(rc.ID, 10), Body: rc.Body, CreatedAt: rc.CreatedAt}
+		c.Author.Login = rc.User.Login
+		comments = append(comments, c)
+	}
+	return comments, nil
+}
+
+// labelID resolves a label name to its id. Older Gitea versions only accept ids
+// when editing issue labels, so names are always resolved first.
+func (g *giteaForge) labelID(ctx context.Context, repo, name string) (int64, error) {
+	path := parseRepo(repo).Path
+
+	g.mu.Lock()
+	id, ok := g.labels[path][name]
+	g.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	var raw []giteaLabel
+	if err := g.api.do(ctx, http.MethodGet, g.repoPath(repo)+"/labels?limit=100", nil, &raw); err != nil {
+		return 0, err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.labels == nil {
+		g.labels = map[string]map[string]int64{}
+	}
+	byName := map[string]int64{}
+	for _, l := range raw {
+		byName[l.Name] = l.ID
+	}
+	g.labels[path] = byName
+
+	if id, ok := byName[name]; ok {
+		return id, nil
+	}
+	return 0, fmt.Errorf("label %q not found on %s", name, repo)
+}
+
+func (g *giteaForge) AddLabel(ctx context.Context, issue Issue, label string) error {
+	id, err := g.labelID(ctx, issue.Repo, label)
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("%s/issues/%d/labels", g.repoPath(issue.Repo), issue.Number)
+	return g.api.do(ctx, http.MethodPost, path, map[string]any{"labels": []int64{id}}, nil)
+}
+
+func (g *giteaForge) RemoveLabel(ctx context.Context, issue Issue, label string) error {
+	id, err := g.labelID(ctx, issue.Repo, label)
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("%s/issues/%d/labels/%d", g.repoPath(issue.Repo), issue.Number, id)
+	return g.api.do(ctx, http.MethodDelete, path, nil, nil)
+}
+
+func (g *giteaForge) Comment(ctx context.Context, issue Issue, body string) error {
+	path := fmt.Sprintf("%s/issues/%d/comments", g.repoPath(issue.Repo), issue.Number)
+	return g.api.do(ctx, http.MethodPost, path, map[string]string{"body": body}, nil)
+}
+
+func (g *giteaForge) EnsureLabel(ctx context.Context, repo, name, color, desc string) (bool, error) {
+	if _, err := g.labelID(ctx, repo, name); err == nil {
+		return false, nil
+	}
+	var created giteaLabel
+	if err := g.api.do(ctx, http.MethodPost, g.repoPath(repo)+"/labels",
+		map[string]string{"name": name, "color": "#" + color, "description": desc}, &created); err != nil {
+		return false, err
+	}
+
+	g.mu.Lock()
+	if byName := g.labels[parseRepo(repo).Path]; byName != nil {
+		byName[name] = created.ID
+	}
+	g.mu.Unlock()
+	return true, nil
+}
+
+type giteaPR struct {
+	Number  int    `json:"number"`
+	HTMLURL string `json:"html_url"`
+	Title   string `json:"title"`
+	Body    string `json:"body"`
+	Head    struct {
+		Ref string `json:"ref"`
+	} `json:"head"`
+}
+
+// FindPR looks through every open PR: older Gitea versions can't filter the
+// list by head branch.
+func (g *giteaForge) FindPR(ctx context.Context, repo, branch string) (string, error) {
+	prs, err := g.ListPRs(ctx, repo)
+	if err != nil {
+		return "", err
+	}
+	for _, pr := range prs {
+		if pr.Branch == branch {
+			return pr.URL, nil
+		}
+	}
+	return "", nil
+}
+
+func (g *giteaForge) CreatePR(ctx context.Context, repo string, pr PullRequest) (string, error) {
+	var created giteaPR
+	if err := g.api.do(ctx, http.MethodPost, g.repoPath(repo)+"/pulls", map[string]string{
+		"title": pr.Title,
+		"body":  pr.Body,
+		"head":  pr.Head,
+		"base":  pr.Base,
+	}, &created); err != nil {
+		return "", err
+	}
+	if len(pr.Reviewers) > 0 {
+		path := fmt.Sprintf("%s/pulls/%d/requested_reviewers", g.repoPath(repo), created.Number)
+		if err := g.api.do(ctx, http.MethodPost, path, map[string][]string{"reviewers": pr.Reviewers}, nil); err != nil {
+			logger(ctx).Warn("couldn't request reviewers", "pr", created.HTMLURL, "reviewers", pr.Reviewers, "err", err)
+		}
+	}
+	return created.HTMLURL, nil
+}
+
+func (g *giteaForge) ListPRs(ctx context.Context, repo string) ([]PR, error) {
+	raw, err := getAll[giteaPR](ctx, g.api, g.repoPath(repo)+"/pulls?state=open&limit=50")
+	if err != nil {
+		return nil, err
+	}
+	prs := make([]PR, 0, len(raw))
+	for _, r := range raw {
+		prs = append(prs, PR{Number: r.Number, URL: r.HTMLURL, Title: r.Title, Body: r.Body, Branch: r.Head.Ref})
+	}
+	return prs, nil
+}
+
+type giteaReview struct {
+	ID            int64     `json:"id"`
+	Body          string    `json:"body"`
+	User          giteaUser `json:"user"`
+	SubmittedAt   string    `json:"submitted_at"`
+	CommentsCount int       `json:"comments_count"`
+}
+
+type giteaReviewComment struct {
+	Body      string    `json:"body"`
+	User      giteaUser `json:"user"`
+	Path      string    `json:"path"`
+	Position  int       `json:"position"`
+	CreatedAt string    `json:"created_at"`
+}
+
+// ReviewThreads groups review comments by file and line, the way Gitea shows
+// them as one conversation; it has no reply-to-comment API.
+func (g *giteaForge) ReviewThreads(ctx context.Context, repo string, pr PR) ([]ReviewThread, error) {
+	comments, err := g.Comments(ctx, Issue{Repo: repo, Number: pr.Number})
+	if err != nil {
+		return nil, err
+	}
+	var reviews []giteaReview
+	path := fmt.Sprintf("%s/pulls/%d/reviews", g.repoPath(repo), pr.Number)
+	if err := g.api.do(ctx, http.MethodGet, path, nil, &reviews); err != nil {
+		return nil, err
+	}
+
+	conversation := ReviewThread{Comments: comments}
+	threads := []ReviewThread{}
+	byPos := map[string]int{} // path:line → index in threads
+	for _, r := range reviews {
+		if strings.TrimSpace(r.Body) != "" {
+			c := Comment{Body: r.Body, CreatedAt: r.SubmittedAt}
+			c.Author.Login = r.User.Login
+			conversation.Comments = append(conversation.Comments, c)
+		}
+		if r.CommentsCount == 0 {
+			continue
+		}
+		var raw []giteaReviewComment
+		if err := g.api.do(ctx, http.MethodGet, fmt.Sprintf("%s/%d/comments", path, r.ID), nil, &raw); err != nil {
+			return nil, err
+		}
+		for _, rc := range raw {
+			pos := fmt.Sprintf("%s:%d", rc.Path, rc.Position)
+			i, ok := byPos[pos]
+			if !ok {
+				threads = append(threads, ReviewThread{ID: pos, Path: rc.Path, Line: rc.Position})
+				i = len(threads) - 1
+				byPos[pos] = i
+			}
+			c := Comment{Body: rc.Body, CreatedAt: rc.CreatedAt}
+			c.Author.Login = rc.User.Login
+			threads[i].Comments = append(threads[i].Comments, c)
+		}
+	}
+	sort.SliceStable(conversation.Comments, func(i, j int) bool {
+		return conversation.Comments[i].CreatedAt < conversation.Comments[j].CreatedAt
+	})
+	for _, th := range threads {
+		sort.SliceStable(th.Comments, func(i, j int) bool { return th.Comments[i].CreatedAt < th.Comments[j].CreatedAt })
+	}
+	return append([]ReviewThread{conversation}, threads...), nil
+}
+
+// Reply answers an inline thread with a single-comment review on the same line.
+func (g *giteaForge) Reply(ctx context.Context, repo string, pr PR, thread ReviewThread, body string) error {
+	if thread.ID == "" {
+		return g.Comment(ctx, Issue{Repo: repo, Number: pr.Number}, body)
+	}
+	path := fmt.Sprintf("%s/pulls/%d/reviews", g.repoPath(repo), pr.Number)
+	return g.api.do(ctx, http.MethodPost, path, map[string]any{
+		"event":    "COMMENT",
+		"comments": []map[string]any{{"path": thread.Path, "body": body, "new_position": thread.Line}},
+	}, nil)
+}
+
+type giteaStatus struct {
+	Context     string `json:"context"`
+	Status      string `json:"status"` // pending, success, error, failure, warning
+	TargetURL   string `json:"target_url"`
+	Description string `json:"description"`
+}
+
+// Checks reads commit statuses, which Gitea Actions and external CI both
+// post. The list is newest first, so the first status per context wins.
+func (g *giteaForge) Checks(ctx context.Context, repo, sha string) ([]Check, error) {
+	var raw []giteaStatus
+	path := fmt.Sprintf("%s/commits/%s/statuses?limit=50", g.repoPath(repo), sha)
+	if err := g.api.do(ctx, http.MethodGet, path, nil, &raw); err != nil {
+		return nil, err
+	}
+	var checks []Check
+	seen := map[string]bool{}
+	for _, s := range raw {
+		if seen[s.Context] {
+			continue
+		}
+		seen[s.Context] = true
+		c := Check{Name: s.Context, URL: s.TargetURL, Desc: s.Description, State: checkPending}
+		switch s.Status {
+		case "success", "warning":
+			c.State = checkPassed
+		case "failure", "error":
+			c.State = checkFailed
+		}
+		checks = append(checks, c)
+	}
+	return checks, nil
+}
+
+// CheckLog returns nothing: job logs aren't part of Gitea's API, so the
+// agent gets the status description and link instead.
+func (g *giteaForge) CheckLog(context.Context, string, Check) (string, error) {
+	return "", nil
+}
+
+// React works for PR conversation comments too: they're issue comments.
+func (g *giteaForge) React(ctx context.Context, repo, commentID, reaction string) error {
+	path := fmt.Sprintf("%s/issues/comments/%s/reactions", g.repoPath(repo), commentID)
+	return g.api.do(ctx, http.MethodPost, path, map[string]string{"content": reaction}, nil)
+}
+
+// giteaEvent is an issue timeline entry; for "label" events Body is "1" when
+// the label was added.
+type giteaEvent struct {
+	Type  string     `json:"type"`
+	Body  string     `json:"body"`
+	User  giteaUser  `json:"user"`
+	Label giteaLabel `json:"label"`
+}
+
+func (g *giteaForge) Labeler(ctx context.Context, issue Issue, label string) (string, error) {
+	const limit = 50
+	var labeler string
+	for page := 1; ; page++ {
+		var events []giteaEvent
+		path := fmt.Sprintf("%s/issues/%d/timeline?limit=%d&page=%d", g.repoPath(issue.Repo), issue.Number, limit, page)
+		if err := g.api.do(ctx, http.MethodGet, path, nil, &events); err != nil {
+			return "", err
+		}
+		for _, e := range events {
+			if e.Type == "label" && e.Body == "1" && e.Label.Name == label {
+				labeler = e.User.Login
+			}
+		}
+		if len(events) < limit {
+			return labeler, nil
+		}
+	}
+}
+
+func (g *giteaForge) Permission(ctx context.Context, repo, user string) (permission, error) {
+	var raw struct {
+		Permission string `json:"permission"` // owner, admin, write, read or none
+	}
+	err := g.api.do(ctx, http.MethodGet, fmt.Sprintf("%s/collaborators/%s/permission", g.repoPath(repo), user), nil, &raw)
+	if notFound(err) {
+		return permNone, nil
+	}
+	if err != nil {
+		return permNone, err
+	}
+	if raw.Permission == "owner" {
+		return permAdmin, nil
+	}
+	return parsePermission(raw.Permission)
+}
+
+func (g *giteaForge) OrgMember(ctx context.Context, repo, user string) (bool, error) {
+	org := parseRepo(repo).owner()
+	if strings.EqualFold(org, user) {
+		return true, nil
+	}
+	err := g.api.do(ctx, http.MethodGet, fmt.Sprintf("/orgs/%s/members/%s", org, user), nil, nil)
+	if notFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (g *giteaForge) TeamMember(ctx context.Context, repo, team, user string) (bool, error) {
+	org, name, ok := strings.Cut(team, "/")
+	if !ok {
+		return false, fmt.Errorf("team %q isn't org/team-name", team)
+	}
+	var found struct {
+		Data []struct {
+			ID   int64  `json:"id"`
+			Name string `json:"name"`
+		} `json:"data"`
+	}
+	path := fmt.Sprintf("/orgs/%s/teams/search?%s", org, url.Values{"q": {name}}.Encode())
+	if err := g.api.do(ctx, http.MethodGet, path, nil, &found); err != nil {
+		return false, err
+	}
+	for _, t := range found.Data {
+		if !strings.EqualFold(t.Name, name) {
+			continue
+		}
+		err := g.api.do(ctx, http.MethodGet, fmt.Sprintf("/teams/%d/members/%s", t.ID, user), nil, nil)
+		if notFound(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	return false, fmt.Errorf("no team %q in %s", name, org)
+}
+
+func (g *giteaForge) Whoami(ctx context.Context, _ string) (string, error) {
+	var me giteaUser
+	err := g.api.do(ctx, http.MethodGet, "/user", nil, &me)
+	return me.Login, err
+}
+
+func (g *giteaForge) CloneURL(repo string) string {
+	r := parseRepo(repo)
+	return fmt.Sprintf("https://%s/%s.git", r.Host, strings.TrimSuffix(r.Path, ".git"))
+}
+
+// CloneConfig has git log in with the API token, which Gitea takes as a
+// password whatever the username.
+func (g *giteaForge) CloneConfig(string) []string {
+	if g.api.token == "" {
+		return nil
+	}
+	return []string{tokenHelper("CB_GITEA_TOKEN", "GITEA_TOKEN")}
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// githubForge drives GitHub through the gh CLI, which handles auth for us.
+type githubForge struct{}
+
+// ghRepo is the --repo argument gh expects: OWNER/REPO, or HOST/OWNER/REPO
+// for GitHub Enterprise.
+func ghRepo(spec string) string {
+	r := parseRepo(spec)
+	if r.Host == "github.com" {
+		return r.Path
+	}
+	return r.Host + "/" + r.Path
+}
+
+func (g *githubForge) ListIssues(ctx context.Context, repo, label string) ([]Issue, error) {
+	args := []string{"issue", "list", "--repo", ghRepo(repo),
+		"--json", "number,title,body,labels,url,comments,author",
+		"--limit", "50",
+	}
+	if label != "" {
+		args = append(args, "--label", label)
+	}
+
+	out, err := run(ctx, "", "gh", args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var issues []Issue
+	if err := json.Unmarshal([]byte(out), &issues); err != nil {
+		return nil, fmt.Errorf("parsing issues JSON: %w", err)
+	}
+	return issues, nil
+}
+
+func (g *githubForge) GetIssue(ctx context.Context, repo string, number int) (Issue, error) {
+	out, err := run(ctx, "", "gh", "issue", "view", strconv.Itoa(number),
+		"--repo", ghRepo(repo),
+		"--json", "number,title,body,labels,url,comments,author",
+	)
+	if err != nil {
+		return Issue{}, err
+	}
+	var issue Issue
+	if err := json.Unmarshal([]byte(out), &issue); err != nil {
+		return Issue{}, fmt.Errorf("parsing issue JSON: %w", err)
+	}
+	issue.Repo = repo
+	return issue, nil
+}
+
+func (g *githubForge) Comments(ctx context.Context, issue Issue) ([]Comment, error) {
+	out, err := run(ctx, "", "gh", "issue", "view",
+		strconv.Itoa(issue.Number),
+		"--repo", ghRepo(issue.Repo),
+		"--json", "comments",
+	)
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Comments []Comment `json:"comments"`
+	}
+	if err := json.Unmarshal([]byte(out), &result); err != nil {
+		return nil, fmt.Errorf("parsing comments JSON: %w", err)
+	}
+	return result.Comments, nil
+}
+
+func (g *githubForge) AddLabel(ctx context.Context, issue Issue, label string) error {
+	_, err := run(ctx, "", "gh", "issue", "edit",
+		strconv.Itoa(issue.Number),
+		"--repo", ghRepo(issue.Repo),
+		"--add-label", label,
+	)
+	return err
+}
+
+func (g *githubForge) RemoveLabel(ctx context.Context, issue Issue, label string) error {
+	_, err := run(ctx, "", "gh", "issue", "edit",
+		strconv.Itoa(issue.Number),
+		"--repo", ghRepo(issue.Repo),
+		"--remove-label", label,
+	)
+	return err
+}
+
+func (g *githubForge) Comment(ctx context.Context, issue Issue, body string) error {
+	_, err := run(ctx, "", "gh", "issue", "comment",
+		strconv.Itoa(issue.Number),
+		"--repo", ghRepo(issue.Repo),
+		"--body", body,
+	)
+	return err
+}
+
+// EnsureLabel relies on gh label create failing when the label already exists.
+func (g *githubForge) EnsureLabel(ctx context.Context, repo, name, color, desc string) (bool, error) {
+	if _, err := run(ctx, "", "gh", "label", "create", name,
+		"--repo", ghRepo(repo),
+		"--color", color,
+		"--description", desc,
+	); err != nil {
+		return false, nil // Label already exists — fine
+	}
+	return true, nil
+}
+
+func (g *githubForge) FindPR(ctx context.Context, repo, branch string) (string, error) {
+	out, err := run(ctx, "", "gh", "pr", "list",
+		"--repo", ghRepo(repo),
+		"--head", branch,
+		"--json", "url",
+		"--limit", "1",
+	)
+	if err != nil {
+		return "", err
+	}
+	var prs []struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal([]byte(out), &prs); err != nil {
+		return "", fmt.Errorf("parsing PR JSON: %w", err)
+	}
+	if len(prs) == 0 {
+		return "", nil
+	}
+	return prs[0].URL, nil
+}
+
+func (g *githubForge) CreatePR(ctx context.Context, repo string, pr PullRequest) (string, error) {
+	out, err := run(ctx, "", "gh", "pr", "create",
+		"--repo", ghRepo(repo),
+		"--title", pr.Title,
+		"--body", pr.Body,
+		"--head", pr.Head,
+		"--base", pr.Base,
+	)
+	if err != nil {
+		return "", err
+	}
+	url := strings.TrimSpace(out)
+	if len(pr.Reviewers) > 0 {
+		if _, err := run(ctx, "", "gh", "pr", "edit", url, "--add-reviewer", strings.Join(pr.Reviewers, ",")); err != nil {
+			logger(ctx).Warn("couldn't request reviewers", "pr", url, "reviewers", pr.Reviewers, "err", err)
+		}
+	}
+	return url, nil
+}
+
+func (g *githubForge) ListPRs(ctx context.Context, repo string) ([]PR, error) {
+	out, err := run(ctx, "", "gh", "pr", "list",
+		"--repo", ghRepo(repo),
+		"--json", "number,url,title,body,headRefName",
+		"--limit", "50",
+	)
+	if err != nil {
+		return nil, err
+	}
+	var raw []struct {
+		Number      int    `json:"number"`
+		URL         string `json:"url"`
+		Title       string `json:"title"`
+		Body        string `json:"body"`
+		HeadRefName string `json:"headRefName"`
+	}
+	if err := json.Unmarshal([]byte(out), &raw); err != nil {
+		return nil, fmt.Errorf("parsing PR JSON: %w", err)
+	}
+	prs := make([]PR, 0, len(raw))
+	for _, r := range raw {
+		prs = append(prs, PR{Number: r.Number, URL: r.URL, Title: r.Title, Body: r.Body, Branch: r.HeadRefName})
+	}
+	return prs, nil
+}
+
+// ghAPI calls the REST API of the repo's host through gh.
+func ghAPI(ctx context.Context, repo string, args ...string) (string, error) {
+	return run(ctx, "", "gh", append([]string{"api", "--hostname", parseRepo(repo).Host}, args...)...)
+}
+
+type ghReviewComment struct {
+	ID           int64  `json:"id"`
+	InReplyTo    int64  `json:"in_reply_to_id"`
+	Path         string `json:"path"`
+	Line         int    `json:"line"`
+	OriginalLine int    `json:"original_line"` // for comments on outdated diffs
+	Body         string `json:"body"`
+	User         struct {
+		Login string `json:"login"`
+	} `json:"user"`
+	CreatedAt string `json:"created_at"`
+}
+
+// ReviewThreads groups inline comments by the comment that started the
+// thread. Review summaries join the top-level conversation.
+func (g *githubForge) ReviewThreads(ctx context.Context, repo string, pr PR) ([]ReviewThread, error) {
+	out, err := run(ctx, "", "gh", "pr", "view",
+		strconv.Itoa(pr.Number),
+		"--repo", ghRepo(repo),
+		"--json", "comments,reviews",
+	)
+	if err != nil {
+		return nil, err
+	}
+	var top struct {
+		Comments []Comment `json:"comments"`
+		Reviews  []struct {
+			Author struct {
+				Login string `json:"login"`
+			} `json:"author"`
+			Body        string `json:"body"`
+			SubmittedAt string `json:"submittedAt"`
+		} `json:"reviews"`
+	}
+	if err := json.Unmarshal([]byte(out), &top); err != nil {
+		return nil, fmt.Errorf("parsing PR comments JSON: %w", err)
+	}
+	conversation := ReviewThread{Comments: top.Comments}
+	for _, r := range top.Reviews {
+		if strings.TrimSpace(r.Body) == "" {
+			continue
+		}
+		c := Comment{Body: r.Body, CreatedAt: r.SubmittedAt}
+		c.Author.Login = r.Author.Login
+		conversation.Comments = append(conversation.Comments, c)
+	}
+	sort.SliceStable(conversation.Comments, func(i, j int) bool {
+		return conversation.Comments[i].CreatedAt < conversation.Comments[j].CreatedAt
+	})
+
+	out, err = ghAPI(ctx, repo, fmt.Sprintf("repos/%s/pulls/%d/comments?per_page=100", parseRepo(repo).Path, pr.Number))
+	if err != nil {
+		return nil, err
+	}
+	var raw []ghReviewComment
+	if err := json.Unmarshal([]byte(out), &raw); err != nil {
+		return nil, fmt.Errorf("parsing review comments JSON: %w", err)
+	}
+
+	threads := []ReviewThread{conversation}
+	byRoot := map[int64]int{} // root comment id → index in threads
+	for _, rc := range raw {
+		root := rc.ID
+		if rc.InReplyTo != 0 {
+			root = rc.InReplyTo
+		}
+		i, ok := byRoot[root]
+		if !ok {
+			line := rc.Line
+			if line == 0 {
+				line = rc.OriginalLine
+			}
+			threads = append(threads, ReviewThread{ID: strconv.FormatInt(root, 10), Path: rc.Path, Line: line})
+			i = len(threads) - 1
+			byRoot[root] = i
+		}
+		c := Comment{Body: rc.Body, CreatedAt: rc.CreatedAt}
+		c.Author.Login = rc.User.Login
+		threads[i].Comments = append(threads[i].Comments, c)
+	}
+	return threads, nil
+}
+
+func (g *githubForge) Reply(ctx context.Context, repo string, pr PR, thread ReviewThread, body string) error {
+	if thread.ID == "" {
+		_, err := run(ctx, "", "gh", "pr", "comment",
+			strconv.Itoa(pr.Number),
+			"--repo", ghRepo(repo),
+			"--body", body,
+		)
+		return err
+	}
+	_, err := ghAPI(ctx, repo, "--method", "POST",
+		fmt.Sprintf("repos/%s/pulls/%d/comments/%s/replies", parseRepo(repo).Path, pr.Number, thread.ID),
+		"-f", "body="+body,
+	)
+	return err
+}
+
+type ghCheckRun struct {
+	ID         int64  `json:"id"`
+	Name       string `json:"name"`
+	Status     string `json:"status"`     // queued, in_progress, completed
+	Conclusion string `json:"conclusion"` // set once completed
+	HTMLURL    string `json:"html_url"`
+	Output     struct {
+		Title string `json:"title"`
+	} `json:"output"`
+	App struct {
+		Slug string `json:"slug"`
+	} `json:"app"`
+}
+
+// Checks merges check runs (GitHub Actions and other apps) with legacy commit
+// statuses, which external CI services still use.
+func (g *githubForge) Checks(ctx context.Context, repo, sha string) ([]Check, error) {
+	commit := fmt.Sprintf("repos/%s/commits/%s", parseRepo(repo).Path, sha)
+	out, err := ghAPI(ctx, repo, commit+"/check-runs?per_page=100")
+	if err != nil {
+		return nil, err
+	}
+	var runs struct {
+		CheckRuns []ghCheckRun `json:"check_runs"`
+	}
+	if err := json.Unmarshal([]byte(out), &runs); err != nil {
+		return nil, fmt.Errorf("parsing check runs JSON: %w", err)
+	}
+	var checks []Check
+	for _, r := range runs.CheckRuns {
+		c := Check{Name: r.Name, URL: r.HTMLURL, Desc: r.Output.Title, State: checkPending}
+		if r.Status == "completed" {
+			switch r.Conclusion {
+			case "success", "neutral", "skipped":
+				c.State = checkPassed
+			default: // failure, cancelled, timed_out, action_required, ...
+				c.State = checkFailed
+			}
+		}
+		// Only Actions jobs have logs we can fetch; a job's id is its check run's
+		if r.App.Slug == "github-actions" {
+			c.JobID = strconv.FormatInt(r.ID, 10)
+		}
+		checks = append(checks, c)
+	}
+
+	out, err = ghAPI(ctx, repo, commit+"/status")
+	if err != nil {
+		return nil, err
+	}
+	var combined struct {
+		Statuses []struct {
+			Context     string `json:"context"`
+			State       string `json:"state"` // pending, success, failure, error
+			TargetURL   string `json:"target_url"`
+			Description string `json:"description"`
+		} `json:"statuses"`
+	}
+	if err := json.Unmarshal([]byte(out), &combined); err != nil {
+		return nil, fmt.Errorf("parsing commit status JSON: %w", err)
+	}
+	for _, s := range combined.Statuses {
+		c := Check{Name: s.Context, URL: s.TargetURL, Desc: s.Description, State: checkPending}
+		switch s.State {
+		case "success":
+			c.State = checkPassed
+		case "failure", "error":
+			c.State = checkFailed
+		}
+		checks = append(checks, c)
+	}
+	return checks, nil
+}
+
+func (g *githubForge) CheckLog(ctx context.Context, repo string, check Check) (string, error) {
+	if check.JobID == "" {
+		return "", nil
+	}
+	return ghAPI(ctx, repo, fmt.Sprintf("repos/%s/actions/jobs/%s/logs", parseRepo(repo).Path, check.JobID))
+}
+
+// ghReactions maps reactions to GraphQL ReactionContent values. Comment ids
+// from gh are GraphQL node ids, so one mutation covers issue and PR comments.
+var ghReactions = map[string]string{reactionOK: "THUMBS_UP", reactionConfused: "CONFUSED"}
+
+func (g *githubForge) React(ctx context.Context, repo, commentID, reaction string) error {
+	_, err := ghAPI(ctx, repo, "graphql",
+		"-f", "query=mutation($id: ID!, $content: ReactionContent!) { addReaction(input: {subjectId: $id, content: $content}) { clientMutationId } }",
+		"-f", "id="+commentID,
+		"-f", "content="+ghReactions[reaction],
+	)
+	return err
+}
+
+// ghNotFound reports whether a gh api call failed with a 404.
+func ghNotFound(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "HTTP 404")
+}
+
+func (g *githubForge) Labeler(ctx context.Context, issue Issue, label string) (string, error) {
+	out, err := ghAPI(ctx, issue.Repo, "--paginate",
+		fmt.Sprintf("repos/%s/issues/%d/events?per_page=100", parseRepo(issue.Repo).Path, issue.Number),
+		"--jq", fmt.Sprintf(`.[] | select(.event == "labeled" and .label.name == %q) | .actor.login`, label))
+	if err != nil {
+		return "", err
+	}
+	logins := strings.Fields(out)
+	if len(logins) == 0 {
+		return "", nil
+	}
+	return logins[len(logins)-1], nil
+}
+
+func (g *githubForge) Permission(ctx context.Context, repo, user string) (permission, error) {
+	out, err := ghAPI(ctx, repo, fmt.Sprintf("repos/%s/collaborators/%s/permission", parseRepo(repo).Path, user))
+	if ghNotFound(err) {
+		return permNone, nil
+	}
+	if err != nil {
+		return permNone, err
+	}
+	var raw struct {
+		Permission string `json:"permission"` // admin, write, read or none
+		RoleName   string `json:"role_name"`  // adds triage and maintain
+	}
+	if err := json.Unmarshal([]byte(out), &raw); err != nil {
+		return permNone, fmt.Errorf("parsing permission: %w", err)
+	}
+	if p, err := parsePermission(raw.RoleName); err == nil {
+		return p, nil
+	}
+	return parsePermission(raw.Permission)
+}
+
+func (g *githubForge) OrgMember(ctx context.Context, repo, user string) (bool, error) {
+	org := parseRepo(repo).owner()
+	if strings.EqualFold(org, user) {
+		return true, nil
+	}
+	// 204 for members, 404 for non-members and for personal accounts
+	_, err := ghAPI(ctx, repo, fmt.Sprintf("orgs/%s/members/%s", org, user))
+	if ghNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (g *githubForge) TeamMember(ctx context.Context, repo, team, user string) (bool, error) {
+	org, slug, ok := strings.Cut(team, "/")
+	if !ok {
+		return false, fmt.Errorf("team %q isn't org/team-slug", team)
+	}
+	out, err := ghAPI(ctx, repo, fmt.Sprintf("orgs/%s/teams/%s/memberships/%s", org, slug, user), "--jq", ".state")
+	if ghNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(out) == "active", nil // "pending" until the invitation is accepted
+}
+
+func (g *githubForge) Whoami(ctx context.Context, repo string) (string, error) {
+	out, err := ghAPI(ctx, repo, "user", "--jq", ".login")
+	return strings.TrimSpace(out), err
+}
+
+func (g *githubForge) CloneURL(repo string) string {
+	r := parseRepo(repo)
+	return fmt.Sprintf("https://%s/%s.git", r.Host, r.Path)
+}
+
+// CloneConfig is empty: gh sets up git's credentials for GitHub.
+func (g *githubForge) CloneConfig(string) []string { return nil }
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// gitlabForge drives GitLab (gitlab.com or self-managed) through REST API v4.
+// Auth: CB_GITLAB_TOKEN (or GITLAB_TOKEN), sent as PRIVATE-TOKEN.
+type gitlabForge struct {
+	host string
+	api  *restClient
+}
+
+func newGitLabForge(host, token string) *gitlabForge {
+	return &gitlabForge{
+		host: host,
+		api:  &restClient{base: "https://" + host + "/api/v4", header: "PRIVATE-TOKEN", token: token},
+	}
+}
+
+type gitlabUser struct {
+	Username string `json:"username"`
+}
+
+type gitlabIssue struct {
+	IID    int        `json:"iid"`
+	Title  string     `json:"title"`
+	Desc   string     `json:"description"`
+	WebURL string     `json:"web_url"`
+	Labels []string   `json:"labels"`
+	Author gitlabUser `json:"author"`
+	Notes  int        `json:"user_notes_count"`
+}
+
+type gitlabNote struct {
+	ID        int64      `json:"id"`
+	Body      string     `json:"body"`
+	Author    gitlabUser `json:"author"`
+	CreatedAt string     `json:"created_at"`
+	System    bool       `json:"system"`
+}
+
+// projectPath is the URL-encoded project path GitLab accepts in place of an id.
+func (g *gitlabForge) projectPath(repo string) string {
+	return "/projects/" + url.PathEscape(parseRepo(repo).Path)
+}
+
+func (g *gitlabForge) ListIssues(ctx context.Context, repo, label string) ([]Issue, error) {
+	q := url.Values{"state": {"opened"}, "per_page": {"50"}}
+	if label != "" {
+		q.Set("labels", label)
+	}
+	raw, err := getAll[gitlabIssue](ctx, g.api, g.projectPath(repo)+"/issues?"+q.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	issues := make([]Issue, 0, len(raw))
+	for _, ri := range raw {
+		issue, err := g.issue(ctx, repo, ri)
+		if err != nil {
+			return nil, err
+		}
+		issues = append(issues, issue)
+	}
+	return issues, nil
+}
+
+func (g *gitlabForge) GetIssue(ctx context.Context, repo string, number int) (Issue, error) {
+	var ri gitlabIssue
+	if err := g.api.do(ctx, http.MethodGet, fmt.Sprintf("%s/issues/%d", g.projectPath(repo), number), nil, &ri); err != nil {
+		return Issue{}, err
+	}
+	return g.issue(ctx, repo, ri)
+}
+
+// issue converts an API issue, fetching its notes when it has any.
+func (g *gitlabForge) issue(ctx context.Context, repo string, ri gitlabIssue) (Issue, error) {
+	issue := Issue{Number: ri.IID, Title: ri.Title, Body: ri.Desc, URL: ri.WebURL, Repo: repo}
+	issue.Author.Login = ri.Author.Username
+	for _, l := range ri.Labels {
+		issue.Labels = append(issue.Labels, Label{Name: l})
+	}
+	if ri.Notes > 0 {
+		comments, err := g.Comments(ctx, issue)
+		if err != nil {
+			return Issue{}, err
+		}
+		issue.Comments = comments
+	}
+	return issue, nil
+}
+
+// Comments returns user notes only; system notes ("added label ...") are skipped.
+func (g *gitlabForge) Comments(ctx context.Context, issue Issue) ([]Comment, error) {
+	path := fmt.Sprintf("%s/issues/%d/notes?sort=asc&order_by=created_at&per_page=100", g.projectPath(issue.Repo), issue.Number)
+	raw, err := getAll[gitlabNote](ctx, g.api, path)
+	if err != nil {
+		return nil, err
+	}
+	comments := make([]Comment, 0, len(raw))
+	for _, n := range raw {
+		if n.System {
+			continue
+		}
+		c := Comment{ID: fmt.Sprintf("issues/%d/notes/%d", issue.Number, n.ID), Body: n.Body, CreatedAt: n.CreatedAt}
+		c.Author.Login = n.Author.Username
+		comments = append(comments, c)
+	}
+	return comments, nil
+}
+
+func (g *gitlabForge) AddLabel(ctx context.Context, issue Issue, label string) error {
+	path := fmt.Sprintf("%s/issues/%d", g.projectPath(issue.Repo), issue.Number)
+	return g.api.do(ctx, http.MethodPut, path, map[string]string{"add_labels": label}, nil)
+}
+
+func (g *gitlabForge) RemoveLabel(ctx context.Context, issue Issue, label string) error {
+	path := fmt.Sprintf("%s/issues/%d", g.projectPath(issue.Repo), issue.Number)
+	return g.api.do(ctx, http.MethodPut, path, map[string]string{"remove_labels": label}, nil)
+}
+
+func (g *gitlabForge) Comment(ctx context.Context, issue Issue, body string) error {
+	path := fmt.Sprintf("%s/issues/%d/notes", g.projectPath(issue.Repo), issue.Number)
+	return g.api.do(ctx, http.MethodPost, path, map[string]string{"body": body}, nil)
+}
+
+// EnsureLabel treats 409 Conflict as "already exists".
+func (g *gitlabForge) EnsureLabel(ctx context.Context, repo, name, color, desc string) (bool, error) {
+	err := g.api.do(ctx, http.MethodPost, g.projectPath(repo)+"/labels",
+		map[string]string{"name": name, "color": "#" + color, "description": desc}, nil)
+	if apiErr, ok := err.(*apiError); ok && apiErr.Status == http.StatusConflict {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+type gitlabMR struct {
+	IID          int    `json:"iid"`
+	WebURL       string `json:"web_url"`
+	Title        string `json:"title"`
+	Desc         string `json:"description"`
+	SourceBranch string `json:"source_branch"`
+}
+
+func (g *gitlabForge) FindPR(ctx context.Context, repo, branch string) (string, error) {
+	q := url.Values{"state": {"opened"}, "source_branch": {branch}}
+	var mrs []gitlabMR
+	if err := g.api.do(ctx, http.MethodGet, g.projectPath(repo)+"/merge_requests?"+q.Encode(), nil, &mrs); err != nil {
+		return "", err
+	}
+	if len(mrs) == 0 {
+		return "", nil
+	}
+	return mrs[0].WebURL, nil
+}
+
+func (g *gitlabForge) CreatePR(ctx context.Context, repo string, pr PullRequest) (string, error) {
+	body := map[string]any{
+		"title":         pr.Title,
+		"description":   pr.Body,
+		"source_branch": pr.Head,
+		"target_branch": pr.Base,
+	}
+	if ids := g.userIDs(ctx, pr.Reviewers); len(ids) > 0 {
+		body["reviewer_ids"] = ids
+	}
+	var created gitlabMR
+	if err := g.api.do(ctx, http.MethodPost, g.projectPath(repo)+"/merge_requests", body, &created); err != nil {
+		return "", err
+	}
+	return created.WebURL, nil
+}
+
+// userIDs resolves usernames to GitLab user ids, skipping unknown users.
+func (g *gitlabForge) userIDs(ctx context.Context, usernames []string) []int {
+	var ids []int
+	for _, name := range usernames {
+		id, err := g.userID(ctx, name)
+		if err != nil {
+			logger(ctx).Warn("couldn't resolve reviewer", "user", name, "err", err)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// userID resolves a username to the numeric id most endpoints want.
+func (g *gitlabForge) userID(ctx context.Context, name string) (int, error) {
+	var users []struct {
+		ID int `json:"id"`
+	}
+	if err := g.api.do(ctx, http.MethodGet, "/users?"+url.Values{"username": {name}}.Encode(), nil, &users); err != nil {
+		return 0, err
+	}
+	if len(users) == 0 {
+		return 0, fmt.Errorf("no user %q", name)
+	}
+	return users[0].ID, nil
+}
+
+func (g *gitlabForge) ListPRs(ctx context.Context, repo string) ([]PR, error) {
+	raw, err := getAll[gitlabMR](ctx, g.api, g.projectPath(repo)+"/merge_requests?state=opened&per_page=50")
+	if err != nil {
+		return nil, err
+	}
+	prs := make([]PR, 0, len(raw))
+	for _, mr := range raw {
+		prs = append(prs, PR{Number: mr.IID, URL: mr.WebURL, Title: mr.Title, Body: mr.Desc, Branch: mr.SourceBranch})
+	}
+	return prs, nil
+}
+
+type gitlabDiscussion struct {
+	ID             string `json:"id"`
+	IndividualNote bool   `json:"individual_note"`
+	Notes          []struct {
+		gitlabNote
+		Resolved bool `json:"resolved"`
+		Position *struct {
+			NewPath string `json:"new_path"`
+			NewLine int    `json:"new_line"`
+			OldLine int    `json:"old_line"`
+		} `json:"position"`
+	} `json:"notes"`
+}
+
+// ReviewThreads maps MR discussions to threads. Standalone notes make up the
+// top-level conversation; system notes are skipped.
+func (g *gitlabForge) ReviewThreads(ctx context.Context, repo string, pr PR) ([]ReviewThread, error) {
+	var raw []gitlabDiscussion
+	path := fmt.Sprintf("%s/merge_requests/%d/discussions?per_page=100", g.projectPath(repo), pr.Number)
+	if err := g.api.do(ctx, http.MethodGet, path, nil, &raw); err != nil {
+		return nil, err
+	}
+	threads := []ReviewThread{{}}
+	for _, d := range raw {
+		th := &threads[0]
+		if !d.IndividualNote {
+			threads = append(threads, ReviewThread{ID: d.ID})
+			th = &threads[len(threads)-1]
+		}
+		for _, n := range d.Notes {
+			if n.System {
+				continue
+			}
+			if th.ID != "" && len(th.Comments) == 0 {
+				th.Resolved = n.Resolved
+				if p := n.Position; p != nil {
+					th.Path, th.Line = p.NewPath, p.NewLine
+					if th.Line == 0 {
+						th.Line = p.OldLine
+					}
+				}
+			}
+			c := Comment{ID: fmt.Sprintf("merge_requests/%d/notes/%d", pr.Number, n.ID), Body: n.Body, CreatedAt: n.CreatedAt}
+			c.Author.Login = n.Author.Username
+			th.Comments = append(th.Comments, c)
+		}
+	}
+	return threads, nil
+}
+
+func (g *gitlabForge) Reply(ctx context.Context, repo string, pr PR, thread ReviewThread, body string) error {
+	path := fmt.Sprintf("%s/merge_requests/%d/notes", g.projectPath(repo), pr.Number)
+	if thread.ID != "" {
+		path = fmt.Sprintf("%s/merge_requests/%d/discussions/%s/notes", g.projectPath(repo), pr.Number, thread.ID)
+	}
+	return g.api.do(ctx, http.MethodPost, path, map[string]string{"body": body}, nil)
+}
+
+type gitlabStatus struct {
+	ID           int64  `json:"id"` // the job id for pipeline jobs
+	Name         string `json:"name"`
+	Status       string `json:"status"` // created, pending, running, success, failed, canceled, skipped, manual
+	AllowFailure bool   `json:"allow_failure"`
+	TargetURL    string `json:"target_url"`
+	Description  string `json:"description"`
+}
+
+// Checks reads the commit's statuses: one per pipeline job (latest run only)
+// plus any posted by external CI. Jobs allowed to fail never block.
+func (g *gitlabForge) Checks(ctx context.Context, repo, sha string) ([]Check, error) {
+	var raw []gitlabStatus
+	path := fmt.Sprintf("%s/repository/commits/%s/statuses?per_page=100", g.projectPath(repo), sha)
+	if err := g.api.do(ctx, http.MethodGet, path, nil, &raw); err != nil {
+		return nil, err
+	}
+	checks := make([]Check, 0, len(raw))
+	for _, s := range raw {
+		c := Check{Name: s.Name, URL: s.TargetURL, Desc: s.Description, State: checkPending, JobID: strconv.FormatInt(s.ID, 10)}
+		switch s.Status {
+		case "success", "skipped", "manual":
+			c.State = checkPassed
+		case "failed", "canceled":
+			c.State = checkFailed
+			if s.AllowFailure {
+				c.State = checkPassed
+			}
+		}
+		checks = append(checks, c)
+	}
+	return checks, nil
+}
+
+func (g *gitlabForge) CheckLog(ctx context.Context, repo string, check Check) (string, error) {
+	if check.JobID == "" {
+		return "", nil
+	}
+	var trace string
+	err := g.api.do(ctx, http.MethodGet, fmt.Sprintf("%s/jobs/%s/trace", g.projectPath(repo), check.JobID), nil, &trace)
+	return trace, err
+}
+
+// glReactions maps reactions to GitLab award emoji names.
+var glReactions = map[string]string{reactionOK: "thumbsup", reactionConfused: "confused"}
+
+// React awards an emoji to a note. Note ids are relative to the project
+// ("issues/7/notes/123"), since the endpoint differs for issues and MRs.
+func (g *gitlabForge) React(ctx context.Context, repo, commentID, reaction string) error {
+	path := fmt.Sprintf("%s/%s/award_emoji", g.projectPath(repo), commentID)
+	return g.api.do(ctx, http.MethodPost, path, map[string]string{"name": glReactions[reaction]}, nil)
+}
+
+type gitlabLabelEvent struct {
+	Action string     `json:"action"` // add or remove
+	User   gitlabUser `json:"user"`
+	Label  *struct {
+		Name string `json:"name"`
+	} `json:"label"` // null once the label is deleted
+}
+
+// Labeler reads every page of label events, oldest first.
+func (g *gitlabForge) Labeler(ctx context.Context, issue Issue, label string) (string, error) {
+	path := fmt.Sprintf("%s/issues/%d/resource_label_events?per_page=100", g.projectPath(issue.Repo), issue.Number)
+	events, err := getAll[gitlabLabelEvent](ctx, g.api, path)
+	if err != nil {
+		return "", err
+	}
+	var labeler string
+	for _, e := range events {
+		if e.Action == "add" && e.Label != nil && e.Label.Name == label {
+			labeler = e.User.Username
+		}
+	}
+	return labeler, nil
+}
+
+// glAccess maps GitLab access levels onto permissions: guest, reporter,
+// developer, maintainer and owner.
+var glAccess = map[int]permission{10: permRead, 20: permTriage, 30: permWrite, 40: permMaintain, 50: permAdmin}
+
+// member returns user's access level in a project or group path, counting
+// inherited membership; 0 if they have none or the path doesn't exist.
+func (g *gitlabForge) member(ctx context.Context, path, user string) (int, error) {
+	id, err := g.userID(ctx, user)
+	if err != nil {
+		return 0, err
+	}
+	var m struct {
+		AccessLevel int `json:"access_level"`
+	}
+	err = g.api.do(ctx, http.MethodGet, fmt.Sprintf("%s/members/all/%d", path, id), nil, &m)
+	if notFound(err) {
+		return 0, nil
+	}
+	return m.AccessLevel, err
+}
+
+func (g *gitlabForge) Permission(ctx context.Context, repo, user string) (permission, error) {
+	level, err := g.member(ctx, g.projectPath(repo), user)
+	return glAccess[level], err
+}
+
+func (g *gitlabForge) OrgMember(ctx context.Context, repo, user string) (bool, error) {
+	group, _, _ := strings.Cut(parseRepo(repo).Path, "/")
+	if strings.EqualFold(group, user) {
+		return true, nil // a personal namespace
+	}
+	// A 404 for other users' namespaces, which aren't groups
+	level, err := g.member(ctx, "/groups/"+url.PathEscape(group), user)
+	return level > 0, err
+}
+
+func (g *gitlabForge) TeamMember(ctx context.Context, repo, team, user string) (bool, error) {
+	level, err := g.member(ctx, "/groups/"+url.PathEscape(team), user)
+	return level > 0, err
+}
+
+func (g *gitlabForge) Whoami(ctx context.Context, _ string) (string, error) {
+	var me gitlabUser
+	err := g.api.do(ctx, http.MethodGet, "/user", nil, &me)
+	return me.Username, err
+}
+
+func (g *gitlabForge) CloneURL(repo string) string {
+	r := parseRepo(repo)
+	return fmt.Sprintf("https://%s/%s.git", r.Host, strings.TrimSuffix(r.Path, ".git"))
+}
+
+// CloneConfig has git log in with the API token, as user oauth2.
+func (g *gitlabForge) CloneConfig(string) []string {
+	if g.api.token == "" {
+		return nil
+	}
+	return []string{tokenHelper("CB_GITLAB_TOKEN", "GITLAB_TOKEN")}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// --- Local repos ---
+// "local:/srv/git/app.git" in CB_REPOS is a git repo on this machine, bare so
+// it takes pushes. The bot clones it and pushes its branches to it as usual,
+// but there's no one to open a PR with: the pushed branch is the result, and
+// file:///srv/git/app.git#<branch> stands in for the PR's URL. There's no CI
+// to wait for or review to follow up on, and everyone counts as an admin.
+// Issues come from the repo's issue_source (see sources.go).
+
+type localForge struct{ unknownForge }
+
+// localBotLogin is who the bot is on local repos and sources.
+const localBotLogin = "claude-bot"
+
+func (localForge) branchURL(ctx context.Context, repo, branch string) (string, error) {
+	path := parseRepo(repo).Path
+	if _, err := run(ctx, "", "git", "--git-dir", path, "rev-parse", "--verify", "--quiet", "refs/heads/"+branch); err != nil {
+		return "", err
+	}
+	return "file://" + path + "#" + branch, nil
+}
+
+func (f localForge) FindPR(ctx context.Context, repo, branch string) (string, error) {
+	url, err := f.branchURL(ctx, repo, branch)
+	if err != nil {
+		return "", nil // not pushed yet
+	}
+	return url, nil
+}
+
+func (f localForge) CreatePR(ctx context.Context, repo string, pr PullRequest) (string, error) {
+	url, err := f.branchURL(ctx, repo, pr.Head)
+	if err != nil {
+		return "", fmt.Errorf("branch %s isn't in %s", pr.Head, parseRepo(repo).Path)
+	}
+	return url, nil
+}
+
+func (localForge) ListPRs(context.Context, string) ([]PR, error) { return nil, nil }
+func (localForge) ReviewThreads(context.Context, string, PR) ([]ReviewThread, error) {
+	return nil, nil
+}
+func (localForge) Reply(context.Context, string, PR, ReviewThread, string) error { return nil }
+func (localForge) Checks(context.Context, string, string) ([]Check, error)       { return nil, nil }
+func (localForge) CheckLog(context.Context, string, Check) (string, error)       { return "", nil }
+func (localForge) Permission(context.Context, string, string) (permission, error) {
+	return permAdmin, nil
+}
+func (localForge) OrgMember(context.Context, string, string) (bool, error)          { return true, nil }
+func (localForge) TeamMember(context.Context, string, string, string) (bool, error) { return true, nil }
+func (localForge) Whoami(context.Context, string) (string, error)                   { return localBotLogin, nil }
+func (localForge) CloneURL(repo string) string                                      { return parseRepo(repo).Path }
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseRepo(t *testing.T) {
+	tests := []struct {
+		spec string
+		want repoRef
+	}{
+		{"owner/repo", repoRef{"github", "github.com", "owner/repo"}},
+		{"github:owner/repo", repoRef{"github", "github.com", "owner/repo"}},
+		{"github:ghe.corp/owner/repo", repoRef{"github", "ghe.corp", "owner/repo"}},
+		{"gitea:git.example.com/owner/repo", repoRef{"gitea", "git.example.com", "owner/repo"}},
+		{"forgejo:codeberg.org/owner/repo", repoRef{"gitea", "codeberg.org", "owner/repo"}},
+		{"gitlab:gitlab.com/group/sub/project", repoRef{"gitlab", "gitlab.com", "group/sub/project"}},
+	}
+	for _, tt := range tests {
+		if got := parseRepo(tt.spec); got != tt.want {
+			t.Errorf("parseRepo(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+
+	r := parseRepo("gitlab:gitlab.com/group/sub/project")
+	if r.owner() != "group/sub" || r.name() != "project" {
+		t.Errorf("owner/name = %q/%q", r.owner(), r.name())
+	}
+}
+
+func TestRepoLocalDir(t *testing.T) {
+	if got := repoLocalDir("/base", "owner/repo"); got != filepath.FromSlash("/base/owner/repo") {
+		t.Errorf("github dir = %q", got)
+	}
+	if got := repoLocalDir("/base", "gitea:git.example.com/owner/repo"); got != filepath.FromSlash("/base/git.example.com/owner/repo") {
+		t.Errorf("gitea dir = %q", got)
+	}
+}
+
+func TestGiteaForge(t *testing.T) {
+	var added []int64
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/repos/owner/repo/issues", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("labels") != "todo" {
+			t.Errorf("labels filter = %q", r.URL.Query().Get("labels"))
+		}
+		if r.Header.Get("Authorization") != "token secret" {
+			t.Errorf("auth header = %q", r.Header.Get("Authorization"))
+		}
+		json.NewEncoder(w).Encode([]map[string]any{{
+			"number": 7, "title": "Fix it", "body": "broken", "html_url": "https://git/7",
+			"labels": []map[string]any{{"id": 1, "name": "todo"}},
+			"user":   map[string]any{"login": "alice"}, "comments": 1,
+		}})
+	})
+	mux.HandleFunc("GET /api/v1/repos/owner/repo/issues/7", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"number": 7, "title": "Fix it", "body": "broken", "user": {"login": "alice"}, "comments": 1}`))
+	})
+	mux.HandleFunc("GET /api/v1/repos/owner/repo/issues/7/comments", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]map[string]any{{"id": 11, "body": "more info", "user": map[string]any{"login": "bob"}}})
+	})
+	var reaction string
+	mux.HandleFunc("POST /api/v1/repos/owner/repo/issues/comments/11/reactions", func(w http.ResponseWriter, r *http.Request) {
+		var body struct{ Content string }
+		json.NewDecoder(r.Body).Decode(&body)
+		reaction = body.Content
+		w.Write([]byte("{}"))
+	})
+	mux.HandleFunc("GET /api/v1/repos/owner/repo/labels", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]map[string]any{{"id": 1, "name": "todo"}, {"id": 2, "name": "in-progress"}})
+	})
+	mux.HandleFunc("POST /api/v1/repos/owner/repo/issues/7/labels", func(w http.ResponseWriter, r *http.Request) {
+		var body struct{ Labels []int64 }
+		json.NewDecoder(r.Body).Decode(&body)
+		added = append(added, body.Labels...)
+		w.Write([]byte("[]"))
+	})
+	// The bot's PR is on the second page
+	mux.HandleFunc("GET /api/v1/repos/owner/repo/pulls", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "2" {
+			w.Write([]byte(`[{"number": 2, "html_url": "https://git/pulls/2", "head": {"ref": "issue-7-fix"}}]`))
+			return
+		}
+		w.Header().Set("Link", `<https://git.example.com/api/v1/repos/owner/repo/pulls?limit=50&page=2&state=open>; rel="next", <https://git.example.com/api/v1/repos/owner/repo/pulls?limit=50&page=2&state=open>; rel="last"`)
+		w.Write([]byte(`[{"number": 1, "html_url": "https://git/pulls/1", "head": {"ref": "other"}}]`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	g := newGiteaForge("git.example.com", "secret")
+	g.api.base = srv.URL + "/api/v1"
+	ctx := context.Background()
+	repo := "gitea:git.example.com/owner/repo"
+
+	if url, err := g.FindPR(ctx, repo, "issue-7-fix"); err != nil || url != "https://git/pulls/2" {
+		t.Errorf("FindPR = %q, %v", url, err)
+	}
+
+	issues, err := g.ListIssues(ctx, repo, "todo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues) != 1 || issues[0].Author.Login != "alice" || !issues[0].hasLabel("todo") {
+		t.Fatalf("issues = %+v", issues)
+	}
+	if len(issues[0].Comments) != 1 || issues[0].Comments[0].Author.Login != "bob" || issues[0].Comments[0].ID != "11" {
+		t.Fatalf("comments = %+v", issues[0].Comments)
+	}
+	if one, err := g.GetIssue(ctx, repo, 7); err != nil || one.Title != "Fix it" || one.Repo != repo || len(one.Comments) != 1 {
+		t.Errorf("GetIssue = %+v, %v", one, err)
+	}
+	if err := g.React(ctx, repo, issues[0].Comments[0].ID, reactionOK); err != nil || reaction != "+1" {
+		t.Errorf("React = %v, reaction %q", err, reaction)
+	}
+
+	if err := g.AddLabel(ctx, issues[0], "in-progress"); err != nil {
+		t.Fatal(err)
+	}
+	if len(added) != 1 || added[0] != 2 {
+		t.Errorf("added label ids = %v, want [2]", added)
+	}
+	if err := g.AddLabel(ctx, issues[0], "missing"); err == nil {
+		t.Error("unknown label should fail")
+	}
+}
+
+func TestGitLabForge(t *testing.T) {
+	var created map[string]string
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v4/projects/{id}/merge_requests", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("id") != "group/project" {
+			t.Errorf("project id = %q", r.PathValue("id"))
+		}
+		w.Write([]byte("[]"))
+	})
+	mux.HandleFunc("POST /api/v4/projects/{id}/merge_requests", func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&created)
+		w.Write([]byte(`{"web_url": "https://gitlab/mr/1"}`))
+	})
+	// A system note, then bob's on the next page
+	mux.HandleFunc("GET /api/v4/projects/{id}/issues/3/notes", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "2" {
+			w.Write([]byte(`[{"id": 12, "body": "/bot retry", "author": {"username": "bob"}}]`))
+			return
+		}
+		w.Header().Set("Link", `<https://gitlab.example.com/api/v4/projects/group%2Fproject/issues/3/notes?page=2&per_page=100>; rel="next"`)
+		w.Write([]byte(`[{"id": 11, "body": "added ~todo label", "system": true}]`))
+	})
+	mux.HandleFunc("POST /api/v4/projects/{id}/labels", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"message": "Label already exists"}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	g := newGitLabForge("gitlab.example.com", "secret")
+	g.api.base = srv.URL + "/api/v4"
+	ctx := context.Background()
+	repo := "gitlab:gitlab.example.com/group/project"
+
+	if url, err := g.FindPR(ctx, repo, "issue-1-fix"); err != nil || url != "" {
+		t.Fatalf("FindPR = %q, %v", url, err)
+	}
+	url, err := g.CreatePR(ctx, repo, PullRequest{Title: "fix", Head: "issue-1-fix", Base: "main"})
+	if err != nil || url != "https://gitlab/mr/1" {
+		t.Fatalf("CreatePR = %q, %v", url, err)
+	}
+	if created["source_branch"] != "issue-1-fix" || created["target_branch"] != "main" {
+		t.Errorf("created = %v", created)
+	}
+
+	if made, err := g.EnsureLabel(ctx, repo, "todo", "0E8A16", "ready"); err != nil || made {
+		t.Errorf("EnsureLabel on existing label = %v, %v", made, err)
+	}
+	if comments, err := g.Comments(ctx, Issue{Repo: repo, Number: 3}); err != nil || len(comments) != 1 ||
+		comments[0].ID != "issues/3/notes/12" || comments[0].Author.Login != "bob" {
+		t.Errorf("Comments = %+v, %v", comments, err)
+	}
+}
+
+func TestCloneConfig(t *testing.T) {
+	if cfg := newGitLabForge("gitlab.example.com", "").CloneConfig(""); cfg != nil {
+		t.Errorf("without a token = %q", cfg)
+	}
+	cfg := newGiteaForge("git.example.com", "secret").CloneConfig("")
+	if len(cfg) != 1 {
+		t.Fatalf("CloneConfig = %q", cfg)
+	}
+	// git gets the token from the environment when it asks
+	t.Setenv("GITEA_TOKEN", "fallback")
+	t.Setenv("CB_GITEA_TOKEN", "from-env")
+	cmd := exec.Command("git", "-c", "credential.helper=", "-c", cfg[0], "credential", "fill")
+	cmd.Stdin = strings.NewReader("protocol=https\nhost=git.example.com\n\n")
+	out, err := cmd.Output()
+	if err != nil || !strings.Contains(string(out), "username=oauth2\npassword=from-env\n") {
+		t.Errorf("git credential fill = %q, %v", out, err)
+	}
+}
+
+func TestGitLabReviewThreads(t *testing.T) {
+	var replied []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v4/projects/{id}/merge_requests/5/discussions", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[
+			{"id": "aaa", "individual_note": true, "notes": [{"id": 31, "body": "@claude-bot bump the version", "author": {"username": "bob"}}]},
+			{"id": "bbb", "individual_note": true, "notes": [{"body": "added 1 commit", "system": true}]},
+			{"id": "ccc", "individual_note": false, "notes": [
+				{"body": "off by one", "author": {"username": "alice"}, "resolved": false, "position": {"new_path": "main.go", "new_line": 12}},
+				{"body": "agreed", "author": {"username": "bob"}}
+			]},
+			{"id": "ddd", "individual_note": false, "notes": [
+				{"body": "old nit", "author": {"username": "alice"}, "resolved": true, "position": {"new_path": "gone.go", "new_line": 0, "old_line": 4}}
+			]}
+		]`))
+	})
+	mux.HandleFunc("POST /api/v4/projects/{id}/merge_requests/5/{rest...}", func(w http.ResponseWriter, r *http.Request) {
+		replied = append(replied, r.PathValue("rest"))
+		w.Write([]byte(`{}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	g := newGitLabForge("gitlab.example.com", "secret")
+	g.api.base = srv.URL + "/api/v4"
+	ctx := context.Background()
+	repo := "gitlab:gitlab.example.com/group/project"
+	pr := PR{Number: 5}
+
+	threads, err := g.ReviewThreads(ctx, repo, pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(threads) != 3 || len(threads[0].Comments) != 1 || threads[0].Comments[0].Author.Login != "bob" {
+		t.Fatalf("threads = %+v", threads)
+	}
+	if th := threads[1]; th.ID != "ccc" || th.Path != "main.go" || th.Line != 12 || th.Resolved || len(th.Comments) != 2 {
+		t.Errorf("inline thread = %+v", th)
+	}
+	if th := threads[2]; !th.Resolved || th.Line != 4 {
+		t.Errorf("resolved thread = %+v", th)
+	}
+
+	for _, th := range threads[:2] {
+		if err := g.Reply(ctx, repo, pr, th, "done"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(replied) != 2 || replied[0] != "notes" || replied[1] != "discussions/ccc/notes" {
+		t.Errorf("replies went to %v", replied)
+	}
+
+	// Note ids carry their endpoint, since issue and MR notes differ
+	if id := threads[0].Comments[0].ID; id != "merge_requests/5/notes/31" {
+		t.Fatalf("note id = %q", id)
+	}
+	if err := g.React(ctx, repo, threads[0].Comments[0].ID, reactionOK); err != nil || replied[2] != "notes/31/award_emoji" {
+		t.Errorf("React = %v, posted to %v", err, replied)
+	}
+}
+
+func TestGitLabChecks(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v4/projects/{id}/repository/commits/abc/statuses", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[
+			{"id": 31, "name": "test", "status": "failed"},
+			{"id": 32, "name": "flaky", "status": "failed", "allow_failure": true},
+			{"id": 33, "name": "deploy", "status": "manual"},
+			{"id": 34, "name": "build", "status": "running"}
+		]`))
+	})
+	mux.HandleFunc("GET /api/v4/projects/{id}/jobs/31/trace", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("$ go test ./...\nFAIL\n"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	g := newGitLabForge("gitlab.example.com", "secret")
+	g.api.base = srv.URL + "/api/v4"
+	ctx := context.Background()
+	repo := "gitlab:gitlab.example.com/group/project"
+
+	checks, err := g.Checks(ctx, repo, "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{checkFailed, checkPassed, checkPassed, checkPending}
+	if len(checks) != len(want) {
+		t.Fatalf("checks = %+v", checks)
+	}
+	for i, c := range checks {
+		if c.State != want[i] {
+			t.Errorf("%s = %s, want %s", c.Name, c.State, want[i])
+		}
+	}
+	// Job logs are plain text, not JSON
+	if log, err := g.CheckLog(ctx, repo, checks[0]); err != nil || log != "$ go test ./...\nFAIL\n" {
+		t.Errorf("CheckLog = %q, %v", log, err)
+	}
+}
+
+func TestGiteaPolicyLookups(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/repos/acme/repo/issues/7/timeline", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]map[string]any{
+			{"type": "label", "body": "1", "user": map[string]any{"login": "alice"}, "label": map[string]any{"name": "todo"}},
+			{"type": "comment", "body": "hi", "user": map[string]any{"login": "bob"}},
+			{"type": "label", "body": "0", "user": map[string]any{"login": "carol"}, "label": map[string]any{"name": "todo"}},
+			{"type": "label", "body": "1", "user": map[string]any{"login": "dave"}, "label": map[string]any{"name": "bug"}},
+		})
+	})
+	mux.HandleFunc("GET /api/v1/repos/acme/repo/collaborators/{user}/permission", func(w http.ResponseWriter, r *http.Request) {
+		switch r.PathValue("user") {
+		case "alice":
+			w.Write([]byte(`{"permission": "owner"}`))
+		case "bob":
+			w.Write([]byte(`{"permission": "write"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	mux.HandleFunc("GET /api/v1/orgs/acme/members/{user}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("user") != "bob" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /api/v1/orgs/acme/teams/search", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok": true, "data": [{"id": 5, "name": "Maintainers"}]}`))
+	})
+	mux.HandleFunc("GET /api/v1/teams/5/members/{user}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("user") != "bob" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"login": "bob"}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	g := newGiteaForge("git.example.com", "secret")
+	g.api.base = srv.URL + "/api/v1"
+	ctx := context.Background()
+	repo := "gitea:git.example.com/acme/repo"
+
+	if who, err := g.Labeler(ctx, Issue{Repo: repo, Number: 7}, "todo"); err != nil || who != "alice" {
+		t.Errorf("Labeler = %q, %v", who, err)
+	}
+	for user, want := range map[string]permission{"alice": permAdmin, "bob": permWrite, "mallory": permNone} {
+		if p, err := g.Permission(ctx, repo, user); err != nil || p != want {
+			t.Errorf("Permission(%s) = %v, %v; want %v", user, p, err, want)
+		}
+	}
+	for user, want := range map[string]bool{"bob": true, "acme": true, "mallory": false} {
+		if in, err := g.OrgMember(ctx, repo, user); err != nil || in != want {
+			t.Errorf("OrgMember(%s) = %v, %v", user, in, err)
+		}
+	}
+	for user, want := range map[string]bool{"bob": true, "mallory": false} {
+		if in, err := g.TeamMember(ctx, repo, "acme/maintainers", user); err != nil || in != want {
+			t.Errorf("TeamMember(%s) = %v, %v", user, in, err)
+		}
+	}
+}
+
+func TestGitLabPolicyLookups(t *testing.T) {
+	ids := map[string]int{"alice": 1, "bob": 2, "mallory": 3}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v4/users", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]map[string]any{{"id": ids[r.URL.Query().Get("username")]}})
+	})
+	// mallory's add on the second page is the latest
+	mux.HandleFunc("GET /api/v4/projects/{id}/issues/7/resource_label_events", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "2" {
+			w.Write([]byte(`[{"action": "add", "user": {"username": "mallory"}, "label": {"name": "todo"}}]`))
+			return
+		}
+		w.Header().Set("Link", `<https://gitlab.example.com/api/v4/projects/group%2Fsub%2Fproject/issues/7/resource_label_events?page=2&per_page=100>; rel="next"`)
+		w.Write([]byte(`[
+			{"action": "add", "user": {"username": "alice"}, "label": {"name": "todo"}},
+			{"action": "remove", "user": {"username": "bob"}, "label": {"name": "todo"}},
+			{"action": "add", "user": {"username": "bob"}, "label": null}
+		]`))
+	})
+	// alice maintains the project; bob is a developer in the top-level group
+	mux.HandleFunc("GET /api/v4/projects/{id}/members/all/1", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("id") != "group/sub/project" {
+			t.Errorf("project id = %q", r.PathValue("id"))
+		}
+		w.Write([]byte(`{"access_level": 40}`))
+	})
+	mux.HandleFunc("GET /api/v4/projects/{id}/members/all/{uid}", http.NotFound)
+	mux.HandleFunc("GET /api/v4/groups/group/members/all/2", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"access_level": 30}`))
+	})
+	mux.HandleFunc("GET /api/v4/groups/{group}/members/all/{uid}", http.NotFound)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	g := newGitLabForge("gitlab.example.com", "secret")
+	g.api.base = srv.URL + "/api/v4"
+	ctx := context.Background()
+	repo := "gitlab:gitlab.example.com/group/sub/project"
+
+	if who, err := g.Labeler(ctx, Issue{Repo: repo, Number: 7}, "todo"); err != nil || who != "mallory" {
+		t.Errorf("Labeler = %q, %v", who, err)
+	}
+	for user, want := range map[string]permission{"alice": permMaintain, "mallory": permNone} {
+		if p, err := g.Permission(ctx, repo, user); err != nil || p != want {
+			t.Errorf("Permission(%s) = %v, %v; want %v", user, p, err, want)
+		}
+	}
+	for user, want := range map[string]bool{"bob": true, "mallory": false} {
+		if in, err := g.OrgMember(ctx, repo, user); err != nil || in != want {
+			t.Errorf("OrgMember(%s) = %v, %v", user, in, err)
+		}
+	}
+	if in, err := g.TeamMember(ctx, repo, "group", "bob"); err != nil || !in {
+		t.Errorf("TeamMember = %v, %v", in, err)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// --- Logging ---
+// log/slog with a text (default) or JSON handler, chosen by CB_LOG_FORMAT.
+// Jobs carry a logger in their context with the attempt's attributes (repo,
+// issue, key, branch, worker, run_id), so every line a job produces — including
+// each gh/git call in run — can be correlated in a log aggregator.
+
+type loggerKey struct{}
+
+// setupLogging installs the default slog handler. The std log package is
+// routed through it too, so stray log.Fatal calls stay structured.
+func setupLogging(format, level string) {
+	opts := &slog.HandlerOptions{Level: parseLogLevel(level)}
+	var h slog.Handler
+	if strings.EqualFold(format, "json") {
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	} else {
+		h = slog.NewTextHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(h))
+}
+
+func parseLogLevel(s string) slog.Level {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return slog.LevelInfo
+	}
+	return l
+}
+
+// withLogger returns ctx carrying l.
+func withLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// logger returns the logger carried by ctx, or the default.
+func logger(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+// issueLogger scopes the default logger to one job. processIssue adds the
+// branch once the target repo's branch_prefix is known.
+func issueLogger(issue Issue, workerID int) *slog.Logger {
+	return slog.Default().With(
+		"repo", issue.Repo,
+		"issue", issue.Number,
+		"key", issue.key(),
+		"worker", workerID,
+	)
+}
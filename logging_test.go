@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	for in, want := range map[string]slog.Level{
+		"":      slog.LevelInfo,
+		"debug": slog.LevelDebug,
+		"WARN":  slog.LevelWarn,
+		"bogus": slog.LevelInfo,
+	} {
+		if got := parseLogLevel(in); got != want {
+			t.Errorf("parseLogLevel(%q) = %v, want %v", in, got, want)
+		}
+	}
+}
+
+func TestRunLogsJobAttributes(t *testing.T) {
+	prev := slog.Default()
+	defer slog.SetDefault(prev)
+	var buf bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	issue := Issue{Repo: "owner/repo", Number: 42, Title: "Fix login"}
+	ctx := withLogger(context.Background(), issueLogger(issue, 3).With("run_id", "abc123", "branch", branchName(issue)))
+	if _, err := run(ctx, "", "git", "--version"); err != nil {
+		t.Skipf("git unavailable: %v", err)
+	}
+
+	var rec map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("decoding %q: %v", buf.String(), err)
+	}
+	want := map[string]any{
+		"msg":    "ran command",
+		"cmd":    "git",
+		"repo":   "owner/repo",
+		"issue":  float64(42),
+		"key":    "owner/repo#42",
+		"branch": "issue-42-fix-login",
+		"worker": float64(3),
+		"run_id": "abc123",
+	}
+	for k, v := range want {
+		if rec[k] != v {
+			t.Errorf("%s = %v, want %v", k, rec[k], v)
+		}
+	}
+}
+
+func TestLoggerDefault(t *testing.T) {
+	if logger(context.Background()) != slog.Default() {
+		t.Error("a context without a logger should use the default")
+	}
+}
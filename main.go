@@ -6,7 +6,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
@@ -24,46 +27,122 @@ import (
 // All env vars are prefixed with CB_ to avoid clashes with other tools.
 
 type Config struct {
-	Repos          []string
-	PollInterval   time.Duration
-	Workers        int
-	IssueLabel     string
-	WIPLabel       string
-	DoneLabel      string
-	NeedsInfoLabel string
-	FailedLabel    string
-	TriageLabel        string
-	Triage             bool
-	TriageDiscussions  bool
-	WorktreeDir    string
-	RepoDir        string
-	LogDir         string
-	MaxTurns       int
-	MaxRetries     int
+	Repos                 []string
+	PollInterval          time.Duration
+	Workers               int
+	IssueLabel            string
+	WIPLabel              string
+	DoneLabel             string
+	NeedsInfoLabel        string
+	FailedLabel           string
+	CIFailedLabel         string
+	TriageLabel           string
+	PlanLabel             string // issues carrying it get a plan to approve first (see plan.go)
+	AwaitingApprovalLabel string
+	NeedsReviewLabel      string
+	Triage                bool
+	PlanFirst             bool // every issue gets a plan to approve before any code
+	TriageDiscussions     bool
+	WorktreeDir           string
+	RepoDir               string
+	LogDir                string
+	StateDir              string
+	MaxTurns              int
+	MaxRetries            int
+	HTTPAddr              string                  // optional listener for the dashboard, metrics and webhooks (CB_HTTP_ADDR)
+	DashboardPassword     string                  // basic auth for the dashboard; required with HTTPAddr
+	WebhookSecret         string                  // GitHub webhook secret; enables /webhook
+	ReconcileInterval     time.Duration           // poll interval when webhooks are enabled
+	Agent                 string                  // default agent spec (see agent.go)
+	RepoAgents            map[string]string       // repo → agent spec override
+	AgentTimeout          time.Duration           // per-run limit for the coding agent
+	AllowedTools          []string                // tools the coding agent may use
+	DisallowedTools       []string                // tools it may not, even if allowed (see profiles.go)
+	AgentArgs             []string                // extra flags for the agent's command line
+	MCPConfig             string                  // MCP server config file for the Claude CLI; "" = none
+	Profiles              map[string]agentProfile // label → agent settings for issues carrying it
+	BaseBranch            string                  // PR base; "" = the repo's default branch
+	PromptTemplate        string                  // text/template file for the issue prompt; "" = built-in (see prompts.go)
+	PromptDir             string                  // directory of <name>.tmpl prompt overrides
+	IssueSource           string                  // where issues come from if not the forge (see sources.go)
+	JQL                   string                  // extra filter for Jira issue sources
+	MembersOnly           bool                    // only the team can write to IssueSource; its text isn't an outsider's
+	Transitions           labelNames              // label → tracker workflow status, for Jira and Linear sources
+	ConfigFile            string                  // claude-bot.toml / .json in use, if any
+	Sandbox               sandboxConfig           // confinement for agent runs; see sandbox.go
+	DailyBudgetUSD        float64                 // spend cap per UTC day, all repos; 0 = none (see budget.go)
+	RepoBudgetUSD         float64                 // spend cap per UTC day for this repo (config file only)
+	IssueBudgetUSD        float64                 // spend cap per UTC day for one issue
+	Reviews               bool                    // address review feedback on the bot's PRs (see review.go)
+	CIWait                time.Duration           // how long to wait for CI on the bot's PRs; 0 = don't (see ci.go)
+	CIFixRounds           int                     // agent runs allowed per PR to fix failing CI
+	Verify                bool                    // run the repo's checks before committing (see verify.go)
+	VerifyRounds          int                     // agent runs allowed to repair failing checks
+	VerifyTimeout         time.Duration           // limit for one run of all the checks
+	Attempts              int                     // agent runs per issue; the best gets the PR (see bestof.go)
+	CommandUsers          []string                // who may give /bot commands in comments; empty = nobody (see commands.go)
+	Model                 string                  // agent model; "" = the agent's default
+	AllowedUsers          []string                // who may queue issues by labelling them; empty = anyone (see policy.go)
+	AllowedTeams          []string                // teams (org/team-slug) whose members may queue issues
+	MinPermission         permission              // repo permission the labeler needs; permNone = any
+	OutsideApproval       bool                    // issues from outside the org wait for a "/bot approve"
+	InjectionReview       bool                    // hold issues that look like prompt injections for review (see untrusted.go)
+	OutsiderTools         []string                // tools for runs on outsiders' text; nil = AllowedTools without Bash
+
+	// Per-repo sections of the config file; see forRepo.
+	RepoOverrides map[string]repoOverride
 }
 
 func loadConfig() Config {
 	// Load .env file if present (no external deps, just parse KEY=VALUE lines)
 	loadDotEnv()
+	setupLogging(os.Getenv("CB_LOG_FORMAT"), os.Getenv("CB_LOG_LEVEL"))
 
 	cfg := Config{
-		PollInterval:   30 * time.Second,
-		Workers:        3,
-		IssueLabel:     "todo",
-		WIPLabel:       "in-progress",
-		DoneLabel:      "done",
-		NeedsInfoLabel: "needs-info",
-		FailedLabel:    "failed",
-		TriageLabel:    "triaged",
-		Triage:         false,
-		WorktreeDir:    expandHome("~/.claude-bot/trees"),
-		RepoDir:        expandHome("~/.claude-bot/repos"),
-		LogDir:         expandHome("~/.claude-bot/logs"),
-		MaxTurns:       50,
-		MaxRetries:     3,
+		PollInterval:          30 * time.Second,
+		Workers:               3,
+		IssueLabel:            "todo",
+		WIPLabel:              "in-progress",
+		DoneLabel:             "done",
+		NeedsInfoLabel:        "needs-info",
+		FailedLabel:           "failed",
+		CIFailedLabel:         "ci-failed",
+		TriageLabel:           "triaged",
+		NeedsReviewLabel:      "needs-review",
+		PlanLabel:             "bot:plan",
+		AwaitingApprovalLabel: "awaiting-approval",
+		Triage:                false,
+		WorktreeDir:           expandHome("~/.claude-bot/trees"),
+		RepoDir:               expandHome("~/.claude-bot/repos"),
+		LogDir:                expandHome("~/.claude-bot/logs"),
+		StateDir:              expandHome("~/.claude-bot/state"),
+		MaxTurns:              50,
+		MaxRetries:            3,
+		Agent:                 "claude",
+		ReconcileInterval:     10 * time.Minute,
+		AgentTimeout:          10 * time.Minute,
+		AllowedTools:          []string{"Bash", "Read", "Write", "Edit"},
+		Reviews:               true,
+		CIWait:                30 * time.Minute,
+		CIFixRounds:           2,
+		Verify:                true,
+		VerifyRounds:          2,
+		Attempts:              1,
+		VerifyTimeout:         10 * time.Minute,
+	}
+
+	// Config file sits between the defaults and the env vars
+	if path := findConfigFile(); path != "" {
+		fc, err := loadConfigFile(path)
+		if err != nil {
+			log.Fatalf("loading config: %v", err)
+		}
+		cfg.applyFile(fc)
+		cfg.ConfigFile = path
 	}
 
 	if v := os.Getenv("CB_REPOS"); v != "" {
+		cfg.Repos = nil
 		for _, r := range strings.Split(v, ",") {
 			r = strings.TrimSpace(r)
 			if r != "" {
@@ -96,6 +175,21 @@ func loadConfig() Config {
 	if v := os.Getenv("CB_FAILED_LABEL"); v != "" {
 		cfg.FailedLabel = v
 	}
+	if v := os.Getenv("CB_CI_FAILED_LABEL"); v != "" {
+		cfg.CIFailedLabel = v
+	}
+	if v := os.Getenv("CB_PLAN_LABEL"); v != "" {
+		cfg.PlanLabel = v
+	}
+	if v := os.Getenv("CB_AWAITING_APPROVAL_LABEL"); v != "" {
+		cfg.AwaitingApprovalLabel = v
+	}
+	if v := os.Getenv("CB_PLAN_FIRST"); v != "" {
+		cfg.PlanFirst = v == "1"
+	}
+	if v := os.Getenv("CB_NEEDS_REVIEW_LABEL"); v != "" {
+		cfg.NeedsReviewLabel = v
+	}
 	if v := os.Getenv("CB_WORKTREE_DIR"); v != "" {
 		cfg.WorktreeDir = expandHome(v)
 	}
@@ -105,6 +199,9 @@ func loadConfig() Config {
 	if v := os.Getenv("CB_LOG_DIR"); v != "" {
 		cfg.LogDir = expandHome(v)
 	}
+	if v := os.Getenv("CB_STATE_DIR"); v != "" {
+		cfg.StateDir = expandHome(v)
+	}
 	if v := os.Getenv("CB_MAX_TURNS"); v != "" {
 		if n, err := strconv.Atoi(v); err == nil && n > 0 {
 			cfg.MaxTurns = n
@@ -118,20 +215,209 @@ func loadConfig() Config {
 	if v := os.Getenv("CB_TRIAGE_LABEL"); v != "" {
 		cfg.TriageLabel = v
 	}
-	if os.Getenv("CB_TRIAGE") == "1" {
-		cfg.Triage = true
+	if v := os.Getenv("CB_TRIAGE"); v != "" {
+		cfg.Triage = v == "1"
+	}
+	if v := os.Getenv("CB_TRIAGE_DISCUSSIONS"); v != "" {
+		cfg.TriageDiscussions = v == "1"
+	}
+	if v := os.Getenv("CB_REVIEWS"); v != "" {
+		cfg.Reviews = v == "1"
 	}
-	if os.Getenv("CB_TRIAGE_DISCUSSIONS") == "1" {
-		cfg.TriageDiscussions = true
+	if v := os.Getenv("CB_VERIFY"); v != "" {
+		cfg.Verify = v == "1"
+	}
+	if v := os.Getenv("CB_VERIFY_ROUNDS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			cfg.VerifyRounds = n
+		}
+	}
+	if v := os.Getenv("CB_ATTEMPTS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 1 && n <= maxAttempts {
+			cfg.Attempts = n
+		}
+	}
+	if v := os.Getenv("CB_VERIFY_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			cfg.VerifyTimeout = d
+		}
+	}
+	if v := os.Getenv("CB_CI_WAIT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			cfg.CIWait = d
+		}
+	}
+	if v := os.Getenv("CB_CI_FIX_ROUNDS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			cfg.CIFixRounds = n
+		}
+	}
+	if v := os.Getenv("CB_COMMAND_USERS"); v != "" {
+		cfg.CommandUsers = splitList(v)
+	}
+	if v := os.Getenv("CB_ALLOWED_USERS"); v != "" {
+		cfg.AllowedUsers = splitList(v)
+	}
+	if v := os.Getenv("CB_ALLOWED_TEAMS"); v != "" {
+		cfg.AllowedTeams = splitList(v)
+	}
+	// Like the sandbox, a policy that's asked for must not quietly let everyone in
+	if v := os.Getenv("CB_MIN_PERMISSION"); v != "" {
+		p, err := parsePermission(v)
+		if err != nil {
+			log.Fatalf("CB_MIN_PERMISSION: %v", err)
+		}
+		cfg.MinPermission = p
+	}
+	if v := os.Getenv("CB_OUTSIDE_APPROVAL"); v != "" {
+		cfg.OutsideApproval = v == "1"
+	}
+	if v := os.Getenv("CB_INJECTION_REVIEW"); v != "" {
+		cfg.InjectionReview = v == "1"
+	}
+	if v := os.Getenv("CB_HTTP_ADDR"); v != "" {
+		cfg.HTTPAddr = v
+	}
+	cfg.WebhookSecret = os.Getenv("CB_WEBHOOK_SECRET")
+	cfg.DashboardPassword = os.Getenv("CB_DASHBOARD_PASSWORD")
+	if v := os.Getenv("CB_RECONCILE_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			cfg.ReconcileInterval = d
+		}
+	}
+	if v := os.Getenv("CB_AGENT"); v != "" {
+		if _, err := parseAgent(v); err != nil {
+			slog.Warn("ignoring CB_AGENT", "component", "config", "err", err)
+		} else {
+			cfg.Agent = v
+		}
+	}
+	// CB_REPO_AGENTS="owner/repo=shell:./agent.sh;other/repo=claude" (";"-separated,
+	// since agent commands may contain commas)
+	if v := os.Getenv("CB_REPO_AGENTS"); v != "" {
+		cfg.RepoAgents = make(map[string]string)
+		for _, entry := range strings.Split(v, ";") {
+			repo, spec, ok := strings.Cut(entry, "=")
+			repo, spec = strings.TrimSpace(repo), strings.TrimSpace(spec)
+			if !ok || repo == "" {
+				continue
+			}
+			if _, err := parseAgent(spec); err != nil {
+				slog.Warn("ignoring CB_REPO_AGENTS entry", "component", "config", "repo", repo, "err", err)
+				continue
+			}
+			cfg.RepoAgents[repo] = spec
+		}
+	}
+	if v := os.Getenv("CB_AGENT_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			cfg.AgentTimeout = d
+		}
+	}
+	if v := os.Getenv("CB_ALLOWED_TOOLS"); v != "" {
+		cfg.AllowedTools = nil
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				cfg.AllowedTools = append(cfg.AllowedTools, t)
+			}
+		}
+	}
+	if v := os.Getenv("CB_DISALLOWED_TOOLS"); v != "" {
+		cfg.DisallowedTools = splitList(v)
+	}
+	if v := os.Getenv("CB_AGENT_ARGS"); v != "" {
+		cfg.AgentArgs = strings.Fields(v)
+	}
+	if v := os.Getenv("CB_MODEL"); v != "" {
+		cfg.Model = v
+	}
+	if v := os.Getenv("CB_MCP_CONFIG"); v != "" {
+		cfg.MCPConfig = expandHome(v)
+	}
+	if v := os.Getenv("CB_OUTSIDER_TOOLS"); v != "" {
+		cfg.OutsiderTools = splitList(v)
+	}
+	if v := os.Getenv("CB_BASE_BRANCH"); v != "" {
+		cfg.BaseBranch = v
+	}
+	if v := os.Getenv("CB_PROMPT_TEMPLATE"); v != "" {
+		cfg.PromptTemplate = expandHome(v)
+	}
+	if v := os.Getenv("CB_PROMPT_DIR"); v != "" {
+		cfg.PromptDir = expandHome(v)
+	}
+	if v := os.Getenv("CB_ISSUE_SOURCE"); v != "" {
+		cfg.IssueSource = v
+	}
+	if v := os.Getenv("CB_JQL"); v != "" {
+		cfg.JQL = v
+	}
+	if v := os.Getenv("CB_MEMBERS_ONLY"); v != "" {
+		cfg.MembersOnly = v == "1"
+	}
+	if v := os.Getenv("CB_DAILY_BUDGET_USD"); v != "" {
+		if n, err := strconv.ParseFloat(v, 64); err == nil && n >= 0 {
+			cfg.DailyBudgetUSD = n
+		}
+	}
+	if v := os.Getenv("CB_ISSUE_BUDGET_USD"); v != "" {
+		if n, err := strconv.ParseFloat(v, 64); err == nil && n >= 0 {
+			cfg.IssueBudgetUSD = n
+		}
+	}
+	// A sandbox that's asked for but misconfigured must not quietly run unconfined
+	if v := os.Getenv("CB_SANDBOX"); v != "" {
+		mode, err := parseSandboxMode(v)
+		if err != nil {
+			log.Fatalf("CB_SANDBOX: %v", err)
+		}
+		cfg.Sandbox.Mode = mode
+	}
+	if v := os.Getenv("CB_SANDBOX_MEMORY"); v != "" {
+		n, err := parseSize(v)
+		if err != nil {
+			log.Fatalf("CB_SANDBOX_MEMORY: %v", err)
+		}
+		cfg.Sandbox.Memory = n
+	}
+	if v := os.Getenv("CB_SANDBOX_PIDS"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			log.Fatalf("CB_SANDBOX_PIDS: invalid count %q", v)
+		}
+		cfg.Sandbox.Pids = n
+	}
+	if v := os.Getenv("CB_SANDBOX_CPUS"); v != "" {
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil || n < 0 {
+			log.Fatalf("CB_SANDBOX_CPUS: invalid count %q", v)
+		}
+		cfg.Sandbox.CPUs = n
+	}
+	if v := os.Getenv("CB_SANDBOX_CGROUP"); v != "" {
+		cfg.Sandbox.Cgroup = v
+	}
+	if v := os.Getenv("CB_SANDBOX_RO_PATHS"); v != "" {
+		for _, p := range strings.Split(v, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				cfg.Sandbox.ReadOnly = append(cfg.Sandbox.ReadOnly, expandHome(p))
+			}
+		}
 	}
 
 	return cfg
 }
 
+// webhooksEnabled reports whether GitHub deliveries drive polling.
+func (cfg Config) webhooksEnabled() bool {
+	return cfg.HTTPAddr != "" && cfg.WebhookSecret != ""
+}
+
 // --- Types ---
 
 type Issue struct {
 	Number   int       `json:"number"`
+	Ref      string    `json:"ref,omitempty"` // the tracker's key ("APP-123") or the local task's id, for issues from an issue source
 	Title    string    `json:"title"`
 	Body     string    `json:"body"`
 	Repo     string    `json:"-"`
@@ -141,6 +427,7 @@ type Issue struct {
 	Author   struct {
 		Login string `json:"login"`
 	} `json:"author"`
+	Review *PR `json:"-"` // set on jobs that address feedback on the bot's PR for this issue
 }
 
 type Label struct {
@@ -148,6 +435,7 @@ type Label struct {
 }
 
 type Comment struct {
+	ID     string `json:"id"` // forge-specific; "" where the forge gives none (e.g. review summaries)
 	Author struct {
 		Login string `json:"login"`
 	} `json:"author"`
@@ -168,31 +456,27 @@ func (i Issue) key() string {
 	return fmt.Sprintf("%s#%d", i.Repo, i.Number)
 }
 
-// --- Tracker (in-memory dedup) ---
-
-type tracker struct {
-	mu       sync.Mutex
-	inflight map[string]bool
-}
-
-func newTracker() *tracker {
-	return &tracker{inflight: make(map[string]bool)}
-}
-
-func (t *tracker) tryAcquire(key string) bool {
-	t.mu.Lock()
-	defer t.mu.Unlock()
-	if t.inflight[key] {
-		return false
+// reference is how commits and PRs name the issue: "#12", the tracker's key
+// for issues from Jira or Linear, which link PRs that mention it, or a local
+// task's id.
+func (i Issue) reference() string {
+	if i.Ref != "" {
+		return i.Ref
 	}
-	t.inflight[key] = true
-	return true
+	return fmt.Sprintf("#%d", i.Number)
 }
 
-func (t *tracker) release(key string) {
-	t.mu.Lock()
-	defer t.mu.Unlock()
-	delete(t.inflight, key)
+// parseIssueKey splits a key ("repo#N") back into the issue it names.
+func parseIssueKey(key string) (Issue, error) {
+	i := strings.LastIndex(key, "#")
+	if i <= 0 {
+		return Issue{}, fmt.Errorf("invalid issue key %q", key)
+	}
+	n, err := strconv.Atoi(key[i+1:])
+	if err != nil || n <= 0 {
+		return Issue{}, fmt.Errorf("invalid issue number in %q", key)
+	}
+	return Issue{Repo: key[:i], Number: n}, nil
 }
 
 // --- Dependency Check ---
@@ -200,7 +484,7 @@ func (t *tracker) release(key string) {
 // checkDependencies verifies all required external tools are installed and configured.
 // With CB_AUTO_INSTALL=1, it will attempt to install missing tools automatically.
 // Idempotent: skips tools that are already installed.
-func checkDependencies() {
+func checkDependencies(cfg Config) {
 	autoInstall := os.Getenv("CB_AUTO_INSTALL") == "1"
 
 	// Detect package manager (idempotent — just reads state)
@@ -210,7 +494,7 @@ func checkDependencies() {
 		if pm == "" {
 			log.Fatal("CB_AUTO_INSTALL=1 but no supported package manager found (need brew, apt, or dnf)")
 		}
-		log.Printf("auto-install enabled, using %s", pm)
+		slog.Info("auto-install enabled", "component", "deps", "package_manager", pm)
 	}
 
 	var manual []string
@@ -232,8 +516,9 @@ func checkDependencies() {
 		}
 	}
 
-	// --- gh (GitHub CLI) ---
-	if _, err := exec.LookPath("gh"); err != nil {
+	// --- gh (GitHub CLI) — only needed when a repo is hosted on GitHub ---
+	needGH := usesGitHub(cfg.Repos)
+	if _, err := exec.LookPath("gh"); err != nil && needGH {
 		if autoInstall {
 			installPackage(pm, "gh")
 		} else {
@@ -241,7 +526,7 @@ func checkDependencies() {
 		}
 	}
 	// Verify gh auth (can't auto-install — user must authenticate)
-	if _, err := exec.LookPath("gh"); err == nil {
+	if _, err := exec.LookPath("gh"); err == nil && needGH {
 		if err := exec.Command("gh", "auth", "status").Run(); err != nil {
 			manual = append(manual, "gh auth (run: gh auth login)")
 		}
@@ -251,8 +536,9 @@ func checkDependencies() {
 		}
 	}
 
-	// --- claude (Claude Code CLI) ---
-	if _, err := exec.LookPath("claude"); err != nil {
+	// --- claude (Claude Code CLI) — only needed when a repo uses the Claude agent ---
+	needClaude := cfg.usesClaude()
+	if _, err := exec.LookPath("claude"); err != nil && needClaude {
 		if autoInstall {
 			installNpm("@anthropic-ai/claude-code")
 		} else {
@@ -264,7 +550,14 @@ func checkDependencies() {
 		log.Fatalf("missing required dependencies (set CB_AUTO_INSTALL=1 to auto-install where possible):\n  - %s", strings.Join(manual, "\n  - "))
 	}
 
-	log.Println("dependency check passed: git, gh, claude all available")
+	tools := []string{"git"}
+	if needGH {
+		tools = append(tools, "gh")
+	}
+	if needClaude {
+		tools = append(tools, "claude")
+	}
+	slog.Info("dependency check passed", "component", "deps", "tools", strings.Join(tools, ", "))
 }
 
 // detectPackageManager returns the available package manager, or "" if none found.
@@ -291,7 +584,7 @@ func detectPackageManager() string {
 // installPackage installs a system package via the detected package manager.
 // Idempotent: the package manager itself skips already-installed packages.
 func installPackage(pm, pkg string) {
-	log.Printf("installing %s via %s...", pkg, pm)
+	slog.Info("installing package", "component", "deps", "package", pkg, "via", pm)
 	var cmd *exec.Cmd
 	switch pm {
 	case "brew":
@@ -308,7 +601,7 @@ func installPackage(pm, pkg string) {
 	if err := cmd.Run(); err != nil {
 		log.Fatalf("failed to install %s: %v", pkg, err)
 	}
-	log.Printf("installed %s successfully", pkg)
+	slog.Info("installed package", "component", "deps", "package", pkg)
 }
 
 // upgradePackage upgrades an already-installed package to latest.
@@ -328,34 +621,34 @@ func upgradePackage(pm, pkg string) {
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	if err := cmd.Run(); err != nil {
-		log.Printf("warning: couldn't upgrade %s: %v", pkg, err)
+		slog.Warn("couldn't upgrade package", "component", "deps", "package", pkg, "err", err)
 	}
 }
 
 // installNpm installs a global package. Prefers bun, falls back to npm.
 func installNpm(pkg string) {
 	if _, err := exec.LookPath("bun"); err == nil {
-		log.Printf("installing %s via bun...", pkg)
+		slog.Info("installing package", "component", "deps", "package", pkg, "via", "bun")
 		cmd := exec.Command("bun", "install", "-g", pkg)
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
 		if err := cmd.Run(); err == nil {
-			log.Printf("installed %s via bun", pkg)
+			slog.Info("installed package", "component", "deps", "package", pkg, "via", "bun")
 			return
 		}
-		log.Printf("bun failed, falling back to npm")
+		slog.Warn("bun failed, falling back to npm", "component", "deps", "package", pkg)
 	}
 	if _, err := exec.LookPath("npm"); err != nil {
 		log.Fatalf("cannot auto-install %s: neither bun nor npm found", pkg)
 	}
-	log.Printf("installing %s via npm...", pkg)
+	slog.Info("installing package", "component", "deps", "package", pkg, "via", "npm")
 	cmd := exec.Command("npm", "install", "-g", pkg)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	if err := cmd.Run(); err != nil {
 		log.Fatalf("failed to install %s: %v", pkg, err)
 	}
-	log.Printf("installed %s via npm", pkg)
+	slog.Info("installed package", "component", "deps", "package", pkg, "via", "npm")
 }
 
 // --- Main ---
@@ -379,10 +672,16 @@ func main() {
 		case "--release":
 			selfRelease()
 			return
+		case "--sandbox-exec":
+			sandboxExec(os.Args[2:])
+			return
 		}
 	}
 
 	cfg := loadConfig()
+	if err := registerIssueSources(cfg); err != nil {
+		log.Fatal(err)
+	}
 
 	// Config-dependent subcommands
 	if len(os.Args) > 1 {
@@ -393,32 +692,120 @@ func main() {
 		case "--clean-all":
 			cleanEverything(cfg)
 			return
+		case "--history":
+			if len(os.Args) < 3 {
+				log.Fatal("usage: claude-bot --history owner/repo#N")
+			}
+			t, err := readTracker(cfg.StateDir)
+			if err != nil {
+				log.Fatalf("loading state: %v", err)
+			}
+			printHistory(t, os.Args[2], os.Stdout)
+			t.close()
+			return
+		case "--render-prompt":
+			if len(os.Args) < 3 {
+				log.Fatal("usage: claude-bot --render-prompt owner/repo#N [triage]")
+			}
+			kind := promptIssue
+			if len(os.Args) > 3 {
+				kind = os.Args[3]
+			}
+			t, err := readTracker(cfg.StateDir)
+			if err != nil {
+				log.Fatalf("loading state: %v", err)
+			}
+			err = printPrompt(context.Background(), cfg, t, os.Args[2], kind, os.Stdout)
+			t.close()
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "--eval", "eval":
+			if len(os.Args) < 3 {
+				log.Fatal("usage: claude-bot --eval corpus.jsonl [report]")
+			}
+			out := ""
+			if len(os.Args) > 3 {
+				out = os.Args[3]
+			}
+			if err := checkSandbox(cfg.Sandbox); err != nil {
+				log.Fatalf("CB_SANDBOX=%s doesn't work on this host: %v", cfg.Sandbox.Mode, err)
+			}
+			ensureDirs(cfg)
+			ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+			err := runEval(ctx, cfg, os.Args[2], out, os.Stdout)
+			cancel()
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "--usage":
+			t, err := readTracker(cfg.StateDir)
+			if err != nil {
+				log.Fatalf("loading state: %v", err)
+			}
+			repo := ""
+			if len(os.Args) > 2 {
+				repo = os.Args[2]
+			}
+			printUsageReport(t, repo, os.Stdout)
+			t.close()
+			return
 		}
 	}
 
 	if len(cfg.Repos) == 0 {
-		log.Fatal("CB_REPOS environment variable is required (comma-separated list of owner/repo)")
+		log.Fatal("CB_REPOS environment variable is required (comma-separated list of owner/repo or forge:host/owner/repo)")
+	}
+	// The dashboard can retry, cancel and fail jobs, so it's never left open
+	if cfg.HTTPAddr != "" && cfg.DashboardPassword == "" {
+		log.Fatal("CB_DASHBOARD_PASSWORD is required when CB_HTTP_ADDR is set")
 	}
 
 	// Idempotent dependency check — verifies required tools are installed and configured
-	checkDependencies()
+	checkDependencies(cfg)
+	if err := checkSandbox(cfg.Sandbox); err != nil {
+		log.Fatalf("CB_SANDBOX=%s doesn't work on this host: %v", cfg.Sandbox.Mode, err)
+	}
 
 	ensureDirs(cfg)
 
-	log.Printf("claude-bot starting: repos=%v workers=%d poll=%s retries=%d",
-		cfg.Repos, cfg.Workers, cfg.PollInterval, cfg.MaxRetries)
+	slog.Info("claude-bot starting", "repos", cfg.Repos, "workers", cfg.Workers,
+		"poll", cfg.PollInterval, "retries", cfg.MaxRetries, "webhooks", cfg.webhooksEnabled(),
+		"config", cfg.ConfigFile, "sandbox", cfg.Sandbox.Mode)
 
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
+	t, err := loadTracker(cfg.StateDir)
+	if err != nil {
+		log.Fatalf("loading state from %s: %v", cfg.StateDir, err)
+	}
+	defer t.close()
+
 	// Startup: create labels if missing, recover stale issues
 	ensureLabels(ctx, cfg)
-	recoverStaleIssues(ctx, cfg)
+	recoverStaleIssues(ctx, cfg, t)
 
 	jobs := make(chan Issue, 100)
-	t := newTracker()
+	triggers := make(chan string, 64)
 	var wg sync.WaitGroup
 
+	// Optional HTTP listener (dashboard, metrics, webhooks)
+	if cfg.HTTPAddr != "" {
+		mux := http.NewServeMux()
+		newDashboard(cfg, t).register(mux)
+		registerRuntimeGauges(cfg, jobs, t)
+		mux.Handle("GET /metrics", metrics)
+		if cfg.webhooksEnabled() {
+			mux.Handle("POST /webhook", webhookHandler(cfg, triggers))
+		} else {
+			slog.Info("CB_WEBHOOK_SECRET not set, /webhook disabled", "component", "http")
+		}
+		go serveHTTP(ctx, cfg.HTTPAddr, mux)
+	}
+
 	// Start workers
 	for i := 0; i < cfg.Workers; i++ {
 		wg.Add(1)
@@ -429,12 +816,12 @@ func main() {
 	}
 
 	// Poll loop
-	pollLoop(ctx, cfg, jobs, t)
+	pollLoop(ctx, cfg, jobs, triggers, t)
 
 	close(jobs)
-	log.Println("waiting for workers to finish...")
+	slog.Info("waiting for workers to finish")
 	wg.Wait()
-	log.Println("claude-bot stopped")
+	slog.Info("claude-bot stopped")
 }
 
 func printUsage() {
@@ -446,29 +833,94 @@ Usage:
   claude-bot --release      Cross-compile and publish GitHub release
   claude-bot --update       Self-update from latest GitHub release
   claude-bot --clean        Remove worktrees and logs
-  claude-bot --clean-all    Full reset (worktrees, repos, logs)
+  claude-bot --clean-all    Full reset (worktrees, repos, logs, state)
+  claude-bot --history owner/repo#N   Show recorded attempts for an issue
+  claude-bot --usage [owner/repo]     Agent cost per repo (or per issue of one repo)
+  claude-bot --render-prompt owner/repo#N [triage]  Print the prompt the agent would get
+  claude-bot --eval corpus.jsonl [report]  Run the agent on a local corpus and report how it did
   claude-bot --version      Print version
   claude-bot --help         Print this help
 
 Environment:
-  CB_REPOS          Comma-separated list of owner/repo to watch (required)
+  CB_REPOS          Comma-separated repos to watch (required): owner/repo for
+                    GitHub, or gitea:host/owner/repo, forgejo:host/owner/repo,
+                    gitlab:host/group/project, local:/path/to/bare.git
+  CB_ISSUE_SOURCE   Take issues from markdown:<dir>, jsonl:<file>, jira:<host>/<project>
+                    or linear:<team> instead of the forge
+  CB_JQL            Extra JQL filter for Jira issues, e.g. component = backend
+  CB_MEMBERS_ONLY   Set to 1 if only the team can write to CB_ISSUE_SOURCE, so its
+                    text never restricts the agent's tools
+  CB_JIRA_EMAIL     Jira Cloud account email, for CB_JIRA_TOKEN
+  CB_JIRA_TOKEN     Jira Cloud API token
+  CB_LINEAR_API_KEY Linear API key
   CB_POLL_INTERVAL  How often to poll (default: 30s)
   CB_WORKERS        Parallel workers (default: 3)
   CB_MAX_TURNS      Max Claude turns per issue (default: 50)
   CB_MAX_RETRIES    Max retries before marking failed (default: 3)
+  CB_STATE_DIR      Job history journal (default: ~/.claude-bot/state)
+  CB_LOG_FORMAT     Log format: text (default) or json
+  CB_LOG_LEVEL      Log level: debug, info (default), warn, error
   CB_TRIAGE=1                Enable triage (respond to new issues via Claude)
   CB_TRIAGE_DISCUSSIONS=1    Also triage GitHub Discussions
+  CB_REVIEWS=0               Don't address review comments on the bot's PRs
+  CB_COMMAND_USERS  Users who may steer the bot with /bot commands in comments
+  CB_ALLOWED_USERS  Users who may queue issues by labelling them (default: anyone)
+  CB_ALLOWED_TEAMS  Teams (org/team-slug) whose members may queue issues
+  CB_MIN_PERMISSION Repo permission the labeler needs: read, triage, write, maintain, admin
+  CB_OUTSIDE_APPROVAL=1      Issues opened from outside the org wait for "/bot approve"
+  CB_PLAN_FIRST=1            Post a plan and wait for "/bot approve" before writing code
+  CB_INJECTION_REVIEW=1      Hold issues that look like prompt injections under needs-review
   CB_AUTO_INSTALL=1          Auto-install missing dependencies
+  CB_GITEA_TOKEN    API token for Gitea/Forgejo repos
+  CB_GITLAB_TOKEN   API token for GitLab repos
+  CB_HTTP_ADDR      Optional HTTP listener for the dashboard and /metrics, e.g. :8080
+  CB_DASHBOARD_PASSWORD  HTTP basic auth for the dashboard (required with CB_HTTP_ADDR)
+  CB_WEBHOOK_SECRET GitHub webhook secret; enables POST /webhook
+  CB_RECONCILE_INTERVAL  Poll interval when webhooks are enabled (default: 10m)
+  CB_AGENT          Coding agent: claude (default), shell:<cmd>, shell-file:<cmd>
+  CB_REPO_AGENTS    Per-repo agents: owner/repo=<agent>;other/repo=<agent>
+  CB_AGENT_TIMEOUT  Limit for each agent run (default: 10m)
+  CB_VERIFY=0       Don't run the repo's build/test/lint commands before committing
+  CB_VERIFY_ROUNDS  Agent runs to repair failing checks (default: 2)
+  CB_VERIFY_TIMEOUT Limit for each run of the checks (default: 10m)
+  CB_ATTEMPTS       Agent runs per issue, side by side; the best one gets the PR (default: 1)
+  CB_CI_WAIT        How long to wait for CI on a new PR (default: 30m; 0 = don't)
+  CB_CI_FIX_ROUNDS  Agent runs to fix failing CI before labelling ci-failed (default: 2)
+  CB_DAILY_BUDGET_USD  Agent spend per UTC day, all repos; then new work waits
+  CB_ISSUE_BUDGET_USD  Agent spend per UTC day on one issue
+  CB_ALLOWED_TOOLS  Tools the agent may use (default: Bash,Read,Write,Edit)
+  CB_DISALLOWED_TOOLS  Tools the agent may not use, e.g. Bash(git push:*)
+  CB_MODEL          Agent model (default: the agent's own)
+  CB_MCP_CONFIG     MCP server config file passed to the Claude CLI
+  CB_AGENT_ARGS     Extra flags for the agent's command line
+  CB_OUTSIDER_TOOLS Tools for issues with text from non-collaborators (default: CB_ALLOWED_TOOLS without Bash)
+  CB_BASE_BRANCH    Branch PRs target (default: the repo's default branch)
+  CB_PROMPT_TEMPLATE  text/template file for the agent prompt
+  CB_PROMPT_DIR     Directory of prompt templates (issue.tmpl, triage.tmpl, discussion.tmpl)
+  CB_CONFIG         Config file (default: ./claude-bot.toml or ./claude-bot.json);
+                    [repo."owner/repo"] sections override settings per repo
+  CB_SANDBOX        Confine agent runs (Linux): off (default), auto, bwrap, ns
+  CB_SANDBOX_MEMORY Memory limit per run, e.g. 4G
+  CB_SANDBOX_PIDS   Process limit per run
+  CB_SANDBOX_CPUS   CPU limit per run (needs CB_SANDBOX_CGROUP)
+  CB_SANDBOX_CGROUP Delegated cgroup v2 dir for per-run cgroups
+  CB_SANDBOX_RO_PATHS  Extra host paths visible read-only in the sandbox
 `, version)
 }
 
 // --- Poll Loop ---
 
-func pollLoop(ctx context.Context, cfg Config, jobs chan<- Issue, t *tracker) {
+// pollLoop polls every repo on a ticker, and a single repo whenever a webhook
+// triggers it. With webhooks enabled the ticker is only a reconciliation pass.
+func pollLoop(ctx context.Context, cfg Config, jobs chan<- Issue, triggers <-chan string, t *tracker) {
 	// Immediate first poll
 	poll(ctx, cfg, jobs, t)
 
-	ticker := time.NewTicker(cfg.PollInterval)
+	interval := cfg.PollInterval
+	if cfg.webhooksEnabled() {
+		interval = cfg.ReconcileInterval
+	}
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
@@ -477,6 +929,8 @@ func pollLoop(ctx context.Context, cfg Config, jobs chan<- Issue, t *tracker) {
 			return
 		case <-ticker.C:
 			poll(ctx, cfg, jobs, t)
+		case repo := <-triggers:
+			pollRepo(ctx, cfg, repo, jobs, t)
 		}
 	}
 }
@@ -486,72 +940,104 @@ func poll(ctx context.Context, cfg Config, jobs chan<- Issue, t *tracker) {
 		if ctx.Err() != nil {
 			return
 		}
+		pollRepo(ctx, cfg, repo, jobs, t)
+	}
+}
+
+func pollRepo(ctx context.Context, cfg Config, repo string, jobs chan<- Issue, t *tracker) {
+	defer pollDuration.since(time.Now(), repo)
+	cfg = cfg.forRepo(repo)
+
+	// Triage: find unlabeled issues and respond
+	if cfg.Triage {
+		triageNewIssues(ctx, cfg, repo)
+	}
+
+	// Triage discussions (GitHub only)
+	if cfg.TriageDiscussions && parseRepo(repo).Kind == "github" {
+		triageNewDiscussions(ctx, cfg, repo)
+	}
+
+	// /bot commands and plan approvals go first, so what they queue is picked
+	// up by this same poll
+	if len(cfg.CommandUsers) > 0 {
+		pollCommands(ctx, cfg, repo, t)
+	}
+	pollPlanApprovals(ctx, cfg, repo)
+
+	issues, err := fetchIssues(ctx, repo, cfg.IssueLabel)
+	if err != nil {
+		slog.Error("fetching issues failed", "component", "poll", "repo", repo, "err", err)
+		pollErrors.inc(repo)
+		return
+	}
 
-		// Triage: find unlabeled issues and respond
-		if cfg.Triage {
-			triageNewIssues(ctx, cfg, repo)
+	for _, issue := range issues {
+		// Skip if already has WIP, done, or failed label
+		if issue.hasLabel(cfg.WIPLabel) || issue.hasLabel(cfg.DoneLabel) || issue.hasLabel(cfg.FailedLabel) {
+			continue
 		}
 
-		// Triage discussions
-		if cfg.TriageDiscussions {
-			triageNewDiscussions(ctx, cfg, repo)
+		// Retry limit: if too many failed attempts, mark as failed and skip
+		if errors := retryCount(t, issue); errors >= cfg.MaxRetries {
+			slog.Warn("retry limit reached, marking as failed", "component", "poll", "repo", repo, "key", issue.key(), "failures", errors, "max", cfg.MaxRetries)
+			_ = addLabel(ctx, issue, cfg.FailedLabel)
+			_ = removeLabel(ctx, issue, cfg.IssueLabel)
+			continue
 		}
 
-		issues, err := fetchIssues(ctx, repo, cfg.IssueLabel)
-		if err != nil {
-			log.Printf("[poll] error fetching issues from %s: %v", repo, err)
+		// Authorization policy (see policy.go)
+		if !admitIssue(ctx, cfg, t, issue) {
 			continue
 		}
 
-		for _, issue := range issues {
-			// Skip if already has WIP, done, or failed label
-			if issue.hasLabel(cfg.WIPLabel) || issue.hasLabel(cfg.DoneLabel) || issue.hasLabel(cfg.FailedLabel) {
-				continue
-			}
+		if !enqueue(ctx, cfg, issue, jobs, t) {
+			return
+		}
+	}
 
-			// Retry limit: if too many bot errors, mark as failed and skip
-			if errors := countBotErrors(issue); errors >= cfg.MaxRetries {
-				log.Printf("[poll] %s has failed %d times (max %d), marking as failed", issue.key(), errors, cfg.MaxRetries)
-				_ = addLabel(ctx, issue, cfg.FailedLabel)
-				_ = removeLabel(ctx, issue, cfg.IssueLabel)
-				continue
-			}
+	// Follow-ups on the bot's own PRs
+	if cfg.Reviews {
+		pollReviews(ctx, cfg, repo, jobs, t)
+	}
+}
 
-			// Skip if already inflight
-			if !t.tryAcquire(issue.key()) {
-				continue
-			}
+// enqueue hands issue to the workers unless it's already in flight or over
+// budget. Returns false once ctx is done.
+func enqueue(ctx context.Context, cfg Config, issue Issue, jobs chan<- Issue, t *tracker) bool {
+	// Skip if already inflight
+	if !t.tryAcquire(issue.key()) {
+		return true
+	}
 
-			select {
-			case jobs <- issue:
-				log.Printf("[poll] queued %s: %q", issue.key(), issue.Title)
-			case <-ctx.Done():
-				t.release(issue.key())
-				return
-			}
-		}
+	// Budgets: leave the issue labeled for the next window
+	if reason := overBudget(cfg, t, issue, time.Now()); reason != "" {
+		t.release(issue.key())
+		deferIssue(withLogger(ctx, slog.Default().With("component", "poll", "repo", issue.Repo)), t, issue, reason, time.Now())
+		return true
 	}
-}
 
-func fetchIssues(ctx context.Context, repo, label string) ([]Issue, error) {
-	args := []string{"issue", "list", "--repo", repo,
-		"--json", "number,title,body,labels,url,comments,author",
-		"--limit", "50",
+	msg := "queued issue"
+	if issue.Review != nil {
+		msg = "queued review follow-up"
 	}
-	if label != "" {
-		args = append(args, "--label", label)
+	select {
+	case jobs <- issue:
+		jobsTotal.inc(issue.Repo, "queued")
+		slog.Info(msg, "component", "poll", "repo", issue.Repo, "key", issue.key(), "title", issue.Title)
+		return true
+	case <-ctx.Done():
+		t.release(issue.key())
+		return false
 	}
+}
 
-	out, err := run(ctx, "", "gh", args...)
+func fetchIssues(ctx context.Context, repo, label string) ([]Issue, error) {
+	issues, err := sourceFor(repo).ListIssues(ctx, repo, label)
 	if err != nil {
 		return nil, err
 	}
 
-	var issues []Issue
-	if err := json.Unmarshal([]byte(out), &issues); err != nil {
-		return nil, fmt.Errorf("parsing issues JSON: %w", err)
-	}
-
 	for i := range issues {
 		issues[i].Repo = repo
 	}
@@ -566,13 +1052,14 @@ func fetchIssues(ctx context.Context, repo, label string) ([]Issue, error) {
 func triageNewIssues(ctx context.Context, cfg Config, repo string) {
 	issues, err := fetchIssues(ctx, repo, "")
 	if err != nil {
-		log.Printf("[triage] error fetching issues from %s: %v", repo, err)
+		slog.Error("fetching issues failed", "component", "triage", "repo", repo, "err", err)
 		return
 	}
 
 	botLabels := []string{
 		cfg.IssueLabel, cfg.WIPLabel, cfg.DoneLabel,
-		cfg.NeedsInfoLabel, cfg.FailedLabel, cfg.TriageLabel,
+		cfg.NeedsInfoLabel, cfg.FailedLabel, cfg.CIFailedLabel, cfg.TriageLabel,
+		cfg.NeedsReviewLabel, cfg.PlanLabel, cfg.AwaitingApprovalLabel,
 	}
 
 	for _, issue := range issues {
@@ -594,11 +1081,11 @@ func triageNewIssues(ctx context.Context, cfg Config, repo string) {
 			continue
 		}
 
-		log.Printf("[triage] responding to %s: %q", issue.key(), issue.Title)
+		slog.Info("responding to issue", "component", "triage", "key", issue.key(), "title", issue.Title)
 
-		response := buildTriageResponse(ctx, issue)
+		response := buildTriageResponse(ctx, cfg, issue)
 		if err := commentOnIssue(ctx, issue, response); err != nil {
-			log.Printf("[triage] error commenting on %s: %v", issue.key(), err)
+			slog.Error("commenting failed", "component", "triage", "key", issue.key(), "err", err)
 			continue
 		}
 		_ = addLabel(ctx, issue, cfg.TriageLabel)
@@ -644,11 +1131,11 @@ func (d Discussion) key() string {
 // triageNewDiscussions finds unanswered discussions and responds via Claude.
 // Idempotent: skips discussions that already have a bot comment.
 func triageNewDiscussions(ctx context.Context, cfg Config, repo string) {
-	parts := strings.SplitN(repo, "/", 2)
-	if len(parts) != 2 {
+	r := parseRepo(repo)
+	owner, name := r.owner(), r.name()
+	if owner == "" {
 		return
 	}
-	owner, name := parts[0], parts[1]
 
 	query := fmt.Sprintf(`{
 		repository(owner: %q, name: %q) {
@@ -662,9 +1149,9 @@ func triageNewDiscussions(ctx context.Context, cfg Config, repo string) {
 		}
 	}`, owner, name)
 
-	out, err := run(ctx, "", "gh", "api", "graphql", "-f", "query="+query)
+	out, err := run(ctx, "", "gh", "api", "graphql", "--hostname", r.Host, "-f", "query="+query)
 	if err != nil {
-		log.Printf("[triage-discussions] error fetching from %s: %v", repo, err)
+		slog.Error("fetching discussions failed", "component", "triage-discussions", "repo", repo, "err", err)
 		return
 	}
 
@@ -696,73 +1183,55 @@ func triageNewDiscussions(ctx context.Context, cfg Config, repo string) {
 			continue
 		}
 
-		log.Printf("[triage-discussions] responding to %s: %q", d.key(), d.Title)
+		slog.Info("responding to discussion", "component", "triage-discussions", "key", d.key(), "title", d.Title)
 
-		response := buildDiscussionResponse(ctx, d)
+		response := buildDiscussionResponse(ctx, cfg, d)
 
 		// Add comment via GraphQL mutation
 		mutation := fmt.Sprintf(`mutation { addDiscussionComment(input: {discussionId: %q, body: %q}) { comment { id } } }`,
 			d.ID, response+"\n"+botCommentMarker)
 
-		if _, err := run(ctx, "", "gh", "api", "graphql", "-f", "query="+mutation); err != nil {
-			log.Printf("[triage-discussions] error commenting on %s: %v", d.key(), err)
+		if _, err := run(ctx, "", "gh", "api", "graphql", "--hostname", r.Host, "-f", "query="+mutation); err != nil {
+			slog.Error("commenting failed", "component", "triage-discussions", "key", d.key(), "err", err)
 		}
 	}
 }
 
-// buildDiscussionResponse generates a Claude-powered response for a discussion.
-func buildDiscussionResponse(ctx context.Context, d Discussion) string {
-	prompt := fmt.Sprintf(`You are responding to a GitHub Discussion as a helpful project contributor.
-Write a short, friendly, human-sounding reply. DO NOT say you are a bot.
-Just respond naturally as if you're a person on the team.
+// buildDiscussionResponse generates an agent-written response for a discussion.
+func buildDiscussionResponse(ctx context.Context, cfg Config, d Discussion) string {
+	issue := Issue{Number: d.Number, Title: d.Title, Body: d.Body, Repo: d.Repo}
+	issue.Author.Login = d.Author.Login
+	repoDir, defBranch := clonedRepo(ctx, cfg, d.Repo)
+	prompt, err := renderPrompt(ctx, cfg, repoDir, defBranch, promptDiscussion, newPromptData(cfg, nil, repoSettings{}, defBranch, issue))
 
-- If they asked a question, try to help or point them in the right direction.
-- If they're sharing an idea, engage with it constructively.
-- Keep it to 2-4 sentences max. Be warm but concise.
-
-Discussion title: %s
-Discussion author: @%s
-Discussion body:
-%s`, d.Title, d.Author.Login, d.Body)
-
-	cmd := exec.CommandContext(ctx, "claude", "-p", prompt, "--max-turns", "1")
-	cmd.Env = filterEnv(os.Environ(), "CLAUDECODE")
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		log.Printf("[triage-discussions] claude failed, using fallback: %v", err)
+	var out string
+	if err == nil {
+		out, err = askAgent(ctx, cfg.agentFor(d.Repo), prompt)
+	}
+	if err != nil || out == "" {
+		slog.Warn("agent failed, using fallback", "component", "triage-discussions", "key", d.key(), "err", err)
 		return fmt.Sprintf("Hey @%s, thanks for starting this discussion! A maintainer will chime in soon.", d.Author.Login)
 	}
 
-	return strings.TrimSpace(string(out))
+	return out
 }
 
-// buildTriageResponse uses Claude CLI to generate a context-aware, human-sounding
-// triage response based on the issue content. Falls back to a simple response if Claude fails.
-func buildTriageResponse(ctx context.Context, issue Issue) string {
-	prompt := fmt.Sprintf(`You are responding to a GitHub issue as a helpful project contributor.
-Write a short, friendly, human-sounding reply. DO NOT say you are a bot. DO NOT use phrases like "I'm an AI" or "automated".
-Just respond naturally as if you're a person on the team who read their issue.
-
-- If they asked a question, try to help or point them in the right direction.
-- If they reported a bug, acknowledge it and ask for more details if the report is thin.
-- If they requested a feature, acknowledge the idea.
-- Keep it to 2-4 sentences max. Be warm but concise.
-- End by letting them know a maintainer will look at this soon, and if it's something actionable, it can be picked up for work.
-
-Issue title: %s
-Issue author: @%s
-Issue body:
-%s`, issue.Title, issue.Author.Login, issue.Body)
-
-	cmd := exec.CommandContext(ctx, "claude", "-p", prompt, "--max-turns", "1")
-	cmd.Env = filterEnv(os.Environ(), "CLAUDECODE")
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		log.Printf("[triage] claude failed, using fallback: %v", err)
+// buildTriageResponse uses the repo's agent to generate a context-aware, human-sounding
+// triage response based on the issue content. Falls back to a simple response if the agent fails.
+func buildTriageResponse(ctx context.Context, cfg Config, issue Issue) string {
+	repoDir, defBranch := clonedRepo(ctx, cfg, issue.Repo)
+	prompt, err := renderPrompt(ctx, cfg, repoDir, defBranch, promptTriage, newPromptData(cfg, nil, repoSettings{}, defBranch, issue))
+
+	var out string
+	if err == nil {
+		out, err = askAgent(ctx, cfg.agentFor(issue.Repo), prompt)
+	}
+	if err != nil || out == "" {
+		slog.Warn("agent failed, using fallback", "component", "triage", "key", issue.key(), "err", err)
 		return fmt.Sprintf("Hey @%s, thanks for raising this! A maintainer will take a look soon.", issue.Author.Login)
 	}
 
-	return strings.TrimSpace(string(out))
+	return out
 }
 
 // --- Worker ---
@@ -774,24 +1243,85 @@ func worker(ctx context.Context, cfg Config, id int, jobs <-chan Issue, t *track
 			return
 		}
 
-		log.Printf("[worker-%d] picked up %s: %q", id, issue.key(), issue.Title)
+		lg := issueLogger(issue, id)
 
-		if err := processIssue(ctx, cfg, id, issue); err != nil {
-			log.Printf("[worker-%d] error processing %s: %v", id, issue.key(), err)
+		// Queued before the budget ran out: don't start it
+		if reason := overBudget(cfg.forRepo(issue.Repo), t, issue, time.Now()); reason != "" {
+			deferIssue(withLogger(ctx, lg), t, issue, reason, time.Now())
+			t.release(issue.key())
+			continue
+		}
+
+		lg.Info("picked up issue", "title", issue.Title)
+		jobsTotal.inc(issue.Repo, "started")
+
+		// Per-job context so the job can be cancelled on its own (dashboard)
+		jobCtx, cancel := context.WithCancelCause(ctx)
+		t.setCancel(issue.key(), cancel)
+
+		process := processIssue
+		if issue.Review != nil {
+			process = processReview
+		}
+		if err := process(jobCtx, cfg, t, id, issue); err != nil {
+			lg.Error("processing failed", "err", err)
 		}
 
+		cancel(nil)
 		t.release(issue.key())
 	}
 }
 
-func processIssue(ctx context.Context, cfg Config, workerID int, issue Issue) (retErr error) {
+func processIssue(ctx context.Context, cfg Config, t *tracker, workerID int, issue Issue) (retErr error) {
+	opts := t.options(issue.key())
+	cfg = cfg.forIssue(issue).withOptions(opts)
 	branch := branchName(issue)
-	repoDir := filepath.Join(cfg.RepoDir, issue.Repo)
-	wtDir := filepath.Join(cfg.WorktreeDir, issue.Repo, branch)
+	repoDir := repoLocalDir(cfg.RepoDir, issue.Repo)
+	wtDir := filepath.Join(repoLocalDir(cfg.WorktreeDir, issue.Repo), branch)
 	logFile := filepath.Join(cfg.LogDir, fmt.Sprintf("%s-%d.log", slugify(issue.Repo), issue.Number))
 
+	// Record the attempt; result and prURL are filled in as the steps below complete
+	attempt, err := t.begin(Attempt{
+		Key:     issue.key(),
+		Repo:    issue.Repo,
+		Number:  issue.Number,
+		Worker:  workerID,
+		Branch:  branch,
+		LogPath: logFile,
+	})
+	jobLog := issueLogger(issue, workerID)
+	if err != nil {
+		jobLog.Warn("couldn't record attempt", "err", err)
+	} else {
+		jobLog = jobLog.With("run_id", attempt.ID)
+	}
+	lg := jobLog.With("branch", branch)
+	ctx = withLogger(ctx, lg)
+	result, prURL := resultDone, ""
+	defer func() {
+		switch {
+		case retErr != nil && jobCancelled(ctx):
+			result = resultCancelled
+		case retErr != nil && ctx.Err() != nil:
+			result = resultInterrupted
+		case retErr != nil:
+			result = resultError
+		}
+		jobsTotal.inc(issue.Repo, jobState(result))
+		if err := t.finish(attempt.ID, result, retErr, prURL); err != nil {
+			lg.Warn("couldn't record attempt result", "err", err)
+		}
+	}()
+
 	// On failure: comment error on issue (deduped), reset labels, cleanup
 	defer func() {
+		if retErr != nil && jobCancelled(ctx) {
+			// Cancelled on purpose: don't comment or requeue, just stand down
+			cleanupCtx := context.WithoutCancel(ctx)
+			_ = removeLabel(cleanupCtx, issue, cfg.WIPLabel)
+			cleanupWorktree(cleanupCtx, repoDir, wtDir, branch)
+			return
+		}
 		if retErr != nil {
 			commentErr := fmt.Sprintf("claude-bot encountered an error:\n```\n%s\n```\nNeeds manual attention.", retErr.Error())
 			// Only comment if we haven't already posted this exact error
@@ -810,10 +1340,12 @@ func processIssue(ctx context.Context, cfg Config, workerID int, issue Issue) (r
 			return fmt.Errorf("marking in-progress: %w", err)
 		}
 		if err := removeLabel(ctx, issue, cfg.IssueLabel); err != nil {
-			log.Printf("[worker-%d] warning: couldn't remove %s label: %v", workerID, cfg.IssueLabel, err)
+			lg.Warn("couldn't remove label", "label", cfg.IssueLabel, "err", err)
 		}
 	}
 
+	lg.Info("marked in-progress")
+
 	// Step 2: Ensure repo cloned (idempotent)
 	if err := ensureRepoCloned(ctx, cfg, issue); err != nil {
 		return fmt.Errorf("cloning repo: %w", err)
@@ -824,80 +1356,222 @@ func processIssue(ctx context.Context, cfg Config, workerID int, issue Issue) (r
 		return fmt.Errorf("fetching latest: %w", err)
 	}
 
+	// Repo settings (.claude-bot.yml) come from the default branch, so the
+	// bot's own branches can't change them
+	defBranch := defaultBranch(ctx, repoDir)
+	settings, err := loadRepoSettings(ctx, repoDir, defBranch)
+	if err != nil {
+		return fmt.Errorf("reading repo settings: %w", err)
+	}
+	if settings.BranchPrefix != "" {
+		branch = settings.BranchPrefix + branchName(issue)
+		wtDir = filepath.Join(repoLocalDir(cfg.WorktreeDir, issue.Repo), branch)
+		_ = t.setBranch(attempt.ID, branch)
+		lg = jobLog.With("branch", branch)
+		ctx = withLogger(ctx, lg)
+	}
+	cfg = restrictTools(ctx, cfg, issue.Repo, contentAuthors(cfg, issue))
+	cfg.AllowedTools = settings.allowedTools(cfg.AllowedTools)
+	if settings.Source != "" {
+		lg.Info("loaded repo settings", "file", settings.Source)
+	}
+	if names := cfg.issueProfiles(issue); len(names) > 0 {
+		lg.Info("using agent profiles", "profiles", strings.Join(names, ","))
+	}
+
+	// PR base: per-repo config, else the repo's default branch
+	base := cfg.BaseBranch
+	if base == "" {
+		base = defBranch
+	}
+
 	// Step 4: Create worktree (idempotent)
-	if err := ensureWorktree(ctx, repoDir, wtDir, branch); err != nil {
+	if err := ensureWorktree(ctx, repoDir, wtDir, branch, base); err != nil {
 		return fmt.Errorf("creating worktree: %w", err)
 	}
+	lg.Info("worktree ready", "dir", wtDir)
+
+	// Step 4b: Post a plan instead of changing anything after /bot plan, or
+	// until one is approved in plan-first mode (see plan.go)
+	var plan approvedPlan
+	planning := opts.Plan
+	if cfg.needsPlan(issue) && !opts.Plan {
+		ap, ok, err := findPlanApproval(ctx, cfg, issue)
+		if err != nil {
+			return fmt.Errorf("checking plan approval: %w", err)
+		}
+		if ok {
+			plan = ap
+			lg.Info("implementing approved plan", "approver", ap.Approver)
+		}
+		planning = !ok
+	}
+	if planning {
+		awaiting := cfg.needsPlan(issue)
+		if err := postPlan(ctx, cfg, settings, t, attempt.ID, issue, wtDir, logFile, awaiting); err != nil {
+			return err
+		}
+		o := t.options(issue.key())
+		o.Plan = false
+		if err := t.setOptions(issue.key(), o); err != nil {
+			lg.Warn("couldn't clear plan option", "err", err)
+		}
+		if awaiting {
+			_ = addLabel(ctx, issue, cfg.AwaitingApprovalLabel)
+		}
+		_ = removeLabel(ctx, issue, cfg.WIPLabel)
+		cleanupWorktree(ctx, repoDir, wtDir, branch)
+		result = resultPlanned
+		lg.Info("posted plan")
+		return nil
+	}
 
-	// Step 5: Run Claude Code (skip if changes already present)
+	// Step 5: Run the agent (skip if changes already present)
 	hasChanges, err := checkChanges(ctx, wtDir)
 	if err != nil {
 		return fmt.Errorf("checking changes: %w", err)
 	}
 
+	var usage *Usage
+	var candidates []candidate
 	if !hasChanges {
-		if err := runClaude(ctx, cfg, issue, wtDir, logFile); err != nil {
-			return fmt.Errorf("running claude: %w", err)
+		var prompt string
+		data := newPromptData(cfg, t, settings, defBranch, issue)
+		data.Plan, data.PlanAmendments = plan.Plan, plan.Amendments
+		if prompt, err = renderPrompt(ctx, cfg, repoDir, defBranch, promptIssue, data); err != nil {
+			return err
+		}
+		if cfg.Attempts > 1 {
+			// Several runs side by side, keeping the best (see bestof.go)
+			if candidates, err = bestOf(ctx, cfg, settings, t, attempt.ID, issue, repoDir, wtDir, branch, base, prompt, logFile); err != nil {
+				return fmt.Errorf("running agent: %w", err)
+			}
+			if a, ok := t.lastAttempt(issue.key()); ok && a.ID == attempt.ID && a.Usage != nil {
+				usage = a.Usage
+			}
+		} else {
+			usage, err = runAgent(ctx, cfg, settings, issue, prompt, wtDir, logFile)
+			// A failed run still cost something
+			if usage != nil {
+				_ = t.setUsage(attempt.ID, *usage)
+			}
+			if err != nil {
+				return fmt.Errorf("running agent: %w", err)
+			}
+
+			if usage != nil {
+				lg.Info("agent finished", "turns", usage.Turns, "cost_usd", usage.CostUSD, "stop_reason", usage.StopReason)
+			} else {
+				lg.Info("agent finished")
+			}
 		}
 
 		// Re-check for changes
 		hasChanges, err = checkChanges(ctx, wtDir)
 		if err != nil {
-			return fmt.Errorf("checking changes after claude: %w", err)
+			return fmt.Errorf("checking changes after agent: %w", err)
 		}
 	}
 
 	// Step 6: No changes → needs more info from user
 	if !hasChanges {
+		lg.Info("no changes, asking for more info")
 		_ = commentOnIssue(ctx, issue, "claude-bot ran but couldn't resolve this issue — no file changes were made.\n\nPlease add more context or details as a comment, then replace the `"+cfg.NeedsInfoLabel+"` label with `"+cfg.IssueLabel+"` to retry.")
 		_ = removeLabel(ctx, issue, cfg.WIPLabel)
 		_ = addLabel(ctx, issue, cfg.NeedsInfoLabel)
 		cleanupWorktree(ctx, repoDir, wtDir, branch)
+		result = resultNeedsInfo
 		return nil // Not an error, just nothing to do
 	}
 
+	// Step 6b: Run the repo's checks, letting the agent repair failures
+	var report *verifyReport
+	if cfg.Verify {
+		if report, err = verifyChanges(ctx, cfg, settings, t, attempt.ID, issue, wtDir, logFile); err != nil {
+			return err
+		}
+		// Repairs add to what the PR reports
+		if a, ok := t.lastAttempt(issue.key()); ok && a.ID == attempt.ID && a.Usage != nil {
+			usage = a.Usage
+		}
+	}
+
 	// Step 7: Commit (idempotent — skip if clean)
-	if err := commitChanges(ctx, wtDir, issue); err != nil {
+	if err := commitChanges(ctx, wtDir, prTitle(issue)); err != nil {
 		return fmt.Errorf("committing: %w", err)
 	}
 
+	// Step 7b: Enforce the repo's forbidden_paths and max_diff_lines
+	if err := settings.checkBranch(ctx, wtDir, base); err != nil {
+		return err
+	}
+
 	// Step 8: Push (idempotent)
 	if _, err := run(ctx, wtDir, "git", "push", "-u", "origin", branch); err != nil {
 		return fmt.Errorf("pushing: %w", err)
 	}
 
+	lg.Info("pushed")
+
 	// Step 9: Create PR (idempotent — skip if exists)
-	prURL, err := ensurePR(ctx, issue, branch, repoDir)
+	prURL, err = ensurePR(ctx, issue, branch, base, repoDir, settings.Reviewers, usage, report, alternativesMarkdown(candidates))
 	if err != nil {
 		return fmt.Errorf("creating PR: %w", err)
 	}
 
 	// Step 10: Comment on issue (idempotent — skip if already commented)
 	if err := ensurePRComment(ctx, issue, prURL); err != nil {
-		log.Printf("[worker-%d] warning: couldn't comment PR URL: %v", workerID, err)
+		lg.Warn("couldn't comment PR URL", "err", err)
+	}
+
+	// Step 11: Wait for CI, letting the agent fix failures (see ci.go)
+	if cfg.CIWait > 0 {
+		reason, err := watchCI(ctx, cfg, settings, t, attempt.ID, issue, wtDir, branch, base, logFile)
+		if err != nil {
+			return err
+		}
+		if reason != "" {
+			lg.Warn("CI isn't green", "reason", reason, "pr", prURL)
+			_ = commentOnIssue(ctx, issue, fmt.Sprintf("claude-bot opened %s, but CI isn't passing: %s.\n\nNeeds manual attention.", prURL, reason))
+			_ = addLabel(ctx, issue, cfg.CIFailedLabel)
+			_ = removeLabel(ctx, issue, cfg.WIPLabel)
+			cleanupWorktree(ctx, repoDir, wtDir, branch)
+			result = resultCIFailed
+			return nil
+		}
 	}
 
-	// Step 11: Mark done (idempotent)
+	// Step 12: Mark done (idempotent)
 	if !issue.hasLabel(cfg.DoneLabel) {
 		_ = addLabel(ctx, issue, cfg.DoneLabel)
 		_ = removeLabel(ctx, issue, cfg.WIPLabel)
 	}
+	if issue.hasLabel(cfg.CIFailedLabel) {
+		_ = removeLabel(ctx, issue, cfg.CIFailedLabel)
+	}
 
-	// Step 12: Cleanup worktree
+	// Step 13: Cleanup worktree
 	cleanupWorktree(ctx, repoDir, wtDir, branch)
 
-	log.Printf("[worker-%d] completed %s → %s", workerID, issue.key(), prURL)
+	lg.Info("completed", "pr", prURL)
 	return nil
 }
 
 // --- Idempotent Operations ---
 
 func ensureRepoCloned(ctx context.Context, cfg Config, issue Issue) error {
-	repoDir := filepath.Join(cfg.RepoDir, issue.Repo)
+	repoDir := repoLocalDir(cfg.RepoDir, issue.Repo)
 	gitDir := filepath.Join(repoDir, ".git")
+	forge := forgeFor(issue.Repo)
 
-	// Already cloned?
+	// Already cloned? Clones from before the forge had any config get it now
 	if info, err := os.Stat(gitDir); err == nil && info.IsDir() {
+		for _, kv := range forge.CloneConfig(issue.Repo) {
+			key, value, _ := strings.Cut(kv, "=")
+			if _, err := run(ctx, repoDir, "git", "config", key, value); err != nil {
+				return err
+			}
+		}
 		return nil
 	}
 
@@ -906,12 +1580,15 @@ func ensureRepoCloned(ctx context.Context, cfg Config, issue Issue) error {
 		return err
 	}
 
-	repoURL := fmt.Sprintf("https://github.com/%s.git", issue.Repo)
-	_, err := run(ctx, "", "git", "clone", repoURL, repoDir)
+	args := []string{"clone"}
+	for _, kv := range forge.CloneConfig(issue.Repo) {
+		args = append(args, "--config", kv)
+	}
+	_, err := run(ctx, "", "git", append(args, forge.CloneURL(issue.Repo), repoDir)...)
 	return err
 }
 
-func ensureWorktree(ctx context.Context, repoDir, wtDir, branch string) error {
+func ensureWorktree(ctx context.Context, repoDir, wtDir, branch, base string) error {
 	// Already exists as a worktree?
 	if _, err := os.Stat(wtDir); err == nil {
 		return nil
@@ -928,8 +1605,7 @@ func ensureWorktree(ctx context.Context, repoDir, wtDir, branch string) error {
 	// Delete stale local branch if it exists (left over from a previous failed run)
 	_ = deleteLocalBranch(ctx, repoDir, branch)
 
-	// Create new worktree with new branch from origin/default
-	base := defaultBranch(ctx, repoDir)
+	// Create new worktree with new branch from origin/<base>
 	_, err := run(ctx, repoDir, "git", "worktree", "add", "-b", branch, wtDir, "origin/"+base)
 	return err
 }
@@ -953,7 +1629,7 @@ func checkChanges(ctx context.Context, wtDir string) (bool, error) {
 	return strings.TrimSpace(out) != "", nil
 }
 
-func commitChanges(ctx context.Context, wtDir string, issue Issue) error {
+func commitChanges(ctx context.Context, wtDir, msg string) error {
 	// Check if there's anything to commit
 	out, err := run(ctx, wtDir, "git", "status", "--porcelain")
 	if err != nil {
@@ -967,49 +1643,56 @@ func commitChanges(ctx context.Context, wtDir string, issue Issue) error {
 		return err
 	}
 
-	msg := fmt.Sprintf("fix: resolve #%d — %s", issue.Number, issue.Title)
 	_, err = run(ctx, wtDir, "git", "commit", "-m", msg)
 	return err
 }
 
-func ensurePR(ctx context.Context, issue Issue, branch, repoDir string) (string, error) {
+// usage, if known, is summarized in the PR body.
+func ensurePR(ctx context.Context, issue Issue, branch, baseBranch, repoDir string, reviewers []string, usage *Usage, report *verifyReport, alternatives string) (string, error) {
+	forge := forgeFor(issue.Repo)
+
 	// Check if PR already exists for this branch
-	out, err := run(ctx, "", "gh", "pr", "list",
-		"--repo", issue.Repo,
-		"--head", branch,
-		"--json", "url",
-		"--limit", "1",
-	)
-	if err == nil {
-		var prs []struct{ URL string `json:"url"` }
-		if json.Unmarshal([]byte(out), &prs) == nil && len(prs) > 0 {
-			return prs[0].URL, nil // PR already exists
-		}
+	if url, err := forge.FindPR(ctx, issue.Repo, branch); err == nil && url != "" {
+		return url, nil // PR already exists
 	}
 
-	// Detect default branch (don't hardcode "main")
-	baseBranch := defaultBranch(ctx, repoDir)
-
 	// Get diff stat for PR body
 	diffStat, _ := run(ctx, repoDir, "git", "diff", "--stat", "HEAD~1")
 
-	body := fmt.Sprintf("Closes #%d\n\n## What changed\n```\n%s\n```\n\n## Issue\n%s\n\n---\n*Automated by claude-bot. Review before merging.*",
-		issue.Number, diffStat, issue.URL)
+	body := fmt.Sprintf("%s## What changed\n```\n%s\n```\n\n## Issue\n%s\n\n",
+		closingLine(issue), diffStat, issue.URL)
+	if report != nil {
+		body += report.markdown()
+	}
+	body += alternatives
+	if usage != nil {
+		body += fmt.Sprintf("## Agent usage\n%s\n\n", usage.summary())
+	}
+	// The marker lets pollReviews tell the bot's PRs from others on issue-N branches
+	body += "---\n*Automated by claude-bot. Review before merging.*\n" + botCommentMarker
 
-	title := fmt.Sprintf("fix: resolve #%d — %s", issue.Number, issue.Title)
+	return forge.CreatePR(ctx, issue.Repo, PullRequest{
+		Title:     prTitle(issue),
+		Body:      body,
+		Head:      branch,
+		Base:      baseBranch,
+		Reviewers: reviewers,
+	})
+}
 
-	prOut, err := run(ctx, "", "gh", "pr", "create",
-		"--repo", issue.Repo,
-		"--title", title,
-		"--body", body,
-		"--head", branch,
-		"--base", baseBranch,
-	)
-	if err != nil {
-		return "", err
+// closingLine is the start of the PR body that closes issue on merge. Only the
+// forge's own issues can be closed that way; those from an issue source are
+// linked by the reference in the title.
+func closingLine(issue Issue) string {
+	if hasIssueSource(issue.Repo) {
+		return ""
 	}
+	return fmt.Sprintf("Closes %s\n\n", issue.reference())
+}
 
-	return strings.TrimSpace(prOut), nil
+// prTitle is the title of the PR for issue, and of its commit.
+func prTitle(issue Issue) string {
+	return fmt.Sprintf("fix: resolve %s — %s", issue.reference(), issue.Title)
 }
 
 // defaultBranch detects the repo's default branch from origin/HEAD.
@@ -1026,19 +1709,16 @@ func defaultBranch(ctx context.Context, repoDir string) string {
 }
 
 func ensurePRComment(ctx context.Context, issue Issue, prURL string) error {
+	if l, ok := sourceFor(issue.Repo).(prLinker); ok {
+		if err := l.LinkPR(ctx, issue, prURL, prTitle(issue)); err != nil {
+			logger(ctx).Warn("linking PR to the issue failed", "pr", prURL, "err", err)
+		}
+	}
 	// Check this specific issue's comments (not all issues)
-	out, err := run(ctx, "", "gh", "issue", "view",
-		strconv.Itoa(issue.Number),
-		"--repo", issue.Repo,
-		"--json", "comments",
-	)
-	if err == nil {
-		var result struct{ Comments []Comment `json:"comments"` }
-		if json.Unmarshal([]byte(out), &result) == nil {
-			for _, c := range result.Comments {
-				if strings.Contains(c.Body, prURL) {
-					return nil // Already commented
-				}
+	if comments, err := sourceFor(issue.Repo).Comments(ctx, issue); err == nil {
+		for _, c := range comments {
+			if strings.Contains(c.Body, prURL) {
+				return nil // Already commented
 			}
 		}
 	}
@@ -1046,43 +1726,65 @@ func ensurePRComment(ctx context.Context, issue Issue, prURL string) error {
 	return commentOnIssue(ctx, issue, fmt.Sprintf("PR ready for review: %s", prURL))
 }
 
-func runClaude(ctx context.Context, cfg Config, issue Issue, wtDir, logFile string) error {
-	prompt := buildPrompt(issue)
-
-	// Create a context with 10-minute timeout
-	claudeCtx, cancel := context.WithTimeout(ctx, 10*time.Minute)
-	defer cancel()
-
-	cmd := exec.CommandContext(claudeCtx, "claude", "-p", prompt,
-		"--allowedTools", "Bash,Read,Write,Edit",
-		"--max-turns", strconv.Itoa(cfg.MaxTurns),
-	)
-	cmd.Dir = wtDir
+// runAgent runs the repo's coding agent on prompt inside the issue's worktree.
+// It returns the agent's usage when the agent reports it, even on failure.
+func runAgent(ctx context.Context, cfg Config, settings repoSettings, issue Issue, prompt, wtDir, logFile string) (*Usage, error) {
+	return runAgentTo(ctx, cfg, settings, issue, prompt, wtDir, logFile, io.Discard)
+}
 
-	// Clear CLAUDECODE env var so claude doesn't think it's nested
-	cmd.Env = filterEnv(os.Environ(), "CLAUDECODE")
+// runAgentTo is runAgent, also copying the agent's output (for Claude, its
+// final reply) to reply.
+func runAgentTo(ctx context.Context, cfg Config, settings repoSettings, issue Issue, prompt, wtDir, logFile string, reply io.Writer) (*Usage, error) {
+	prompt += settings.promptNotes()
 
 	// Capture output to log file
 	f, err := os.Create(logFile)
 	if err != nil {
-		return fmt.Errorf("creating log file: %w", err)
+		return nil, fmt.Errorf("creating log file: %w", err)
 	}
 	defer f.Close()
 
-	cmd.Stdout = f
-	cmd.Stderr = f
+	sb := cfg.Sandbox.forWorktree(wtDir)
 
-	log.Printf("[claude] running on %s (log: %s)", issue.key(), logFile)
+	// The repo's setup commands (.claude-bot.yml) run first, outside the agent timeout
+	if err := settings.runSetup(ctx, wtDir, sb, f); err != nil {
+		return nil, err
+	}
 
-	if err := cmd.Run(); err != nil {
+	agentCtx, cancel := context.WithTimeout(ctx, cfg.AgentTimeout)
+	defer cancel()
+
+	logger(ctx).Info("running agent", "log", logFile)
+
+	defer agentDuration.since(time.Now(), issue.Repo)
+	var usage *Usage
+	reported := &Usage{}
+	err = cfg.agentFor(issue.Repo).Run(agentCtx, AgentRequest{
+		Prompt:   prompt,
+		Dir:      wtDir,
+		MaxTurns: cfg.MaxTurns,
+		Model:    cfg.Model,
+		Tools:    cfg.AllowedTools,
+		Denied:   cfg.DisallowedTools,
+		MCP:      cfg.MCPConfig,
+		Args:     cfg.AgentArgs,
+		Output:   io.MultiWriter(f, reply),
+		Sandbox:  sb,
+		Usage:    reported,
+	})
+	if *reported != (Usage{}) {
+		usage = reported
+		recordUsage(issue.Repo, *usage)
+	}
+	if err != nil {
 		// Context deadline = timeout
-		if claudeCtx.Err() == context.DeadlineExceeded {
-			return fmt.Errorf("claude timed out after 10 minutes")
+		if agentCtx.Err() == context.DeadlineExceeded {
+			return usage, fmt.Errorf("agent timed out after %s", cfg.AgentTimeout)
 		}
-		return fmt.Errorf("claude exited with error: %w", err)
+		return usage, fmt.Errorf("agent exited with error: %w", err)
 	}
 
-	return nil
+	return usage, nil
 }
 
 // filterEnv returns env vars with the specified key removed.
@@ -1097,54 +1799,30 @@ func filterEnv(env []string, key string) []string {
 	return filtered
 }
 
-// --- GitHub Label/Comment Helpers ---
+// --- Label/Comment Helpers ---
+// Thin wrappers over the repo's Forge, so call sites don't care where it's hosted.
 
 func addLabel(ctx context.Context, issue Issue, label string) error {
-	_, err := run(ctx, "", "gh", "issue", "edit",
-		strconv.Itoa(issue.Number),
-		"--repo", issue.Repo,
-		"--add-label", label,
-	)
-	return err
+	return sourceFor(issue.Repo).AddLabel(ctx, issue, label)
 }
 
 func removeLabel(ctx context.Context, issue Issue, label string) error {
-	_, err := run(ctx, "", "gh", "issue", "edit",
-		strconv.Itoa(issue.Number),
-		"--repo", issue.Repo,
-		"--remove-label", label,
-	)
-	return err
+	return sourceFor(issue.Repo).RemoveLabel(ctx, issue, label)
 }
 
 func commentOnIssue(ctx context.Context, issue Issue, body string) error {
 	// Embed invisible marker so hasBotComment can reliably detect bot comments
-	_, err := run(ctx, "", "gh", "issue", "comment",
-		strconv.Itoa(issue.Number),
-		"--repo", issue.Repo,
-		"--body", body+"\n"+botCommentMarker,
-	)
-	return err
+	return sourceFor(issue.Repo).Comment(ctx, issue, body+"\n"+botCommentMarker)
 }
 
 // lastCommentContains checks if the most recent comment on an issue contains the given text.
 // Used to prevent duplicate error comments on retries.
 func lastCommentContains(ctx context.Context, issue Issue, text string) bool {
-	out, err := run(ctx, "", "gh", "issue", "view",
-		strconv.Itoa(issue.Number),
-		"--repo", issue.Repo,
-		"--json", "comments",
-	)
-	if err != nil {
-		return false
-	}
-	var result struct {
-		Comments []Comment `json:"comments"`
-	}
-	if json.Unmarshal([]byte(out), &result) != nil || len(result.Comments) == 0 {
+	comments, err := sourceFor(issue.Repo).Comments(ctx, issue)
+	if err != nil || len(comments) == 0 {
 		return false
 	}
-	last := result.Comments[len(result.Comments)-1]
+	last := comments[len(comments)-1]
 	return strings.Contains(last.Body, text)
 }
 
@@ -1155,7 +1833,7 @@ func cleanupWorktree(ctx context.Context, repoDir, wtDir, branch string) {
 		return
 	}
 	if _, err := run(ctx, repoDir, "git", "worktree", "remove", wtDir, "--force"); err != nil {
-		log.Printf("[cleanup] warning: couldn't remove worktree %s: %v", wtDir, err)
+		logger(ctx).Warn("couldn't remove worktree", "dir", wtDir, "err", err)
 	}
 	// Also delete the local branch to prevent stale branch errors on retry
 	_ = deleteLocalBranch(ctx, repoDir, branch)
@@ -1169,8 +1847,15 @@ func run(ctx context.Context, dir string, name string, args ...string) (string,
 		cmd.Dir = dir
 	}
 
+	sub := subcommand(name, args)
+	start := time.Now()
 	out, err := cmd.CombinedOutput()
+	subprocessTotal.inc(name, sub)
+	subprocessDuration.since(start, name, sub)
+	logger(ctx).Debug("ran command", "cmd", name, "subcommand", sub, "dir", dir,
+		"duration", time.Since(start), "err", err)
 	if err != nil {
+		subprocessErrors.inc(name, sub)
 		return string(out), fmt.Errorf("%s %s: %w\n%s", name, strings.Join(args, " "), err, out)
 	}
 
@@ -1179,7 +1864,7 @@ func run(ctx context.Context, dir string, name string, args ...string) (string,
 
 // --- Clean ---
 
-// cleanState removes worktrees and logs but preserves repo clones.
+// cleanState removes worktrees and logs but preserves repo clones and job history.
 // Use this to reset working state so the next run re-tests deps and starts fresh.
 // Idempotent: skips directories that don't exist.
 func cleanState(cfg Config) {
@@ -1187,7 +1872,7 @@ func cleanState(cfg Config) {
 		{"worktrees", cfg.WorktreeDir},
 		{"logs", cfg.LogDir},
 	})
-	log.Println("[clean] done — repo clones preserved in", cfg.RepoDir)
+	slog.Info("clean done, repo clones preserved", "component", "clean", "path", cfg.RepoDir)
 }
 
 // cleanEverything removes all claude-bot directories including repo clones.
@@ -1198,51 +1883,27 @@ func cleanEverything(cfg Config) {
 		{"worktrees", cfg.WorktreeDir},
 		{"repos", cfg.RepoDir},
 		{"logs", cfg.LogDir},
+		{"state", cfg.StateDir},
 	})
-	log.Println("[clean-all] done — full reset")
+	slog.Info("full reset done", "component", "clean-all")
 }
 
 func removeDirs(dirs []struct{ name, path string }) {
 	for _, d := range dirs {
 		if _, err := os.Stat(d.path); os.IsNotExist(err) {
-			log.Printf("[clean] %s: %s (not found, skipping)", d.name, d.path)
+			slog.Info("not found, skipping", "component", "clean", "name", d.name, "path", d.path)
 			continue
 		}
 		if err := os.RemoveAll(d.path); err != nil {
-			log.Printf("[clean] error removing %s (%s): %v", d.name, d.path, err)
+			slog.Error("removing failed", "component", "clean", "name", d.name, "path", d.path, "err", err)
 		} else {
-			log.Printf("[clean] removed %s: %s", d.name, d.path)
+			slog.Info("removed", "component", "clean", "name", d.name, "path", d.path)
 		}
 	}
 }
 
 // --- Helpers ---
 
-func buildPrompt(issue Issue) string {
-	var b strings.Builder
-
-	b.WriteString("You are working on a codebase. Fix the following GitHub issue.\n\n")
-	fmt.Fprintf(&b, "## Issue #%d: %s\n%s\n\n", issue.Number, issue.Title, issue.Body)
-
-	if len(issue.Comments) > 0 {
-		b.WriteString("## Comments (conversation with the user):\n")
-		for _, c := range issue.Comments {
-			fmt.Fprintf(&b, "**%s** (%s):\n%s\n\n", c.Author.Login, c.CreatedAt, c.Body)
-		}
-	}
-
-	b.WriteString(`## Instructions:
-- Read CLAUDE.md in the repo root for project-specific instructions
-- Understand the codebase before making changes
-- Make minimal, focused changes that address the issue
-- Run any existing tests and make sure they pass
-- If you create new functionality, add tests
-- Do NOT commit — just make the file changes
-`)
-
-	return b.String()
-}
-
 var nonAlphanumeric = regexp.MustCompile(`[^a-z0-9]+`)
 
 func slugify(s string) string {
@@ -1263,6 +1924,17 @@ func branchName(issue Issue) string {
 	return fmt.Sprintf("issue-%d-%s", issue.Number, slug)
 }
 
+// splitList splits a comma-separated env var, dropping blanks.
+func splitList(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
 // loadDotEnv reads .env from the binary's directory. No-op if missing.
 // Stdlib only — no external deps. Only sets vars not already in the environment.
 func loadDotEnv() {
@@ -1304,7 +1976,7 @@ func expandHome(path string) string {
 }
 
 func ensureDirs(cfg Config) {
-	for _, dir := range []string{cfg.WorktreeDir, cfg.RepoDir, cfg.LogDir} {
+	for _, dir := range []string{cfg.WorktreeDir, cfg.RepoDir, cfg.LogDir, cfg.StateDir} {
 		if err := os.MkdirAll(dir, 0755); err != nil {
 			log.Fatalf("failed to create directory %s: %v", dir, err)
 		}
@@ -1312,64 +1984,92 @@ func ensureDirs(cfg Config) {
 }
 
 // ensureLabels creates the required labels on each repo if they don't exist.
-// Idempotent: gh label create errors if label already exists, which we ignore.
+// Idempotent: each forge skips labels that already exist.
 func ensureLabels(ctx context.Context, cfg Config) {
-	labels := []struct{ name, color, desc string }{
-		{cfg.IssueLabel, "0E8A16", "Issue ready for claude-bot"},
-		{cfg.WIPLabel, "FBCA04", "claude-bot is working on this"},
-		{cfg.DoneLabel, "1D76DB", "claude-bot created a PR"},
-		{cfg.NeedsInfoLabel, "D93F0B", "claude-bot needs more context"},
-		{cfg.FailedLabel, "B60205", "claude-bot failed after max retries"},
-		{cfg.TriageLabel, "C5DEF5", "claude-bot triaged this issue"},
-	}
 	for _, repo := range cfg.Repos {
+		cfg := cfg.forRepo(repo)
+		labels := []struct{ name, color, desc string }{
+			{cfg.IssueLabel, "0E8A16", "Issue ready for claude-bot"},
+			{cfg.WIPLabel, "FBCA04", "claude-bot is working on this"},
+			{cfg.DoneLabel, "1D76DB", "claude-bot created a PR"},
+			{cfg.NeedsInfoLabel, "D93F0B", "claude-bot needs more context"},
+			{cfg.FailedLabel, "B60205", "claude-bot failed after max retries"},
+			{cfg.CIFailedLabel, "E99695", "claude-bot's PR is failing CI"},
+			{cfg.TriageLabel, "C5DEF5", "claude-bot triaged this issue"},
+			{cfg.NeedsReviewLabel, "5319E7", "claude-bot is waiting for a maintainer to check this issue"},
+			{cfg.PlanLabel, "BFD4F2", "claude-bot plans first and waits for approval"},
+			{cfg.AwaitingApprovalLabel, "FEF2C0", "claude-bot's plan is waiting for approval"},
+		}
+		for _, name := range cfg.profileNames() {
+			labels = append(labels, struct{ name, color, desc string }{name, "BFD4F2", "claude-bot agent profile"})
+		}
+		source := sourceFor(repo)
 		for _, l := range labels {
-			if _, err := run(ctx, "", "gh", "label", "create", l.name,
-				"--repo", repo,
-				"--color", l.color,
-				"--description", l.desc,
-			); err != nil {
-				continue // Label already exists — fine
+			created, err := source.EnsureLabel(ctx, repo, l.name, l.color, l.desc)
+			if err != nil {
+				slog.Error("creating label failed", "component", "labels", "repo", repo, "label", l.name, "err", err)
+				continue
+			}
+			if created {
+				slog.Info("created label", "component", "labels", "repo", repo, "label", l.name)
 			}
-			log.Printf("[labels] created %q on %s", l.name, repo)
 		}
 	}
 }
 
 // recoverStaleIssues resets issues stuck in "in-progress" with no PR back to "todo".
 // Called on startup before workers start, so there's no race condition.
-func recoverStaleIssues(ctx context.Context, cfg Config) {
+// Attempts the previous process left running are closed as interrupted first.
+func recoverStaleIssues(ctx context.Context, cfg Config, t *tracker) {
+	for _, a := range t.markInterrupted() {
+		slog.Warn("run was interrupted", "component", "recovery", "key", a.Key, "run_id", a.ID, "worker", a.Worker, "started", a.Start)
+	}
+
 	for _, repo := range cfg.Repos {
+		cfg := cfg.forRepo(repo)
 		issues, err := fetchIssues(ctx, repo, cfg.WIPLabel)
 		if err != nil {
-			log.Printf("[recovery] error checking stale issues in %s: %v", repo, err)
+			slog.Error("checking stale issues failed", "component", "recovery", "repo", repo, "err", err)
 			continue
 		}
 		for _, issue := range issues {
+			// Prefer the branch the last attempt actually used — the title may have changed since
 			branch := branchName(issue)
+			if last, ok := t.lastAttempt(issue.key()); ok {
+				if last.PRURL != "" {
+					slog.Info("has PR, marking done", "component", "recovery", "key", issue.key(), "pr", last.PRURL)
+					_ = addLabel(ctx, issue, cfg.DoneLabel)
+					_ = removeLabel(ctx, issue, cfg.WIPLabel)
+					continue
+				}
+				branch = last.Branch
+			}
 			// Check if a PR already exists
-			out, _ := run(ctx, "", "gh", "pr", "list",
-				"--repo", issue.Repo,
-				"--head", branch,
-				"--json", "url",
-				"--limit", "1",
-			)
-			var prs []struct{ URL string `json:"url"` }
-			if json.Unmarshal([]byte(out), &prs) == nil && len(prs) > 0 {
+			if url, _ := forgeFor(repo).FindPR(ctx, issue.Repo, branch); url != "" {
 				// PR exists — mark done
-				log.Printf("[recovery] %s has PR, marking done", issue.key())
+				slog.Info("has PR, marking done", "component", "recovery", "key", issue.key(), "pr", url)
 				_ = addLabel(ctx, issue, cfg.DoneLabel)
 				_ = removeLabel(ctx, issue, cfg.WIPLabel)
 				continue
 			}
 			// No PR — reset to todo
-			log.Printf("[recovery] %s stuck in-progress with no PR, resetting to todo", issue.key())
+			slog.Info("stuck in-progress with no PR, resetting", "component", "recovery", "key", issue.key())
 			_ = removeLabel(ctx, issue, cfg.WIPLabel)
 			_ = addLabel(ctx, issue, cfg.IssueLabel)
 		}
 	}
 }
 
+// retryCount returns how many attempts on the issue count toward CB_MAX_RETRIES.
+// Local history is authoritative; issues it has never seen fall back to counting
+// the bot's error comments.
+func retryCount(t *tracker, issue Issue) int {
+	if n, ok := t.failures(issue.key()); ok {
+		return n
+	}
+	return countBotErrors(issue)
+}
+
 // countBotErrors counts how many error comments the bot has posted on an issue.
 func countBotErrors(issue Issue) int {
 	count := 0
@@ -219,7 +219,10 @@ func TestBuildPrompt(t *testing.T) {
 			},
 		},
 	}
-	prompt := buildPrompt(issue)
+	prompt, err := renderPrompt(context.Background(), Config{}, "", "", promptIssue, promptData{Issue: issue})
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	for _, want := range []string{"Issue #42", "Fix bug", "It's broken", "alice", "Please fix", "Do NOT commit"} {
 		if !strings.Contains(prompt, want) {
@@ -328,7 +331,7 @@ func TestIntegration(t *testing.T) {
 		ghRun(t, "issue", "create", "--repo", fullRepo,
 			"--title", "Stale issue", "--body", "Stuck", "--label", "in-progress")
 
-		recoverStaleIssues(ctx, cfg)
+		recoverStaleIssues(ctx, cfg, newTracker())
 
 		stale, _ := fetchIssues(ctx, fullRepo, "in-progress")
 		for _, iss := range stale {
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Metrics ---
+// A minimal Prometheus text-format registry (stdlib only): counters and
+// histograms with labels, plus gauges read at scrape time. Served at /metrics
+// on CB_HTTP_ADDR.
+
+type metricsRegistry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+type metric interface {
+	write(w io.Writer)
+}
+
+func (r *metricsRegistry) add(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+func (r *metricsRegistry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, m := range r.metrics {
+		m.write(w)
+	}
+}
+
+// labelPairs renders {a="x",b="y"} for the given names and values.
+func labelPairs(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	parts := make([]string, len(names))
+	for i, n := range names {
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(values[i])
+		parts[i] = fmt.Sprintf(`%s="%s"`, n, v)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// seriesKey joins label values into a map key.
+func seriesKey(values []string) string { return strings.Join(values, "\x00") }
+
+// --- Counter ---
+
+type counterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]float64
+	order  map[string][]string // key → label values
+}
+
+func (r *metricsRegistry) counter(name, help string, labels ...string) *counterVec {
+	c := &counterVec{name: name, help: help, labels: labels, values: map[string]float64{}, order: map[string][]string{}}
+	r.add(c)
+	return c
+}
+
+func (c *counterVec) inc(values ...string) { c.add(1, values...) }
+
+func (c *counterVec) add(v float64, values ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	k := seriesKey(values)
+	c.values[k] += v
+	c.order[k] = values
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, k := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, labelPairs(c.labels, c.order[k]), formatFloat(c.values[k]))
+	}
+}
+
+// --- Histogram ---
+
+type histogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64 // upper bounds, ascending
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	values []string
+	counts []uint64 // per bucket, non-cumulative
+	sum    float64
+	count  uint64
+}
+
+// Default buckets, in seconds.
+var (
+	subprocessBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+	agentBuckets      = []float64{10, 30, 60, 120, 180, 300, 450, 600, 900}
+)
+
+func (r *metricsRegistry) histogram(name, help string, buckets []float64, labels ...string) *histogramVec {
+	h := &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: map[string]*histogramSeries{}}
+	r.add(h)
+	return h
+}
+
+func (h *histogramVec) observe(v float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	k := seriesKey(values)
+	s, ok := h.series[k]
+	if !ok {
+		s = &histogramSeries{values: values, counts: make([]uint64, len(h.buckets))}
+		h.series[k] = s
+	}
+	for i, b := range h.buckets {
+		if v <= b {
+			s.counts[i]++
+			break
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+// since observes the seconds elapsed since start.
+func (h *histogramVec) since(start time.Time, values ...string) {
+	h.observe(time.Since(start).Seconds(), values...)
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, k := range sortedKeys(h.series) {
+		s := h.series[k]
+		names := append(append([]string{}, h.labels...), "le")
+		var cum uint64
+		for i, b := range h.buckets {
+			cum += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelPairs(names, append(append([]string{}, s.values...), formatFloat(b))), cum)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelPairs(names, append(append([]string{}, s.values...), "+Inf")), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labelPairs(h.labels, s.values), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labelPairs(h.labels, s.values), s.count)
+	}
+}
+
+// --- Gauge (read at scrape time) ---
+
+type gaugeFunc struct {
+	name, help string
+	labels     []string
+	fn         func() map[string]float64 // single label value → value; "" key when unlabeled
+}
+
+func (r *metricsRegistry) gauge(name, help string, labels []string, fn func() map[string]float64) {
+	r.add(&gaugeFunc{name: name, help: help, labels: labels, fn: fn})
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+	values := g.fn()
+	for _, k := range sortedKeys(values) {
+		var lp string
+		if len(g.labels) > 0 {
+			lp = labelPairs(g.labels, []string{k})
+		}
+		fmt.Fprintf(w, "%s%s %s\n", g.name, lp, formatFloat(values[k]))
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// --- Bot metrics ---
+
+var (
+	metrics = &metricsRegistry{}
+
+	jobsTotal = metrics.counter("claudebot_jobs_total",
+		"Jobs by repo and state (queued, deferred, started, succeeded, failed, needs_info, ci_failed, planned, cancelled, interrupted).",
+		"repo", "state")
+	agentDuration = metrics.histogram("claudebot_agent_run_duration_seconds",
+		"Duration of coding agent runs.", agentBuckets, "repo")
+	agentCost = metrics.counter("claudebot_agent_cost_usd_total",
+		"Cost of coding agent runs in USD, as reported by the agent.", "repo")
+	agentTurns = metrics.counter("claudebot_agent_turns_total",
+		"Turns used by coding agent runs.", "repo")
+	agentTokens = metrics.counter("claudebot_agent_tokens_total",
+		"Tokens used by coding agent runs, by kind (input, output, cache_read, cache_write).", "repo", "kind")
+	subprocessTotal = metrics.counter("claudebot_subprocess_total",
+		"gh and git subprocesses run, by command and subcommand.", "cmd", "subcommand")
+	subprocessErrors = metrics.counter("claudebot_subprocess_errors_total",
+		"gh and git subprocesses that exited with an error.", "cmd", "subcommand")
+	subprocessDuration = metrics.histogram("claudebot_subprocess_duration_seconds",
+		"Latency of gh and git subprocesses.", subprocessBuckets, "cmd", "subcommand")
+	pollDuration = metrics.histogram("claudebot_poll_duration_seconds",
+		"Duration of a poll cycle for one repo.", subprocessBuckets, "repo")
+	pollErrors = metrics.counter("claudebot_poll_errors_total",
+		"Poll cycles that failed to list issues.", "repo")
+)
+
+// jobState maps an attempt result to its claudebot_jobs_total state.
+func jobState(result string) string {
+	switch result {
+	case resultDone:
+		return "succeeded"
+	case resultError:
+		return "failed"
+	case resultNeedsInfo:
+		return "needs_info"
+	case resultCIFailed:
+		return "ci_failed"
+	}
+	return result
+}
+
+// subcommand names a gh/git invocation for metrics: "issue list", "api graphql", "fetch".
+// gh subcommands are two words deep; flags are never included.
+func subcommand(name string, args []string) string {
+	depth := 1
+	if name == "gh" {
+		depth = 2
+	}
+	var words []string
+	for _, a := range args {
+		if len(words) == depth || strings.HasPrefix(a, "-") {
+			break
+		}
+		words = append(words, a)
+	}
+	return strings.Join(words, " ")
+}
+
+// registerRuntimeGauges exposes queue depth and worker utilisation.
+func registerRuntimeGauges(cfg Config, jobs chan Issue, t *tracker) {
+	metrics.gauge("claudebot_queue_depth", "Issues waiting in the jobs channel.", nil, func() map[string]float64 {
+		return map[string]float64{"": float64(len(jobs))}
+	})
+	metrics.gauge("claudebot_workers", "Workers by state (busy, idle).", []string{"state"}, func() map[string]float64 {
+		busy := float64(len(t.running()))
+		return map[string]float64{"busy": busy, "idle": float64(cfg.Workers) - busy}
+	})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSubcommand(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"gh", []string{"issue", "list", "--repo", "owner/repo"}, "issue list"},
+		{"gh", []string{"api", "graphql", "-f", "query=..."}, "api graphql"},
+		{"gh", []string{"api", "--hostname", "ghe.example.com", "graphql"}, "api"},
+		{"git", []string{"worktree", "add", "-b", "x"}, "worktree"},
+		{"git", []string{"push", "-u", "origin", "x"}, "push"},
+	}
+	for _, tt := range tests {
+		if got := subcommand(tt.name, tt.args); got != tt.want {
+			t.Errorf("subcommand(%s, %v) = %q, want %q", tt.name, tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestMetricsExposition(t *testing.T) {
+	r := &metricsRegistry{}
+	c := r.counter("test_jobs_total", "Jobs.", "repo", "state")
+	c.inc("owner/repo", "queued")
+	c.inc("owner/repo", "queued")
+	c.inc(`we"ird`, "failed")
+	h := r.histogram("test_duration_seconds", "Durations.", []float64{1, 5}, "repo")
+	h.observe(0.5, "owner/repo")
+	h.observe(3, "owner/repo")
+	h.observe(9, "owner/repo")
+	r.gauge("test_workers", "Workers.", []string{"state"}, func() map[string]float64 {
+		return map[string]float64{"busy": 1, "idle": 3}
+	})
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	out := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE test_jobs_total counter",
+		`test_jobs_total{repo="owner/repo",state="queued"} 2`,
+		`test_jobs_total{repo="we\"ird",state="failed"} 1`,
+		"# TYPE test_duration_seconds histogram",
+		`test_duration_seconds_bucket{repo="owner/repo",le="1"} 1`,
+		`test_duration_seconds_bucket{repo="owner/repo",le="5"} 2`,
+		`test_duration_seconds_bucket{repo="owner/repo",le="+Inf"} 3`,
+		`test_duration_seconds_sum{repo="owner/repo"} 12.5`,
+		`test_duration_seconds_count{repo="owner/repo"} 3`,
+		`test_workers{state="busy"} 1`,
+		`test_workers{state="idle"} 3`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("exposition missing %q:\n%s", want, out)
+		}
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
+
+func TestJobState(t *testing.T) {
+	for result, want := range map[string]string{
+		resultDone:        "succeeded",
+		resultError:       "failed",
+		resultNeedsInfo:   "needs_info",
+		resultCancelled:   "cancelled",
+		resultInterrupted: "interrupted",
+	} {
+		if got := jobState(result); got != want {
+			t.Errorf("jobState(%q) = %q, want %q", result, got, want)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// --- Plans ---
+// "/bot plan" (see commands.go) makes the issue's next run a planning run: the
+// agent reads the code with read-only tools, and its reply is posted on the
+// issue. Nothing is committed or pushed. The plan stays in the conversation,
+// so the run that implements it (after "/bot retry") sees it, along with any
+// replies that amend it.
+//
+// With CB_PLAN_FIRST (plan_first per repo), or on issues labelled bot:plan,
+// every issue gets a plan before any code: the plan is posted, the issue
+// moves from the issue label to awaiting-approval, and it waits there until
+// an authorized user comments "/bot approve" below the plan. The poll then
+// queues it again, and the run implements the approved plan, with the rest of
+// the approval comment as amendments. A newer plan (from "/bot plan") needs
+// its own approval.
+
+// planTools are what the agent may use while planning: it can look, not touch.
+var planTools = []string{"Read", "Glob", "Grep"}
+
+// planDenied keeps it that way whatever the CLI's own permission settings
+// allow: the tools noToolsDenied covers, less WebFetch, which only looks.
+var planDenied = slices.DeleteFunc(slices.Clone(noToolsDenied), func(t string) bool { return t == "WebFetch" })
+
+// maxPlanComment keeps the posted plan under GitHub's 65536-character limit.
+const maxPlanComment = 60000
+
+// planHeader starts every posted plan; the plan runs from there to planFooter.
+const (
+	planHeader = "claude-bot's plan for this issue:\n\n"
+	planFooter = "\n\n---\n"
+)
+
+// postPlan runs the agent read-only in wtDir and posts its plan on the issue.
+// If it's to wait for approval, the comment says how to give it.
+func postPlan(ctx context.Context, cfg Config, settings repoSettings, t *tracker, attemptID string, issue Issue, wtDir, logFile string, awaiting bool) error {
+	cfg.AllowedTools = settings.allowedTools(planTools)
+	cfg.DisallowedTools = append(slices.Clone(cfg.DisallowedTools), planDenied...)
+	var out strings.Builder
+	usage, err := runAgentTo(ctx, cfg, settings, issue, buildPlanPrompt(issue), wtDir, logFile, &out)
+	if usage != nil {
+		_ = t.setUsage(attemptID, *usage)
+	}
+	if err != nil {
+		return fmt.Errorf("running agent: %w", err)
+	}
+	plan := strings.TrimSpace(out.String())
+	if plan == "" {
+		return errors.New("the agent produced no plan")
+	}
+	if len(plan) > maxPlanComment {
+		plan = plan[:maxPlanComment] + "\n\n[... plan trimmed ...]"
+	}
+	next := "Reply to amend it, then comment `/bot retry` to have it implemented."
+	if awaiting {
+		next = "To have it implemented, comment `/bot approve`. Anything else in that comment is taken as changes to the plan."
+	}
+	body := planHeader + plan + planFooter + next
+	if err := commentOnIssue(ctx, issue, body); err != nil {
+		return fmt.Errorf("posting plan: %w", err)
+	}
+	return nil
+}
+
+// buildPlanPrompt asks the agent for an implementation plan, not changes.
+func buildPlanPrompt(issue Issue) string {
+	var b strings.Builder
+
+	b.WriteString("You are working on a codebase. Plan how to fix the following GitHub issue, but don't change anything yet.\n\n")
+	fmt.Fprintf(&b, "## Issue #%d\n%s\n\n", issue.Number, untrusted(issue.Title, issue.Body))
+
+	if len(issue.Comments) > 0 {
+		b.WriteString("## Comments (conversation with the user):\n")
+		for _, c := range issue.Comments {
+			fmt.Fprintf(&b, "**%s** (%s):\n%s\n\n", c.Author.Login, c.CreatedAt, untrusted(c.Body))
+		}
+	}
+
+	b.WriteString(`## Instructions:
+- Read CLAUDE.md in the repo root for project-specific instructions
+- Read the code the issue touches before deciding on an approach
+- Reply with the plan only, in Markdown: the approach, the files to change and how, the tests to add, and any open questions
+- Keep it short enough to review at a glance
+- Do NOT modify any files
+` + untrustedNote + "\n")
+
+	return b.String()
+}
+
+// needsPlan reports whether issue must have an approved plan before any code.
+func (cfg Config) needsPlan(issue Issue) bool {
+	return cfg.PlanFirst || issue.hasLabel(cfg.PlanLabel)
+}
+
+// approvedPlan is a posted plan and what its approver added to it.
+type approvedPlan struct {
+	Plan       string
+	Amendments string // the approval comment without its /bot lines; may be ""
+	Approver   string
+}
+
+// latestPlan returns the newest plan the bot posted on issue and the index of
+// its comment, or -1 if there isn't one.
+func latestPlan(issue Issue) (string, int) {
+	for i, c := range slices.Backward(issue.Comments) {
+		if !isBotComment(c) {
+			continue
+		}
+		rest, ok := strings.CutPrefix(c.Body, planHeader)
+		if !ok {
+			continue
+		}
+		plan, _, _ := strings.Cut(rest, planFooter)
+		return plan, i
+	}
+	return "", -1
+}
+
+// findPlanApproval returns the issue's latest plan if an authorized user
+// approved it with "/bot approve" after it was posted. Authorized means in
+// CB_COMMAND_USERS, or allowed to queue issues with at least write access.
+func findPlanApproval(ctx context.Context, cfg Config, issue Issue) (approvedPlan, bool, error) {
+	plan, at := latestPlan(issue)
+	if at < 0 {
+		return approvedPlan{}, false, nil
+	}
+	for _, c := range issue.Comments[at+1:] {
+		user := c.Author.Login
+		if isBotComment(c) || !slices.Contains(parseCommands(c.Body), botCommand{Verb: "approve"}) {
+			continue
+		}
+		ok := cfg.commandAllowed(user)
+		if !ok {
+			why, err := checkUser(ctx, cfg, issue.Repo, user)
+			if err != nil {
+				return approvedPlan{}, false, err
+			}
+			p, err := repoPermission(ctx, issue.Repo, user)
+			if err != nil {
+				return approvedPlan{}, false, fmt.Errorf("checking %s's permission: %w", user, err)
+			}
+			ok = why == "" && p >= permWrite
+		}
+		if ok {
+			notes := strings.TrimSpace(commandLine.ReplaceAllString(c.Body, ""))
+			return approvedPlan{Plan: plan, Amendments: sanitizeUntrusted(notes), Approver: user}, true, nil
+		}
+	}
+	return approvedPlan{}, false, nil
+}
+
+// pollPlanApprovals queues the issues awaiting approval whose plans have been
+// approved since the last poll.
+func pollPlanApprovals(ctx context.Context, cfg Config, repo string) {
+	lg := slog.Default().With("component", "plan", "repo", repo)
+	issues, err := fetchIssues(ctx, repo, cfg.AwaitingApprovalLabel)
+	if err != nil {
+		lg.Error("fetching issues awaiting approval failed", "err", err)
+		return
+	}
+	for _, issue := range issues {
+		ap, ok, err := findPlanApproval(ctx, cfg, issue)
+		if err != nil {
+			lg.Error("checking plan approval failed", "key", issue.key(), "err", err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		lg.Info("plan approved", "key", issue.key(), "approver", ap.Approver)
+		if err := addLabel(ctx, issue, cfg.IssueLabel); err != nil {
+			lg.Error("queueing approved issue failed", "key", issue.key(), "err", err)
+			continue
+		}
+		_ = removeLabel(ctx, issue, cfg.AwaitingApprovalLabel)
+	}
+}
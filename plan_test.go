@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlanRun(t *testing.T) {
+	ctx := context.Background()
+	clone := gitRepo(t, map[string]string{"README.md": "hello\n"})
+	origin, err := run(ctx, clone, "git", "remote", "get-url", "origin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := "gitea:plan.test/owner/repo"
+	f := &cmdForge{origin: strings.TrimSpace(origin), reactions: map[string]string{}}
+	useForge(t, "gitea:plan.test", f)
+
+	cfg := Config{
+		IssueLabel:      "todo",
+		WIPLabel:        "in-progress",
+		DoneLabel:       "done",
+		RepoDir:         t.TempDir(),
+		WorktreeDir:     t.TempDir(),
+		LogDir:          t.TempDir(),
+		AgentTimeout:    time.Minute,
+		DisallowedTools: []string{"WebSearch"},
+		// Read-only tools, with the rest denied, and it writes a file to prove
+		// nothing gets committed
+		Agent: `shell:test "$CB_ALLOWED_TOOLS" = Read,Glob,Grep && test "$CB_DISALLOWED_TOOLS" = WebSearch,Bash,Edit,MultiEdit,Write,NotebookEdit && ` +
+			`grep -q "Plan how to fix" && touch stray.txt && echo "1. Add a login helper"`,
+	}
+	issue := Issue{Repo: repo, Number: 8, Title: "Login", Body: "users can't log in"}
+	tr := newTracker()
+	tr.setOptions(issue.key(), issueOptions{Plan: true, Model: "opus"})
+
+	if err := processIssue(ctx, cfg, tr, 0, issue); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.comments) != 1 || !strings.Contains(f.comments[0], "1. Add a login helper") || !strings.Contains(f.comments[0], "/bot retry") {
+		t.Errorf("comments = %q", f.comments)
+	}
+	if !slices.Equal(f.labels, []string{"+in-progress", "-todo", "-in-progress"}) {
+		t.Errorf("labels = %v", f.labels)
+	}
+	if last, _ := tr.lastAttempt(issue.key()); last.Result != resultPlanned {
+		t.Errorf("attempt = %+v", last)
+	}
+	// The plan is a one-off; the rest of the options stay
+	if o := tr.options(issue.key()); o != (issueOptions{Model: "opus"}) {
+		t.Errorf("options after plan = %+v", o)
+	}
+	if out, _ := run(ctx, clone, "git", "ls-remote", "--heads", "origin"); strings.Contains(out, "issue-8") {
+		t.Errorf("planning pushed a branch: %s", out)
+	}
+}
+
+func TestBuildPlanPrompt(t *testing.T) {
+	issue := Issue{Number: 8, Title: "Login", Body: "users can't log in", Comments: []Comment{comment("alice", "only on Safari", "2026-03-01T09:00:00Z")}}
+	prompt := buildPlanPrompt(issue)
+	for _, want := range []string{"## Issue #8\n<untrusted-content>\nLogin", "users can't log in", "only on Safari", "Do NOT modify any files"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("prompt missing %q:\n%s", want, prompt)
+		}
+	}
+}
+
+func TestPlanFirst(t *testing.T) {
+	ctx := context.Background()
+	clone := gitRepo(t, map[string]string{"README.md": "hello\n"})
+	origin, err := run(ctx, clone, "git", "remote", "get-url", "origin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := "gitea:planfirst.test/owner/repo"
+	f := &cmdForge{origin: strings.TrimSpace(origin), reactions: map[string]string{}}
+	useForge(t, "gitea:planfirst.test", f)
+
+	cfg := Config{
+		IssueLabel:            "todo",
+		WIPLabel:              "in-progress",
+		PlanLabel:             "bot:plan",
+		AwaitingApprovalLabel: "awaiting-approval",
+		CommandUsers:          []string{"alice"},
+		RepoDir:               t.TempDir(),
+		WorktreeDir:           t.TempDir(),
+		LogDir:                t.TempDir(),
+		AgentTimeout:          time.Minute,
+		Agent:                 `shell:grep -q "Plan how to fix" && echo "1. Add a login helper"`,
+	}
+	issue := Issue{Repo: repo, Number: 9, Title: "Login", Body: "users can't log in", Labels: []Label{{Name: "todo"}, {Name: "bot:plan"}}}
+
+	// Labelled bot:plan: a plan to approve instead of code
+	if err := processIssue(ctx, cfg, newTracker(), 0, issue); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.comments) != 1 || !strings.HasPrefix(f.comments[0], planHeader+"1. Add a login helper") || !strings.Contains(f.comments[0], "`/bot approve`") {
+		t.Fatalf("comments = %q", f.comments)
+	}
+	if !slices.Equal(f.labels, []string{"+in-progress", "-todo", "+awaiting-approval", "-in-progress"}) {
+		t.Errorf("labels = %v", f.labels)
+	}
+
+	// Approvals only count from authorized users, after the plan
+	now := time.Now().UTC().Format(time.RFC3339)
+	issue.Labels = []Label{{Name: "awaiting-approval"}, {Name: "bot:plan"}}
+	issue.Comments = []Comment{
+		comment("alice", "/bot approve", now),
+		comment("claude-bot", f.comments[0]+"\n"+botCommentMarker, now),
+		comment("mallory", "/bot approve", now),
+	}
+	f.issues, f.labels = []Issue{issue}, nil
+	if _, ok, err := findPlanApproval(ctx, cfg, issue); ok || err == nil {
+		t.Errorf("mallory's approval counted (or her permission wasn't checked): %v, %v", ok, err)
+	}
+	issue.Comments[2] = comment("alice", "Looks good, but use bcrypt.\n/bot approve", now)
+	ap, ok, err := findPlanApproval(ctx, cfg, issue)
+	if !ok || err != nil || ap.Plan != "1. Add a login helper" || ap.Amendments != "Looks good, but use bcrypt." {
+		t.Fatalf("approval = %+v, %v, %v", ap, ok, err)
+	}
+
+	// The poll queues the approved issue again
+	f.issues = []Issue{issue}
+	pollPlanApprovals(ctx, cfg, repo)
+	if !slices.Equal(f.labels, []string{"+todo", "-awaiting-approval"}) {
+		t.Errorf("labels after approval = %v", f.labels)
+	}
+
+	// ...and the run gets the plan and the amendments
+	data := promptData{Issue: issue, Plan: ap.Plan, PlanAmendments: ap.Amendments}
+	prompt, err := renderPrompt(ctx, Config{}, "", "", promptIssue, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(prompt, "## Approved plan:\nA maintainer approved this plan. Implement it, with the changes they asked for.\n\n1. Add a login helper\n\n### Changes to the plan:\nLooks good, but use bcrypt.\n\n## Instructions:") {
+		t.Errorf("prompt:\n%s", prompt)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Authorization ---
+// Labelling an issue hands its text to an agent that can run commands, so
+// the poller can check who asked before queueing it:
+//
+//	CB_ALLOWED_USERS     only these users' labels count
+//	CB_ALLOWED_TEAMS     ... or those of these teams' members (org/team-slug)
+//	CB_MIN_PERMISSION    the labeler needs at least this permission on the repo
+//	CB_OUTSIDE_APPROVAL  issues opened by someone outside the repo's org also
+//	                     wait for "/bot approve" from a user with write access
+//
+// The labeler is whoever last added the issue label, from the issue's label
+// events. Labels the bot adds itself (requeues after errors, /bot retry, the
+// dashboard) stand for the user whose label last got the issue admitted, as
+// recorded in the tracker, and are checked again as theirs; with no such
+// record they're refused like any other unknown labeler. A refused issue
+// loses the label and gets a comment saying why; one waiting for approval
+// keeps it and gets a comment asking for approval. With nothing configured
+// every issue is allowed and no lookups are made.
+
+// policyCacheTTL is how long permission, membership and login lookups are
+// reused; the labeler is looked up on every poll.
+const policyCacheTTL = 10 * time.Minute
+
+type policyEntry struct {
+	val any
+	at  time.Time
+}
+
+var policyCache = struct {
+	sync.Mutex
+	m map[string]policyEntry
+}{m: map[string]policyEntry{}}
+
+// cachedLookup returns the cached result for key, or calls lookup and caches
+// what it returns. Errors aren't cached.
+func cachedLookup[T any](key string, lookup func() (T, error)) (T, error) {
+	policyCache.Lock()
+	e, ok := policyCache.m[key]
+	policyCache.Unlock()
+	if ok && time.Since(e.at) < policyCacheTTL {
+		return e.val.(T), nil
+	}
+	v, err := lookup()
+	if err != nil {
+		return v, err
+	}
+	policyCache.Lock()
+	policyCache.m[key] = policyEntry{val: v, at: time.Now()}
+	policyCache.Unlock()
+	return v, nil
+}
+
+// Logins are case-insensitive on every forge.
+func cacheKey(parts ...string) string { return strings.ToLower(strings.Join(parts, "\x00")) }
+
+func repoPermission(ctx context.Context, repo, user string) (permission, error) {
+	return cachedLookup(cacheKey("perm", repo, user), func() (permission, error) {
+		return forgeFor(repo).Permission(ctx, repo, user)
+	})
+}
+
+func orgMember(ctx context.Context, repo, user string) (bool, error) {
+	return cachedLookup(cacheKey("org", repo, user), func() (bool, error) {
+		return forgeFor(repo).OrgMember(ctx, repo, user)
+	})
+}
+
+func teamMember(ctx context.Context, repo, team, user string) (bool, error) {
+	return cachedLookup(cacheKey("team", parseRepo(repo).Host, team, user), func() (bool, error) {
+		return forgeFor(repo).TeamMember(ctx, repo, team, user)
+	})
+}
+
+func botLogin(ctx context.Context, repo string) (string, error) {
+	return cachedLookup(cacheKey("whoami", parseRepo(repo).Kind, parseRepo(repo).Host), func() (string, error) {
+		return forgeFor(repo).Whoami(ctx, repo)
+	})
+}
+
+type verdict int
+
+const (
+	verdictAllow verdict = iota
+	verdictDeny
+	verdictPending // waiting for a maintainer's approval
+)
+
+// policyEnabled reports whether any authorization rule is configured.
+func (cfg Config) policyEnabled() bool {
+	return cfg.labelerRules() || cfg.OutsideApproval
+}
+
+func (cfg Config) labelerRules() bool {
+	return len(cfg.AllowedUsers) > 0 || len(cfg.AllowedTeams) > 0 || cfg.MinPermission > permNone
+}
+
+// authorize decides whether issue may be queued and, if not, why. The labeler
+// of an allowed issue is recorded in t.
+func authorize(ctx context.Context, cfg Config, t *tracker, issue Issue) (verdict, string, error) {
+	if !cfg.policyEnabled() {
+		return verdictAllow, "", nil
+	}
+	self, err := botLogin(ctx, issue.Repo)
+	if err != nil {
+		return verdictDeny, "", fmt.Errorf("looking up the bot's login: %w", err)
+	}
+
+	var labeler string
+	if cfg.labelerRules() {
+		labeler, err = sourceFor(issue.Repo).Labeler(ctx, issue, cfg.IssueLabel)
+		if err != nil {
+			return verdictDeny, "", fmt.Errorf("finding who added the label: %w", err)
+		}
+		switch {
+		case labeler == "":
+			return verdictDeny, fmt.Sprintf("there's no record of who added the `%s` label", cfg.IssueLabel), nil
+		case strings.EqualFold(labeler, self):
+			// Put back by the bot: it's as good as the label it replaced
+			var ok bool
+			if labeler, ok = t.labeler(issue.key()); !ok {
+				return verdictDeny, fmt.Sprintf("claude-bot added the `%s` label itself, and there's no record of who queued the issue before", cfg.IssueLabel), nil
+			}
+		}
+		why, err := checkUser(ctx, cfg, issue.Repo, labeler)
+		if err != nil || why != "" {
+			return verdictDeny, why, err
+		}
+	}
+
+	if cfg.OutsideApproval {
+		author := issue.Author.Login
+		member, err := orgMember(ctx, issue.Repo, author)
+		if err != nil {
+			return verdictDeny, "", fmt.Errorf("checking %s's membership: %w", author, err)
+		}
+		if !member && !strings.EqualFold(author, self) {
+			approver, _, err := findApproval(ctx, cfg, issue)
+			if err != nil {
+				return verdictDeny, "", err
+			}
+			if approver == "" {
+				return verdictPending, fmt.Sprintf("@%s isn't a member of the organization", author), nil
+			}
+		}
+	}
+	if labeler != "" {
+		_ = t.setLabeler(issue.key(), labeler)
+	}
+	return verdictAllow, "", nil
+}
+
+// checkUser returns why user may not queue issues in repo, or "" if they may.
+func checkUser(ctx context.Context, cfg Config, repo, user string) (string, error) {
+	if len(cfg.AllowedUsers) > 0 || len(cfg.AllowedTeams) > 0 {
+		allowed := slices.ContainsFunc(cfg.AllowedUsers, func(u string) bool { return strings.EqualFold(u, user) })
+		for _, team := range cfg.AllowedTeams {
+			if allowed {
+				break
+			}
+			in, err := teamMember(ctx, repo, team, user)
+			if err != nil {
+				return "", fmt.Errorf("checking team %s: %w", team, err)
+			}
+			allowed = in
+		}
+		if !allowed {
+			return fmt.Sprintf("@%s isn't allowed to queue issues for it", user), nil
+		}
+	}
+	if cfg.MinPermission > permNone {
+		p, err := repoPermission(ctx, repo, user)
+		if err != nil {
+			return "", fmt.Errorf("checking %s's permission: %w", user, err)
+		}
+		if p < cfg.MinPermission {
+			return fmt.Sprintf("@%s has %s access to the repo, and %s is needed", user, p, cfg.MinPermission), nil
+		}
+	}
+	return "", nil
+}
+
+// findApproval returns who approved issue with "/bot approve", and the index
+// of their latest approving comment: someone other than its author who may
+// queue issues and has at least write access.
+func findApproval(ctx context.Context, cfg Config, issue Issue) (string, int, error) {
+	for i, c := range slices.Backward(issue.Comments) {
+		user := c.Author.Login
+		if isBotComment(c) || strings.EqualFold(user, issue.Author.Login) ||
+			!slices.Contains(parseCommands(c.Body), botCommand{Verb: "approve"}) {
+			continue
+		}
+		why, err := checkUser(ctx, cfg, issue.Repo, user)
+		if err != nil {
+			return "", -1, err
+		}
+		if why != "" {
+			continue
+		}
+		if p, err := repoPermission(ctx, issue.Repo, user); err != nil {
+			return "", -1, fmt.Errorf("checking %s's permission: %w", user, err)
+		} else if p >= permWrite {
+			return user, i, nil
+		}
+	}
+	return "", -1, nil
+}
+
+// admitIssue applies the policy and injection screening (see untrusted.go) to
+// a labeled issue and reports whether it may be queued. Refused issues lose
+// the label; each outcome is explained on the issue once.
+func admitIssue(ctx context.Context, cfg Config, t *tracker, issue Issue) bool {
+	v, why, err := authorize(ctx, cfg, t, issue)
+	lg := slog.Default().With("component", "policy", "repo", issue.Repo, "key", issue.key())
+	switch {
+	case err != nil:
+		lg.Error("authorization check failed", "err", err)
+		return false
+	case v == verdictDeny:
+		lg.Warn("refusing issue", "reason", why)
+		commentOnce(ctx, issue, fmt.Sprintf("claude-bot won't work on this issue: %s.", why))
+		_ = removeLabel(ctx, issue, cfg.IssueLabel)
+		return false
+	case v == verdictPending:
+		lg.Info("issue waiting for approval", "reason", why)
+		commentOnce(ctx, issue, fmt.Sprintf("claude-bot will work on this issue once a maintainer approves it, since %s. "+
+			"Maintainers: comment `/bot approve` to go ahead.", why))
+		return false
+	}
+	ok, err := screenIssue(ctx, cfg, t, issue)
+	if err != nil {
+		lg.Error("injection screening failed", "err", err)
+	}
+	return ok
+}
+
+// commentOnce posts body on issue unless the bot already has.
+func commentOnce(ctx context.Context, issue Issue, body string) {
+	for _, c := range issue.Comments {
+		if isBotComment(c) && strings.Contains(c.Body, body) {
+			return
+		}
+	}
+	_ = commentOnIssue(ctx, issue, body)
+}
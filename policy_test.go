@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// policyForge answers the policy's lookups from fixed data, counting them.
+type policyForge struct {
+	*cmdForge
+	labeler string
+	perms   map[string]permission
+	members []string            // of the repo's org
+	teams   map[string][]string // team → members
+	lookups int
+}
+
+func (f *policyForge) Labeler(context.Context, Issue, string) (string, error) {
+	return f.labeler, nil
+}
+func (f *policyForge) Permission(_ context.Context, _, user string) (permission, error) {
+	f.lookups++
+	return f.perms[user], nil
+}
+func (f *policyForge) OrgMember(_ context.Context, _, user string) (bool, error) {
+	f.lookups++
+	return slices.Contains(f.members, user), nil
+}
+func (f *policyForge) TeamMember(_ context.Context, _, team, user string) (bool, error) {
+	f.lookups++
+	return slices.Contains(f.teams[team], user), nil
+}
+func (f *policyForge) Whoami(context.Context, string) (string, error) { return "claude-bot", nil }
+
+func newPolicyForge(t *testing.T, host string) *policyForge {
+	f := &policyForge{
+		cmdForge: &cmdForge{reactions: map[string]string{}},
+		perms:    map[string]permission{"alice": permAdmin, "bob": permWrite, "carol": permRead},
+		members:  []string{"alice", "bob"},
+		teams:    map[string][]string{"acme/maintainers": {"carol"}},
+	}
+	useForge(t, host, f)
+	return f
+}
+
+func TestParsePermission(t *testing.T) {
+	if p, err := parsePermission("Maintain"); err != nil || p != permMaintain || p.String() != "maintain" {
+		t.Errorf("parsePermission(Maintain) = %v, %v", p, err)
+	}
+	if _, err := parsePermission("owner"); err == nil {
+		t.Error("owner isn't a GitHub permission")
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	ctx := context.Background()
+	repo := "gitea:policy.test/acme/repo"
+	f := newPolicyForge(t, "gitea:policy.test")
+
+	approve := func(user string) Comment { return comment(user, "looks fine\n/bot approve", "2026-03-01T09:00:00Z") }
+	tests := []struct {
+		name     string
+		cfg      Config
+		labeler  string
+		author   string
+		comments []Comment
+		want     verdict
+	}{
+		{"no policy", Config{}, "mallory", "mallory", nil, verdictAllow},
+		{"allowed user", Config{AllowedUsers: []string{"Bob"}}, "bob", "bob", nil, verdictAllow},
+		{"not allowed", Config{AllowedUsers: []string{"bob"}}, "mallory", "bob", nil, verdictDeny},
+		{"team member", Config{AllowedTeams: []string{"acme/maintainers"}}, "carol", "bob", nil, verdictAllow},
+		{"permission too low", Config{MinPermission: permWrite}, "carol", "bob", nil, verdictDeny},
+		{"permission enough", Config{MinPermission: permWrite}, "bob", "mallory", nil, verdictAllow},
+		{"bot's own label", Config{AllowedUsers: []string{"bob"}}, "claude-bot", "bob", nil, verdictDeny},
+		{"unknown labeler", Config{MinPermission: permRead}, "", "bob", nil, verdictDeny},
+		{"org member", Config{OutsideApproval: true}, "bob", "alice", nil, verdictAllow},
+		{"outsider", Config{OutsideApproval: true}, "bob", "mallory", nil, verdictPending},
+		{"outsider approved", Config{OutsideApproval: true}, "bob", "mallory", []Comment{approve("alice")}, verdictAllow},
+		{"self-approved", Config{OutsideApproval: true}, "bob", "mallory", []Comment{approve("mallory")}, verdictPending},
+		{"approver can't write", Config{OutsideApproval: true}, "bob", "mallory", []Comment{approve("carol")}, verdictPending},
+		{"approver not allowed", Config{OutsideApproval: true, AllowedUsers: []string{"bob"}}, "bob", "mallory", []Comment{approve("alice")}, verdictPending},
+	}
+	for _, tt := range tests {
+		f.labeler = tt.labeler
+		tt.cfg.IssueLabel = "todo"
+		issue := Issue{Repo: repo, Number: 1, Comments: tt.comments}
+		issue.Author.Login = tt.author
+		got, why, err := authorize(ctx, tt.cfg, newTracker(), issue)
+		if err != nil || got != tt.want {
+			t.Errorf("%s: authorize = %v (%s), %v; want %v", tt.name, got, why, err, tt.want)
+		}
+		if got != verdictAllow && why == "" {
+			t.Errorf("%s: no reason given", tt.name)
+		}
+	}
+}
+
+func TestAuthorizeBotLabels(t *testing.T) {
+	ctx := context.Background()
+	f := newPolicyForge(t, "gitea:requeue.test")
+	cfg := Config{IssueLabel: "todo", AllowedUsers: []string{"bob"}}
+	issue := Issue{Repo: "gitea:requeue.test/acme/repo", Number: 1}
+	tr := newTracker()
+
+	// A refused labeler isn't recorded, so the bot's label can't launder theirs
+	f.labeler = "mallory"
+	authorize(ctx, cfg, tr, issue)
+	f.labeler = "claude-bot"
+	if v, why, _ := authorize(ctx, cfg, tr, issue); v != verdictDeny || !strings.Contains(why, "no record of who queued") {
+		t.Errorf("bot's label without a record = %v (%s)", v, why)
+	}
+
+	f.labeler = "bob"
+	if v, _, err := authorize(ctx, cfg, tr, issue); v != verdictAllow || err != nil {
+		t.Fatalf("bob's label = %v, %v", v, err)
+	}
+	f.labeler = "claude-bot"
+	if v, why, err := authorize(ctx, cfg, tr, issue); v != verdictAllow || err != nil {
+		t.Errorf("bot's label after bob's = %v (%s), %v", v, why, err)
+	}
+	// ... and it's only as good as bob's still is
+	cfg.AllowedUsers = []string{"alice"}
+	if v, why, _ := authorize(ctx, cfg, tr, issue); v != verdictDeny || !strings.Contains(why, "@bob") {
+		t.Errorf("bot's label after bob lost access = %v (%s)", v, why)
+	}
+}
+
+func TestAuthorizeCachesLookups(t *testing.T) {
+	ctx := context.Background()
+	f := newPolicyForge(t, "gitea:cache.test")
+	f.labeler = "bob"
+	cfg := Config{IssueLabel: "todo", MinPermission: permWrite, OutsideApproval: true}
+	issue := Issue{Repo: "gitea:cache.test/acme/repo", Number: 1}
+	issue.Author.Login = "alice"
+
+	for range 3 {
+		if v, why, err := authorize(ctx, cfg, newTracker(), issue); v != verdictAllow || err != nil {
+			t.Fatalf("authorize = %v (%s), %v", v, why, err)
+		}
+	}
+	if f.lookups != 2 {
+		t.Errorf("lookups = %d, want 2 (one permission, one membership)", f.lookups)
+	}
+}
+
+func TestAdmitIssue(t *testing.T) {
+	ctx := context.Background()
+	repo := "gitea:admit.test/acme/repo"
+	f := newPolicyForge(t, "gitea:admit.test")
+	cfg := Config{IssueLabel: "todo", MinPermission: permWrite, OutsideApproval: true}
+	issue := Issue{Repo: repo, Number: 4}
+	issue.Author.Login = "mallory"
+	tr := newTracker()
+
+	// Labeled by someone without write access: refused, label removed
+	f.labeler = "carol"
+	if admitIssue(ctx, cfg, tr, issue) {
+		t.Fatal("carol's label was accepted")
+	}
+	if len(f.comments) != 1 || !strings.Contains(f.comments[0], "@carol has read access") || !slices.Equal(f.labels, []string{"-todo"}) {
+		t.Errorf("comments = %q, labels = %v", f.comments, f.labels)
+	}
+
+	// Labeled by bob, but opened by an outsider: waits, and asks only once
+	f.labeler, f.comments, f.labels = "bob", nil, nil
+	if admitIssue(ctx, cfg, tr, issue) {
+		t.Fatal("outsider's issue was accepted without approval")
+	}
+	if len(f.comments) != 1 || !strings.Contains(f.comments[0], "/bot approve") || len(f.labels) != 0 {
+		t.Fatalf("comments = %q, labels = %v", f.comments, f.labels)
+	}
+	issue.Comments = append(issue.Comments, comment("claude-bot", f.comments[0]+"\n"+botCommentMarker, "2026-03-01T09:00:00Z"))
+	admitIssue(ctx, cfg, tr, issue)
+	if len(f.comments) != 1 {
+		t.Errorf("asked for approval again: %q", f.comments)
+	}
+
+	// Approved by a maintainer
+	issue.Comments = append(issue.Comments, comment("alice", "/bot approve", time.Now().UTC().Format(time.RFC3339)))
+	if !admitIssue(ctx, cfg, tr, issue) {
+		t.Error("approved issue was refused")
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"maps"
+	"slices"
+)
+
+// --- Agent profiles ---
+// How the agent runs (its tools, model, MCP servers and extra CLI flags) is
+// set bot-wide, per repo, and per issue by label. In the config file:
+//
+//	allowed_tools = ["Bash", "Read", "Write", "Edit"]
+//	disallowed_tools = ["Bash(git push:*)"]
+//	agent_args = ["--permission-mode", "acceptEdits"]
+//	model = "sonnet"
+//	mcp_config = "mcp/default.json"
+//
+//	[profile."bot:readonly"]
+//	allowed_tools = ["Read", "Glob", "Grep"]
+//
+//	[repo."acme/docs".profile."bot:web"]
+//	allowed_tools = ["Read", "Write", "Edit", "WebFetch", "WebSearch"]
+//	mcp_config = "mcp/docs.json"
+//
+// A profile applies to issues carrying the label it's named after, on top of
+// the repo's settings; if several match they apply in name order. "/bot model"
+// still wins over a profile's model, and the tools are then narrowed for
+// outsiders (see untrusted.go) and by the repo's .claude-bot.yml.
+
+// agentProfile is the part of the config a label can change.
+type agentProfile struct {
+	AllowedTools    []string `json:"allowed_tools"`
+	DisallowedTools []string `json:"disallowed_tools"`
+	AgentArgs       []string `json:"agent_args"` // appended to the agent's command line
+	Model           string   `json:"model"`
+	MCPConfig       string   `json:"mcp_config"` // MCP server config file for the Claude CLI
+}
+
+// applyProfile copies the non-zero fields of p onto cfg.
+func (cfg *Config) applyProfile(p agentProfile) {
+	if len(p.AllowedTools) > 0 {
+		cfg.AllowedTools = slices.Clone(p.AllowedTools)
+	}
+	if len(p.DisallowedTools) > 0 {
+		cfg.DisallowedTools = slices.Clone(p.DisallowedTools)
+	}
+	if len(p.AgentArgs) > 0 {
+		cfg.AgentArgs = slices.Clone(p.AgentArgs)
+	}
+	if p.Model != "" {
+		cfg.Model = p.Model
+	}
+	if p.MCPConfig != "" {
+		cfg.MCPConfig = p.MCPConfig
+	}
+}
+
+// addProfiles merges profiles into cfg's, replacing any with the same name.
+func (cfg *Config) addProfiles(profiles map[string]agentProfile) {
+	if len(profiles) == 0 {
+		return
+	}
+	merged := maps.Clone(cfg.Profiles)
+	if merged == nil {
+		merged = map[string]agentProfile{}
+	}
+	maps.Copy(merged, profiles)
+	cfg.Profiles = merged
+}
+
+// profileNames returns the names (labels) of cfg's profiles, sorted.
+func (cfg Config) profileNames() []string {
+	return slices.Sorted(maps.Keys(cfg.Profiles))
+}
+
+// issueProfiles returns the names of the profiles that apply to issue.
+func (cfg Config) issueProfiles(issue Issue) []string {
+	var names []string
+	for _, name := range cfg.profileNames() {
+		if issue.hasLabel(name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// forIssue returns the effective config for work on issue: its repo's, with
+// the profiles its labels select applied on top.
+func (cfg Config) forIssue(issue Issue) Config {
+	cfg = cfg.forRepo(issue.Repo)
+	for _, name := range cfg.issueProfiles(issue) {
+		cfg.applyProfile(cfg.Profiles[name])
+	}
+	return cfg
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestForIssue(t *testing.T) {
+	path := writeConfig(t, "claude-bot.toml", `
+model = "sonnet"
+disallowed_tools = ["Bash(git push:*)"]
+
+[profile."bot:readonly"]
+allowed_tools = ["Read", "Glob", "Grep"]
+
+[profile."bot:web"]
+allowed_tools = ["Read", "Write", "Edit", "WebFetch"]
+mcp_config = "mcp/web.json"
+
+[repo."acme/infra"]
+allowed_tools = ["Read", "Write", "Edit"]
+agent_args = ["--permission-mode", "acceptEdits"]
+
+[repo."acme/infra".profile."bot:web"]
+allowed_tools = ["Read", "WebFetch"]
+model = "opus"
+`)
+	fc, err := loadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := Config{AllowedTools: []string{"Bash", "Read", "Write", "Edit"}}
+	cfg.applyFile(fc)
+
+	issue := func(repo string, labels ...string) Issue {
+		i := Issue{Repo: repo}
+		for _, l := range labels {
+			i.Labels = append(i.Labels, Label{Name: l})
+		}
+		return i
+	}
+
+	// No profile label: the repo's settings
+	if got := cfg.forIssue(issue("acme/app", "todo")); !slices.Equal(got.AllowedTools, cfg.AllowedTools) || got.Model != "sonnet" || got.MCPConfig != "" {
+		t.Errorf("acme/app = %q %q %q", got.AllowedTools, got.Model, got.MCPConfig)
+	}
+	infra := cfg.forIssue(issue("acme/infra"))
+	if !slices.Equal(infra.AllowedTools, []string{"Read", "Write", "Edit"}) || !slices.Equal(infra.AgentArgs, []string{"--permission-mode", "acceptEdits"}) ||
+		!slices.Equal(infra.DisallowedTools, []string{"Bash(git push:*)"}) {
+		t.Errorf("acme/infra = %+v", infra)
+	}
+
+	web := cfg.forIssue(issue("acme/app", "todo", "bot:web"))
+	if !slices.Equal(web.AllowedTools, []string{"Read", "Write", "Edit", "WebFetch"}) || web.MCPConfig != filepath.Join(filepath.Dir(path), "mcp/web.json") {
+		t.Errorf("bot:web = %q %q", web.AllowedTools, web.MCPConfig)
+	}
+	// The repo's own profile replaces the top-level one of the same name
+	if got := cfg.forIssue(issue("acme/infra", "bot:web")); !slices.Equal(got.AllowedTools, []string{"Read", "WebFetch"}) || got.Model != "opus" || got.MCPConfig != "" {
+		t.Errorf("acme/infra bot:web = %q %q %q", got.AllowedTools, got.Model, got.MCPConfig)
+	}
+	// Several profiles apply in name order; /bot model still wins
+	both := cfg.forIssue(issue("acme/app", "bot:web", "bot:readonly"))
+	if !slices.Equal(both.AllowedTools, []string{"Read", "Write", "Edit", "WebFetch"}) || both.MCPConfig == "" {
+		t.Errorf("both profiles = %q %q", both.AllowedTools, both.MCPConfig)
+	}
+	if got := cfg.forIssue(issue("acme/infra", "bot:web")).withOptions(issueOptions{Model: "haiku"}); got.Model != "haiku" {
+		t.Errorf("model = %q, want /bot model's", got.Model)
+	}
+	if names := cfg.forRepo("acme/infra").profileNames(); !slices.Equal(names, []string{"bot:readonly", "bot:web"}) {
+		t.Errorf("profile names = %q", names)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"embed"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// --- Prompt templates ---
+// The prompts for working on an issue, triaging one and replying to a
+// discussion are text/template files. Each is looked up by name, first match
+// wins:
+//
+//	.claude-bot/<name>.tmpl   in the target repo, on its default branch
+//	CB_PROMPT_TEMPLATE        the issue prompt only (prompt_template per repo)
+//	CB_PROMPT_DIR/<name>.tmpl (prompt_dir per repo)
+//	prompts/<name>.tmpl       built into the binary
+//
+// Templates are executed with promptData. "claude-bot --render-prompt
+// owner/repo#N" prints what the agent would get.
+
+//go:embed prompts
+var promptFS embed.FS
+
+// Template names.
+const (
+	promptIssue      = "issue"
+	promptTriage     = "triage"
+	promptDiscussion = "discussion"
+)
+
+// repoPromptDir holds a target repo's own templates.
+const repoPromptDir = ".claude-bot"
+
+// maxPreviousErrors is how many earlier failures the issue prompt shows.
+const maxPreviousErrors = 3
+
+// promptData is what templates see. The issue's fields are promoted, so
+// {{.Number}}, {{.Title}}, {{.Body}}, {{.URL}}, {{.Repo}} (the repo spec),
+// {{.Labels}}, {{.Comments}} and {{.Author.Login}} work as they always have.
+// Discussions fill in the same fields.
+type promptData struct {
+	Issue
+	RepoInfo       promptRepo
+	PreviousErrors []string // from the issue's last failed attempts, oldest first
+	Plan           string   // the approved plan to implement, in plan-first mode (see plan.go)
+	PlanAmendments string   // what the approver added to it
+	Conventions    promptConventions
+}
+
+type promptRepo struct {
+	Forge         string // github, gitea or gitlab
+	Host          string
+	Owner         string // group path on GitLab
+	Name          string
+	DefaultBranch string // "" if the repo isn't cloned yet
+	BaseBranch    string // where PRs go
+}
+
+// promptConventions are the target repo's .claude-bot.yml settings the agent
+// should know about.
+type promptConventions struct {
+	Build          []string
+	Test           []string
+	Lint           []string
+	ForbiddenPaths []string
+	MaxDiffLines   int
+}
+
+var promptFuncs = template.FuncMap{
+	"tail":          tailLog,                                // {{tail .Body 2000}}: the last 2000 bytes
+	"untrusted":     untrusted,                              // {{untrusted .Title .Body}}: user text, cleaned and fenced (see untrusted.go)
+	"sanitize":      sanitizeUntrusted,                      // {{sanitize .Title}}: user text, cleaned
+	"untrustedNote": func() string { return untrustedNote }, // {{untrustedNote}}: the instruction about untrusted blocks
+}
+
+// newPromptData gathers what the templates can use about issue. t may be nil.
+func newPromptData(cfg Config, t *tracker, settings repoSettings, defBranch string, issue Issue) promptData {
+	r := parseRepo(issue.Repo)
+	d := promptData{
+		Issue: issue,
+		RepoInfo: promptRepo{
+			Forge:         r.Kind,
+			Host:          r.Host,
+			Owner:         r.owner(),
+			Name:          r.name(),
+			DefaultBranch: defBranch,
+			BaseBranch:    cmp.Or(cfg.BaseBranch, defBranch),
+		},
+		Conventions: promptConventions{
+			Build:          settings.Build,
+			Test:           settings.Test,
+			Lint:           settings.Lint,
+			ForbiddenPaths: settings.ForbiddenPaths,
+			MaxDiffLines:   settings.MaxDiffLines,
+		},
+	}
+	if t != nil {
+		for _, a := range t.history(issue.key()) {
+			if a.Kind == "" && a.Result == resultError && a.Error != "" {
+				d.PreviousErrors = append(d.PreviousErrors, a.Error)
+			}
+		}
+		if n := len(d.PreviousErrors); n > maxPreviousErrors {
+			d.PreviousErrors = d.PreviousErrors[n-maxPreviousErrors:]
+		}
+	}
+	return d
+}
+
+// loadPromptTemplate finds the named template for a repo whose clone is
+// repoDir ("" if there isn't one), and says where it came from.
+func loadPromptTemplate(ctx context.Context, cfg Config, repoDir, defBranch, name string) (*template.Template, string, error) {
+	file := name + ".tmpl"
+	if repoDir != "" && defBranch != "" {
+		src := path.Join(repoPromptDir, file)
+		if text, err := run(ctx, repoDir, "git", "show", "origin/"+defBranch+":"+src); err == nil {
+			return parsePrompt(name, text, src)
+		}
+	}
+
+	var candidates []string
+	if name == promptIssue && cfg.PromptTemplate != "" {
+		candidates = append(candidates, cfg.PromptTemplate)
+	}
+	if cfg.PromptDir != "" {
+		candidates = append(candidates, filepath.Join(cfg.PromptDir, file))
+	}
+	for _, p := range candidates {
+		data, err := os.ReadFile(p)
+		if os.IsNotExist(err) && p != cfg.PromptTemplate {
+			continue // the dir needn't override every template
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("loading prompt template: %w", err)
+		}
+		return parsePrompt(name, string(data), p)
+	}
+
+	data, err := promptFS.ReadFile("prompts/" + file)
+	if err != nil {
+		return nil, "", fmt.Errorf("no built-in %s prompt", name)
+	}
+	return parsePrompt(name, string(data), "built-in")
+}
+
+func parsePrompt(name, text, source string) (*template.Template, string, error) {
+	tmpl, err := template.New(name).Funcs(promptFuncs).Parse(text)
+	if err != nil {
+		return nil, "", fmt.Errorf("parsing prompt template %s: %w", source, err)
+	}
+	return tmpl, source, nil
+}
+
+// renderPrompt executes the named template with data.
+func renderPrompt(ctx context.Context, cfg Config, repoDir, defBranch, name string, data promptData) (string, error) {
+	tmpl, source, err := loadPromptTemplate(ctx, cfg, repoDir, defBranch, name)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("rendering prompt template %s: %w", source, err)
+	}
+	return b.String(), nil
+}
+
+// clonedRepo returns the repo's local clone and default branch, or "" for
+// both if it hasn't been cloned. Nothing is fetched.
+func clonedRepo(ctx context.Context, cfg Config, repo string) (string, string) {
+	dir := repoLocalDir(cfg.RepoDir, repo)
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil {
+		return "", ""
+	}
+	return dir, defaultBranch(ctx, dir)
+}
+
+// printPrompt renders the prompt for key ("owner/repo#N") as the next run
+// would, from the forge's issue and the local clone as of its last fetch.
+// kind is promptIssue or promptTriage.
+func printPrompt(ctx context.Context, cfg Config, t *tracker, key, kind string, w io.Writer) error {
+	issue, err := parseIssueKey(key)
+	if err != nil {
+		return err
+	}
+	if kind != promptIssue && kind != promptTriage {
+		return fmt.Errorf("can't render a %q prompt (want %s or %s)", kind, promptIssue, promptTriage)
+	}
+	cfg = cfg.forRepo(issue.Repo)
+	if issue, err = sourceFor(issue.Repo).GetIssue(ctx, issue.Repo, issue.Number); err != nil {
+		return fmt.Errorf("fetching %s: %w", key, err)
+	}
+
+	repoDir, defBranch := clonedRepo(ctx, cfg, issue.Repo)
+	var settings repoSettings
+	if repoDir == "" {
+		fmt.Fprintf(os.Stderr, "%s isn't cloned yet, so its templates and settings aren't used\n", issue.Repo)
+	} else if settings, err = loadRepoSettings(ctx, repoDir, defBranch); err != nil {
+		return fmt.Errorf("reading repo settings: %w", err)
+	}
+	if kind == promptTriage {
+		settings, t = repoSettings{}, nil // triage replies only see the issue
+	}
+	tmpl, source, err := loadPromptTemplate(ctx, cfg, repoDir, defBranch, kind)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "template: %s\n", source)
+	return tmpl.Execute(w, newPromptData(cfg, t, settings, defBranch, issue))
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Review follow-ups ---
+// The bot keeps working on the PRs it opened (head branch issue-N-*, body
+// carrying botCommentMarker). Each poll looks for review threads whose latest
+// comment isn't the bot's, and top-level comments mentioning @claude-bot.
+// Feedback counts only if its authors may queue issues (or are in
+// CB_COMMAND_USERS) and it doesn't look like a prompt injection. A PR with any
+// gets a job: check out its branch, run the agent with that feedback as the
+// prompt, push a follow-up commit and reply to each thread. The reply is what
+// marks feedback as handled, so there's nothing else to store. After
+// CB_MAX_RETRIES failed runs on the same feedback the bot replies with the
+// error instead, so a broken PR doesn't loop.
+
+// reviewMention asks the bot to act on a top-level PR comment.
+const reviewMention = "@claude-bot"
+
+// botBranch matches the bot's branch names, after any branch_prefix.
+var botBranch = regexp.MustCompile(`\bissue-(\d+)-`)
+
+// reviewIssue returns the job for a PR if it's one of the bot's.
+func reviewIssue(repo string, pr PR) (Issue, bool) {
+	m := botBranch.FindStringSubmatch(pr.Branch)
+	if m == nil || !strings.Contains(pr.Body, botCommentMarker) {
+		return Issue{}, false
+	}
+	n, err := strconv.Atoi(m[1])
+	if err != nil {
+		return Issue{}, false
+	}
+	return Issue{Repo: repo, Number: n, Title: pr.Title, URL: pr.URL, Review: &pr}, true
+}
+
+func isBotComment(c Comment) bool {
+	return strings.Contains(c.Body, botCommentMarker)
+}
+
+// feedback returns the comments in th the bot hasn't answered: everything
+// after its last reply. In the top-level conversation only comments that
+// mention @claude-bot count.
+func (th ReviewThread) feedback() []Comment {
+	if th.Resolved {
+		return nil
+	}
+	i := len(th.Comments)
+	for i > 0 && !isBotComment(th.Comments[i-1]) {
+		i--
+	}
+	unanswered := th.Comments[i:]
+	if th.Path != "" {
+		return unanswered
+	}
+	var asks []Comment
+	for _, c := range unanswered {
+		if strings.Contains(c.Body, reviewMention) {
+			asks = append(asks, c)
+		}
+	}
+	return asks
+}
+
+// pendingThreads returns the threads with unanswered feedback.
+func pendingThreads(threads []ReviewThread) []ReviewThread {
+	var out []ReviewThread
+	for _, th := range threads {
+		if len(th.feedback()) > 0 {
+			out = append(out, th)
+		}
+	}
+	return out
+}
+
+// trustedThreads returns the threads with unanswered feedback the bot may act
+// on: every comment is from someone in CB_COMMAND_USERS or allowed to queue
+// issues, and none looks like a prompt injection. The others stay unanswered.
+func trustedThreads(ctx context.Context, cfg Config, repo string, threads []ReviewThread) []ReviewThread {
+	lg := slog.Default().With("component", "review", "repo", repo)
+	var out []ReviewThread
+	for _, th := range pendingThreads(threads) {
+		fb := th.feedback()
+		why, err := refuseFeedback(ctx, cfg, repo, fb)
+		if err != nil {
+			lg.Error("checking review feedback failed", "thread", th.ID, "err", err)
+			continue
+		}
+		if why != "" {
+			lg.Warn("ignoring review feedback", "thread", th.ID, "reason", why)
+			continue
+		}
+		if hits := detectInjection(Issue{Comments: fb}); len(hits) > 0 {
+			lg.Warn("ignoring review feedback that looks like a prompt injection", "thread", th.ID, "patterns", strings.Join(hits, ", "))
+			continue
+		}
+		out = append(out, th)
+	}
+	return out
+}
+
+// refuseFeedback returns why one of comments' authors may not direct the bot,
+// or "" if they all may.
+func refuseFeedback(ctx context.Context, cfg Config, repo string, comments []Comment) (string, error) {
+	for _, c := range comments {
+		if cfg.commandAllowed(c.Author.Login) {
+			continue
+		}
+		if why, err := checkUser(ctx, cfg, repo, c.Author.Login); err != nil || why != "" {
+			return why, err
+		}
+	}
+	return "", nil
+}
+
+// latestFeedback returns when the newest unanswered comment was posted, or
+// the zero time if the forge's timestamps can't be parsed.
+func latestFeedback(threads []ReviewThread) time.Time {
+	var latest time.Time
+	for _, th := range threads {
+		for _, c := range th.feedback() {
+			if at, err := time.Parse(time.RFC3339, c.CreatedAt); err == nil && at.After(latest) {
+				latest = at
+			}
+		}
+	}
+	return latest
+}
+
+// pollReviews queues a follow-up for each of the bot's open PRs with
+// unanswered feedback.
+func pollReviews(ctx context.Context, cfg Config, repo string, jobs chan<- Issue, t *tracker) {
+	forge := forgeFor(repo)
+	prs, err := forge.ListPRs(ctx, repo)
+	if err != nil {
+		slog.Error("listing PRs failed", "component", "review", "repo", repo, "err", err)
+		pollErrors.inc(repo)
+		return
+	}
+	for _, pr := range prs {
+		issue, ok := reviewIssue(repo, pr)
+		if !ok {
+			continue
+		}
+		threads, err := forge.ReviewThreads(ctx, repo, pr)
+		if err != nil {
+			slog.Error("fetching review threads failed", "component", "review", "repo", repo, "pr", pr.URL, "err", err)
+			continue
+		}
+		// /bot commands in the conversation apply to the issue the PR resolves
+		for _, th := range threads {
+			if len(cfg.CommandUsers) > 0 && th.ID == "" && th.Path == "" {
+				handleCommands(ctx, cfg, t, Issue{Repo: repo, Number: issue.Number}, th.Comments)
+			}
+		}
+		if len(trustedThreads(ctx, cfg, repo, threads)) == 0 {
+			continue
+		}
+		if !enqueue(ctx, cfg, issue, jobs, t) {
+			return
+		}
+	}
+}
+
+// processReview addresses the unanswered feedback on the bot's PR for issue.
+func processReview(ctx context.Context, cfg Config, t *tracker, workerID int, issue Issue) (retErr error) {
+	cfg = cfg.forIssue(issue).withOptions(t.options(issue.key()))
+	pr := *issue.Review
+	forge := forgeFor(issue.Repo)
+	repoDir := repoLocalDir(cfg.RepoDir, issue.Repo)
+	wtDir := filepath.Join(repoLocalDir(cfg.WorktreeDir, issue.Repo), pr.Branch)
+	logFile := filepath.Join(cfg.LogDir, fmt.Sprintf("%s-%d-review.log", slugify(issue.Repo), issue.Number))
+
+	attempt, err := t.begin(Attempt{
+		Key:     issue.key(),
+		Repo:    issue.Repo,
+		Number:  issue.Number,
+		Worker:  workerID,
+		Branch:  pr.Branch,
+		LogPath: logFile,
+		Kind:    kindReview,
+	})
+	jobLog := issueLogger(issue, workerID).With("pr", pr.URL)
+	if err != nil {
+		jobLog.Warn("couldn't record attempt", "err", err)
+	} else {
+		jobLog = jobLog.With("run_id", attempt.ID)
+	}
+	lg := jobLog.With("branch", pr.Branch)
+	ctx = withLogger(ctx, lg)
+	result := resultDone
+	defer func() {
+		switch {
+		case retErr != nil && jobCancelled(ctx):
+			result = resultCancelled
+		case retErr != nil && ctx.Err() != nil:
+			result = resultInterrupted
+		case retErr != nil:
+			result = resultError
+		}
+		jobsTotal.inc(issue.Repo, jobState(result))
+		if err := t.finish(attempt.ID, result, retErr, pr.URL); err != nil {
+			lg.Warn("couldn't record attempt result", "err", err)
+		}
+	}()
+
+	// The poll may have been a while ago
+	threads, err := forge.ReviewThreads(ctx, issue.Repo, pr)
+	if err != nil {
+		return fmt.Errorf("fetching review threads: %w", err)
+	}
+	threads = trustedThreads(ctx, cfg, issue.Repo, threads)
+	if len(threads) == 0 {
+		lg.Info("no unanswered review feedback")
+		return nil
+	}
+	lg.Info("addressing review feedback", "threads", len(threads))
+
+	// Always start the next round from a fresh checkout; give up loudly on
+	// feedback that keeps failing
+	defer func() {
+		cleanupWorktree(context.WithoutCancel(ctx), repoDir, wtDir, pr.Branch)
+		if retErr == nil || ctx.Err() != nil {
+			return
+		}
+		if n := t.reviewFailures(issue.key(), latestFeedback(threads)) + 1; n >= cfg.MaxRetries {
+			lg.Warn("giving up on review feedback", "failures", n, "max", cfg.MaxRetries)
+			replyAll(ctx, issue.Repo, pr, threads, fmt.Sprintf("claude-bot couldn't address this:\n```\n%s\n```\nNeeds manual attention.", retErr.Error()))
+		}
+	}()
+
+	if err := ensureRepoCloned(ctx, cfg, issue); err != nil {
+		return fmt.Errorf("cloning repo: %w", err)
+	}
+	if _, err := run(ctx, repoDir, "git", "fetch", "origin"); err != nil {
+		return fmt.Errorf("fetching latest: %w", err)
+	}
+	defBranch := defaultBranch(ctx, repoDir)
+	settings, err := loadRepoSettings(ctx, repoDir, defBranch)
+	if err != nil {
+		return fmt.Errorf("reading repo settings: %w", err)
+	}
+	var authors []string
+	for _, th := range threads {
+		for _, c := range th.Comments {
+			if !isBotComment(c) {
+				authors = append(authors, c.Author.Login)
+			}
+		}
+	}
+	cfg = restrictTools(ctx, cfg, issue.Repo, authors)
+	cfg.AllowedTools = settings.allowedTools(cfg.AllowedTools)
+	base := cfg.BaseBranch
+	if base == "" {
+		base = defBranch
+	}
+
+	if err := ensureWorktree(ctx, repoDir, wtDir, pr.Branch, base); err != nil {
+		return fmt.Errorf("creating worktree: %w", err)
+	}
+
+	usage, err := runAgent(ctx, cfg, settings, issue, buildReviewPrompt(issue, threads), wtDir, logFile)
+	if usage != nil {
+		_ = t.setUsage(attempt.ID, *usage)
+	}
+	if err != nil {
+		return fmt.Errorf("running agent: %w", err)
+	}
+
+	hasChanges, err := checkChanges(ctx, wtDir)
+	if err != nil {
+		return fmt.Errorf("checking changes after agent: %w", err)
+	}
+	reply := "claude-bot looked at this but didn't change anything."
+	if hasChanges {
+		if err := commitChanges(ctx, wtDir, fmt.Sprintf("fix: address review feedback on #%d", pr.Number)); err != nil {
+			return fmt.Errorf("committing: %w", err)
+		}
+		if err := settings.checkBranch(ctx, wtDir, base); err != nil {
+			return err
+		}
+		if _, err := run(ctx, wtDir, "git", "push", "origin", pr.Branch); err != nil {
+			return fmt.Errorf("pushing: %w", err)
+		}
+		sha, _ := run(ctx, wtDir, "git", "rev-parse", "--short", "HEAD")
+		reply = fmt.Sprintf("Addressed in %s.", strings.TrimSpace(sha))
+	}
+
+	replyAll(ctx, issue.Repo, pr, threads, reply)
+	lg.Info("answered review feedback", "threads", len(threads), "changed", hasChanges)
+	return nil
+}
+
+// replyAll answers each thread, which marks its feedback as handled.
+func replyAll(ctx context.Context, repo string, pr PR, threads []ReviewThread, body string) {
+	for _, th := range threads {
+		if err := forgeFor(repo).Reply(ctx, repo, pr, th, body+"\n"+botCommentMarker); err != nil {
+			logger(ctx).Warn("couldn't reply to review thread", "thread", th.ID, "path", th.Path, "err", err)
+		}
+	}
+}
+
+// buildReviewPrompt asks the agent to address the feedback in threads. Inline
+// threads are shown whole for context; the conversation only as the asks.
+func buildReviewPrompt(issue Issue, threads []ReviewThread) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "You are working on a codebase. Reviewers left feedback on your pull request for issue #%d. Address it.\n\n", issue.Number)
+	fmt.Fprintf(&b, "## Pull request: %s\n%s\n\n", issue.URL, untrusted(issue.Title))
+
+	b.WriteString("## Review feedback:\n")
+	for _, th := range threads {
+		comments := th.Comments
+		switch {
+		case th.Path != "" && th.Line > 0:
+			fmt.Fprintf(&b, "### %s:%d\n", th.Path, th.Line)
+		case th.Path != "":
+			fmt.Fprintf(&b, "### %s\n", th.Path)
+		default:
+			b.WriteString("### General\n")
+			comments = th.feedback()
+		}
+		for _, c := range comments {
+			author := c.Author.Login
+			if isBotComment(c) {
+				author += " (you)"
+			}
+			fmt.Fprintf(&b, "**%s** (%s):\n%s\n\n", author, c.CreatedAt, untrusted(c.Body))
+		}
+	}
+
+	b.WriteString(`## Instructions:
+- Read CLAUDE.md in the repo root for project-specific instructions
+- The branch already has your earlier changes for this issue
+- Address each piece of feedback with minimal, focused changes
+- If feedback is a question or needs no code change, leave the code as it is
+- Run any existing tests and make sure they pass
+- Do NOT commit — just make the file changes
+` + untrustedNote + "\n")
+
+	return b.String()
+}
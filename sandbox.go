@@ -0,0 +1,348 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// --- Sandbox ---
+// CB_SANDBOX confines the coding agent and the repo's setup commands (Linux only):
+//
+//	off    run directly on the host (default)
+//	bwrap  under bubblewrap
+//	ns     in new user, mount and PID namespaces set up by the bot itself
+//	auto   bwrap when installed, else ns
+//
+// Inside, the host toolchain (/usr, /etc, the PATH, the agent's install dir) is
+// read-only, $HOME and /tmp are empty tmpfs, and the only writable host paths
+// are the job's worktree, its git admin dir and the agent's own state
+// (~/.claude). ~/.ssh, the other worktrees, the bot's state and its tokens
+// aren't visible. The worktree's shared .git stays read-only, so the agent
+// can't plant hooks or config that the bot's own git commands would run.
+//
+// Both modes start through a re-exec of this binary (--sandbox-exec), which
+// applies CB_SANDBOX_MEMORY and CB_SANDBOX_PIDS as rlimits before exec'ing
+// bwrap or the agent. With CB_SANDBOX_CGROUP (a cgroup v2 directory delegated
+// to the bot) each run gets its own child cgroup with memory.max, pids.max and
+// cpu.max (CB_SANDBOX_CPUS) instead.
+
+type sandboxConfig struct {
+	Mode     string   // "" (off), "bwrap" or "ns"
+	Memory   int64    // bytes; 0 = unlimited
+	Pids     int64    // 0 = unlimited
+	CPUs     float64  // cgroup only; 0 = unlimited
+	Cgroup   string   // delegated cgroup v2 directory; "" = rlimits
+	ReadOnly []string // extra host paths to expose read-only (CB_SANDBOX_RO_PATHS)
+}
+
+// sandboxSystemPaths is the read-only host toolchain every sandbox sees.
+var sandboxSystemPaths = []string{
+	"/usr", "/bin", "/sbin", "/lib", "/lib32", "/lib64", "/etc", "/opt", "/nix",
+	"/run/systemd/resolve", // /etc/resolv.conf often points here
+}
+
+// sandboxHiddenHome lists $HOME entries that are never exposed, even when a
+// PATH entry or the agent's install lives under them.
+var sandboxHiddenHome = []string{
+	".ssh", ".gnupg", ".aws", ".azure", ".config", ".docker", ".kube",
+	".netrc", ".git-credentials", ".claude-bot",
+}
+
+// sandboxEnvKeep lists the CB_ variables an agent is meant to see (shellAgent).
+var sandboxEnvKeep = []string{
+	"CB_PROMPT_FILE", "CB_MAX_TURNS", "CB_MODEL", "CB_ALLOWED_TOOLS", "CB_DISALLOWED_TOOLS", "CB_MCP_CONFIG", "CB_AGENT_ARGS",
+}
+
+// sandboxEnvDrop lists credentials stripped from a sandboxed command's env,
+// on top of every other CB_ variable.
+var sandboxEnvDrop = []string{
+	"SSH_AUTH_SOCK", "GH_TOKEN", "GITHUB_TOKEN", "GH_ENTERPRISE_TOKEN", "GITHUB_ENTERPRISE_TOKEN",
+}
+
+func parseSandboxMode(v string) (string, error) {
+	mode := strings.ToLower(strings.TrimSpace(v))
+	if mode == "" || mode == "off" || mode == "0" {
+		return "", nil
+	}
+	if runtime.GOOS != "linux" {
+		return "", fmt.Errorf("sandboxing needs Linux")
+	}
+	_, bwrapErr := exec.LookPath("bwrap")
+	switch mode {
+	case "auto", "1":
+		if bwrapErr == nil {
+			return "bwrap", nil
+		}
+		return "ns", nil
+	case "bwrap":
+		if bwrapErr != nil {
+			return "", fmt.Errorf("bwrap isn't installed")
+		}
+		return "bwrap", nil
+	case "ns":
+		return "ns", nil
+	}
+	return "", fmt.Errorf("unknown sandbox mode %q (want off, auto, bwrap or ns)", v)
+}
+
+// parseSize reads a byte count with an optional K, M, G or T suffix (powers of
+// 1024; a trailing "B" or "iB" is allowed).
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	num := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(s), "B"), "I")
+	mult := int64(1)
+	if n := len(num); n > 0 {
+		if i := strings.IndexByte("KMGT", num[n-1]); i >= 0 {
+			mult = 1 << (10 * (i + 1))
+			num = num[:n-1]
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(num), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * mult, nil
+}
+
+// sandboxSpec is what the --sandbox-exec launcher is handed, as JSON.
+type sandboxSpec struct {
+	Mode     string   `json:"mode"`
+	Root     string   `json:"root,omitempty"` // ns: empty mount point for the new root
+	Dir      string   `json:"dir"`
+	Home     string   `json:"home"`
+	UID      int      `json:"uid"`
+	GID      int      `json:"gid"`
+	ReadOnly []string `json:"ro,omitempty"`
+	Writable []string `json:"rw,omitempty"`
+	Memory   int64    `json:"memory,omitempty"`
+	Pids     int64    `json:"pids,omitempty"`
+}
+
+type sandboxMount struct {
+	Kind string // "ro", "rw" or "tmpfs"
+	Path string
+}
+
+// mounts lays out the sandbox filesystem: tmpfs on $HOME and /tmp, and binds
+// of the same paths, shallowest first so each lands on top of its parents.
+// A path listed twice keeps its first kind (rw, then tmpfs, then ro), and a
+// read-only bind already covered by a read-only parent is dropped.
+func (s sandboxSpec) mounts() []sandboxMount {
+	var all []sandboxMount
+	seen := map[string]bool{}
+	add := func(kind string, paths ...string) {
+		for _, p := range paths {
+			if p == "" {
+				continue
+			}
+			p = filepath.Clean(p)
+			if !seen[p] {
+				seen[p] = true
+				all = append(all, sandboxMount{kind, p})
+			}
+		}
+	}
+	add("rw", s.Writable...)
+	add("tmpfs", "/tmp", s.Home)
+	add("ro", s.ReadOnly...)
+	slices.SortStableFunc(all, func(a, b sandboxMount) int {
+		return cmp.Compare(strings.Count(a.Path, "/"), strings.Count(b.Path, "/"))
+	})
+
+	var out []sandboxMount
+	for _, m := range all {
+		if m.Kind == "ro" {
+			if parent := enclosingMount(out, m.Path); parent != nil && parent.Kind == "ro" {
+				continue
+			}
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// enclosingMount returns the deepest of mounts that contains p.
+func enclosingMount(mounts []sandboxMount, p string) *sandboxMount {
+	var best *sandboxMount
+	for i, m := range mounts {
+		if pathWithin(m.Path, p) && (best == nil || len(m.Path) > len(best.Path)) {
+			best = &mounts[i]
+		}
+	}
+	return best
+}
+
+// pathWithin reports whether p is dir or below it.
+func pathWithin(dir, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+// bwrapArgs translates the spec into bubblewrap flags (without the command).
+func (s sandboxSpec) bwrapArgs() []string {
+	args := []string{
+		"--die-with-parent", "--new-session",
+		"--unshare-user", "--unshare-pid", "--unshare-ipc", "--unshare-uts", "--unshare-cgroup-try",
+		"--proc", "/proc", "--dev", "/dev",
+	}
+	for _, m := range s.mounts() {
+		switch m.Kind {
+		case "tmpfs":
+			args = append(args, "--tmpfs", m.Path)
+		case "ro":
+			args = append(args, "--ro-bind-try", m.Path, m.Path)
+		case "rw":
+			args = append(args, "--bind-try", m.Path, m.Path)
+		}
+	}
+	return append(args, "--chdir", s.Dir)
+}
+
+// hostToolchain lists the read-only view of the host: the system dirs, each
+// PATH entry, the install dir of program when it lives under $HOME (e.g.
+// ~/.bun or ~/.nvm for the Claude CLI), and extra.
+func hostToolchain(home, program string, extra []string) []string {
+	paths := slices.Clone(sandboxSystemPaths)
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		if filepath.IsAbs(dir) && !hiddenInSandbox(home, dir) {
+			paths = append(paths, dir)
+		}
+	}
+	if real, err := filepath.EvalSymlinks(program); err == nil && home != "" && pathWithin(home, real) {
+		rel, _ := filepath.Rel(home, real)
+		top := filepath.Join(home, strings.Split(rel, string(filepath.Separator))[0])
+		if !hiddenInSandbox(home, top) {
+			paths = append(paths, top)
+		}
+	}
+	return append(paths, extra...)
+}
+
+// hiddenInSandbox reports whether p is $HOME itself or under one of the
+// sandboxHiddenHome entries.
+func hiddenInSandbox(home, p string) bool {
+	if home == "" || !pathWithin(home, p) {
+		return false
+	}
+	rel, _ := filepath.Rel(home, p)
+	return rel == "." || slices.Contains(sandboxHiddenHome, strings.Split(rel, string(filepath.Separator))[0])
+}
+
+// worktreeMounts returns what a job needs of its worktree: the worktree
+// itself and its admin dir (.git/worktrees/<name>: index, HEAD) writable; the
+// .git link file and the repo's shared .git read-only.
+func worktreeMounts(wtDir string) (writable, readOnly []string) {
+	dotGit := filepath.Join(wtDir, ".git")
+	writable, readOnly = []string{wtDir}, []string{dotGit}
+	data, err := os.ReadFile(dotGit)
+	if err != nil {
+		return // not a linked worktree
+	}
+	gitDir, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir: ")
+	if !ok {
+		return
+	}
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(wtDir, gitDir)
+	}
+	common := filepath.Join(gitDir, "..", "..")
+	if c, err := os.ReadFile(filepath.Join(gitDir, "commondir")); err == nil {
+		common = strings.TrimSpace(string(c))
+		if !filepath.IsAbs(common) {
+			common = filepath.Join(gitDir, common)
+		}
+	}
+	return append(writable, filepath.Clean(gitDir)), append(readOnly, filepath.Clean(common))
+}
+
+// sandboxEnv strips the bot's credentials and settings from env and points
+// TMPDIR at the sandbox's /tmp.
+func sandboxEnv(env []string) []string {
+	out := make([]string, 0, len(env)+1)
+	for _, e := range env {
+		k, _, _ := strings.Cut(e, "=")
+		if k == "TMPDIR" || slices.Contains(sandboxEnvDrop, k) ||
+			(strings.HasPrefix(k, "CB_") && !slices.Contains(sandboxEnvKeep, k)) {
+			continue
+		}
+		out = append(out, e)
+	}
+	return append(out, "TMPDIR=/tmp")
+}
+
+// sandboxRun is one job's sandbox: the config plus the job's worktree.
+// A nil *sandboxRun runs commands unconfined.
+type sandboxRun struct {
+	cfg      sandboxConfig
+	writable []string
+	readOnly []string
+}
+
+// forWorktree returns the sandbox for a job in wtDir, or nil when sandboxing
+// is off.
+func (c sandboxConfig) forWorktree(wtDir string) *sandboxRun {
+	if c.Mode == "" {
+		return nil
+	}
+	rw, ro := worktreeMounts(wtDir)
+	return &sandboxRun{cfg: c, writable: rw, readOnly: ro}
+}
+
+// wrap rewrites cmd to run inside the sandbox. writable and readOnly add host
+// paths this particular command needs. The returned cleanup must be called
+// once cmd has exited.
+func (r *sandboxRun) wrap(cmd *exec.Cmd, writable, readOnly []string) (func(), error) {
+	if r == nil {
+		return func() {}, nil
+	}
+	home, _ := os.UserHomeDir()
+	spec := sandboxSpec{
+		Mode:     r.cfg.Mode,
+		Dir:      cmd.Dir,
+		Home:     home,
+		UID:      os.Getuid(),
+		GID:      os.Getgid(),
+		Writable: append(slices.Clone(r.writable), writable...),
+		ReadOnly: append(append(hostToolchain(home, cmd.Path, r.cfg.ReadOnly), r.readOnly...), readOnly...),
+	}
+	if r.cfg.Cgroup == "" {
+		spec.Memory, spec.Pids = r.cfg.Memory, r.cfg.Pids
+	}
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = sandboxEnv(cmd.Env)
+	return spec.start(cmd, r.cfg)
+}
+
+// checkSandbox runs a no-op in the sandbox at startup, so a host without
+// unprivileged user namespaces (or a bad cgroup) fails fast instead of
+// failing every job.
+func checkSandbox(cfg sandboxConfig) error {
+	if cfg.Mode == "" {
+		return nil
+	}
+	dir, err := os.MkdirTemp("", "claude-bot-check-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	cmd := exec.Command("true")
+	cmd.Dir = dir
+	cleanup, err := cfg.forWorktree(dir).wrap(cmd, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
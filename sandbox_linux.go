@@ -0,0 +1,330 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// Not in package syscall.
+const (
+	rlimitNproc     = 6
+	prSetNoNewPrivs = 38
+)
+
+// start points cmd at the --sandbox-exec launcher. In ns mode the launcher
+// starts in fresh namespaces; with a cgroup configured it's born into a new
+// child cgroup, which cleanup removes.
+func (s sandboxSpec) start(cmd *exec.Cmd, cfg sandboxConfig) (func(), error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: %w", err)
+	}
+	target := append([]string{cmd.Path}, cmd.Args[1:]...)
+	cleanup := func() {}
+	attr := &syscall.SysProcAttr{}
+
+	switch s.Mode {
+	case "bwrap":
+		bwrap, err := exec.LookPath("bwrap")
+		if err != nil {
+			return nil, fmt.Errorf("sandbox: %w", err)
+		}
+		target = append(append([]string{bwrap}, s.bwrapArgs()...), append([]string{"--"}, target...)...)
+	case "ns":
+		if s.Root, err = os.MkdirTemp("", "claude-bot-sandbox-"); err != nil {
+			return nil, fmt.Errorf("sandbox: %w", err)
+		}
+		root := s.Root
+		cleanup = func() { os.Remove(root) }
+		// Root inside, so the launcher may mount; the agent itself gets a nested,
+		// unprivileged namespace (see runAsInit)
+		attr.Cloneflags = syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID |
+			syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS
+		attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: s.UID, Size: 1}}
+		attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: s.GID, Size: 1}}
+	default:
+		return nil, fmt.Errorf("sandbox: unknown mode %q", s.Mode)
+	}
+
+	if cfg.Cgroup != "" {
+		dir, fd, err := newRunCgroup(cfg)
+		if err != nil {
+			cleanup()
+			return nil, fmt.Errorf("sandbox cgroup: %w", err)
+		}
+		attr.UseCgroupFD, attr.CgroupFD = true, fd
+		prev := cleanup
+		cleanup = func() {
+			syscall.Close(fd)
+			os.Remove(dir) // fails only if something is still running in it
+			prev()
+		}
+	}
+
+	spec, err := json.Marshal(s)
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	cmd.Path = self
+	cmd.Args = append([]string{self, "--sandbox-exec", string(spec), "--"}, target...)
+	cmd.SysProcAttr = attr
+	return cleanup, nil
+}
+
+// newRunCgroup makes a child of the delegated cgroup with the configured
+// limits and returns it with an fd for SysProcAttr.CgroupFD.
+func newRunCgroup(cfg sandboxConfig) (string, int, error) {
+	dir, err := os.MkdirTemp(cfg.Cgroup, "run-")
+	if err != nil {
+		return "", -1, err
+	}
+	limits := map[string]string{}
+	if cfg.Memory > 0 {
+		limits["memory.max"] = strconv.FormatInt(cfg.Memory, 10)
+		limits["memory.swap.max"] = "0"
+	}
+	if cfg.Pids > 0 {
+		limits["pids.max"] = strconv.FormatInt(cfg.Pids, 10)
+	}
+	if cfg.CPUs > 0 {
+		limits["cpu.max"] = fmt.Sprintf("%d 100000", int64(cfg.CPUs*100000))
+	}
+	for _, file := range sortedKeys(limits) {
+		err := os.WriteFile(filepath.Join(dir, file), []byte(limits[file]), 0)
+		if err != nil && file != "memory.swap.max" { // no swap accounting is fine
+			os.Remove(dir)
+			return "", -1, fmt.Errorf("setting %s (is the controller enabled in %s/cgroup.subtree_control?): %w", file, cfg.Cgroup, err)
+		}
+	}
+	fd, err := syscall.Open(dir, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		os.Remove(dir)
+		return "", -1, err
+	}
+	return dir, fd, nil
+}
+
+// sandboxExec is the --sandbox-exec launcher. args are the JSON spec, "--"
+// and the command to run. It never returns.
+func sandboxExec(args []string) {
+	fail := func(err error) {
+		fmt.Fprintf(os.Stderr, "claude-bot sandbox: %v\n", err)
+		os.Exit(125)
+	}
+	if len(args) < 3 || args[1] != "--" {
+		fail(fmt.Errorf("usage: --sandbox-exec <spec> -- <command...>"))
+	}
+	var spec sandboxSpec
+	if err := json.Unmarshal([]byte(args[0]), &spec); err != nil {
+		fail(fmt.Errorf("reading spec: %w", err))
+	}
+	argv := args[2:]
+
+	if err := setSandboxRlimits(spec); err != nil {
+		fail(err)
+	}
+	if spec.Mode != "ns" {
+		// bwrap does the rest
+		fail(syscall.Exec(argv[0], argv, os.Environ()))
+	}
+	if err := enterSandboxRoot(spec); err != nil {
+		fail(err)
+	}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		fail(fmt.Errorf("no_new_privs: %w", errno))
+	}
+	os.Exit(runAsInit(argv, spec.UID, spec.GID))
+}
+
+func setSandboxRlimits(spec sandboxSpec) error {
+	for _, l := range []struct {
+		name     string
+		resource int
+		value    int64
+	}{
+		{"memory", syscall.RLIMIT_DATA, spec.Memory},
+		{"pids", rlimitNproc, spec.Pids},
+	} {
+		if l.value <= 0 {
+			continue
+		}
+		lim := &syscall.Rlimit{Cur: uint64(l.value), Max: uint64(l.value)}
+		if err := syscall.Setrlimit(l.resource, lim); err != nil {
+			return fmt.Errorf("setting %s limit: %w", l.name, err)
+		}
+	}
+	return nil
+}
+
+// enterSandboxRoot builds the sandbox filesystem on a tmpfs at spec.Root,
+// pivots into it and makes the root itself read-only.
+func enterSandboxRoot(spec sandboxSpec) error {
+	// Keep our mounts from propagating back to the host
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("making mounts private: %w", err)
+	}
+	root := spec.Root
+	if err := syscall.Mount("tmpfs", root, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=0755"); err != nil {
+		return fmt.Errorf("mounting root: %w", err)
+	}
+	for _, m := range spec.mounts() {
+		target := filepath.Join(root, m.Path)
+		var err error
+		switch m.Kind {
+		case "tmpfs":
+			if err = os.MkdirAll(target, 0755); err == nil {
+				err = syscall.Mount("tmpfs", target, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=1777")
+			}
+		case "ro", "rw":
+			err = bindMount(m.Path, target, m.Kind == "ro")
+		}
+		if err != nil {
+			return fmt.Errorf("mounting %s: %w", m.Path, err)
+		}
+	}
+	if err := mountSandboxDev(root); err != nil {
+		return err
+	}
+	proc := filepath.Join(root, "proc")
+	if err := os.MkdirAll(proc, 0755); err != nil {
+		return err
+	}
+	if err := syscall.Mount("proc", proc, "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, ""); err != nil {
+		return fmt.Errorf("mounting /proc: %w", err)
+	}
+
+	old := filepath.Join(root, ".old")
+	if err := os.Mkdir(old, 0700); err != nil {
+		return err
+	}
+	if err := syscall.PivotRoot(root, old); err != nil {
+		return fmt.Errorf("pivot_root: %w", err)
+	}
+	if err := syscall.Chdir("/"); err != nil {
+		return err
+	}
+	if err := syscall.Unmount("/.old", syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("detaching the host root: %w", err)
+	}
+	os.Remove("/.old")
+	if err := syscall.Mount("", "/", "", syscall.MS_REMOUNT|syscall.MS_RDONLY|syscall.MS_NOSUID|syscall.MS_NODEV, ""); err != nil {
+		return fmt.Errorf("making root read-only: %w", err)
+	}
+	return syscall.Chdir(spec.Dir)
+}
+
+// bindMount binds src onto target, creating the mount point. A missing src
+// is skipped, like bwrap's --bind-try.
+func bindMount(src, target string, readOnly bool) error {
+	st, err := os.Stat(src)
+	if err != nil {
+		return nil
+	}
+	if st.IsDir() {
+		err = os.MkdirAll(target, 0755)
+	} else if err = os.MkdirAll(filepath.Dir(target), 0755); err == nil {
+		var f *os.File
+		if f, err = os.OpenFile(target, os.O_CREATE|os.O_WRONLY, 0644); err == nil {
+			f.Close()
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := syscall.Mount(src, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return err
+	}
+	if !readOnly {
+		return nil
+	}
+	// Remounting inside a user namespace must keep the flags the host locked
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(target, &fs); err != nil {
+		return err
+	}
+	locked := uintptr(fs.Flags) & (syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC |
+		syscall.MS_NOATIME | syscall.MS_NODIRATIME | syscall.MS_RELATIME)
+	return syscall.Mount("", target, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY|locked, "")
+}
+
+// mountSandboxDev populates a minimal /dev from the host's device nodes.
+func mountSandboxDev(root string) error {
+	dev := filepath.Join(root, "dev")
+	if err := os.MkdirAll(dev, 0755); err != nil {
+		return err
+	}
+	if err := syscall.Mount("tmpfs", dev, "tmpfs", syscall.MS_NOSUID|syscall.MS_NOEXEC, "mode=0755"); err != nil {
+		return fmt.Errorf("mounting /dev: %w", err)
+	}
+	for _, name := range []string{"null", "zero", "full", "random", "urandom", "tty"} {
+		if err := bindMount("/dev/"+name, filepath.Join(dev, name), false); err != nil {
+			return fmt.Errorf("mounting /dev/%s: %w", name, err)
+		}
+	}
+	for name, target := range map[string]string{
+		"fd": "/proc/self/fd", "stdin": "/proc/self/fd/0", "stdout": "/proc/self/fd/1", "stderr": "/proc/self/fd/2",
+	} {
+		if err := os.Symlink(target, filepath.Join(dev, name)); err != nil {
+			return err
+		}
+	}
+	shm := filepath.Join(dev, "shm")
+	if err := os.Mkdir(shm, 0755); err != nil {
+		return err
+	}
+	return syscall.Mount("tmpfs", shm, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=1777")
+}
+
+// runAsInit runs argv as the sandbox's only child and, being PID 1, reaps
+// anything reparented to it. The child gets a nested user and mount namespace
+// as the bot's own uid: it has no capabilities, and the mounts above are
+// locked, so it can't unmount or remount them writable. Returns the child's
+// exit code.
+func runAsInit(argv []string, uid, gid int) int {
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:  syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS,
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: uid, HostID: 0, Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: gid, HostID: 0, Size: 1}},
+	}
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "claude-bot sandbox: %v\n", err)
+		return 127
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	go func() {
+		for sig := range sigs {
+			cmd.Process.Signal(sig)
+		}
+	}()
+
+	for {
+		var ws syscall.WaitStatus
+		pid, err := syscall.Wait4(-1, &ws, 0, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return 127
+		}
+		if pid != cmd.Process.Pid {
+			continue // an orphan
+		}
+		if ws.Signaled() {
+			return 128 + int(ws.Signal())
+		}
+		return ws.ExitStatus()
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+func (s sandboxSpec) start(cmd *exec.Cmd, cfg sandboxConfig) (func(), error) {
+	return nil, fmt.Errorf("sandboxing needs Linux")
+}
+
+func sandboxExec(args []string) {
+	fmt.Fprintln(os.Stderr, "claude-bot sandbox: sandboxing needs Linux")
+	os.Exit(125)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	for in, want := range map[string]int64{
+		"1024":  1024,
+		"512K":  512 << 10,
+		"4G":    4 << 30,
+		"2GiB":  2 << 30,
+		"100mb": 100 << 20,
+	} {
+		if got, err := parseSize(in); err != nil || got != want {
+			t.Errorf("parseSize(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	for _, bad := range []string{"", "G", "lots", "-1M", "1.5G"} {
+		if _, err := parseSize(bad); err == nil {
+			t.Errorf("parseSize(%q) should fail", bad)
+		}
+	}
+}
+
+func TestParseSandboxMode(t *testing.T) {
+	for _, off := range []string{"", "off", "0"} {
+		if mode, err := parseSandboxMode(off); mode != "" || err != nil {
+			t.Errorf("parseSandboxMode(%q) = %q, %v", off, mode, err)
+		}
+	}
+	if _, err := parseSandboxMode("docker"); err == nil {
+		t.Error("unknown modes should be rejected")
+	}
+}
+
+func TestSandboxMounts(t *testing.T) {
+	spec := sandboxSpec{
+		Dir:      "/home/bot/.claude-bot/trees/o/r/issue-1",
+		Home:     "/home/bot",
+		Writable: []string{"/home/bot/.claude-bot/trees/o/r/issue-1", "/home/bot/.claude-bot/repos/o/r/.git/worktrees/issue-1"},
+		ReadOnly: []string{
+			"/usr", "/usr/local/bin", "/etc",
+			"/home/bot/.claude-bot/trees/o/r/issue-1/.git", "/home/bot/.claude-bot/repos/o/r/.git",
+			"/home/bot/.claude-bot/trees/o/r/issue-1", // already writable
+		},
+	}
+	want := []sandboxMount{
+		{"tmpfs", "/tmp"},
+		{"ro", "/usr"},
+		{"ro", "/etc"},
+		{"tmpfs", "/home/bot"},
+		{"rw", "/home/bot/.claude-bot/trees/o/r/issue-1"},
+		{"ro", "/home/bot/.claude-bot/repos/o/r/.git"},
+		{"ro", "/home/bot/.claude-bot/trees/o/r/issue-1/.git"},
+		{"rw", "/home/bot/.claude-bot/repos/o/r/.git/worktrees/issue-1"},
+	}
+	if got := spec.mounts(); !reflect.DeepEqual(got, want) {
+		t.Errorf("mounts =\n%v\nwant\n%v", got, want)
+	}
+
+	args := strings.Join(spec.bwrapArgs(), " ")
+	for _, frag := range []string{
+		"--unshare-user --unshare-pid",
+		"--tmpfs /home/bot --bind-try /home/bot/.claude-bot/trees/o/r/issue-1 /home/bot/.claude-bot/trees/o/r/issue-1",
+		"--ro-bind-try /home/bot/.claude-bot/repos/o/r/.git /home/bot/.claude-bot/repos/o/r/.git",
+		"--chdir /home/bot/.claude-bot/trees/o/r/issue-1",
+	} {
+		if !strings.Contains(args, frag) {
+			t.Errorf("bwrap args missing %q:\n%s", frag, args)
+		}
+	}
+}
+
+func TestHostToolchain(t *testing.T) {
+	home := t.TempDir()
+	bin := filepath.Join(home, ".bun", "bin")
+	os.MkdirAll(bin, 0755)
+	os.WriteFile(filepath.Join(bin, "claude"), []byte("#!/bin/sh\n"), 0755)
+	t.Setenv("PATH", strings.Join([]string{"/usr/bin", filepath.Join(home, ".ssh", "bin"), home, "relative"}, string(filepath.ListSeparator)))
+
+	got := hostToolchain(home, filepath.Join(bin, "claude"), []string{"/srv/sdk"})
+	for _, want := range []string{"/usr", "/usr/bin", filepath.Join(home, ".bun"), "/srv/sdk"} {
+		if !slices.Contains(got, want) {
+			t.Errorf("toolchain missing %s: %v", want, got)
+		}
+	}
+	for _, hidden := range []string{filepath.Join(home, ".ssh", "bin"), home, "relative"} {
+		if slices.Contains(got, hidden) {
+			t.Errorf("toolchain exposes %s: %v", hidden, got)
+		}
+	}
+}
+
+func TestSandboxEnv(t *testing.T) {
+	env := sandboxEnv([]string{
+		"PATH=/usr/bin", "ANTHROPIC_API_KEY=k", "TMPDIR=/var/tmp",
+		"GH_TOKEN=t", "SSH_AUTH_SOCK=/tmp/agent", "CB_GITLAB_TOKEN=t", "CB_PROMPT_FILE=/tmp/p.md",
+	})
+	want := []string{"PATH=/usr/bin", "ANTHROPIC_API_KEY=k", "CB_PROMPT_FILE=/tmp/p.md", "TMPDIR=/tmp"}
+	if !reflect.DeepEqual(env, want) {
+		t.Errorf("env = %v, want %v", env, want)
+	}
+}
+
+func TestSandboxShellAgentEnv(t *testing.T) {
+	req := AgentRequest{MaxTurns: 40, Model: "opus", Tools: []string{"Read", "Edit"}, Denied: []string{"Bash"}, MCP: "mcp.json", Args: []string{"--verbose"}}
+	vars := req.shellEnv("/tmp/p.md")
+	env := sandboxEnv(append([]string{"CB_GITHUB_TOKEN=t"}, vars...))
+	if want := append(vars, "TMPDIR=/tmp"); !reflect.DeepEqual(env, want) {
+		t.Errorf("env = %v, want %v", env, want)
+	}
+}
+
+func TestWorktreeMounts(t *testing.T) {
+	clone := gitRepo(t, map[string]string{"README.md": "hello\n"})
+	wt := filepath.Join(t.TempDir(), "issue-1")
+	if _, err := run(context.Background(), clone, "git", "worktree", "add", "-q", "-b", "issue-1", wt); err != nil {
+		t.Fatal(err)
+	}
+	// git may report paths through symlinks (macOS /var → /private/var)
+	real := func(p string) string {
+		r, err := filepath.EvalSymlinks(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	rw, ro := worktreeMounts(wt)
+	if len(rw) != 2 || len(ro) != 2 {
+		t.Fatalf("rw = %v, ro = %v", rw, ro)
+	}
+	if rw[0] != wt || real(rw[1]) != real(filepath.Join(clone, ".git", "worktrees", "issue-1")) {
+		t.Errorf("writable = %v", rw)
+	}
+	if ro[0] != filepath.Join(wt, ".git") || real(ro[1]) != real(filepath.Join(clone, ".git")) {
+		t.Errorf("read-only = %v", ro)
+	}
+
+	// A plain clone has no separate admin dir
+	if rw, ro := worktreeMounts(clone); len(rw) != 1 || len(ro) != 1 {
+		t.Errorf("plain clone: rw = %v, ro = %v", rw, ro)
+	}
+}
+
+func TestSandboxOff(t *testing.T) {
+	sb := sandboxConfig{}.forWorktree("/tmp/wt")
+	if sb != nil {
+		t.Fatal("no mode should mean no sandbox")
+	}
+	if err := checkSandbox(sandboxConfig{}); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// --- HTTP Server ---
+// One optional listener (CB_HTTP_ADDR) hosts everything the bot serves over HTTP.
+
+// serveHTTP runs srv until ctx is cancelled, then shuts it down gracefully.
+// Listen errors are logged, not fatal — the poll loop keeps the bot working.
+func serveHTTP(ctx context.Context, addr string, handler http.Handler) {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	slog.Info("listening", "component", "http", "addr", addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("server error", "component", "http", "err", err)
+	}
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// --- Repo settings (.claude-bot.yml) ---
+// Target repos can tune the bot with a .claude-bot.yml (or .claude-bot.json)
+// at the root of their default branch:
+//
+//	setup:
+//	  - npm ci
+//	build: npm run build
+//	test: npm test
+//	lint: npm run lint
+//	allowed_tools: [Read, Edit, Bash]
+//	forbidden_paths:
+//	  - .github/
+//	  - "*.lock"
+//	branch_prefix: bot/
+//	reviewers: [alice, bob]
+//	max_diff_lines: 400
+//
+// It's always read from the default branch, never from the issue branch, so a
+// bot-authored PR can't loosen its own limits. Settings can only narrow what
+// the bot host allows (allowed_tools is intersected with CB_ALLOWED_TOOLS).
+
+var repoSettingsFiles = []string{".claude-bot.yml", ".claude-bot.yaml", ".claude-bot.json"}
+
+type repoSettings struct {
+	Source         string     `json:"-"` // file the settings came from; "" = none
+	Setup          stringList `json:"setup"`
+	Build          stringList `json:"build"`
+	Test           stringList `json:"test"`
+	Lint           stringList `json:"lint"`
+	AllowedTools   stringList `json:"allowed_tools"`
+	ForbiddenPaths stringList `json:"forbidden_paths"`
+	BranchPrefix   string     `json:"branch_prefix"`
+	Reviewers      stringList `json:"reviewers"`
+	MaxDiffLines   int        `json:"max_diff_lines"`
+}
+
+// stringList accepts a single string or a list of strings.
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*l = stringList{s}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return fmt.Errorf("expected a string or a list of strings")
+	}
+	*l = list
+	return nil
+}
+
+var validBranchPrefix = regexp.MustCompile(`^[A-Za-z0-9._/-]*$`)
+
+// loadRepoSettings reads the first settings file present on origin/<branch>.
+// A repo without one gets zero settings.
+func loadRepoSettings(ctx context.Context, repoDir, branch string) (repoSettings, error) {
+	return loadRepoSettingsAt(ctx, repoDir, "origin/"+branch)
+}
+
+// loadRepoSettingsAt reads the settings as of any revision.
+func loadRepoSettingsAt(ctx context.Context, repoDir, rev string) (repoSettings, error) {
+	for _, name := range repoSettingsFiles {
+		data, err := run(ctx, repoDir, "git", "show", rev+":"+name)
+		if err != nil {
+			continue // not present
+		}
+		s, err := parseRepoSettings(name, []byte(data))
+		if err != nil {
+			return repoSettings{}, fmt.Errorf("%s: %w", name, err)
+		}
+		s.Source = name
+		return s, nil
+	}
+	return repoSettings{}, nil
+}
+
+func parseRepoSettings(name string, data []byte) (repoSettings, error) {
+	var s repoSettings
+	if !strings.HasSuffix(name, ".json") {
+		m, err := parseYAML(string(data))
+		if err != nil {
+			return s, err
+		}
+		if data, err = json.Marshal(m); err != nil {
+			return s, err
+		}
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&s); err != nil && err != io.EOF {
+		return s, err
+	}
+	if !validBranchPrefix.MatchString(s.BranchPrefix) || strings.Contains(s.BranchPrefix, "..") {
+		return s, fmt.Errorf("invalid branch_prefix %q", s.BranchPrefix)
+	}
+	if s.MaxDiffLines < 0 {
+		return s, fmt.Errorf("max_diff_lines must not be negative")
+	}
+	return s, nil
+}
+
+// allowedTools narrows the host's tool list to the repo's, if it declares one.
+func (s repoSettings) allowedTools(host []string) []string {
+	if len(s.AllowedTools) == 0 {
+		return host
+	}
+	var out []string
+	for _, t := range host {
+		if slices.Contains(s.AllowedTools, t) {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// promptNotes tells the agent about the repo's constraints and checks.
+func (s repoSettings) promptNotes() string {
+	var b strings.Builder
+	if len(s.ForbiddenPaths) > 0 {
+		fmt.Fprintf(&b, "- Do NOT modify these paths: %s\n", strings.Join(s.ForbiddenPaths, ", "))
+	}
+	if checks := slices.Concat(s.Build, s.Test, s.Lint); len(checks) > 0 {
+		fmt.Fprintf(&b, "- Check your work with: `%s`\n", strings.Join(checks, "`, `"))
+	}
+	if s.MaxDiffLines > 0 {
+		fmt.Fprintf(&b, "- Keep the change under %d changed lines\n", s.MaxDiffLines)
+	}
+	if b.Len() == 0 {
+		return ""
+	}
+	return "\n## Repository rules (" + s.Source + "):\n" + b.String()
+}
+
+// runSetup runs the repo's setup commands in the worktree, streaming to w.
+// They're confined by the same sandbox as the agent.
+func (s repoSettings) runSetup(ctx context.Context, wtDir string, sb *sandboxRun, w io.Writer) error {
+	for _, c := range s.Setup {
+		fmt.Fprintf(w, "$ %s\n", c)
+		cmd := exec.CommandContext(ctx, "sh", "-c", c)
+		cmd.Dir = wtDir
+		cmd.Stdout, cmd.Stderr = w, w
+		cleanup, err := sb.wrap(cmd, nil, nil)
+		if err != nil {
+			return err
+		}
+		err = cmd.Run()
+		cleanup()
+		if err != nil {
+			return fmt.Errorf("setup command %q: %w", c, err)
+		}
+	}
+	return nil
+}
+
+// fileChange is one line of git diff --numstat.
+type fileChange struct {
+	Path           string
+	Added, Deleted int
+}
+
+// branchChanges lists what the branch changes relative to origin/<base>.
+func branchChanges(ctx context.Context, wtDir, base string) ([]fileChange, error) {
+	out, err := run(ctx, wtDir, "git", "diff", "--numstat", "--no-renames", "origin/"+base+"...HEAD")
+	if err != nil {
+		return nil, err
+	}
+	return parseNumstat(out), nil
+}
+
+// parseNumstat parses the output of git diff --numstat.
+func parseNumstat(out string) []fileChange {
+	var changes []fileChange
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		f := strings.SplitN(line, "\t", 3)
+		if len(f) != 3 {
+			continue
+		}
+		// Binary files show "-" for both counts
+		added, _ := strconv.Atoi(f[0])
+		deleted, _ := strconv.Atoi(f[1])
+		changes = append(changes, fileChange{Path: f[2], Added: added, Deleted: deleted})
+	}
+	return changes
+}
+
+// checkBranch enforces forbidden_paths and max_diff_lines on everything the
+// branch checked out in wtDir changes relative to origin/<base>.
+func (s repoSettings) checkBranch(ctx context.Context, wtDir, base string) error {
+	if len(s.ForbiddenPaths) == 0 && s.MaxDiffLines == 0 {
+		return nil
+	}
+	changes, err := branchChanges(ctx, wtDir, base)
+	if err != nil {
+		return fmt.Errorf("diffing against %s: %w", base, err)
+	}
+	return s.checkChangesAllowed(changes)
+}
+
+// checkChangesAllowed enforces forbidden_paths and max_diff_lines.
+func (s repoSettings) checkChangesAllowed(changes []fileChange) error {
+	total := 0
+	for _, c := range changes {
+		for _, p := range s.ForbiddenPaths {
+			if matchPath(p, c.Path) {
+				return fmt.Errorf("changes touch %s, which %s forbids (%q)", c.Path, s.Source, p)
+			}
+		}
+		total += c.Added + c.Deleted
+	}
+	if s.MaxDiffLines > 0 && total > s.MaxDiffLines {
+		return fmt.Errorf("diff is %d lines, over the %d allowed by %s", total, s.MaxDiffLines, s.Source)
+	}
+	return nil
+}
+
+// matchPath reports whether a repo-relative file path matches a forbidden_paths
+// pattern. "dir/" and "dir/**" match everything under dir; a pattern without a
+// slash matches the file name at any depth; anything else is a path.Match glob.
+func matchPath(pattern, p string) bool {
+	pattern = strings.TrimPrefix(pattern, "/")
+	for _, suffix := range []string{"/**", "/"} {
+		if dir, ok := strings.CutSuffix(pattern, suffix); ok {
+			return p == dir || strings.HasPrefix(p, dir+"/")
+		}
+	}
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(p))
+		return ok
+	}
+	ok, _ := path.Match(pattern, p)
+	return ok
+}
+
+// --- YAML subset ---
+
+// parseYAML reads the flat YAML .claude-bot.yml needs: top-level "key: value"
+// pairs whose values are scalars, [flow, lists] or "- item" block lists.
+func parseYAML(src string) (map[string]any, error) {
+	out := map[string]any{}
+	var listKey string // key whose block list is being read
+	for i, raw := range strings.Split(src, "\n") {
+		lineNo := i + 1
+		line := strings.TrimRight(stripYAMLComment(raw), " \t\r")
+		if strings.TrimSpace(line) == "" || line == "---" {
+			continue
+		}
+
+		indented := line[0] == ' ' || line[0] == '\t'
+		if item, ok := strings.CutPrefix(strings.TrimSpace(line), "- "); ok {
+			if listKey == "" {
+				return nil, fmt.Errorf("line %d: list item outside a list", lineNo)
+			}
+			list, _ := out[listKey].([]any)
+			out[listKey] = append(list, yamlScalar(strings.TrimSpace(item)))
+			continue
+		}
+		if indented {
+			return nil, fmt.Errorf("line %d: nested mappings aren't supported", lineNo)
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", lineNo)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if _, dup := out[key]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %q", lineNo, key)
+		}
+		listKey = ""
+		switch {
+		case value == "":
+			out[key] = nil // a block list may follow
+			listKey = key
+		case value == "|" || value == ">" || strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">"):
+			return nil, fmt.Errorf("line %d: block scalars aren't supported; use a list of commands", lineNo)
+		case strings.HasPrefix(value, "["):
+			if !strings.HasSuffix(value, "]") {
+				return nil, fmt.Errorf("line %d: unterminated list", lineNo)
+			}
+			items := []any{}
+			for _, item := range splitYAMLFlow(value[1 : len(value)-1]) {
+				items = append(items, yamlScalar(item))
+			}
+			out[key] = items
+		default:
+			out[key] = yamlScalar(value)
+		}
+	}
+	return out, nil
+}
+
+// yamlScalar converts a plain or quoted scalar.
+func yamlScalar(s string) any {
+	if len(s) >= 2 && (s[0] == '"' && s[len(s)-1] == '"') {
+		if v, err := strconv.Unquote(s); err == nil {
+			return v
+		}
+	}
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'")
+	}
+	switch s {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "null", "~":
+		return nil
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		return n
+	}
+	return s
+}
+
+// stripYAMLComment drops a # comment that starts a line or follows whitespace,
+// outside quotes.
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// splitYAMLFlow splits "a, 'b, c', d" on commas outside quotes.
+func splitYAMLFlow(s string) []string {
+	var parts []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			parts = append(parts, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(s[start:]); last != "" {
+		parts = append(parts, last)
+	}
+	return parts
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testSettingsYAML = `# tune the bot for this repo
+setup:
+  - npm ci
+  - "echo 'ready: yes'"   # quoted, with a colon
+test: npm test
+lint: [npm run lint, "npx tsc --noEmit"]
+allowed_tools: [Read, Edit]
+forbidden_paths:
+  - .github/
+  - "*.lock"
+  - docs/adr/*.md
+branch_prefix: bot/
+reviewers: [alice, bob]
+max_diff_lines: 400
+`
+
+func TestParseRepoSettings(t *testing.T) {
+	s, err := parseRepoSettings(".claude-bot.yml", []byte(testSettingsYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := repoSettings{
+		Setup:          stringList{"npm ci", "echo 'ready: yes'"},
+		Test:           stringList{"npm test"},
+		Lint:           stringList{"npm run lint", "npx tsc --noEmit"},
+		AllowedTools:   stringList{"Read", "Edit"},
+		ForbiddenPaths: stringList{".github/", "*.lock", "docs/adr/*.md"},
+		BranchPrefix:   "bot/",
+		Reviewers:      stringList{"alice", "bob"},
+		MaxDiffLines:   400,
+	}
+	if !reflect.DeepEqual(s, want) {
+		t.Errorf("settings =\n%+v\nwant\n%+v", s, want)
+	}
+
+	js, err := parseRepoSettings(".claude-bot.json", []byte(`{"test": ["go test ./..."], "max_diff_lines": 50}`))
+	if err != nil || js.Test[0] != "go test ./..." || js.MaxDiffLines != 50 {
+		t.Errorf("json settings = %+v, %v", js, err)
+	}
+
+	for _, bad := range []string{
+		"tests: npm test",          // unknown key
+		"branch_prefix: ../escape", // not a safe ref prefix
+		"max_diff_lines: -1",       // negative
+		"setup: |\n  npm ci",       // block scalar
+		"build:\n  cmd: make",      // nested mapping
+		"- orphan",                 // list without a key
+		"test: a\ntest: b",         // duplicate
+		"reviewers: [alice",        // unterminated
+		"max_diff_lines: [1, 2]",   // wrong type
+	} {
+		if _, err := parseRepoSettings(".claude-bot.yml", []byte(bad)); err == nil {
+			t.Errorf("%q should be rejected", bad)
+		}
+	}
+}
+
+func TestMatchPath(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{".github/", ".github/workflows/ci.yml", true},
+		{".github/**", ".github/workflows/ci.yml", true},
+		{".github/", ".githubx/file", false},
+		{"*.lock", "yarn.lock", true},
+		{"*.lock", "sub/dir/Cargo.lock", true},
+		{"docs/adr/*.md", "docs/adr/001-x.md", true},
+		{"docs/adr/*.md", "docs/other/001-x.md", false},
+		{"/go.mod", "go.mod", true},
+		{"go.mod", "tools/go.mod", true},
+	}
+	for _, tt := range tests {
+		if got := matchPath(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("matchPath(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestCheckChangesAllowed(t *testing.T) {
+	s := repoSettings{Source: ".claude-bot.yml", ForbiddenPaths: stringList{".github/"}, MaxDiffLines: 10}
+	if err := s.checkChangesAllowed([]fileChange{{Path: "main.go", Added: 4, Deleted: 2}}); err != nil {
+		t.Errorf("small change rejected: %v", err)
+	}
+	if err := s.checkChangesAllowed([]fileChange{{Path: ".github/workflows/ci.yml", Added: 1}}); err == nil || !strings.Contains(err.Error(), "forbids") {
+		t.Errorf("forbidden path = %v", err)
+	}
+	if err := s.checkChangesAllowed([]fileChange{{Path: "a.go", Added: 8}, {Path: "b.go", Deleted: 3}}); err == nil || !strings.Contains(err.Error(), "11 lines") {
+		t.Errorf("oversized diff = %v", err)
+	}
+}
+
+func TestRepoSettingsAllowedTools(t *testing.T) {
+	host := []string{"Bash", "Read", "Write", "Edit"}
+	if got := (repoSettings{}).allowedTools(host); !reflect.DeepEqual(got, host) {
+		t.Errorf("no settings = %v", got)
+	}
+	// The repo can narrow the host's list but never widen it
+	s := repoSettings{AllowedTools: stringList{"Read", "Edit", "WebFetch"}}
+	if got := s.allowedTools(host); !reflect.DeepEqual(got, []string{"Read", "Edit"}) {
+		t.Errorf("narrowed = %v", got)
+	}
+}
+
+func TestRepoSettingsPromptNotes(t *testing.T) {
+	if (repoSettings{}).promptNotes() != "" {
+		t.Error("no settings should add nothing to the prompt")
+	}
+	s, _ := parseRepoSettings(".claude-bot.yml", []byte(testSettingsYAML))
+	s.Source = ".claude-bot.yml"
+	notes := s.promptNotes()
+	for _, want := range []string{".github/", "`npm test`", "`npx tsc --noEmit`", "400 changed lines"} {
+		if !strings.Contains(notes, want) {
+			t.Errorf("notes missing %q:\n%s", want, notes)
+		}
+	}
+}
+
+// gitRepo makes an origin repo on branch main with files committed, and
+// returns a clone of it.
+func gitRepo(t *testing.T, files map[string]string) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	for k, v := range map[string]string{
+		"GIT_AUTHOR_NAME": "test", "GIT_AUTHOR_EMAIL": "test@example.com",
+		"GIT_COMMITTER_NAME": "test", "GIT_COMMITTER_EMAIL": "test@example.com",
+	} {
+		t.Setenv(k, v)
+	}
+	ctx := context.Background()
+	origin := filepath.Join(t.TempDir(), "origin")
+	git := func(dir string, args ...string) {
+		t.Helper()
+		if _, err := run(ctx, dir, "git", args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git("", "init", "-q", "-b", "main", origin)
+	for name, content := range files {
+		os.MkdirAll(filepath.Dir(filepath.Join(origin, name)), 0755)
+		os.WriteFile(filepath.Join(origin, name), []byte(content), 0644)
+	}
+	git(origin, "add", "-A")
+	git(origin, "commit", "-q", "-m", "init")
+
+	clone := filepath.Join(t.TempDir(), "clone")
+	git("", "clone", "-q", origin, clone)
+	return clone
+}
+
+func TestLoadRepoSettingsAndBranchChanges(t *testing.T) {
+	ctx := context.Background()
+	clone := gitRepo(t, map[string]string{
+		".claude-bot.yml": "forbidden_paths: [.github/]\nmax_diff_lines: 5\n",
+		"README.md":       "hello\n",
+	})
+
+	s, err := loadRepoSettings(ctx, clone, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Source != ".claude-bot.yml" || s.MaxDiffLines != 5 {
+		t.Fatalf("settings = %+v", s)
+	}
+	if none, err := loadRepoSettings(ctx, clone, "no-such-branch"); err != nil || none.Source != "" {
+		t.Errorf("missing settings = %+v, %v", none, err)
+	}
+
+	// A local change to the settings file must not count: only origin's copy does
+	os.WriteFile(filepath.Join(clone, ".claude-bot.yml"), []byte("max_diff_lines: 1000\n"), 0644)
+	os.MkdirAll(filepath.Join(clone, ".github"), 0755)
+	os.WriteFile(filepath.Join(clone, ".github", "ci.yml"), []byte("on: push\n"), 0644)
+	run(ctx, clone, "git", "add", "-A")
+	run(ctx, clone, "git", "commit", "-q", "-m", "change")
+
+	if again, _ := loadRepoSettings(ctx, clone, "main"); again.MaxDiffLines != 5 {
+		t.Errorf("settings should come from origin/main, got max_diff_lines=%d", again.MaxDiffLines)
+	}
+	changes, err := branchChanges(ctx, clone, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 {
+		t.Fatalf("changes = %+v", changes)
+	}
+	if err := s.checkChangesAllowed(changes); err == nil {
+		t.Error("a change under .github/ should be rejected")
+	}
+}
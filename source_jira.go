@@ -0,0 +1,432 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Jira issue source ---
+// "jira:acme.atlassian.net/APP" takes a repo's issues from Jira Cloud project
+// APP through REST API v3. Auth: CB_JIRA_EMAIL and CB_JIRA_TOKEN, an Atlassian
+// API token. Jira Data Center doesn't serve this API. jql (CB_JQL) narrows the
+// project's issues further, e.g. "component = backend".
+//
+// APP-123 is issue #123 to the rest of the bot. Labels are Jira labels, except
+// those mapped to workflow statuses by transitions: adding one of those moves
+// the issue with the transition to its status, and removing it does nothing,
+// since the next status replaces it. Comments are posted as plain paragraphs
+// and code blocks, with a "claude-bot" comment property marking them as the
+// bot's. The bot's PRs are added to the issue as remote links.
+
+type jiraSource struct {
+	host    string
+	project string
+	jql     string
+	flow    workflow
+	api     *restClient
+}
+
+func newJiraSource(host, project, jql, email, token string, flow workflow) *jiraSource {
+	auth := ""
+	if email != "" && token != "" {
+		auth = "Basic " + base64.StdEncoding.EncodeToString([]byte(email+":"+token))
+	}
+	return &jiraSource{
+		host:    host,
+		project: project,
+		jql:     jql,
+		flow:    flow,
+		api:     &restClient{base: "https://" + host + "/rest/api/3", header: "Authorization", token: auth},
+	}
+}
+
+// jiraFields are the issue fields the bot reads.
+const jiraFields = "summary,description,labels,status,reporter"
+
+// jiraCommentProperty marks the bot's comments.
+const jiraCommentProperty = "claude-bot"
+
+// jiraUser is known by its account id. Display names and emails can change
+// and needn't be unique, so they're never used.
+type jiraUser struct {
+	AccountID string `json:"accountId"`
+}
+
+type jiraIssue struct {
+	Key    string `json:"key"`
+	Fields struct {
+		Summary     string   `json:"summary"`
+		Description *adfNode `json:"description"`
+		Labels      []string `json:"labels"`
+		Status      struct {
+			Name string `json:"name"`
+		} `json:"status"`
+		Reporter jiraUser `json:"reporter"`
+	} `json:"fields"`
+}
+
+type jiraComment struct {
+	ID         string   `json:"id"`
+	Author     jiraUser `json:"author"`
+	Body       *adfNode `json:"body"`
+	Created    string   `json:"created"`
+	Properties []struct {
+		Key string `json:"key"`
+	} `json:"properties"`
+}
+
+func (c jiraComment) fromBot() bool {
+	for _, p := range c.Properties {
+		if p.Key == jiraCommentProperty {
+			return true
+		}
+	}
+	return false
+}
+
+func (j *jiraSource) key(number int) string {
+	return fmt.Sprintf("%s-%d", j.project, number)
+}
+
+// jqlString quotes s for a JQL query.
+func jqlString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func (j *jiraSource) ListIssues(ctx context.Context, repo, label string) ([]Issue, error) {
+	clauses := []string{"project = " + jqlString(j.project)}
+	if j.jql != "" {
+		clauses = append(clauses, "("+j.jql+")")
+	}
+	switch status := j.flow.status(label); {
+	case status != "":
+		clauses = append(clauses, "status = "+jqlString(status))
+	case label != "":
+		clauses = append(clauses, "labels = "+jqlString(label))
+	default:
+		clauses = append(clauses, "statusCategory != Done")
+	}
+	q := url.Values{
+		"jql":        {strings.Join(clauses, " AND ") + " ORDER BY created ASC"},
+		"fields":     {jiraFields},
+		"maxResults": {"50"},
+	}
+	var issues []Issue
+	for {
+		var page struct {
+			Issues        []jiraIssue `json:"issues"`
+			NextPageToken string      `json:"nextPageToken"`
+		}
+		if err := j.api.do(ctx, http.MethodGet, "/search/jql?"+q.Encode(), nil, &page); err != nil {
+			return nil, err
+		}
+		for _, ri := range page.Issues {
+			issue, err := j.issue(ctx, repo, ri)
+			if err != nil {
+				return nil, err
+			}
+			issues = append(issues, issue)
+		}
+		if page.NextPageToken == "" {
+			return issues, nil
+		}
+		q.Set("nextPageToken", page.NextPageToken)
+	}
+}
+
+func (j *jiraSource) GetIssue(ctx context.Context, repo string, number int) (Issue, error) {
+	var ri jiraIssue
+	if err := j.api.do(ctx, http.MethodGet, "/issue/"+j.key(number)+"?fields="+jiraFields, nil, &ri); err != nil {
+		return Issue{}, err
+	}
+	return j.issue(ctx, repo, ri)
+}
+
+// issue converts an API issue, fetching its comments: search results don't
+// carry their properties.
+func (j *jiraSource) issue(ctx context.Context, repo string, ri jiraIssue) (Issue, error) {
+	_, num, _ := strings.Cut(ri.Key, "-")
+	number, err := strconv.Atoi(num)
+	if err != nil {
+		return Issue{}, fmt.Errorf("unexpected Jira issue key %q", ri.Key)
+	}
+	issue := Issue{
+		Number: number,
+		Ref:    ri.Key,
+		Title:  ri.Fields.Summary,
+		Body:   ri.Fields.Description.plain(),
+		Repo:   repo,
+		URL:    "https://" + j.host + "/browse/" + ri.Key,
+	}
+	issue.Author.Login = ri.Fields.Reporter.AccountID
+	labels := slices.Clone(ri.Fields.Labels)
+	if l := j.flow.label(ri.Fields.Status.Name); l != "" && !slices.Contains(labels, l) {
+		labels = append(labels, l)
+	}
+	for _, l := range labels {
+		issue.Labels = append(issue.Labels, Label{Name: l})
+	}
+	if issue.Comments, err = j.Comments(ctx, issue); err != nil {
+		return Issue{}, err
+	}
+	return issue, nil
+}
+
+func (j *jiraSource) Comments(ctx context.Context, issue Issue) ([]Comment, error) {
+	var comments []Comment
+	for {
+		var page struct {
+			Total    int           `json:"total"`
+			Comments []jiraComment `json:"comments"`
+		}
+		path := fmt.Sprintf("/issue/%s/comment?orderBy=created&startAt=%d&maxResults=100&expand=properties", j.key(issue.Number), len(comments))
+		if err := j.api.do(ctx, http.MethodGet, path, nil, &page); err != nil {
+			return nil, err
+		}
+		for _, rc := range page.Comments {
+			c := Comment{ID: rc.ID, Body: rc.Body.plain(), CreatedAt: jiraTime(rc.Created)}
+			c.Author.Login = rc.Author.AccountID
+			if rc.fromBot() {
+				c.Body += "\n" + botCommentMarker
+			}
+			comments = append(comments, c)
+		}
+		if len(page.Comments) == 0 || len(comments) >= page.Total {
+			return comments, nil
+		}
+	}
+}
+
+// jiraTime converts Jira's timestamps ("2026-03-01T09:00:00.000+0000") to
+// RFC 3339, as the rest of the bot expects.
+func jiraTime(s string) string {
+	t, err := time.Parse("2006-01-02T15:04:05.000-0700", s)
+	if err != nil {
+		return s
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func (j *jiraSource) AddLabel(ctx context.Context, issue Issue, label string) error {
+	if status := j.flow.status(label); status != "" {
+		return j.transition(ctx, j.key(issue.Number), status)
+	}
+	return j.editLabels(ctx, issue, "add", label)
+}
+
+func (j *jiraSource) RemoveLabel(ctx context.Context, issue Issue, label string) error {
+	if j.flow.status(label) != "" {
+		return nil // replaced by the next status
+	}
+	return j.editLabels(ctx, issue, "remove", label)
+}
+
+func (j *jiraSource) editLabels(ctx context.Context, issue Issue, op, label string) error {
+	body := map[string]any{"update": map[string]any{"labels": []map[string]string{{op: label}}}}
+	return j.api.do(ctx, http.MethodPut, "/issue/"+j.key(issue.Number), body, nil)
+}
+
+// transition moves an issue to status, by the transition that leads there or
+// one named after it.
+func (j *jiraSource) transition(ctx context.Context, key, status string) error {
+	var raw struct {
+		Transitions []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+			To   struct {
+				Name string `json:"name"`
+			} `json:"to"`
+		} `json:"transitions"`
+	}
+	if err := j.api.do(ctx, http.MethodGet, "/issue/"+key+"/transitions", nil, &raw); err != nil {
+		return err
+	}
+	for _, t := range raw.Transitions {
+		if strings.EqualFold(t.To.Name, status) || strings.EqualFold(t.Name, status) {
+			return j.api.do(ctx, http.MethodPost, "/issue/"+key+"/transitions", map[string]any{"transition": map[string]string{"id": t.ID}}, nil)
+		}
+	}
+	// Workflows rarely offer a transition to the status an issue is already in
+	var current jiraIssue
+	if err := j.api.do(ctx, http.MethodGet, "/issue/"+key+"?fields=status", nil, &current); err != nil {
+		return err
+	}
+	if strings.EqualFold(current.Fields.Status.Name, status) {
+		return nil
+	}
+	return fmt.Errorf("%s: no transition from %q to %q", key, current.Fields.Status.Name, status)
+}
+
+func (j *jiraSource) Comment(ctx context.Context, issue Issue, body string) error {
+	body = strings.TrimSpace(strings.ReplaceAll(body, botCommentMarker, ""))
+	in := map[string]any{
+		"body":       adfDoc(body),
+		"properties": []map[string]any{{"key": jiraCommentProperty, "value": map[string]bool{"bot": true}}},
+	}
+	return j.api.do(ctx, http.MethodPost, "/issue/"+j.key(issue.Number)+"/comment", in, nil)
+}
+
+// Jira labels need no creating, and its API has no reactions.
+func (j *jiraSource) EnsureLabel(context.Context, string, string, string, string) (bool, error) {
+	return false, nil
+}
+func (j *jiraSource) React(context.Context, string, string, string) error { return nil }
+
+// Labeler finds who last added label, or moved the issue to its status, in
+// the issue's changelog.
+func (j *jiraSource) Labeler(ctx context.Context, issue Issue, label string) (string, error) {
+	status := j.flow.status(label)
+	labeler := ""
+	for start := 0; ; {
+		var page struct {
+			IsLast bool `json:"isLast"`
+			Values []struct {
+				Author jiraUser `json:"author"`
+				Items  []struct {
+					Field      string `json:"field"`
+					FromString string `json:"fromString"`
+					ToString   string `json:"toString"`
+				} `json:"items"`
+			} `json:"values"`
+		}
+		path := fmt.Sprintf("/issue/%s/changelog?startAt=%d&maxResults=100", j.key(issue.Number), start)
+		if err := j.api.do(ctx, http.MethodGet, path, nil, &page); err != nil {
+			return "", err
+		}
+		for _, v := range page.Values {
+			for _, it := range v.Items {
+				switch {
+				case status != "" && it.Field == "status" && strings.EqualFold(it.ToString, status),
+					status == "" && it.Field == "labels" && slices.Contains(strings.Fields(it.ToString), label) &&
+						!slices.Contains(strings.Fields(it.FromString), label):
+					labeler = v.Author.AccountID
+				}
+			}
+		}
+		if page.IsLast || len(page.Values) == 0 {
+			return labeler, nil
+		}
+		start += len(page.Values)
+	}
+}
+
+// LinkPR adds the PR to the issue's links. Keyed by URL, so it's idempotent.
+func (j *jiraSource) LinkPR(ctx context.Context, issue Issue, url, title string) error {
+	in := map[string]any{
+		"globalId": url,
+		"object":   map[string]string{"url": url, "title": title},
+	}
+	return j.api.do(ctx, http.MethodPost, "/issue/"+j.key(issue.Number)+"/remotelink", in, nil)
+}
+
+// --- Atlassian Document Format ---
+// Jira v3 takes and returns rich text as ADF trees. The bot reads them as
+// plain text and writes paragraphs and fenced code blocks; other Markdown is
+// posted as is.
+
+type adfNode struct {
+	Type    string         `json:"type"`
+	Version int            `json:"version,omitempty"`
+	Text    string         `json:"text,omitempty"`
+	Attrs   map[string]any `json:"attrs,omitempty"`
+	Content []adfNode      `json:"content,omitempty"`
+}
+
+// plain renders n as text; a nil node (an empty description) is "".
+func (n *adfNode) plain() string {
+	if n == nil {
+		return ""
+	}
+	var b strings.Builder
+	n.write(&b)
+	return strings.TrimSpace(b.String())
+}
+
+func (n *adfNode) write(b *strings.Builder) {
+	switch n.Type {
+	case "text":
+		b.WriteString(n.Text)
+		return
+	case "hardBreak":
+		b.WriteString("\n")
+		return
+	case "mention", "emoji", "inlineCard":
+		for _, attr := range []string{"text", "url"} {
+			if s, ok := n.Attrs[attr].(string); ok {
+				b.WriteString(s)
+				break
+			}
+		}
+		return
+	case "codeBlock":
+		b.WriteString("```\n")
+	case "listItem":
+		b.WriteString("- ")
+	}
+	for i := range n.Content {
+		n.Content[i].write(b)
+	}
+	switch n.Type {
+	case "codeBlock":
+		b.WriteString("\n```\n\n")
+	case "paragraph", "heading", "blockquote":
+		b.WriteString("\n\n")
+	}
+}
+
+// adfDoc turns text into a document: paragraphs split on blank lines, with
+// line breaks kept, and ``` fences as code blocks.
+func adfDoc(text string) adfNode {
+	doc := adfNode{Type: "doc", Version: 1}
+	var para []string
+	flush := func() {
+		if len(para) == 0 {
+			return
+		}
+		p := adfNode{Type: "paragraph"}
+		for i, line := range para {
+			if i > 0 {
+				p.Content = append(p.Content, adfNode{Type: "hardBreak"})
+			}
+			if line != "" {
+				p.Content = append(p.Content, adfNode{Type: "text", Text: line})
+			}
+		}
+		doc.Content = append(doc.Content, p)
+		para = nil
+	}
+	lines := strings.Split(text, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		lang, fence := strings.CutPrefix(strings.TrimSpace(line), "```")
+		switch {
+		case fence:
+			flush()
+			var code []string
+			for i++; i < len(lines) && strings.TrimSpace(lines[i]) != "```"; i++ {
+				code = append(code, lines[i])
+			}
+			block := adfNode{Type: "codeBlock"}
+			if lang != "" {
+				block.Attrs = map[string]any{"language": lang}
+			}
+			if s := strings.Join(code, "\n"); s != "" {
+				block.Content = []adfNode{{Type: "text", Text: s}}
+			}
+			doc.Content = append(doc.Content, block)
+		case strings.TrimSpace(line) == "":
+			flush()
+		default:
+			para = append(para, line)
+		}
+	}
+	flush()
+	return doc
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestJiraSource(t *testing.T) {
+	var searched string
+	var requests []string // mutating requests, "METHOD path body"
+	record := func(r *http.Request) map[string]any {
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		data, _ := json.Marshal(body)
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+string(data))
+		return body
+	}
+	const issueJSON = `{"key": "APP-7", "fields": {
+		"summary": "Login fails",
+		"description": {"type": "doc", "version": 1, "content": [
+			{"type": "paragraph", "content": [{"type": "text", "text": "Spaces break it."}, {"type": "hardBreak"}, {"type": "text", "text": "See "}, {"type": "inlineCard", "attrs": {"url": "https://x.test/1"}}]},
+			{"type": "codeBlock", "content": [{"type": "text", "text": "login('a b')"}]}
+		]},
+		"labels": ["backend"],
+		"status": {"name": "To Do"},
+		"reporter": {"accountId": "5b10", "displayName": "Alice"}
+	}}`
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /rest/api/3/search/jql", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Basic Ym90QGFjbWUudGVzdDpzZWNyZXQ=" {
+			t.Errorf("auth = %q", r.Header.Get("Authorization"))
+		}
+		if r.URL.Query().Get("nextPageToken") == "p2" {
+			w.Write([]byte(`{"issues": [{"key": "APP-8", "fields": {"summary": "Typo", "status": {"name": "To Do"}}}], "isLast": true}`))
+			return
+		}
+		searched = r.URL.Query().Get("jql")
+		w.Write([]byte(`{"issues": [` + issueJSON + `], "nextPageToken": "p2"}`))
+	})
+	mux.HandleFunc("GET /rest/api/3/issue/APP-7", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(issueJSON))
+	})
+	mux.HandleFunc("GET /rest/api/3/issue/APP-7/comment", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("expand") != "properties" {
+			t.Error("comments fetched without their properties")
+		}
+		if r.URL.Query().Get("startAt") == "1" {
+			w.Write([]byte(`{"total": 2, "comments": [
+				{"id": "101", "author": {"accountId": "5b99", "displayName": "Bot"}, "created": "2026-03-01T09:30:00.000+0000", "properties": [{"key": "claude-bot", "value": {"bot": true}}],
+				 "body": {"type": "doc", "content": [{"type": "paragraph", "content": [{"type": "text", "text": "PR ready"}]}]}}
+			]}`))
+			return
+		}
+		w.Write([]byte(`{"total": 2, "comments": [
+			{"id": "100", "author": {"accountId": "5b20", "displayName": "Bob"}, "created": "2026-03-01T10:00:00.000+0100",
+			 "body": {"type": "doc", "content": [{"type": "paragraph", "content": [{"type": "text", "text": "/bot retry"}]}]}}
+		]}`))
+	})
+	mux.HandleFunc("GET /rest/api/3/issue/APP-8/comment", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"comments": []}`))
+	})
+	mux.HandleFunc("GET /rest/api/3/issue/APP-7/transitions", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"transitions": [{"id": "21", "name": "Start work", "to": {"name": "In Progress"}}, {"id": "31", "name": "In Review", "to": {"name": "Review"}}]}`))
+	})
+	mux.HandleFunc("GET /rest/api/3/issue/APP-7/changelog", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("startAt") == "0" {
+			w.Write([]byte(`{"isLast": false, "values": [
+				{"author": {"accountId": "5b30", "displayName": "Carol"}, "items": [{"field": "status", "fromString": "Backlog", "toString": "To Do"}]},
+				{"author": {"accountId": "5b40", "displayName": "Dave"}, "items": [{"field": "labels", "fromString": "", "toString": "backend"}]}
+			]}`))
+			return
+		}
+		w.Write([]byte(`{"isLast": true, "values": [
+			{"author": {"accountId": "5b50", "emailAddress": "erin@acme.test", "displayName": "Erin"}, "items": [{"field": "status", "fromString": "In Progress", "toString": "To Do"}]},
+			{"author": {"accountId": "5b60", "displayName": "Frank"}, "items": [{"field": "labels", "fromString": "backend", "toString": "backend bot:plan"}]}
+		]}`))
+	})
+	mux.HandleFunc("POST /rest/api/3/issue/APP-7/transitions", func(w http.ResponseWriter, r *http.Request) { record(r) })
+	mux.HandleFunc("PUT /rest/api/3/issue/APP-7", func(w http.ResponseWriter, r *http.Request) { record(r) })
+	mux.HandleFunc("POST /rest/api/3/issue/APP-7/comment", func(w http.ResponseWriter, r *http.Request) { record(r) })
+	mux.HandleFunc("POST /rest/api/3/issue/APP-7/remotelink", func(w http.ResponseWriter, r *http.Request) { record(r) })
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	t.Setenv("CB_JIRA_EMAIL", "bot@acme.test")
+	t.Setenv("CB_JIRA_TOKEN", "secret")
+	cfg := Config{IssueLabel: "todo", WIPLabel: "in-progress", DoneLabel: "done", NeedsInfoLabel: "needs-info", PlanLabel: "bot:plan", JQL: "component = backend",
+		Transitions: labelNames{Issue: "To Do", WIP: "In Progress", Done: "In Review", NeedsInfo: "Blocked"}}
+	s, err := newIssueSource("jira:jira.acme.test/APP", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := s.(*jiraSource)
+	j.api.base = srv.URL + "/rest/api/3"
+	repo := "acme/app"
+	useSource(t, repo, j)
+	ctx := context.Background()
+
+	issues, err := fetchIssues(ctx, repo, "todo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if searched != `project = "APP" AND (component = backend) AND status = "To Do" ORDER BY created ASC` {
+		t.Errorf("jql = %s", searched)
+	}
+	if len(issues) != 2 || issues[1].Number != 8 {
+		t.Fatalf("issues = %+v", issues)
+	}
+	issue := issues[0]
+	if issue.Number != 7 || issue.Ref != "APP-7" || issue.reference() != "APP-7" || issue.URL != "https://jira.acme.test/browse/APP-7" ||
+		issue.Author.Login != "5b10" || !slices.Equal(issueLabels(issue), []string{"backend", "todo"}) {
+		t.Errorf("issue = %+v", issue)
+	}
+	if want := "Spaces break it.\nSee https://x.test/1\n\n```\nlogin('a b')\n```"; issue.Body != want {
+		t.Errorf("body = %q, want %q", issue.Body, want)
+	}
+	if len(issue.Comments) != 2 || isBotComment(issue.Comments[0]) || !isBotComment(issue.Comments[1]) || issue.Comments[0].CreatedAt != "2026-03-01T09:00:00Z" {
+		t.Errorf("comments = %+v", issue.Comments)
+	}
+	if authors := contentAuthors(cfg, issue); !slices.Equal(authors, []string{"5b10", "5b20"}) {
+		t.Errorf("authors = %q", authors)
+	}
+	cfg.MembersOnly = true
+	if contentAuthors(cfg, issue) != nil {
+		t.Error("members_only Jira users were taken for outsiders")
+	}
+
+	// State labels move the issue through the workflow; others are labels
+	_ = addLabel(ctx, issue, "in-progress")
+	_ = removeLabel(ctx, issue, "todo")
+	_ = addLabel(ctx, issue, "bot:plan")
+	_ = removeLabel(ctx, issue, "bot:plan")
+	if err := addLabel(ctx, issue, "todo"); err != nil {
+		t.Errorf("moving to the current status: %v", err)
+	}
+	if err := addLabel(ctx, issue, "needs-info"); err == nil || !strings.Contains(err.Error(), `no transition from "To Do" to "Blocked"`) {
+		t.Errorf("moving to an unreachable status: %v", err)
+	}
+	want := []string{
+		`POST /rest/api/3/issue/APP-7/transitions {"transition":{"id":"21"}}`,
+		`PUT /rest/api/3/issue/APP-7 {"update":{"labels":[{"add":"bot:plan"}]}}`,
+		`PUT /rest/api/3/issue/APP-7 {"update":{"labels":[{"remove":"bot:plan"}]}}`,
+	}
+	if !slices.Equal(requests, want) {
+		t.Errorf("requests =\n%s\nwant\n%s", strings.Join(requests, "\n"), strings.Join(want, "\n"))
+	}
+
+	requests = nil
+	if err := ensurePRComment(ctx, issue, "https://github.com/acme/app/pull/9"); err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 2 ||
+		requests[0] != `POST /rest/api/3/issue/APP-7/remotelink {"globalId":"https://github.com/acme/app/pull/9","object":{"title":"fix: resolve APP-7 — Login fails","url":"https://github.com/acme/app/pull/9"}}` ||
+		requests[1] != `POST /rest/api/3/issue/APP-7/comment {"body":{"content":[{"content":[{"text":"PR ready for review: https://github.com/acme/app/pull/9","type":"text"}],"type":"paragraph"}],"type":"doc","version":1},"properties":[{"key":"claude-bot","value":{"bot":true}}]}` {
+		t.Errorf("requests =\n%s", strings.Join(requests, "\n"))
+	}
+
+	for label, want := range map[string]string{"todo": "5b50", "bot:plan": "5b60", "backend": "5b40", "done": ""} {
+		if got, err := j.Labeler(ctx, issue, label); err != nil || got != want {
+			t.Errorf("Labeler(%s) = %q, %v; want %q", label, got, err, want)
+		}
+	}
+
+	for _, bad := range []string{"jira:jira.acme.test", "jira:/APP", "jira:host/a/b"} {
+		if _, err := newIssueSource(bad, cfg); err == nil {
+			t.Errorf("issue source %q should fail", bad)
+		}
+	}
+	// Jira accounts can't be checked against the forge
+	for _, rules := range []Config{{CommandUsers: []string{"5b20"}}, {MinPermission: permWrite}, {OutsideApproval: true}} {
+		rules.Repos, rules.IssueSource = []string{"acme/jira-rules"}, "jira:jira.acme.test/APP"
+		if err := registerIssueSources(rules); err == nil {
+			t.Errorf("%+v should fail", rules)
+		}
+	}
+}
+
+func TestADF(t *testing.T) {
+	doc := adfDoc("Tests failed:\n```go\nfoo_test.go:3: want 1\n\n```\n\nFirst line\nsecond line\n\n\nLast")
+	data, _ := json.Marshal(doc)
+	want := `{"type":"doc","version":1,"content":[` +
+		`{"type":"paragraph","content":[{"type":"text","text":"Tests failed:"}]},` +
+		`{"type":"codeBlock","attrs":{"language":"go"},"content":[{"type":"text","text":"foo_test.go:3: want 1\n"}]},` +
+		`{"type":"paragraph","content":[{"type":"text","text":"First line"},{"type":"hardBreak"},{"type":"text","text":"second line"}]},` +
+		`{"type":"paragraph","content":[{"type":"text","text":"Last"}]}]}`
+	if string(data) != want {
+		t.Errorf("adfDoc =\n%s\nwant\n%s", data, want)
+	}
+	if got := doc.plain(); got != "Tests failed:\n\n```\nfoo_test.go:3: want 1\n\n```\n\nFirst line\nsecond line\n\nLast" {
+		t.Errorf("plain = %q", got)
+	}
+	var empty *adfNode
+	if empty.plain() != "" {
+		t.Error("a missing description should be empty")
+	}
+}
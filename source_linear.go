@@ -0,0 +1,463 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// --- Linear issue source ---
+// "linear:ENG" takes a repo's issues from the Linear team with key ENG
+// through the GraphQL API. Auth: CB_LINEAR_API_KEY, a personal API key. The
+// bot's comments are those of the key's user, so give it its own account.
+//
+// ENG-123 is issue #123 to the rest of the bot. Labels are Linear labels
+// (team or workspace), except those mapped to workflow states by transitions:
+// adding one of those moves the issue to its state, and removing it does
+// nothing, since the next state replaces it. Comments are Markdown, so they
+// go as written. The bot's PRs are attached to the issue as links.
+
+type linearSource struct {
+	team string
+	flow workflow
+	api  *restClient
+
+	mu     sync.Mutex
+	ids    map[int]string    // issue number → id
+	states map[string]string // workflow state name, lower-cased → id
+	viewer string            // the API key's user id
+}
+
+func newLinearSource(team, apiKey string, flow workflow) *linearSource {
+	return &linearSource{
+		team: team,
+		flow: flow,
+		api:  &restClient{base: "https://api.linear.app", header: "Authorization", token: apiKey},
+		ids:  map[int]string{},
+	}
+}
+
+// linearIssueFields are the issue fields the bot reads.
+const linearIssueFields = `id identifier number title description url
+	creator { id }
+	state { name }
+	labels { nodes { name } }
+	comments(first: 100) { nodes { id body createdAt user { id } } }`
+
+type linearIssue struct {
+	ID          string     `json:"id"`
+	Identifier  string     `json:"identifier"`
+	Number      int        `json:"number"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	URL         string     `json:"url"`
+	Creator     linearUser `json:"creator"`
+	State       struct {
+		Name string `json:"name"`
+	} `json:"state"`
+	Labels struct {
+		Nodes []struct {
+			Name string `json:"name"`
+		} `json:"nodes"`
+	} `json:"labels"`
+	Comments struct {
+		Nodes []struct {
+			ID        string     `json:"id"`
+			Body      string     `json:"body"`
+			CreatedAt string     `json:"createdAt"`
+			User      linearUser `json:"user"`
+		} `json:"nodes"`
+	} `json:"comments"`
+}
+
+// linearUser is known by its id: display names can change and needn't be
+// unique.
+type linearUser struct {
+	ID string `json:"id"`
+}
+
+// linearPageFields asks a connection where its page ends; pass EndCursor as
+// the next page's "after".
+const linearPageFields = `pageInfo { hasNextPage endCursor }`
+
+type linearPageInfo struct {
+	HasNextPage bool   `json:"hasNextPage"`
+	EndCursor   string `json:"endCursor"`
+}
+
+// query runs a GraphQL query or mutation and decodes its data into out.
+func (l *linearSource) query(ctx context.Context, q string, vars map[string]any, out any) error {
+	var resp struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := l.api.do(ctx, http.MethodPost, "/graphql", map[string]any{"query": q, "variables": vars}, &resp); err != nil {
+		return err
+	}
+	if len(resp.Errors) > 0 {
+		return fmt.Errorf("linear: %s", resp.Errors[0].Message)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(resp.Data, out)
+}
+
+// mutate runs a mutation named op, which must report success.
+func (l *linearSource) mutate(ctx context.Context, op, q string, vars map[string]any) error {
+	var out map[string]struct {
+		Success bool `json:"success"`
+	}
+	if err := l.query(ctx, q, vars, &out); err != nil {
+		return err
+	}
+	if !out[op].Success {
+		return fmt.Errorf("linear: %s failed", op)
+	}
+	return nil
+}
+
+func (l *linearSource) identifier(number int) string {
+	return fmt.Sprintf("%s-%d", l.team, number)
+}
+
+func (l *linearSource) ListIssues(ctx context.Context, repo, label string) ([]Issue, error) {
+	filter := map[string]any{"team": map[string]any{"key": map[string]string{"eq": l.team}}}
+	switch status := l.flow.status(label); {
+	case status != "":
+		filter["state"] = map[string]any{"name": map[string]string{"eqIgnoreCase": status}}
+	case label != "":
+		filter["labels"] = map[string]any{"name": map[string]string{"eqIgnoreCase": label}}
+	default:
+		filter["state"] = map[string]any{"type": map[string]any{"nin": []string{"completed", "canceled"}}}
+	}
+	q := `query($filter: IssueFilter, $after: String) { issues(filter: $filter, first: 50, after: $after, orderBy: createdAt) { nodes { ` +
+		linearIssueFields + ` } ` + linearPageFields + ` } }`
+	viewer, err := l.viewerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	vars := map[string]any{"filter": filter}
+	var issues []Issue
+	for {
+		var out struct {
+			Issues struct {
+				Nodes    []linearIssue  `json:"nodes"`
+				PageInfo linearPageInfo `json:"pageInfo"`
+			} `json:"issues"`
+		}
+		if err := l.query(ctx, q, vars, &out); err != nil {
+			return nil, err
+		}
+		for _, li := range out.Issues.Nodes {
+			issues = append(issues, l.issue(repo, viewer, li))
+		}
+		if !out.Issues.PageInfo.HasNextPage {
+			return issues, nil
+		}
+		vars["after"] = out.Issues.PageInfo.EndCursor
+	}
+}
+
+func (l *linearSource) GetIssue(ctx context.Context, repo string, number int) (Issue, error) {
+	viewer, err := l.viewerID(ctx)
+	if err != nil {
+		return Issue{}, err
+	}
+	var out struct {
+		Issue *linearIssue `json:"issue"`
+	}
+	q := `query($id: String!) { issue(id: $id) { ` + linearIssueFields + ` } }`
+	if err := l.query(ctx, q, map[string]any{"id": l.identifier(number)}, &out); err != nil {
+		return Issue{}, err
+	}
+	if out.Issue == nil {
+		return Issue{}, fmt.Errorf("linear: no issue %s", l.identifier(number))
+	}
+	return l.issue(repo, viewer, *out.Issue), nil
+}
+
+// viewerID returns the id of the API key's user, whose comments are the
+// bot's.
+func (l *linearSource) viewerID(ctx context.Context) (string, error) {
+	l.mu.Lock()
+	id := l.viewer
+	l.mu.Unlock()
+	if id != "" {
+		return id, nil
+	}
+	var out struct {
+		Viewer linearUser `json:"viewer"`
+	}
+	if err := l.query(ctx, `query { viewer { id } }`, nil, &out); err != nil {
+		return "", err
+	}
+	if out.Viewer.ID == "" {
+		return "", fmt.Errorf("linear: the API key has no user")
+	}
+	l.mu.Lock()
+	l.viewer = out.Viewer.ID
+	l.mu.Unlock()
+	return out.Viewer.ID, nil
+}
+
+// issue converts an API issue, remembering its id for mutations. Comments
+// by viewer, the API key's user, are marked as the bot's.
+func (l *linearSource) issue(repo, viewer string, li linearIssue) Issue {
+	l.mu.Lock()
+	l.ids[li.Number] = li.ID
+	l.mu.Unlock()
+
+	issue := Issue{Number: li.Number, Ref: li.Identifier, Title: li.Title, Body: li.Description, Repo: repo, URL: li.URL}
+	issue.Author.Login = li.Creator.ID
+	var labels []string
+	for _, n := range li.Labels.Nodes {
+		labels = append(labels, n.Name)
+	}
+	if lb := l.flow.label(li.State.Name); lb != "" && !slices.Contains(labels, lb) {
+		labels = append(labels, lb)
+	}
+	for _, name := range labels {
+		issue.Labels = append(issue.Labels, Label{Name: name})
+	}
+	for _, n := range li.Comments.Nodes {
+		c := Comment{ID: n.ID, Body: n.Body, CreatedAt: n.CreatedAt}
+		c.Author.Login = n.User.ID
+		if n.User.ID == viewer {
+			c.Body += "\n" + botCommentMarker
+		}
+		issue.Comments = append(issue.Comments, c)
+	}
+	// Timestamps are all UTC in the same format, so they sort as strings
+	slices.SortStableFunc(issue.Comments, func(a, b Comment) int { return strings.Compare(a.CreatedAt, b.CreatedAt) })
+	return issue
+}
+
+func (l *linearSource) Comments(ctx context.Context, issue Issue) ([]Comment, error) {
+	current, err := l.GetIssue(ctx, issue.Repo, issue.Number)
+	return current.Comments, err
+}
+
+// issueID returns the id mutations need for issue.
+func (l *linearSource) issueID(ctx context.Context, issue Issue) (string, error) {
+	l.mu.Lock()
+	id, ok := l.ids[issue.Number]
+	l.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+	if _, err := l.GetIssue(ctx, issue.Repo, issue.Number); err != nil {
+		return "", err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.ids[issue.Number], nil
+}
+
+// stateID resolves one of the team's workflow states by name.
+func (l *linearSource) stateID(ctx context.Context, name string) (string, error) {
+	l.mu.Lock()
+	states := l.states
+	l.mu.Unlock()
+	if states == nil {
+		var out struct {
+			WorkflowStates struct {
+				Nodes []struct {
+					ID   string `json:"id"`
+					Name string `json:"name"`
+				} `json:"nodes"`
+			} `json:"workflowStates"`
+		}
+		q := `query($team: String!) { workflowStates(filter: {team: {key: {eq: $team}}}, first: 100) { nodes { id name } } }`
+		if err := l.query(ctx, q, map[string]any{"team": l.team}, &out); err != nil {
+			return "", err
+		}
+		states = map[string]string{}
+		for _, s := range out.WorkflowStates.Nodes {
+			states[strings.ToLower(s.Name)] = s.ID
+		}
+		l.mu.Lock()
+		l.states = states
+		l.mu.Unlock()
+	}
+	id, ok := states[strings.ToLower(name)]
+	if !ok {
+		return "", fmt.Errorf("linear: team %s has no workflow state %q", l.team, name)
+	}
+	return id, nil
+}
+
+// labelID finds a label by name among the team's and the workspace's; ""
+// if there's none.
+func (l *linearSource) labelID(ctx context.Context, name string) (string, error) {
+	var out struct {
+		IssueLabels struct {
+			Nodes []struct {
+				ID   string `json:"id"`
+				Team *struct {
+					Key string `json:"key"`
+				} `json:"team"`
+			} `json:"nodes"`
+		} `json:"issueLabels"`
+	}
+	q := `query($name: String!) { issueLabels(filter: {name: {eqIgnoreCase: $name}}, first: 50) { nodes { id team { key } } } }`
+	if err := l.query(ctx, q, map[string]any{"name": name}, &out); err != nil {
+		return "", err
+	}
+	for _, n := range out.IssueLabels.Nodes {
+		if n.Team == nil || n.Team.Key == l.team {
+			return n.ID, nil
+		}
+	}
+	return "", nil
+}
+
+func (l *linearSource) AddLabel(ctx context.Context, issue Issue, label string) error {
+	id, err := l.issueID(ctx, issue)
+	if err != nil {
+		return err
+	}
+	if status := l.flow.status(label); status != "" {
+		state, err := l.stateID(ctx, status)
+		if err != nil {
+			return err
+		}
+		return l.mutate(ctx, "issueUpdate", `mutation($id: String!, $state: String!) { issueUpdate(id: $id, input: {stateId: $state}) { success } }`,
+			map[string]any{"id": id, "state": state})
+	}
+	labelID, err := l.labelID(ctx, label)
+	if err != nil {
+		return err
+	}
+	if labelID == "" {
+		return fmt.Errorf("linear: no label %q", label)
+	}
+	return l.mutate(ctx, "issueAddLabel", `mutation($id: String!, $label: String!) { issueAddLabel(id: $id, labelId: $label) { success } }`,
+		map[string]any{"id": id, "label": labelID})
+}
+
+func (l *linearSource) RemoveLabel(ctx context.Context, issue Issue, label string) error {
+	if l.flow.status(label) != "" {
+		return nil // replaced by the next state
+	}
+	id, err := l.issueID(ctx, issue)
+	if err != nil {
+		return err
+	}
+	labelID, err := l.labelID(ctx, label)
+	if err != nil || labelID == "" {
+		return err
+	}
+	return l.mutate(ctx, "issueRemoveLabel", `mutation($id: String!, $label: String!) { issueRemoveLabel(id: $id, labelId: $label) { success } }`,
+		map[string]any{"id": id, "label": labelID})
+}
+
+// Comment posts body without the bot's marker; the comment's author says it's
+// the bot's.
+func (l *linearSource) Comment(ctx context.Context, issue Issue, body string) error {
+	id, err := l.issueID(ctx, issue)
+	if err != nil {
+		return err
+	}
+	body = strings.TrimSpace(strings.ReplaceAll(body, botCommentMarker, ""))
+	return l.mutate(ctx, "commentCreate", `mutation($id: String!, $body: String!) { commentCreate(input: {issueId: $id, body: $body}) { success } }`,
+		map[string]any{"id": id, "body": body})
+}
+
+// EnsureLabel creates a team label, unless label is a workflow state.
+func (l *linearSource) EnsureLabel(ctx context.Context, _, name, color, desc string) (bool, error) {
+	if l.flow.status(name) != "" {
+		return false, nil
+	}
+	if id, err := l.labelID(ctx, name); err != nil || id != "" {
+		return false, err
+	}
+	var out struct {
+		Teams struct {
+			Nodes []struct {
+				ID string `json:"id"`
+			} `json:"nodes"`
+		} `json:"teams"`
+	}
+	if err := l.query(ctx, `query($team: String!) { teams(filter: {key: {eq: $team}}) { nodes { id } } }`, map[string]any{"team": l.team}, &out); err != nil {
+		return false, err
+	}
+	if len(out.Teams.Nodes) == 0 {
+		return false, fmt.Errorf("linear: no team %s", l.team)
+	}
+	err := l.mutate(ctx, "issueLabelCreate", `mutation($input: IssueLabelCreateInput!) { issueLabelCreate(input: $input) { success } }`,
+		map[string]any{"input": map[string]string{"name": name, "color": "#" + color, "description": desc, "teamId": out.Teams.Nodes[0].ID}})
+	return err == nil, err
+}
+
+// React does nothing: Linear's reactions are emoji, not GitHub's set.
+func (l *linearSource) React(context.Context, string, string, string) error { return nil }
+
+// Labeler finds who last added label, or moved the issue to its state, in the
+// issue's history.
+func (l *linearSource) Labeler(ctx context.Context, issue Issue, label string) (string, error) {
+	q := `query($id: String!, $after: String) { issue(id: $id) { history(first: 100, after: $after) {
+		nodes { createdAt actor { id } toState { name } addedLabels { name } } ` + linearPageFields + ` } } }`
+	vars := map[string]any{"id": l.identifier(issue.Number)}
+	status := l.flow.status(label)
+	labeler, at := "", ""
+	for {
+		var out struct {
+			Issue *struct {
+				History struct {
+					Nodes []struct {
+						CreatedAt string      `json:"createdAt"`
+						Actor     *linearUser `json:"actor"`
+						ToState   *struct {
+							Name string `json:"name"`
+						} `json:"toState"`
+						AddedLabels []struct {
+							Name string `json:"name"`
+						} `json:"addedLabels"`
+					} `json:"nodes"`
+					PageInfo linearPageInfo `json:"pageInfo"`
+				} `json:"history"`
+			} `json:"issue"`
+		}
+		if err := l.query(ctx, q, vars, &out); err != nil {
+			return "", err
+		}
+		if out.Issue == nil {
+			return "", nil
+		}
+		for _, h := range out.Issue.History.Nodes {
+			match := false
+			if status != "" {
+				match = h.ToState != nil && strings.EqualFold(h.ToState.Name, status)
+			} else {
+				for _, a := range h.AddedLabels {
+					match = match || strings.EqualFold(a.Name, label)
+				}
+			}
+			if match && h.Actor != nil && h.CreatedAt >= at {
+				labeler, at = h.Actor.ID, h.CreatedAt
+			}
+		}
+		if !out.Issue.History.PageInfo.HasNextPage {
+			return labeler, nil
+		}
+		vars["after"] = out.Issue.History.PageInfo.EndCursor
+	}
+}
+
+// LinkPR attaches the PR to the issue. Attachments are keyed by URL, so it's
+// idempotent.
+func (l *linearSource) LinkPR(ctx context.Context, issue Issue, url, title string) error {
+	id, err := l.issueID(ctx, issue)
+	if err != nil {
+		return err
+	}
+	return l.mutate(ctx, "attachmentLinkURL", `mutation($id: String!, $url: String!, $title: String) { attachmentLinkURL(issueId: $id, url: $url, title: $title) { success } }`,
+		map[string]any{"id": id, "url": url, "title": title})
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLinearSource(t *testing.T) {
+	var filters []string
+	var mutations []string // "op variables"
+	const issueJSON = `{"id": "uuid-42", "identifier": "ENG-42", "number": 42, "title": "Crash on save", "description": "It **crashes**.",
+		"url": "https://linear.app/acme/issue/ENG-42", "creator": {"id": "u-alice"}, "state": {"name": "Todo"},
+		"labels": {"nodes": [{"name": "bug"}]},
+		"comments": {"nodes": [
+			{"id": "c2", "body": "PR ready", "createdAt": "2026-03-01T10:00:00.000Z", "user": {"id": "u-bot"}},
+			{"id": "c1", "body": "/bot plan", "createdAt": "2026-03-01T09:00:00.000Z", "user": {"id": "u-bob"}}
+		]}}`
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/graphql" || r.Header.Get("Authorization") != "lin_api_secret" {
+			t.Errorf("%s %s, auth %q", r.Method, r.URL.Path, r.Header.Get("Authorization"))
+		}
+		var req struct {
+			Query     string         `json:"query"`
+			Variables map[string]any `json:"variables"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		vars, _ := json.Marshal(req.Variables)
+		reply := func(data string) { w.Write([]byte(`{"data": ` + data + `}`)) }
+		switch q := req.Query; {
+		case strings.HasPrefix(q, "mutation"):
+			op := strings.TrimSpace(strings.SplitN(strings.SplitN(q, "{", 2)[1], "(", 2)[0])
+			mutations = append(mutations, op+" "+string(vars))
+			reply(`{"` + op + `": {"success": true}}`)
+		case strings.Contains(q, "viewer"):
+			reply(`{"viewer": {"id": "u-bot"}}`)
+		case strings.Contains(q, "issues("):
+			if req.Variables["after"] == "c1" {
+				reply(`{"issues": {"nodes": [{"id": "uuid-43", "number": 43, "title": "Typo", "state": {"name": "Todo"}}], "pageInfo": {"hasNextPage": false}}}`)
+				return
+			}
+			filter, _ := json.Marshal(req.Variables["filter"])
+			filters = append(filters, string(filter))
+			reply(`{"issues": {"nodes": [` + issueJSON + `], "pageInfo": {"hasNextPage": true, "endCursor": "c1"}}}`)
+		case strings.Contains(q, "history"):
+			if req.Variables["after"] == "h1" {
+				reply(`{"issue": {"history": {"nodes": [
+					{"createdAt": "2026-03-01T09:00:00.000Z", "actor": {"id": "u-frank"}, "toState": {"name": "Todo"}}
+				], "pageInfo": {"hasNextPage": false}}}}`)
+				return
+			}
+			reply(`{"issue": {"history": {"nodes": [
+				{"createdAt": "2026-03-01T08:00:00.000Z", "actor": {"id": "u-carol"}, "toState": {"name": "Todo"}},
+				{"createdAt": "2026-03-01T08:30:00.000Z", "actor": {"id": "u-dave"}, "addedLabels": [{"name": "Bug"}]},
+				{"createdAt": "2026-03-01T07:00:00.000Z", "actor": {"id": "u-erin"}, "toState": {"name": "Todo"}}
+			], "pageInfo": {"hasNextPage": true, "endCursor": "h1"}}}}`)
+		case strings.Contains(q, "issue(id"):
+			if req.Variables["id"] != "ENG-42" {
+				reply(`{"issue": null}`)
+				return
+			}
+			reply(`{"issue": ` + issueJSON + `}`)
+		case strings.Contains(q, "workflowStates"):
+			reply(`{"workflowStates": {"nodes": [{"id": "st-todo", "name": "Todo"}, {"id": "st-prog", "name": "In Progress"}]}}`)
+		case strings.Contains(q, "issueLabels"):
+			if req.Variables["name"] == "bot:plan" {
+				reply(`{"issueLabels": {"nodes": [{"id": "lb-other", "team": {"key": "OPS"}}, {"id": "lb-plan", "team": {"key": "ENG"}}]}}`)
+				return
+			}
+			reply(`{"issueLabels": {"nodes": []}}`)
+		case strings.Contains(q, "teams("):
+			reply(`{"teams": {"nodes": [{"id": "team-eng"}]}}`)
+		default:
+			w.Write([]byte(`{"errors": [{"message": "unexpected query"}]}`))
+		}
+	}))
+	defer srv.Close()
+
+	t.Setenv("CB_LINEAR_API_KEY", "lin_api_secret")
+	cfg := Config{IssueLabel: "todo", WIPLabel: "in-progress", NeedsInfoLabel: "needs-info", PlanLabel: "bot:plan",
+		Transitions: labelNames{Issue: "Todo", WIP: "In Progress", NeedsInfo: "Triage"}}
+	s, err := newIssueSource("linear:ENG", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := s.(*linearSource)
+	l.api.base = srv.URL
+	repo := "acme/app"
+	useSource(t, repo, l)
+	ctx := context.Background()
+
+	issues, err := fetchIssues(ctx, repo, "todo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = fetchIssues(ctx, repo, "bot:plan")
+	_, _ = fetchIssues(ctx, repo, "")
+	wantFilters := []string{
+		`{"state":{"name":{"eqIgnoreCase":"Todo"}},"team":{"key":{"eq":"ENG"}}}`,
+		`{"labels":{"name":{"eqIgnoreCase":"bot:plan"}},"team":{"key":{"eq":"ENG"}}}`,
+		`{"state":{"type":{"nin":["completed","canceled"]}},"team":{"key":{"eq":"ENG"}}}`,
+	}
+	if !slices.Equal(filters, wantFilters) {
+		t.Errorf("filters =\n%s", strings.Join(filters, "\n"))
+	}
+	if len(issues) != 2 || issues[1].Number != 43 {
+		t.Fatalf("issues = %+v", issues)
+	}
+	issue := issues[0]
+	if issue.Number != 42 || issue.reference() != "ENG-42" || issue.Body != "It **crashes**." || issue.Author.Login != "u-alice" ||
+		!slices.Equal(issueLabels(issue), []string{"bug", "todo"}) {
+		t.Errorf("issue = %+v", issue)
+	}
+	if len(issue.Comments) != 2 || issue.Comments[0].ID != "c1" || isBotComment(issue.Comments[0]) || !isBotComment(issue.Comments[1]) {
+		t.Errorf("comments = %+v", issue.Comments)
+	}
+
+	_ = addLabel(ctx, issue, "in-progress")
+	_ = removeLabel(ctx, issue, "todo")
+	_ = addLabel(ctx, issue, "bot:plan")
+	_ = removeLabel(ctx, issue, "bot:plan")
+	if err := addLabel(ctx, issue, "needs-info"); err == nil || !strings.Contains(err.Error(), `no workflow state "Triage"`) {
+		t.Errorf("moving to a missing state: %v", err)
+	}
+	if err := addLabel(ctx, issue, "missing"); err == nil {
+		t.Error("adding a missing label should fail")
+	}
+	if err := ensurePRComment(ctx, issue, "https://github.com/acme/app/pull/9"); err != nil {
+		t.Fatal(err)
+	}
+	if made, err := l.EnsureLabel(ctx, repo, "ci-failed", "E99695", "failing CI"); !made || err != nil {
+		t.Errorf("EnsureLabel = %v, %v", made, err)
+	}
+	for _, name := range []string{"todo", "bot:plan"} {
+		if made, err := l.EnsureLabel(ctx, repo, name, "0E8A16", ""); made || err != nil {
+			t.Errorf("EnsureLabel(%s) = %v, %v", name, made, err)
+		}
+	}
+	want := []string{
+		`issueUpdate {"id":"uuid-42","state":"st-prog"}`,
+		`issueAddLabel {"id":"uuid-42","label":"lb-plan"}`,
+		`issueRemoveLabel {"id":"uuid-42","label":"lb-plan"}`,
+		`attachmentLinkURL {"id":"uuid-42","title":"fix: resolve ENG-42 — Crash on save","url":"https://github.com/acme/app/pull/9"}`,
+		`commentCreate {"body":"PR ready for review: https://github.com/acme/app/pull/9","id":"uuid-42"}`,
+		`issueLabelCreate {"input":{"color":"#E99695","description":"failing CI","name":"ci-failed","teamId":"team-eng"}}`,
+	}
+	if !slices.Equal(mutations, want) {
+		t.Errorf("mutations =\n%s\nwant\n%s", strings.Join(mutations, "\n"), strings.Join(want, "\n"))
+	}
+
+	for label, want := range map[string]string{"todo": "u-frank", "bug": "u-dave", "in-progress": ""} {
+		if got, err := l.Labeler(ctx, issue, label); err != nil || got != want {
+			t.Errorf("Labeler(%s) = %q, %v; want %q", label, got, err, want)
+		}
+	}
+	if _, err := l.GetIssue(ctx, repo, 7); err == nil {
+		t.Error("a missing issue should fail")
+	}
+	if _, err := newIssueSource("linear:", cfg); err == nil {
+		t.Error("a team is required")
+	}
+	if err := registerIssueSources(Config{Repos: []string{"acme/linear-rules"}, IssueSource: "linear:ENG", AllowedUsers: []string{"u-bob"}}); err == nil {
+		t.Error("labeler rules on Linear should fail")
+	}
+}
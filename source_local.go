@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Local issue source ---
+// Tasks for machines with no forge to talk to, and for tests that shouldn't
+// need one. "markdown:<dir>" reads every *.md file in dir:
+//
+//	---
+//	title: Login fails with a space in the password
+//	labels: [bug, bot:plan]
+//	status: todo
+//	---
+//	Steps to reproduce...
+//
+// "jsonl:<file>" reads one task per line:
+//
+//	{"request_id": "user-023", "title": "...", "body": "...", "labels": ["bug"], "status": "todo"}
+//
+// A task's number is its "number", else the first number in its id (the
+// file name, or "request_id" or "id"), else its line. Its status is one more
+// label; a task with neither gets the issue label, so a plain queue is all
+// work to do. The title defaults to the file name.
+//
+// The bot never edits the tasks. What it does goes to a status file per task,
+// <dir or file without extension>.status/<id>.json: the labels it set, its
+// comments, and "status", the label it added last (the job's state).
+// Changing a task's status or labels takes over from the status file, so
+// setting status: todo again retries it.
+
+type localSource struct {
+	mu         sync.Mutex
+	kind       string // markdown or jsonl
+	path       string
+	statusDir  string
+	issueLabel string
+}
+
+func newLocalSource(kind, path, issueLabel string) *localSource {
+	path = filepath.Clean(path)
+	return &localSource{
+		kind:       kind,
+		path:       path,
+		statusDir:  strings.TrimSuffix(path, filepath.Ext(path)) + ".status",
+		issueLabel: issueLabel,
+	}
+}
+
+// localTask is a task as written.
+type localTask struct {
+	ID     string
+	Number int
+	Title  string
+	Body   string
+	Author string
+	Labels []string // status included
+	URL    string
+}
+
+// localTaskFields are the front matter of a Markdown task, or a JSONL line.
+type localTaskFields struct {
+	ID        string     `json:"id"`
+	RequestID string     `json:"request_id"`
+	Number    int        `json:"number"`
+	Title     string     `json:"title"`
+	Body      string     `json:"body"`
+	Author    string     `json:"author"`
+	Labels    stringList `json:"labels"`
+	Status    string     `json:"status"`
+}
+
+// localStatus is what the bot has done about a task.
+type localStatus struct {
+	ID       string    `json:"id"`
+	Number   int       `json:"number"`
+	Status   string    `json:"status"`
+	Labels   []string  `json:"labels"`
+	Declared []string  `json:"declared"` // the task's own labels when this was written
+	Comments []Comment `json:"comments"`
+	Updated  time.Time `json:"updated"`
+}
+
+var firstNumber = regexp.MustCompile(`\d+`)
+
+func (f localTaskFields) task(id string, line int, issueLabel string) localTask {
+	t := localTask{ID: cmp.Or(f.RequestID, f.ID, id), Number: f.Number, Title: f.Title, Body: strings.TrimSpace(f.Body), Author: f.Author}
+	if t.Number == 0 {
+		t.Number, _ = strconv.Atoi(firstNumber.FindString(t.ID))
+	}
+	if t.Number == 0 {
+		t.Number = line
+	}
+	if t.ID == "" {
+		t.ID = strconv.Itoa(t.Number)
+	}
+	t.Title = cmp.Or(t.Title, t.ID)
+	t.Labels = slices.Clone(f.Labels)
+	if f.Status != "" && !slices.Contains(t.Labels, f.Status) {
+		t.Labels = append(t.Labels, f.Status)
+	}
+	if len(t.Labels) == 0 && issueLabel != "" {
+		t.Labels = []string{issueLabel}
+	}
+	return t
+}
+
+// parseMarkdownTask reads a task file: optional front matter between "---"
+// lines, then the body.
+func parseMarkdownTask(data []byte) (localTaskFields, error) {
+	var f localTaskFields
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	if rest, ok := strings.CutPrefix(text, "---\n"); ok {
+		front, body, found := strings.Cut("\n"+rest, "\n---\n")
+		if !found {
+			front, found = strings.CutSuffix("\n"+rest, "\n---")
+		}
+		if !found {
+			return f, fmt.Errorf("front matter isn't closed with ---")
+		}
+		m, err := parseYAML(front)
+		if err != nil {
+			return f, fmt.Errorf("front matter: %w", err)
+		}
+		js, err := json.Marshal(m)
+		if err != nil {
+			return f, err
+		}
+		if err := json.Unmarshal(js, &f); err != nil {
+			return f, fmt.Errorf("front matter: %w", err)
+		}
+		text = body
+	}
+	f.Body = text
+	return f, nil
+}
+
+// tasks reads all the tasks, in file or line order.
+func (s *localSource) tasks() ([]localTask, error) {
+	var tasks []localTask
+	switch s.kind {
+	case "markdown":
+		files, err := filepath.Glob(filepath.Join(s.path, "*.md"))
+		if err != nil {
+			return nil, err
+		}
+		for i, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			f, err := parseMarkdownTask(data)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", file, err)
+			}
+			tasks = append(tasks, f.task(strings.TrimSuffix(filepath.Base(file), ".md"), i+1, s.issueLabel))
+			tasks[len(tasks)-1].URL = "file://" + file
+		}
+	case "jsonl":
+		data, err := os.ReadFile(s.path)
+		if err != nil {
+			return nil, err
+		}
+		sc := bufio.NewScanner(bytes.NewReader(data))
+		sc.Buffer(nil, 16<<20)
+		for n := 1; sc.Scan(); n++ {
+			line := bytes.TrimSpace(sc.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			var f localTaskFields
+			if err := json.Unmarshal(line, &f); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", s.path, n, err)
+			}
+			t := f.task("", n, s.issueLabel)
+			t.URL = fmt.Sprintf("file://%s#L%d", s.path, n)
+			tasks = append(tasks, t)
+		}
+		if err := sc.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	seen := map[int]string{}
+	for _, t := range tasks {
+		if other, dup := seen[t.Number]; dup {
+			return nil, fmt.Errorf("%s: tasks %s and %s are both #%d", s.path, other, t.ID, t.Number)
+		}
+		seen[t.Number] = t.ID
+	}
+	return tasks, nil
+}
+
+func (s *localSource) statusFile(t localTask) string {
+	return filepath.Join(s.statusDir, cmp.Or(slugify(t.ID), strconv.Itoa(t.Number))+".json")
+}
+
+func (s *localSource) readStatus(t localTask) (localStatus, error) {
+	var st localStatus
+	data, err := os.ReadFile(s.statusFile(t))
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return st, err
+	}
+	if err := json.Unmarshal(data, &st); err != nil {
+		return st, fmt.Errorf("%s: %w", s.statusFile(t), err)
+	}
+	return st, nil
+}
+
+// labels returns t's current labels: the status file's, unless the task's
+// own have changed since it was written.
+func (st localStatus) labels(t localTask) []string {
+	if st.Updated.IsZero() || !slices.Equal(st.Declared, t.Labels) {
+		return slices.Clone(t.Labels)
+	}
+	return slices.Clone(st.Labels)
+}
+
+func (s *localSource) issue(repo string, t localTask) (Issue, error) {
+	st, err := s.readStatus(t)
+	if err != nil {
+		return Issue{}, err
+	}
+	issue := Issue{Number: t.Number, Ref: t.ID, Title: t.Title, Body: t.Body, Repo: repo, URL: t.URL, Comments: st.Comments}
+	issue.Author.Login = t.Author
+	for _, l := range st.labels(t) {
+		issue.Labels = append(issue.Labels, Label{Name: l})
+	}
+	return issue, nil
+}
+
+func (s *localSource) find(number int) (localTask, error) {
+	tasks, err := s.tasks()
+	if err != nil {
+		return localTask{}, err
+	}
+	for _, t := range tasks {
+		if t.Number == number {
+			return t, nil
+		}
+	}
+	return localTask{}, fmt.Errorf("no task #%d in %s", number, s.path)
+}
+
+// update changes a task's status file.
+func (s *localSource) update(number int, change func(st *localStatus, labels []string) []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, err := s.find(number)
+	if err != nil {
+		return err
+	}
+	st, err := s.readStatus(t)
+	if err != nil {
+		return err
+	}
+	st.Labels = change(&st, st.labels(t))
+	st.ID, st.Number, st.Declared, st.Updated = t.ID, t.Number, t.Labels, time.Now().UTC()
+
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.statusDir, 0755); err != nil {
+		return err
+	}
+	tmp := s.statusFile(t) + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.statusFile(t))
+}
+
+func (s *localSource) ListIssues(_ context.Context, repo, label string) ([]Issue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tasks, err := s.tasks()
+	if err != nil {
+		return nil, err
+	}
+	var issues []Issue
+	for _, t := range tasks {
+		issue, err := s.issue(repo, t)
+		if err != nil {
+			return nil, err
+		}
+		if label == "" || issue.hasLabel(label) {
+			issues = append(issues, issue)
+		}
+	}
+	return issues, nil
+}
+
+func (s *localSource) GetIssue(_ context.Context, repo string, number int) (Issue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, err := s.find(number)
+	if err != nil {
+		return Issue{}, err
+	}
+	return s.issue(repo, t)
+}
+
+func (s *localSource) Comments(ctx context.Context, issue Issue) ([]Comment, error) {
+	current, err := s.GetIssue(ctx, issue.Repo, issue.Number)
+	return current.Comments, err
+}
+
+func (s *localSource) AddLabel(_ context.Context, issue Issue, label string) error {
+	return s.update(issue.Number, func(st *localStatus, labels []string) []string {
+		st.Status = label
+		if slices.Contains(labels, label) {
+			return labels
+		}
+		return append(labels, label)
+	})
+}
+
+func (s *localSource) RemoveLabel(_ context.Context, issue Issue, label string) error {
+	return s.update(issue.Number, func(st *localStatus, labels []string) []string {
+		labels = slices.DeleteFunc(labels, func(l string) bool { return l == label })
+		if st.Status == label {
+			st.Status = ""
+			if len(labels) > 0 {
+				st.Status = labels[len(labels)-1]
+			}
+		}
+		return labels
+	})
+}
+
+func (s *localSource) Comment(_ context.Context, issue Issue, body string) error {
+	return s.update(issue.Number, func(st *localStatus, labels []string) []string {
+		c := Comment{ID: strconv.Itoa(len(st.Comments) + 1), Body: body, CreatedAt: time.Now().UTC().Format(time.RFC3339)}
+		c.Author.Login = localBotLogin
+		st.Comments = append(st.Comments, c)
+		return labels
+	})
+}
+
+// Labels need no creating, reactions have nowhere to go, and there's no
+// telling who edited the files (so labeler rules are refused at startup).
+func (s *localSource) EnsureLabel(context.Context, string, string, string, string) (bool, error) {
+	return false, nil
+}
+func (s *localSource) React(context.Context, string, string, string) error    { return nil }
+func (s *localSource) Labeler(context.Context, Issue, string) (string, error) { return "", nil }
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func issueLabels(issue Issue) []string {
+	var names []string
+	for _, l := range issue.Labels {
+		names = append(names, l.Name)
+	}
+	return names
+}
+
+func TestLocalSourceMarkdown(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "0042-login.md"), []byte("---\ntitle: Login fails\nlabels: [bug]\nstatus: todo\nauthor: alice\n---\nSpaces in passwords break it.\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "7-typo.md"), []byte("Fix the typo in the README.\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a task"), 0644)
+	s := newLocalSource("markdown", dir, "todo")
+
+	issues, err := s.ListIssues(ctx, "local:/srv/app.git", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues) != 2 {
+		t.Fatalf("issues = %+v", issues)
+	}
+	login, typo := issues[0], issues[1]
+	if login.Number != 42 || login.reference() != "0042-login" || login.Title != "Login fails" || login.Body != "Spaces in passwords break it." || login.Author.Login != "alice" ||
+		!slices.Equal(issueLabels(login), []string{"bug", "todo"}) || login.URL != "file://"+filepath.Join(dir, "0042-login.md") {
+		t.Errorf("login = %+v", login)
+	}
+	// No front matter: numbered by its name, and queued
+	if typo.Number != 7 || typo.Title != "7-typo" || !slices.Equal(issueLabels(typo), []string{"todo"}) {
+		t.Errorf("typo = %+v", typo)
+	}
+
+	// The bot's changes go to a status file, not the task
+	_ = s.AddLabel(ctx, login, "in-progress")
+	_ = s.RemoveLabel(ctx, login, "todo")
+	_ = s.Comment(ctx, login, "PR ready for review: file:///srv/app.git#issue-42")
+	if got, _ := s.ListIssues(ctx, "local:/srv/app.git", "todo"); len(got) != 1 || got[0].Number != 7 {
+		t.Errorf("todo issues = %+v", got)
+	}
+	got, err := s.GetIssue(ctx, "local:/srv/app.git", 42)
+	if err != nil || !slices.Equal(issueLabels(got), []string{"bug", "in-progress"}) || len(got.Comments) != 1 ||
+		got.Comments[0].Author.Login != localBotLogin || got.Comments[0].ID != "1" {
+		t.Errorf("after the bot's changes: %+v, %v", got, err)
+	}
+	var st localStatus
+	data, err := os.ReadFile(filepath.Join(dir+".status", "0042-login.json"))
+	if err != nil || json.Unmarshal(data, &st) != nil || st.Status != "in-progress" || st.ID != "0042-login" {
+		t.Errorf("status file = %s, %v", data, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "0042-login.md")); !strings.Contains(string(data), "status: todo") {
+		t.Error("the task was edited")
+	}
+
+	// Changing the task's labels takes over from the status file
+	os.WriteFile(filepath.Join(dir, "0042-login.md"), []byte("---\ntitle: Login fails\nlabels: [bug, urgent]\nstatus: todo\n---\nStill broken.\n"), 0644)
+	if got, _ := s.GetIssue(ctx, "", 42); !slices.Equal(issueLabels(got), []string{"bug", "urgent", "todo"}) || len(got.Comments) != 1 {
+		t.Errorf("after editing the task: %+v", got)
+	}
+
+	if _, err := s.GetIssue(ctx, "", 8); err == nil {
+		t.Error("a missing task should fail")
+	}
+	os.WriteFile(filepath.Join(dir, "42-dup.md"), []byte("duplicate"), 0644)
+	if _, err := s.ListIssues(ctx, "", ""); err == nil || !strings.Contains(err.Error(), "both #42") {
+		t.Errorf("duplicate numbers: %v", err)
+	}
+}
+
+func TestLocalSourceJSONL(t *testing.T) {
+	ctx := context.Background()
+	path := writeConfig(t, "requests.jsonl", `{"request_id": "user-023", "title": "Eval harness", "body": "Add claude-bot eval"}
+{"request_id": "user-024", "title": "Local source", "body": "Read tasks from files", "status": "done"}
+
+{"title": "Unnumbered", "labels": "bug"}
+`)
+	s := newLocalSource("jsonl", path, "todo")
+	issues, err := s.ListIssues(ctx, "local:/srv/app.git", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues) != 3 || issues[0].Number != 23 || issues[0].Body != "Add claude-bot eval" || !slices.Equal(issueLabels(issues[0]), []string{"todo"}) ||
+		!slices.Equal(issueLabels(issues[1]), []string{"done"}) || issues[2].Number != 4 || !slices.Equal(issueLabels(issues[2]), []string{"bug"}) {
+		t.Fatalf("issues = %+v", issues)
+	}
+	if err := s.AddLabel(ctx, issues[0], "in-progress"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(strings.TrimSuffix(path, ".jsonl")+".status", "user-023.json")); err != nil {
+		t.Errorf("status file: %v", err)
+	}
+}
+
+func useSource(t *testing.T, repo string, s IssueSource) {
+	sourcesMu.Lock()
+	sources[repo] = s
+	sourcesMu.Unlock()
+	t.Cleanup(func() {
+		sourcesMu.Lock()
+		delete(sources, repo)
+		sourcesMu.Unlock()
+	})
+}
+
+func TestLocalRepo(t *testing.T) {
+	ctx := context.Background()
+	clone := gitRepo(t, map[string]string{"README.md": "hello\n"})
+	bare := filepath.Join(t.TempDir(), "app.git")
+	if _, err := run(ctx, clone, "git", "clone", "-q", "--bare", ".", bare); err != nil {
+		t.Fatal(err)
+	}
+	repo := "local:" + bare
+	if r := parseRepo(repo); r.Kind != "local" || r.Path != bare || forgeFor(repo).CloneURL(repo) != bare {
+		t.Fatalf("parseRepo(%q) = %+v", repo, r)
+	}
+
+	tasks := t.TempDir()
+	os.WriteFile(filepath.Join(tasks, "3-greeting.md"), []byte("---\ntitle: Say goodbye too\n---\nThe README only says hello.\n"), 0644)
+	cfg := Config{
+		Repos:        []string{repo},
+		IssueLabel:   "todo",
+		WIPLabel:     "in-progress",
+		DoneLabel:    "done",
+		RepoDir:      t.TempDir(),
+		WorktreeDir:  t.TempDir(),
+		LogDir:       t.TempDir(),
+		CIWait:       time.Hour, // there's no CI to wait for
+		Reviews:      true,
+		IssueSource:  "markdown:" + tasks,
+		AgentTimeout: time.Minute,
+		Agent:        `shell:echo goodbye >> README.md`,
+	}
+	s, err := newIssueSource(cfg.IssueSource, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useSource(t, repo, s)
+	for _, bad := range []string{"markdown:", "trello:board"} {
+		if err := registerIssueSources(Config{Repos: []string{repo}, IssueSource: bad}); err == nil {
+			t.Errorf("issue source %q should fail", bad)
+		}
+	}
+	if err := registerIssueSources(Config{Repos: []string{repo}, IssueSource: cfg.IssueSource, MinPermission: permWrite}); err == nil {
+		t.Error("labeler rules on tasks in files should fail")
+	}
+	if err := registerIssueSources(Config{Repos: []string{repo}, MembersOnly: true}); err == nil {
+		t.Error("members_only without an issue source should fail")
+	}
+	if c := cfg.forRepo(repo); c.CIWait != 0 || c.Reviews {
+		t.Errorf("local repo config: ci_wait %s, reviews %v", c.CIWait, c.Reviews)
+	}
+
+	issues, err := fetchIssues(ctx, repo, "todo")
+	if err != nil || len(issues) != 1 {
+		t.Fatalf("fetchIssues = %+v, %v", issues, err)
+	}
+	// The forge knows nothing of the task, so the PR can't close it
+	if got := closingLine(issues[0]); got != "" {
+		t.Errorf("closing line for a task = %q", got)
+	}
+	if got := closingLine(Issue{Repo: "owner/repo", Number: 3}); got != "Closes #3\n\n" {
+		t.Errorf("closing line for a forge issue = %q", got)
+	}
+	if err := processIssue(ctx, cfg.forRepo(repo), newTracker(), 0, issues[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	// The result is a branch in the bare repo, and the task's status says so
+	branch := branchName(issues[0])
+	if out, err := run(ctx, "", "git", "--git-dir", bare, "show", branch+":README.md"); err != nil || out != "hello\ngoodbye\n" {
+		t.Errorf("README on %s = %q, %v", branch, out, err)
+	}
+	if out, _ := run(ctx, "", "git", "--git-dir", bare, "log", "-1", "--format=%s", branch); !strings.HasPrefix(out, "fix: resolve 3-greeting — ") {
+		t.Errorf("commit subject = %q", out)
+	}
+	done, err := sourceFor(repo).GetIssue(ctx, repo, 3)
+	if err != nil || !slices.Equal(issueLabels(done), []string{"done"}) || len(done.Comments) != 1 ||
+		!strings.Contains(done.Comments[0].Body, "file://"+bare+"#"+branch) {
+		t.Errorf("task after the job = %+v, %v", done, err)
+	}
+}
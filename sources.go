@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// --- Issue sources ---
+// A repo's issues normally live on its forge. With issue_source (per repo in
+// the config file, or CB_ISSUE_SOURCE for every repo) they come from
+// somewhere else, and the bot's labels and comments go back there, while the
+// code, branches and PRs stay where CB_REPOS says:
+//
+//	markdown:<dir>           a directory of Markdown task files (see source_local.go)
+//	jsonl:<file>             a JSONL queue of tasks, one per line
+//	jira:<host>/<project>    a Jira project's issues (see source_jira.go)
+//	linear:<team>            a Linear team's issues, by team key (see source_linear.go)
+//
+// Paths in the config file are relative to it. On Jira and Linear the bot's
+// state labels can be workflow statuses instead (transitions in the config
+// file): adding the label moves the issue to the status, and an issue in the
+// status carries the label. Labels without a status stay labels. Tracker
+// users are known by their account ids, which the forge can't check, so
+// policy rules and command users are refused for Jira and Linear repos.
+//
+// Whoever can write to a source is its business, so its text is checked like
+// the forge's: authors who aren't collaborators on the repo restrict the
+// agent's tools (see untrusted.go). Set members_only for a source only the
+// team can write to.
+
+var (
+	sourcesMu sync.Mutex
+	sources   = map[string]IssueSource{} // by repo spec, for repos with an issue_source
+)
+
+// sourceFor returns where repo's issues come from.
+func sourceFor(repo string) IssueSource {
+	sourcesMu.Lock()
+	s, ok := sources[repo]
+	sourcesMu.Unlock()
+	if ok {
+		return s
+	}
+	return forgeFor(repo)
+}
+
+// hasIssueSource reports whether repo's issues come from somewhere other than
+// its forge.
+func hasIssueSource(repo string) bool {
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+	_, ok := sources[repo]
+	return ok
+}
+
+// prLinker is implemented by sources that can link an issue to its PR beyond
+// the "PR ready for review" comment.
+type prLinker interface {
+	LinkPR(ctx context.Context, issue Issue, url, title string) error
+}
+
+// newIssueSource makes the source spec names, for a repo configured as cfg.
+func newIssueSource(spec string, cfg Config) (IssueSource, error) {
+	kind, arg, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "markdown", "jsonl":
+		if arg == "" {
+			return nil, fmt.Errorf("issue source %q: missing path", spec)
+		}
+		return newLocalSource(kind, arg, cfg.IssueLabel), nil
+	case "jira":
+		host, project, _ := strings.Cut(arg, "/")
+		if host == "" || project == "" || strings.Contains(project, "/") {
+			return nil, fmt.Errorf("issue source %q: want jira:<host>/<project key>", spec)
+		}
+		return newJiraSource(host, project, cfg.JQL, firstEnv("CB_JIRA_EMAIL", "JIRA_EMAIL"), firstEnv("CB_JIRA_TOKEN", "JIRA_API_TOKEN"), cfg.workflow()), nil
+	case "linear":
+		if arg == "" || strings.Contains(arg, "/") {
+			return nil, fmt.Errorf("issue source %q: want linear:<team key>", spec)
+		}
+		return newLinearSource(arg, firstEnv("CB_LINEAR_API_KEY", "LINEAR_API_KEY"), cfg.workflow()), nil
+	}
+	return nil, fmt.Errorf("unknown issue source %q (want markdown:<dir>, jsonl:<file>, jira:<host>/<project> or linear:<team>)", spec)
+}
+
+// workflow maps the bot's labels to a tracker's workflow statuses, in the
+// order labels win when two share a status.
+type workflow []struct{ label, status string }
+
+func (cfg Config) workflow() workflow {
+	var w workflow
+	for _, s := range []struct{ label, status string }{
+		{cfg.IssueLabel, cfg.Transitions.Issue},
+		{cfg.WIPLabel, cfg.Transitions.WIP},
+		{cfg.DoneLabel, cfg.Transitions.Done},
+		{cfg.NeedsInfoLabel, cfg.Transitions.NeedsInfo},
+		{cfg.FailedLabel, cfg.Transitions.Failed},
+		{cfg.CIFailedLabel, cfg.Transitions.CIFailed},
+		{cfg.TriageLabel, cfg.Transitions.Triage},
+		{cfg.NeedsReviewLabel, cfg.Transitions.NeedsReview},
+		{cfg.PlanLabel, cfg.Transitions.Plan},
+		{cfg.AwaitingApprovalLabel, cfg.Transitions.AwaitingApproval},
+	} {
+		if s.label != "" && s.status != "" {
+			w = append(w, s)
+		}
+	}
+	return w
+}
+
+// status returns the status label stands for, or "" if it's a plain label.
+func (w workflow) status(label string) string {
+	for _, s := range w {
+		if s.label == label {
+			return s.status
+		}
+	}
+	return ""
+}
+
+// label returns the label an issue in status carries, or "".
+func (w workflow) label(status string) string {
+	for _, s := range w {
+		if strings.EqualFold(s.status, status) {
+			return s.label
+		}
+	}
+	return ""
+}
+
+// registerIssueSources sets up the issue sources cfg's repos name.
+func registerIssueSources(cfg Config) error {
+	for _, repo := range cfg.Repos {
+		rc := cfg.forRepo(repo)
+		if rc.IssueSource == "" {
+			if rc.MembersOnly {
+				return fmt.Errorf("%s: members_only is for issue sources, and the repo has none", repo)
+			}
+			continue
+		}
+		s, err := newIssueSource(rc.IssueSource, rc)
+		if err != nil {
+			return fmt.Errorf("%s: %w", repo, err)
+		}
+		switch s.(type) {
+		case *localSource:
+			if rc.labelerRules() {
+				return fmt.Errorf("%s: tasks in files don't record who labeled them, so allowed_users, allowed_teams and min_permission can't apply", repo)
+			}
+		case *jiraSource, *linearSource:
+			// Tracker accounts aren't forge logins, and nothing maps one to the other
+			if rc.labelerRules() || len(rc.CommandUsers) > 0 || rc.OutsideApproval {
+				return fmt.Errorf("%s: %s accounts aren't forge users, so allowed_users, allowed_teams, min_permission, command_users and outside_approval can't apply", repo, rc.IssueSource)
+			}
+		}
+		sourcesMu.Lock()
+		sources[repo] = s
+		sourcesMu.Unlock()
+	}
+	return nil
+}
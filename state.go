@@ -0,0 +1,664 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// --- Tracker (in-flight dedup + job history) ---
+// The tracker dedups in-flight issues and records every job attempt. With a state
+// dir it persists to an append-only JSONL journal (journal.jsonl) that is folded
+// into a snapshot (snapshot.json) on startup and every compactEvery entries, so
+// retry counts and history survive restarts without asking GitHub.
+
+// Attempt is one run of the worker on an issue.
+type Attempt struct {
+	ID      string    `json:"id"` // run id
+	Key     string    `json:"key"`
+	Repo    string    `json:"repo"`
+	Number  int       `json:"number"`
+	Worker  int       `json:"worker"`
+	Branch  string    `json:"branch"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end,omitzero"`
+	Result  string    `json:"result,omitempty"` // see result* constants; "" while running
+	Error   string    `json:"error,omitempty"`
+	PRURL   string    `json:"pr_url,omitempty"`
+	LogPath string    `json:"log_path,omitempty"`
+	Usage   *Usage    `json:"usage,omitempty"` // as reported by the agent
+	Kind    string    `json:"kind,omitempty"`  // "" for work on the issue, kindReview for a follow-up on its PR
+}
+
+// Attempt results.
+const (
+	resultDone        = "done"
+	resultNeedsInfo   = "needs-info"
+	resultCIFailed    = "ci-failed" // PR opened, but its checks never went green
+	resultPlanned     = "planned"   // posted a plan (/bot plan or plan-first); nothing changed
+	resultError       = "error"
+	resultInterrupted = "interrupted" // shutdown or crash mid-run; doesn't count as a retry
+	resultCancelled   = "cancelled"   // cancelled by a user; doesn't count as a retry
+)
+
+// kindReview marks attempts that address review feedback on the bot's PR.
+const kindReview = "review"
+
+func (a Attempt) running() bool { return a.End.IsZero() }
+
+// journalEntry is one line of the journal: an attempt upsert, a retry reset,
+// an issue's new options, a handled /bot command, who was let queue an issue,
+// or what a /bot approve approved.
+type journalEntry struct {
+	Attempt  *Attempt      `json:"attempt,omitempty"`
+	Reset    string        `json:"reset,omitempty"` // issue key
+	Key      string        `json:"key,omitempty"`   // issue the options are for
+	Options  *issueOptions `json:"options,omitempty"`
+	Command  string        `json:"command,omitempty"` // commandID of the comment
+	Admit    string        `json:"admit,omitempty"`   // issue key, labeled by Labeler
+	Labeler  string        `json:"labeler,omitempty"`
+	Approve  string        `json:"approve,omitempty"` // issue key, approved as in Approval
+	Approval *approval     `json:"approval,omitempty"`
+	Time     time.Time     `json:"time"`
+}
+
+// approval is what a maintainer's /bot approve let through: the comment, and
+// a hash of the issue's title and body when the bot first saw it.
+type approval struct {
+	Comment string `json:"comment"`
+	Content string `json:"content"`
+}
+
+type snapshot struct {
+	Attempts  []*Attempt              `json:"attempts"`
+	Resets    map[string]time.Time    `json:"resets"`
+	Options   map[string]issueOptions `json:"options,omitempty"`
+	Commands  map[string]time.Time    `json:"commands,omitempty"`
+	Labelers  map[string]string       `json:"labelers,omitempty"`
+	Approvals map[string]approval     `json:"approvals,omitempty"`
+}
+
+const compactEvery = 1000
+
+type tracker struct {
+	mu       sync.Mutex
+	inflight map[string]bool
+	cancels  map[string]context.CancelCauseFunc // running jobs
+	attempts []*Attempt                         // oldest first
+	byID     map[string]*Attempt
+	resets   map[string]time.Time    // key → when its retry counter was last cleared
+	deferred map[string]time.Time    // key → budget window it's deferred until (not persisted)
+	opts     map[string]issueOptions // key → options set with /bot commands
+	commands map[string]time.Time    // commandID → when it was handled
+	labelers map[string]string       // key → who labeled it when the policy admitted it
+	approved map[string]approval     // key → its latest approval after injection screening
+
+	dir       string   // "" = in-memory only
+	journal   *os.File // append-only
+	journaled int      // entries since last compaction
+}
+
+// newTracker returns an in-memory tracker (no persistence).
+func newTracker() *tracker {
+	return &tracker{
+		inflight: make(map[string]bool),
+		cancels:  make(map[string]context.CancelCauseFunc),
+		byID:     make(map[string]*Attempt),
+		resets:   make(map[string]time.Time),
+		deferred: make(map[string]time.Time),
+		opts:     make(map[string]issueOptions),
+		commands: make(map[string]time.Time),
+		labelers: make(map[string]string),
+		approved: make(map[string]approval),
+	}
+}
+
+// loadTracker opens the state dir, replays snapshot + journal, and compacts them.
+// Idempotent: creates the dir and files if missing.
+func loadTracker(dir string) (*tracker, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	t, err := readTracker(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.compact(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// readTracker replays snapshot + journal without writing anything, for the CLI
+// commands that only look at the history: a missing dir is an empty history,
+// and since no journal is open, nothing recorded on t is persisted. It's safe
+// to run while the bot is using the same dir.
+func readTracker(dir string) (*tracker, error) {
+	t := newTracker()
+	t.dir = dir
+	data, err := os.ReadFile(filepath.Join(dir, "snapshot.json"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		var snap snapshot
+		if err := json.Unmarshal(data, &snap); err != nil {
+			return nil, fmt.Errorf("parsing snapshot: %w", err)
+		}
+		for _, a := range snap.Attempts {
+			t.apply(journalEntry{Attempt: a})
+		}
+		for key, at := range snap.Resets {
+			t.resets[key] = at
+		}
+		for key, o := range snap.Options {
+			t.opts[key] = o
+		}
+		for id, at := range snap.Commands {
+			t.commands[id] = at
+		}
+		for key, user := range snap.Labelers {
+			t.labelers[key] = user
+		}
+		for key, a := range snap.Approvals {
+			t.approved[key] = a
+		}
+	}
+
+	if err := t.replayJournal(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *tracker) replayJournal() error {
+	f, err := os.Open(filepath.Join(t.dir, "journal.jsonl"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			var e journalEntry
+			// A torn last line (crash mid-write) is skipped, not fatal.
+			if json.Unmarshal(line, &e) == nil {
+				t.apply(e)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// apply folds one entry into memory. Caller holds mu (or owns t exclusively).
+func (t *tracker) apply(e journalEntry) {
+	if e.Reset != "" {
+		t.resets[e.Reset] = e.Time
+	}
+	if e.Key != "" {
+		if e.Options == nil || *e.Options == (issueOptions{}) {
+			delete(t.opts, e.Key)
+		} else {
+			t.opts[e.Key] = *e.Options
+		}
+	}
+	if e.Command != "" {
+		t.commands[e.Command] = e.Time
+	}
+	if e.Admit != "" {
+		t.labelers[e.Admit] = e.Labeler
+	}
+	if e.Approve != "" && e.Approval != nil {
+		t.approved[e.Approve] = *e.Approval
+	}
+	if e.Attempt == nil {
+		return
+	}
+	a := *e.Attempt
+	if existing, ok := t.byID[a.ID]; ok {
+		*existing = a
+		return
+	}
+	t.attempts = append(t.attempts, &a)
+	t.byID[a.ID] = &a
+}
+
+// compact writes a fresh snapshot and truncates the journal. Handled commands
+// older than commandMaxAge are dropped: their comments are ignored anyway.
+func (t *tracker) compact() error {
+	for id, at := range t.commands {
+		if time.Since(at) > commandMaxAge {
+			delete(t.commands, id)
+		}
+	}
+	snap := snapshot{Attempts: t.attempts, Resets: t.resets, Options: t.opts, Commands: t.commands, Labelers: t.labelers, Approvals: t.approved}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(t.dir, "snapshot.json.tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(t.dir, "snapshot.json")); err != nil {
+		return err
+	}
+
+	if t.journal != nil {
+		t.journal.Close()
+	}
+	t.journal, err = os.OpenFile(filepath.Join(t.dir, "journal.jsonl"), os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0644)
+	t.journaled = 0
+	return err
+}
+
+// record applies an entry and appends it to the journal. Caller holds mu.
+// Persistence errors are returned but memory is always updated.
+func (t *tracker) record(e journalEntry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	t.apply(e)
+	if t.journal == nil {
+		return nil
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := t.journal.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if t.journaled++; t.journaled >= compactEvery {
+		return t.compact()
+	}
+	return nil
+}
+
+// --- In-flight dedup ---
+
+func (t *tracker) tryAcquire(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.inflight[key] {
+		return false
+	}
+	t.inflight[key] = true
+	return true
+}
+
+func (t *tracker) release(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.inflight, key)
+	delete(t.cancels, key)
+}
+
+// inflightKeys returns the keys of queued and running jobs, sorted.
+func (t *tracker) inflightKeys() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	keys := make([]string, 0, len(t.inflight))
+	for k := range t.inflight {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// errJobCancelled is the cancel cause for jobs stopped on purpose.
+var errJobCancelled = errors.New("job cancelled")
+
+func jobCancelled(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errJobCancelled)
+}
+
+// setCancel registers the cancel func of a running job.
+func (t *tracker) setCancel(key string, cancel context.CancelCauseFunc) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cancels[key] = cancel
+}
+
+// cancel stops a running job. Returns false if key isn't running.
+func (t *tracker) cancel(key string) bool {
+	t.mu.Lock()
+	cancel, ok := t.cancels[key]
+	t.mu.Unlock()
+	if ok {
+		cancel(errJobCancelled)
+	}
+	return ok
+}
+
+// --- Attempts ---
+
+// begin records the start of an attempt and returns it with its run id set.
+func (t *tracker) begin(a Attempt) (Attempt, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	a.ID = newRunID()
+	a.Start = time.Now().UTC()
+	return a, t.record(journalEntry{Attempt: &a})
+}
+
+// finish records how an attempt ended.
+func (t *tracker) finish(id, result string, runErr error, prURL string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	existing, ok := t.byID[id]
+	if !ok {
+		return fmt.Errorf("unknown attempt %s", id)
+	}
+	a := *existing
+	a.End = time.Now().UTC()
+	a.Result = result
+	a.PRURL = prURL
+	if runErr != nil {
+		a.Error = runErr.Error()
+	}
+	return t.record(journalEntry{Attempt: &a})
+}
+
+// setBranch records the branch a running attempt settled on (after the
+// target repo's branch_prefix is known).
+func (t *tracker) setBranch(id, branch string) error {
+	return t.update(id, func(a *Attempt) { a.Branch = branch })
+}
+
+// setUsage records what the agent reported for a running attempt.
+func (t *tracker) setUsage(id string, u Usage) error {
+	return t.update(id, func(a *Attempt) { a.Usage = &u })
+}
+
+// addUsage adds another agent run's usage to a running attempt.
+func (t *tracker) addUsage(id string, u Usage) error {
+	return t.update(id, func(a *Attempt) {
+		total := u
+		if a.Usage != nil {
+			total = *a.Usage
+			total.add(u)
+		}
+		a.Usage = &total
+	})
+}
+
+// update records a change to a running attempt.
+func (t *tracker) update(id string, change func(*Attempt)) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	existing, ok := t.byID[id]
+	if !ok {
+		return fmt.Errorf("unknown attempt %s", id)
+	}
+	a := *existing
+	change(&a)
+	return t.record(journalEntry{Attempt: &a})
+}
+
+// history returns all attempts for an issue key, oldest first.
+func (t *tracker) history(key string) []Attempt {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var out []Attempt
+	for _, a := range t.attempts {
+		if a.Key == key {
+			out = append(out, *a)
+		}
+	}
+	return out
+}
+
+// failures counts failed attempts since the issue's retry counter was last reset.
+// ok is false if the tracker has never seen the issue, so callers can fall back
+// to counting error comments for issues that predate the state store. A reset
+// counts as seeing it, so the old comments stop counting too.
+// Review follow-ups don't count; see reviewFailures.
+func (t *tracker) failures(key string) (n int, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	since, ok := t.resets[key]
+	for _, a := range t.attempts {
+		if a.Key != key || a.Kind != "" {
+			continue
+		}
+		ok = true
+		if a.Result == resultError && a.Start.After(since) {
+			n++
+		}
+	}
+	return n, ok
+}
+
+// reviewFailures counts failed review follow-ups on key started after since.
+func (t *tracker) reviewFailures(key string, since time.Time) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := 0
+	for _, a := range t.attempts {
+		if a.Key == key && a.Kind == kindReview && a.Result == resultError && a.Start.After(since) {
+			n++
+		}
+	}
+	return n
+}
+
+// resetRetries clears an issue's retry counter.
+func (t *tracker) resetRetries(key string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.record(journalEntry{Reset: key})
+}
+
+// options returns the settings changed for an issue with /bot commands.
+func (t *tracker) options(key string) issueOptions {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.opts[key]
+}
+
+// setOptions replaces an issue's options; the zero value clears them.
+func (t *tracker) setOptions(key string, o issueOptions) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.record(journalEntry{Key: key, Options: &o})
+}
+
+// commandHandled reports whether the command comment id was acted on.
+func (t *tracker) commandHandled(id string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.commands[id]
+	return ok
+}
+
+// markCommandHandled records that the command comment id was acted on.
+func (t *tracker) markCommandHandled(id string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.record(journalEntry{Command: id})
+}
+
+// labeler returns who labeled the issue when the policy last admitted it.
+func (t *tracker) labeler(key string) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	user, ok := t.labelers[key]
+	return user, ok
+}
+
+// setLabeler records that user's label got the issue admitted. Only changes
+// are journaled, since the policy runs on every poll.
+func (t *tracker) setLabeler(key, user string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if prev, ok := t.labelers[key]; ok && prev == user {
+		return nil
+	}
+	return t.record(journalEntry{Admit: key, Labeler: user})
+}
+
+// approval returns the issue's latest recorded approval.
+func (t *tracker) approval(key string) (approval, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	a, ok := t.approved[key]
+	return a, ok
+}
+
+// setApproval records what an approval let through, if it's news.
+func (t *tracker) setApproval(key string, a approval) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if prev, ok := t.approved[key]; ok && prev == a {
+		return nil
+	}
+	return t.record(journalEntry{Approve: key, Approval: &a})
+}
+
+// running returns attempts that haven't finished yet.
+func (t *tracker) running() []Attempt {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var out []Attempt
+	for _, a := range t.attempts {
+		if a.running() {
+			out = append(out, *a)
+		}
+	}
+	return out
+}
+
+// recent returns up to n finished attempts, newest first.
+func (t *tracker) recent(n int) []Attempt {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var out []Attempt
+	for i := len(t.attempts) - 1; i >= 0 && len(out) < n; i-- {
+		if !t.attempts[i].running() {
+			out = append(out, *t.attempts[i])
+		}
+	}
+	return out
+}
+
+// lastAttempt returns the most recent attempt for key.
+func (t *tracker) lastAttempt(key string) (Attempt, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := len(t.attempts) - 1; i >= 0; i-- {
+		if t.attempts[i].Key == key {
+			return *t.attempts[i], true
+		}
+	}
+	return Attempt{}, false
+}
+
+// markInterrupted closes attempts left running by a previous process.
+// Called on startup before workers start. Returns the closed attempts.
+func (t *tracker) markInterrupted() []Attempt {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var closed []Attempt
+	for _, existing := range t.attempts {
+		if !existing.running() {
+			continue
+		}
+		a := *existing
+		a.End = time.Now().UTC()
+		a.Result = resultInterrupted
+		_ = t.record(journalEntry{Attempt: &a})
+		closed = append(closed, a)
+	}
+	return closed
+}
+
+// close flushes the journal.
+func (t *tracker) close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.journal == nil {
+		return nil
+	}
+	err := t.journal.Close()
+	t.journal = nil
+	return err
+}
+
+func newRunID() string {
+	b := make([]byte, 6)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// --- History CLI ---
+
+// printHistory answers "what happened to issue #42" from local state alone.
+func printHistory(t *tracker, key string, w io.Writer) {
+	attempts := t.history(key)
+	if len(attempts) == 0 {
+		fmt.Fprintf(w, "no attempts recorded for %s\n", key)
+		return
+	}
+	sort.SliceStable(attempts, func(i, j int) bool { return attempts[i].Start.Before(attempts[j].Start) })
+
+	fmt.Fprintf(w, "%s: %d attempt(s)\n", key, len(attempts))
+	for _, a := range attempts {
+		result, took := a.Result, ""
+		if a.running() {
+			result = "running"
+		} else {
+			took = a.End.Sub(a.Start).Round(time.Second).String()
+		}
+		if a.Kind != "" {
+			result = a.Kind + " " + result
+		}
+		fmt.Fprintf(w, "\n  run %s  worker-%d  %s  %s %s\n", a.ID, a.Worker, a.Start.Local().Format(time.DateTime), result, took)
+		fmt.Fprintf(w, "    branch: %s\n", a.Branch)
+		if a.PRURL != "" {
+			fmt.Fprintf(w, "    pr:     %s\n", a.PRURL)
+		}
+		if a.Error != "" {
+			fmt.Fprintf(w, "    error:  %s\n", firstLine(a.Error))
+		}
+		if a.Usage != nil {
+			fmt.Fprintf(w, "    usage:  %s\n", a.Usage.summary())
+		}
+		if a.LogPath != "" {
+			fmt.Fprintf(w, "    log:    %s\n", a.LogPath)
+		}
+	}
+	if n, _ := t.failures(key); n > 0 {
+		fmt.Fprintf(w, "\n  failures counting toward retry limit: %d\n", n)
+	}
+}
+
+func firstLine(s string) string {
+	for i, c := range s {
+		if c == '\n' {
+			return s[:i]
+		}
+	}
+	return s
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTrackerPersistence(t *testing.T) {
+	dir := t.TempDir()
+	tr, err := loadTracker(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, _ := tr.begin(Attempt{Key: "owner/repo#42", Worker: 1, Branch: "issue-42-fix"})
+	tr.finish(first.ID, resultError, errors.New("agent exited with error"), "")
+	second, _ := tr.begin(Attempt{Key: "owner/repo#42", Worker: 2, Branch: "issue-42-fix"})
+	tr.finish(second.ID, resultDone, nil, "https://github.com/owner/repo/pull/7")
+	running, _ := tr.begin(Attempt{Key: "owner/repo#43", Worker: 0})
+	tr.close()
+
+	// Reload: everything comes back from the journal
+	tr, err = loadTracker(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.close()
+
+	hist := tr.history("owner/repo#42")
+	if len(hist) != 2 {
+		t.Fatalf("history = %+v", hist)
+	}
+	if hist[1].PRURL != "https://github.com/owner/repo/pull/7" || hist[1].Worker != 2 {
+		t.Errorf("second attempt = %+v", hist[1])
+	}
+	if n, ok := tr.failures("owner/repo#42"); !ok || n != 1 {
+		t.Errorf("failures = %d, %v", n, ok)
+	}
+	if _, ok := tr.failures("owner/repo#99"); ok {
+		t.Error("unseen issue should report ok=false")
+	}
+
+	interrupted := tr.markInterrupted()
+	if len(interrupted) != 1 || interrupted[0].ID != running.ID {
+		t.Fatalf("interrupted = %+v", interrupted)
+	}
+	if last, _ := tr.lastAttempt("owner/repo#43"); last.Result != resultInterrupted {
+		t.Errorf("last result = %q", last.Result)
+	}
+
+	tr.resetRetries("owner/repo#42")
+	if n, _ := tr.failures("owner/repo#42"); n != 0 {
+		t.Errorf("failures after reset = %d", n)
+	}
+}
+
+func TestTrackerOptionsAndCommands(t *testing.T) {
+	dir := t.TempDir()
+	tr, err := loadTracker(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.setOptions("owner/repo#1", issueOptions{Model: "opus", MaxTurns: 80})
+	tr.setOptions("owner/repo#2", issueOptions{Plan: true})
+	tr.setOptions("owner/repo#2", issueOptions{})
+	tr.markCommandHandled("owner/repo:IC_1")
+	tr.setLabeler("owner/repo#1", "bob")
+	tr.setApproval("owner/repo#1", approval{Comment: "21", Content: "abc"})
+	tr.close()
+
+	// Once from the journal, then again from the snapshot it was compacted into
+	for range 2 {
+		tr, err = loadTracker(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if o := tr.options("owner/repo#1"); o != (issueOptions{Model: "opus", MaxTurns: 80}) {
+			t.Errorf("options = %+v", o)
+		}
+		if _, ok := tr.opts["owner/repo#2"]; ok {
+			t.Error("cleared options should be dropped")
+		}
+		if !tr.commandHandled("owner/repo:IC_1") || tr.commandHandled("owner/repo:IC_2") {
+			t.Error("handled commands not restored")
+		}
+		if who, ok := tr.labeler("owner/repo#1"); !ok || who != "bob" {
+			t.Errorf("labeler = %q, %v", who, ok)
+		}
+		if a, ok := tr.approval("owner/repo#1"); !ok || a != (approval{Comment: "21", Content: "abc"}) {
+			t.Errorf("approval = %+v, %v", a, ok)
+		}
+		tr.close()
+	}
+
+	// Handled commands are forgotten once their comments are too old to act on
+	old := commandMaxAge
+	commandMaxAge = 0
+	t.Cleanup(func() { commandMaxAge = old })
+	tr, _ = loadTracker(dir)
+	defer tr.close()
+	if tr.commandHandled("owner/repo:IC_1") {
+		t.Error("stale command should be pruned on compaction")
+	}
+}
+
+func TestTrackerTornJournal(t *testing.T) {
+	dir := t.TempDir()
+	tr, _ := loadTracker(dir)
+	a, _ := tr.begin(Attempt{Key: "owner/repo#1"})
+	tr.close()
+
+	// Simulate a crash mid-write
+	f, _ := os.OpenFile(filepath.Join(dir, "journal.jsonl"), os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString(`{"attempt":{"id":"trunc`)
+	f.Close()
+
+	tr, err := loadTracker(dir)
+	if err != nil {
+		t.Fatalf("torn journal should load: %v", err)
+	}
+	defer tr.close()
+	if last, ok := tr.lastAttempt("owner/repo#1"); !ok || last.ID != a.ID {
+		t.Errorf("lastAttempt = %+v, %v", last, ok)
+	}
+}
+
+func TestReadTracker(t *testing.T) {
+	dir := t.TempDir()
+	tr, _ := loadTracker(dir)
+	a, _ := tr.begin(Attempt{Key: "owner/repo#1"})
+	// The bot is still running: its journal is open and not compacted
+	defer tr.close()
+	journal, _ := os.ReadFile(filepath.Join(dir, "journal.jsonl"))
+	snap, _ := os.ReadFile(filepath.Join(dir, "snapshot.json"))
+
+	ro, err := readTracker(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last, ok := ro.lastAttempt("owner/repo#1"); !ok || last.ID != a.ID {
+		t.Errorf("lastAttempt = %+v, %v", last, ok)
+	}
+	ro.begin(Attempt{Key: "owner/repo#2"})
+	ro.close()
+	if got, _ := os.ReadFile(filepath.Join(dir, "journal.jsonl")); string(got) != string(journal) {
+		t.Errorf("journal changed: %s", got)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "snapshot.json")); string(got) != string(snap) {
+		t.Errorf("snapshot changed: %s", got)
+	}
+
+	missing := filepath.Join(dir, "missing")
+	if ro, err := readTracker(missing); err != nil || len(ro.attempts) != 0 {
+		t.Errorf("missing state dir: %+v, %v", ro, err)
+	}
+	if _, err := os.Stat(missing); !errors.Is(err, os.ErrNotExist) {
+		t.Error("reading the state created its dir")
+	}
+}
+
+func TestRetryCount(t *testing.T) {
+	tr := newTracker()
+	issue := Issue{Repo: "owner/repo", Number: 5, Comments: []Comment{
+		{Body: "claude-bot encountered an error:\n```\nx\n```"},
+	}}
+	// No local history: fall back to error comments
+	if got := retryCount(tr, issue); got != 1 {
+		t.Errorf("retryCount without history = %d", got)
+	}
+
+	a, _ := tr.begin(Attempt{Key: issue.key()})
+	tr.finish(a.ID, resultInterrupted, nil, "")
+	if got := retryCount(tr, issue); got != 0 {
+		t.Errorf("interrupted attempts shouldn't count, got %d", got)
+	}
+}
+
+func TestPrintHistory(t *testing.T) {
+	tr := newTracker()
+	a, _ := tr.begin(Attempt{Key: "owner/repo#42", Branch: "issue-42-fix", LogPath: "/logs/owner-repo-42.log"})
+	tr.finish(a.ID, resultError, errors.New("pushing: rejected\nmore detail"), "")
+
+	var b strings.Builder
+	printHistory(tr, "owner/repo#42", &b)
+	out := b.String()
+	for _, want := range []string{"1 attempt(s)", a.ID, "issue-42-fix", "pushing: rejected", "/logs/owner-repo-42.log", "retry limit: 1"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("history output missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "more detail") {
+		t.Error("only the first error line should be shown")
+	}
+}
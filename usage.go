@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// --- Usage ---
+// The Claude CLI runs with --output-format json and ends with a result record:
+// turns, token counts, cost, session id and how the run stopped. It's kept on
+// the attempt, summarized in the PR body, added to the claudebot_agent_*
+// counters, and totalled per repo by --usage and the dashboard.
+
+// Usage is what an agent reported about one run.
+type Usage struct {
+	Turns            int     `json:"turns"`
+	InputTokens      int     `json:"input_tokens"`
+	OutputTokens     int     `json:"output_tokens"`
+	CacheReadTokens  int     `json:"cache_read_tokens,omitempty"`
+	CacheWriteTokens int     `json:"cache_write_tokens,omitempty"`
+	CostUSD          float64 `json:"cost_usd"`
+	SessionID        string  `json:"session_id,omitempty"`
+	StopReason       string  `json:"stop_reason,omitempty"`
+}
+
+// claudeResult is the CLI's final record (the whole output with
+// --output-format json, the last line with stream-json).
+type claudeResult struct {
+	Type       string  `json:"type"`
+	Subtype    string  `json:"subtype"`
+	IsError    bool    `json:"is_error"`
+	Result     string  `json:"result"`
+	NumTurns   int     `json:"num_turns"`
+	SessionID  string  `json:"session_id"`
+	CostUSD    float64 `json:"total_cost_usd"`
+	StopReason string  `json:"stop_reason"`
+	Usage      struct {
+		InputTokens              int `json:"input_tokens"`
+		OutputTokens             int `json:"output_tokens"`
+		CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+		CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+	} `json:"usage"`
+}
+
+// parseClaudeResult finds the result record in the CLI's output and returns
+// the reply text and usage.
+func parseClaudeResult(out []byte) (string, Usage, error) {
+	lines := bytes.Split(bytes.TrimSpace(out), []byte("\n"))
+	for i := len(lines) - 1; i >= 0; i-- {
+		var r claudeResult
+		if json.Unmarshal(lines[i], &r) != nil || r.Type != "result" {
+			continue
+		}
+		stop := r.StopReason
+		if stop == "" {
+			stop = r.Subtype // success, error_max_turns, error_during_execution
+		}
+		return r.Result, Usage{
+			Turns:            r.NumTurns,
+			InputTokens:      r.Usage.InputTokens,
+			OutputTokens:     r.Usage.OutputTokens,
+			CacheReadTokens:  r.Usage.CacheReadInputTokens,
+			CacheWriteTokens: r.Usage.CacheCreationInputTokens,
+			CostUSD:          r.CostUSD,
+			SessionID:        r.SessionID,
+			StopReason:       stop,
+		}, nil
+	}
+	return "", Usage{}, fmt.Errorf("no result record in agent output")
+}
+
+// add sums o's counts into u; the session and stop reason are per run and
+// aren't carried over.
+func (u *Usage) add(o Usage) {
+	u.Turns += o.Turns
+	u.InputTokens += o.InputTokens
+	u.OutputTokens += o.OutputTokens
+	u.CacheReadTokens += o.CacheReadTokens
+	u.CacheWriteTokens += o.CacheWriteTokens
+	u.CostUSD += o.CostUSD
+}
+
+// summary is a one-line description, e.g.
+// "12 turns, 45210 input + 3100 output tokens (120000 cached), $0.42".
+func (u Usage) summary() string {
+	s := fmt.Sprintf("%d turns, %d input + %d output tokens", u.Turns, u.InputTokens, u.OutputTokens)
+	if cached := u.CacheReadTokens + u.CacheWriteTokens; cached > 0 {
+		s += fmt.Sprintf(" (%d cached)", cached)
+	}
+	return s + fmt.Sprintf(", $%.2f", u.CostUSD)
+}
+
+// recordUsage adds a run's usage to the per-repo counters.
+func recordUsage(repo string, u Usage) {
+	agentCost.add(u.CostUSD, repo)
+	agentTurns.add(float64(u.Turns), repo)
+	agentTokens.add(float64(u.InputTokens), repo, "input")
+	agentTokens.add(float64(u.OutputTokens), repo, "output")
+	agentTokens.add(float64(u.CacheReadTokens), repo, "cache_read")
+	agentTokens.add(float64(u.CacheWriteTokens), repo, "cache_write")
+}
+
+// usageTotal is the summed usage of a repo's (or an issue's) runs.
+type usageTotal struct {
+	Name  string `json:"name"` // repo or issue key
+	Runs  int    `json:"runs"`
+	Usage Usage  `json:"usage"`
+}
+
+// usageTotals sums recorded usage by key(attempt), sorted by name.
+// Attempts without usage (non-Claude agents, runs that never started the
+// agent) are skipped.
+func (t *tracker) usageTotals(key func(Attempt) string) []usageTotal {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	byName := map[string]*usageTotal{}
+	for _, a := range t.attempts {
+		if a.Usage == nil {
+			continue
+		}
+		name := key(*a)
+		if name == "" {
+			continue
+		}
+		tot, ok := byName[name]
+		if !ok {
+			tot = &usageTotal{Name: name}
+			byName[name] = tot
+		}
+		tot.Runs++
+		tot.Usage.add(*a.Usage)
+	}
+	out := make([]usageTotal, 0, len(byName))
+	for _, tot := range byName {
+		out = append(out, *tot)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func (t *tracker) usageByRepo() []usageTotal {
+	return t.usageTotals(func(a Attempt) string { return a.Repo })
+}
+
+// printUsageReport writes per-repo totals, or with repo set, that repo's
+// per-issue totals (for claude-bot --usage [owner/repo]).
+func printUsageReport(t *tracker, repo string, w io.Writer) {
+	totals := t.usageByRepo()
+	if repo != "" {
+		totals = t.usageTotals(func(a Attempt) string {
+			if a.Repo != repo {
+				return ""
+			}
+			return a.Key
+		})
+	}
+	if len(totals) == 0 {
+		fmt.Fprintln(w, "no usage recorded")
+		return
+	}
+	var all Usage
+	runs := 0
+	for _, tot := range totals {
+		fmt.Fprintf(w, "%-40s %4d run(s)  %s\n", tot.Name, tot.Runs, tot.Usage.summary())
+		all.add(tot.Usage)
+		runs += tot.Runs
+	}
+	fmt.Fprintf(w, "%s\n%-40s %4d run(s)  %s\n", strings.Repeat("-", 40), "total", runs, all.summary())
+}
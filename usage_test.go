@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testClaudeResult = `{"type":"result","subtype":"success","is_error":false,"duration_ms":61234,"num_turns":12,` +
+	`"result":"Fixed the login redirect.","session_id":"5f0c9b1e","total_cost_usd":0.4213,` +
+	`"usage":{"input_tokens":45210,"output_tokens":3100,"cache_creation_input_tokens":20000,"cache_read_input_tokens":100000}}`
+
+func TestParseClaudeResult(t *testing.T) {
+	reply, u, err := parseClaudeResult([]byte(testClaudeResult + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Usage{Turns: 12, InputTokens: 45210, OutputTokens: 3100, CacheReadTokens: 100000, CacheWriteTokens: 20000,
+		CostUSD: 0.4213, SessionID: "5f0c9b1e", StopReason: "success"}
+	if reply != "Fixed the login redirect." || u != want {
+		t.Errorf("got %q, %+v", reply, u)
+	}
+
+	// stream-json: the result is the last of many records
+	stream := `{"type":"system","subtype":"init","session_id":"abc"}` + "\n" +
+		`{"type":"assistant","message":{"content":[]}}` + "\n" +
+		`{"type":"result","subtype":"error_max_turns","is_error":true,"num_turns":50,"session_id":"abc","total_cost_usd":1.5}`
+	if _, u, err := parseClaudeResult([]byte(stream)); err != nil || u.StopReason != "error_max_turns" || u.Turns != 50 {
+		t.Errorf("stream = %+v, %v", u, err)
+	}
+
+	if _, _, err := parseClaudeResult([]byte("plain text output\n")); err == nil {
+		t.Error("text output has no result record")
+	}
+}
+
+func TestUsageSummary(t *testing.T) {
+	u := Usage{Turns: 12, InputTokens: 45210, OutputTokens: 3100, CacheReadTokens: 100000, CostUSD: 0.4213}
+	if got, want := u.summary(), "12 turns, 45210 input + 3100 output tokens (100000 cached), $0.42"; got != want {
+		t.Errorf("summary = %q, want %q", got, want)
+	}
+}
+
+func TestUsageTotals(t *testing.T) {
+	dir := t.TempDir()
+	tr, err := loadTracker(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, run := range []struct {
+		key, repo string
+		usage     *Usage
+	}{
+		{"owner/app#1", "owner/app", &Usage{Turns: 10, InputTokens: 1000, CostUSD: 0.25}},
+		{"owner/app#1", "owner/app", &Usage{Turns: 5, InputTokens: 500, CostUSD: 0.10}},
+		{"owner/app#2", "owner/app", &Usage{Turns: 1, CostUSD: 0.05}},
+		{"owner/lib#7", "owner/lib", &Usage{Turns: 3, CostUSD: 1}},
+		{"owner/lib#8", "owner/lib", nil}, // a shell agent: nothing reported
+	} {
+		a, _ := tr.begin(Attempt{Key: run.key, Repo: run.repo})
+		if run.usage != nil {
+			tr.setUsage(a.ID, *run.usage)
+		}
+		tr.finish(a.ID, resultDone, nil, "")
+	}
+	tr.close()
+
+	// Usage survives a restart
+	tr, err = loadTracker(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.close()
+
+	totals := tr.usageByRepo()
+	if len(totals) != 2 || totals[0].Name != "owner/app" || totals[0].Runs != 3 || totals[0].Usage.Turns != 16 || totals[1].Runs != 1 {
+		t.Fatalf("totals = %+v", totals)
+	}
+
+	var b strings.Builder
+	printUsageReport(tr, "owner/app", &b)
+	out := b.String()
+	for _, want := range []string{"owner/app#1", "2 run(s)", "$0.35", "owner/app#2", "total", "3 run(s)", "$0.40"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("report missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "owner/lib") {
+		t.Errorf("report for owner/app includes other repos:\n%s", out)
+	}
+}
+
+func TestClaudeAgentUsage(t *testing.T) {
+	// A stand-in claude on PATH that checks its flags and prints a result record
+	bin := t.TempDir()
+	script := "#!/bin/sh\ncase \"$*\" in *'--output-format json'*) ;; *) echo 'missing --output-format' >&2; exit 2;; esac\n" +
+		"echo 'warming up' >&2\ncat <<'EOF'\n" + testClaudeResult + "\nEOF\n"
+	if err := os.WriteFile(filepath.Join(bin, "claude"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	var out bytes.Buffer
+	var u Usage
+	err := claudeAgent{}.Run(context.Background(), AgentRequest{Prompt: "fix it", Dir: t.TempDir(), Output: &out, Usage: &u})
+	if err != nil {
+		t.Fatalf("%v\n%s", err, out.String())
+	}
+	if u.Turns != 12 || u.SessionID != "5f0c9b1e" {
+		t.Errorf("usage = %+v", u)
+	}
+	if got := out.String(); !strings.Contains(got, "warming up") || !strings.Contains(got, "Fixed the login redirect.") || strings.Contains(got, "total_cost_usd") {
+		t.Errorf("output = %q", got)
+	}
+
+	// askAgent still gets just the reply
+	reply, err := askAgent(context.Background(), claudeAgent{}, "hello")
+	if err != nil || !strings.HasSuffix(reply, "Fixed the login redirect.") {
+		t.Errorf("reply = %q, %v", reply, err)
+	}
+}
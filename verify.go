@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// --- Verification ---
+// Before committing, the worker runs the repo's checks in the worktree: the
+// build, test and lint commands from .claude-bot.yml or, if it lists none, a
+// test command detected from the repo's files. Failures go back to the agent
+// with their output, up to CB_VERIFY_ROUNDS times; checks that still fail
+// fail the job like any other error, so nothing broken is pushed. The passing
+// run ends up in the PR body so reviewers can see what was checked.
+
+// maxVerifyOutput caps how much of each command's output (its end) is kept
+// for the agent; the PR body gets the last maxPROutput of that.
+const (
+	maxVerifyOutput = 8 << 10
+	maxPROutput     = 2 << 10
+)
+
+type verifyStep struct {
+	Cmd      string
+	Err      error // nil if the command passed
+	Output   string
+	Duration time.Duration
+}
+
+// verifyReport is the passing run, and how many agent repairs it took.
+type verifyReport struct {
+	Steps   []verifyStep
+	Repairs int
+}
+
+func failedSteps(steps []verifyStep) []verifyStep {
+	var out []verifyStep
+	for _, s := range steps {
+		if s.Err != nil {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// verifyCommands returns the checks to run in wtDir.
+func verifyCommands(settings repoSettings, wtDir string) []string {
+	if cmds := slices.Concat(settings.Build, settings.Test, settings.Lint); len(cmds) > 0 {
+		return cmds
+	}
+	if c := detectTestCommand(wtDir); c != "" {
+		return []string{c}
+	}
+	return nil
+}
+
+var taskfileTestTask = regexp.MustCompile(`(?m)^\s+test:`)
+
+// detectTestCommand guesses the repo's test command from its files. A
+// Taskfile test task goes first since it usually wraps the others. Commands
+// whose tool isn't installed are skipped.
+func detectTestCommand(wtDir string) string {
+	has := func(name string) bool {
+		_, err := os.Stat(filepath.Join(wtDir, name))
+		return err == nil
+	}
+	installed := func(tool string) bool {
+		_, err := exec.LookPath(tool)
+		return err == nil
+	}
+	for _, name := range []string{"Taskfile.yml", "Taskfile.yaml"} {
+		data, err := os.ReadFile(filepath.Join(wtDir, name))
+		if err == nil && taskfileTestTask.Match(data) && installed("task") {
+			return "task test"
+		}
+	}
+	switch {
+	case has("go.mod") && installed("go"):
+		return "go test ./..."
+	case has("Cargo.toml") && installed("cargo"):
+		return "cargo test"
+	case hasNPMTests(wtDir) && installed("npm"):
+		return "npm test"
+	}
+	return ""
+}
+
+// hasNPMTests reports whether package.json has a real test script; the one
+// npm init writes fails on purpose.
+func hasNPMTests(wtDir string) bool {
+	data, err := os.ReadFile(filepath.Join(wtDir, "package.json"))
+	if err != nil {
+		return false
+	}
+	var pkg struct {
+		Scripts map[string]string `json:"scripts"`
+	}
+	if json.Unmarshal(data, &pkg) != nil {
+		return false
+	}
+	test := pkg.Scripts["test"]
+	return test != "" && !strings.Contains(test, "no test specified")
+}
+
+// runChecks runs each command in the worktree, confined like the agent, and
+// copies the output to w. Together they get cfg.VerifyTimeout.
+func runChecks(ctx context.Context, cfg Config, cmds []string, wtDir string, w io.Writer) []verifyStep {
+	ctx, cancel := context.WithTimeout(ctx, cfg.VerifyTimeout)
+	defer cancel()
+	sb := cfg.Sandbox.forWorktree(wtDir)
+
+	steps := make([]verifyStep, 0, len(cmds))
+	for _, c := range cmds {
+		fmt.Fprintf(w, "$ %s\n", c)
+		var out bytes.Buffer
+		cmd := exec.CommandContext(ctx, "sh", "-c", c)
+		cmd.Dir = wtDir
+		cmd.Stdout = io.MultiWriter(w, &out)
+		cmd.Stderr = cmd.Stdout
+		cmd.WaitDelay = time.Second // don't wait on children left holding the output open
+		start := time.Now()
+		cleanup, err := sb.wrap(cmd, nil, nil)
+		if err == nil {
+			err = cmd.Run()
+			cleanup()
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %s", cfg.VerifyTimeout)
+		}
+		steps = append(steps, verifyStep{Cmd: c, Err: err, Output: tailLog(out.String(), maxVerifyOutput), Duration: time.Since(start)})
+	}
+	return steps
+}
+
+// verifyChanges runs the checks on the agent's changes in wtDir, letting the
+// agent repair failures up to cfg.VerifyRounds times. It returns nil if there
+// are no checks to run, and an error if they still fail.
+func verifyChanges(ctx context.Context, cfg Config, settings repoSettings, t *tracker, attemptID string, issue Issue, wtDir, logFile string) (*verifyReport, error) {
+	lg := logger(ctx)
+	cmds := verifyCommands(settings, wtDir)
+	if len(cmds) == 0 {
+		lg.Info("no checks to verify with")
+		return nil, nil
+	}
+
+	logBase := strings.TrimSuffix(logFile, ".log")
+	f, err := os.Create(logBase + "-verify.log")
+	if err != nil {
+		return nil, fmt.Errorf("creating verify log: %w", err)
+	}
+	defer f.Close()
+
+	report := &verifyReport{}
+	for {
+		lg.Info("verifying changes", "checks", strings.Join(cmds, "; "))
+		report.Steps = runChecks(ctx, cfg, cmds, wtDir, f)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		failed := failedSteps(report.Steps)
+		if len(failed) == 0 {
+			lg.Info("checks passed", "repairs", report.Repairs)
+			return report, nil
+		}
+		if report.Repairs >= cfg.VerifyRounds {
+			return nil, verifyError(failed, report.Repairs)
+		}
+		if why := overBudget(cfg, t, issue, time.Now()); why != "" {
+			return nil, fmt.Errorf("%w\nNot repairing: %s", verifyError(failed, report.Repairs), why)
+		}
+
+		report.Repairs++
+		lg.Info("checks failed, repairing", "failed", len(failed), "round", report.Repairs)
+		usage, err := runAgent(ctx, cfg, settings, issue, buildRepairPrompt(issue, failed), wtDir, fmt.Sprintf("%s-fix%d.log", logBase, report.Repairs))
+		if usage != nil {
+			_ = t.addUsage(attemptID, *usage)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("repairing failed checks: %w", err)
+		}
+	}
+}
+
+// verifyError describes the checks that failed, with the end of their output.
+func verifyError(failed []verifyStep, repairs int) error {
+	var b strings.Builder
+	b.WriteString("checks failed")
+	if repairs > 0 {
+		fmt.Fprintf(&b, " after %d repair round(s)", repairs)
+	}
+	for _, s := range failed {
+		fmt.Fprintf(&b, "\n$ %s: %v\n%s", s.Cmd, s.Err, tailLog(s.Output, 1<<10))
+	}
+	return errors.New(b.String())
+}
+
+// buildRepairPrompt asks the agent to fix the checks its changes broke.
+func buildRepairPrompt(issue Issue, failed []verifyStep) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "You are working on a codebase. Your changes for issue #%d fail the repository's checks. Fix them.\n\n", issue.Number)
+	fmt.Fprintf(&b, "## Issue #%d\n%s\n\n", issue.Number, untrusted(issue.Title, issue.Body))
+
+	b.WriteString("## Failing checks:\n")
+	for _, s := range failed {
+		fmt.Fprintf(&b, "### `%s` (%v)\n```\n%s\n```\n\n", s.Cmd, s.Err, s.Output)
+	}
+
+	b.WriteString(`## Instructions:
+- Read CLAUDE.md in the repo root for project-specific instructions
+- The working tree already has your changes for this issue; keep what they fix
+- Find the cause of each failure and fix it with minimal, focused changes
+- Don't disable, skip or loosen checks or tests to make them pass
+- Do NOT commit — just make the file changes
+` + untrustedNote + "\n")
+
+	return b.String()
+}
+
+// markdown renders the report for the PR body.
+func (r *verifyReport) markdown() string {
+	var b strings.Builder
+	b.WriteString("## Verification\n")
+	for _, s := range r.Steps {
+		fmt.Fprintf(&b, "- `%s` passed (%s)\n", s.Cmd, s.Duration.Round(time.Second))
+	}
+	if r.Repairs > 0 {
+		fmt.Fprintf(&b, "\nPassed after %d repair round(s).\n", r.Repairs)
+	}
+	for _, s := range r.Steps {
+		if strings.TrimSpace(s.Output) == "" {
+			continue
+		}
+		fmt.Fprintf(&b, "\n<details><summary><code>%s</code> output</summary>\n\n```\n%s\n```\n</details>\n",
+			html.EscapeString(s.Cmd), tailLog(s.Output, maxPROutput))
+	}
+	return b.String() + "\n"
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyCommands(t *testing.T) {
+	// Stand-ins, so detection doesn't depend on what this host has installed
+	bin := t.TempDir()
+	for _, tool := range []string{"go", "npm", "task"} {
+		os.WriteFile(filepath.Join(bin, tool), []byte("#!/bin/sh\n"), 0755)
+	}
+	t.Setenv("PATH", bin)
+
+	repo := func(files map[string]string) string {
+		dir := t.TempDir()
+		for name, content := range files {
+			os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		}
+		return dir
+	}
+	configured := repoSettings{Build: stringList{"make"}, Test: stringList{"make test"}, Lint: stringList{"make lint"}}
+	tests := []struct {
+		name     string
+		settings repoSettings
+		files    map[string]string
+		want     []string
+	}{
+		{"configured wins", configured, map[string]string{"go.mod": "module x"}, []string{"make", "make test", "make lint"}},
+		{"go", repoSettings{}, map[string]string{"go.mod": "module x"}, []string{"go test ./..."}},
+		{"taskfile first", repoSettings{}, map[string]string{"go.mod": "module x", "Taskfile.yml": "tasks:\n  build:\n    cmds: [go build]\n  test:\n    cmds: [go test]\n"}, []string{"task test"}},
+		{"taskfile without tests", repoSettings{}, map[string]string{"go.mod": "module x", "Taskfile.yml": "tasks:\n  build:\n    cmds: [go build]\n"}, []string{"go test ./..."}},
+		{"npm", repoSettings{}, map[string]string{"package.json": `{"scripts": {"test": "jest"}}`}, []string{"npm test"}},
+		{"npm placeholder", repoSettings{}, map[string]string{"package.json": `{"scripts": {"test": "echo \"Error: no test specified\" && exit 1"}}`}, nil},
+		{"cargo not installed", repoSettings{}, map[string]string{"Cargo.toml": "[package]"}, nil},
+	}
+	for _, tt := range tests {
+		if got := verifyCommands(tt.settings, repo(tt.files)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: verifyCommands = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestVerifyChanges(t *testing.T) {
+	ctx := context.Background()
+	issue := Issue{Repo: "owner/repo", Number: 9, Title: "Broken build"}
+	cfg := Config{AgentTimeout: time.Minute, VerifyTimeout: time.Minute, VerifyRounds: 2}
+	settings := repoSettings{Build: stringList{"echo building"}, Test: stringList{"test -f fixed || { echo 'TestThing: want fixed' >&2; exit 1; }"}}
+	verify := func(t *testing.T, cfg Config, settings repoSettings) (*verifyReport, string, error) {
+		t.Helper()
+		wtDir := t.TempDir()
+		logFile := filepath.Join(t.TempDir(), "owner-repo-9.log")
+		tr := newTracker()
+		a, _ := tr.begin(Attempt{Key: issue.key()})
+		report, err := verifyChanges(ctx, cfg, settings, tr, a.ID, issue, wtDir, logFile)
+		return report, logFile, err
+	}
+
+	t.Run("repaired", func(t *testing.T) {
+		cfg := cfg
+		// The agent only sees what failed, and fixes it
+		cfg.Agent = `shell:grep -q "TestThing: want fixed" && ! grep -q "echo building" && touch fixed`
+		report, logFile, err := verify(t, cfg, settings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Repairs != 1 || len(report.Steps) != 2 || len(failedSteps(report.Steps)) != 0 {
+			t.Fatalf("report = %+v", report)
+		}
+		md := report.markdown()
+		for _, want := range []string{"## Verification", "- `echo building` passed", "Passed after 1 repair round(s).", "building"} {
+			if !strings.Contains(md, want) {
+				t.Errorf("PR section missing %q:\n%s", want, md)
+			}
+		}
+		for _, suffix := range []string{"-verify.log", "-fix1.log"} {
+			if _, err := os.Stat(strings.TrimSuffix(logFile, ".log") + suffix); err != nil {
+				t.Errorf("log: %v", err)
+			}
+		}
+	})
+
+	t.Run("still failing", func(t *testing.T) {
+		cfg := cfg
+		cfg.VerifyRounds = 1
+		cfg.Agent = `shell:true`
+		_, _, err := verify(t, cfg, settings)
+		if err == nil || !strings.Contains(err.Error(), "checks failed after 1 repair round(s)") || !strings.Contains(err.Error(), "TestThing: want fixed") {
+			t.Errorf("err = %v", err)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		cfg := cfg
+		cfg.VerifyRounds = 0
+		cfg.VerifyTimeout = 50 * time.Millisecond
+		_, _, err := verify(t, cfg, repoSettings{Test: stringList{"sleep 5"}})
+		if err == nil || !strings.Contains(err.Error(), "timed out after 50ms") {
+			t.Errorf("err = %v", err)
+		}
+	})
+
+	t.Run("nothing to run", func(t *testing.T) {
+		t.Setenv("PATH", t.TempDir())
+		tr := newTracker()
+		report, err := verifyChanges(ctx, cfg, repoSettings{}, tr, "", issue, t.TempDir(), filepath.Join(t.TempDir(), "x.log"))
+		if report != nil || err != nil {
+			t.Errorf("verifyChanges = %+v, %v", report, err)
+		}
+	})
+}
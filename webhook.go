@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// --- Webhooks ---
+// With CB_HTTP_ADDR and CB_WEBHOOK_SECRET set, GitHub deliveries to /webhook
+// trigger an immediate poll of the affected repo, so labeling an issue `todo`
+// starts work within seconds. The regular poll drops to CB_RECONCILE_INTERVAL
+// and only acts as a safety net for missed deliveries.
+
+// maxWebhookBody caps delivery size. GitHub payloads are capped at 25MB.
+const maxWebhookBody = 25 << 20
+
+type webhookPayload struct {
+	Action     string `json:"action"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+	Label struct {
+		Name string `json:"name"`
+	} `json:"label"`
+	Comment struct {
+		Body string `json:"body"`
+	} `json:"comment"`
+}
+
+// webhookHandler verifies X-Hub-Signature-256 and turns relevant deliveries into
+// repo poll triggers. Sends never block: a full trigger queue means a poll is
+// already pending, and the reconciliation poll covers anything dropped.
+func webhookHandler(cfg Config, triggers chan<- string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+		if err != nil {
+			http.Error(w, "reading body", http.StatusBadRequest)
+			return
+		}
+		if !validSignature(cfg.WebhookSecret, body, r.Header.Get("X-Hub-Signature-256")) {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		event := r.Header.Get("X-GitHub-Event")
+		if event == "ping" {
+			w.Write([]byte("pong"))
+			return
+		}
+
+		var p webhookPayload
+		if err := json.Unmarshal(body, &p); err != nil {
+			http.Error(w, "invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		repo := matchRepo(cfg.Repos, p.Repository.FullName)
+		if repo == "" || !webhookRelevant(cfg.forRepo(repo), event, p) {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+
+		select {
+		case triggers <- repo:
+			slog.Info("relevant delivery, polling now", "component", "webhook", "event", event, "action", p.Action, "repo", repo)
+		default:
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// validSignature checks a "sha256=<hex>" HMAC of body against secret.
+func validSignature(secret string, body []byte, header string) bool {
+	sig, ok := strings.CutPrefix(header, "sha256=")
+	if !ok || secret == "" {
+		return false
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// matchRepo maps a delivery's owner/repo to its CB_REPOS entry.
+// Only github.com repos receive webhooks; returns "" if the repo isn't watched.
+func matchRepo(repos []string, fullName string) string {
+	for _, repo := range repos {
+		r := parseRepo(repo)
+		if r.Kind == "github" && r.Host == "github.com" && strings.EqualFold(r.Path, fullName) {
+			return repo
+		}
+	}
+	return ""
+}
+
+// webhookRelevant filters out deliveries that can't change what a poll would do,
+// such as most of the label changes the bot makes itself.
+func webhookRelevant(cfg Config, event string, p webhookPayload) bool {
+	switch event {
+	case "issues":
+		switch p.Action {
+		case "labeled":
+			return p.Label.Name == cfg.IssueLabel
+		case "opened", "reopened":
+			return cfg.Triage
+		}
+	case "discussion":
+		return p.Action == "created" && cfg.TriageDiscussions
+	case "pull_request_review_comment":
+		return p.Action == "created" && cfg.Reviews && !strings.Contains(p.Comment.Body, botCommentMarker)
+	case "pull_request_review":
+		return p.Action == "submitted" && cfg.Reviews
+	case "issue_comment":
+		// PR conversation comments arrive as issue comments; only mentions and commands matter
+		if p.Action != "created" || strings.Contains(p.Comment.Body, botCommentMarker) {
+			return false
+		}
+		// Approvals can be for a plan on any issue (see plan.go)
+		cmds := parseCommands(p.Comment.Body)
+		return cfg.Reviews && strings.Contains(p.Comment.Body, reviewMention) ||
+			len(cfg.CommandUsers) > 0 && len(cmds) > 0 ||
+			slices.Contains(cmds, botCommand{Verb: "approve"})
+	case "pull_request":
+		// Acknowledged so one webhook can subscribe to all bot-related events
+		return false
+	}
+	return false
+}
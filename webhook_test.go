@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func signBody(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestValidSignature(t *testing.T) {
+	body := []byte(`{"action":"labeled"}`)
+	if !validSignature("s3cret", body, signBody("s3cret", string(body))) {
+		t.Error("correct signature rejected")
+	}
+	if validSignature("s3cret", body, signBody("other", string(body))) {
+		t.Error("wrong secret accepted")
+	}
+	if validSignature("s3cret", body, "sha1=abc") {
+		t.Error("non-sha256 header accepted")
+	}
+	if validSignature("", body, signBody("", string(body))) {
+		t.Error("empty secret must never validate")
+	}
+}
+
+func TestWebhookHandler(t *testing.T) {
+	cfg := Config{
+		Repos:         []string{"Owner/Repo", "gitea:git.example.com/owner/other"},
+		IssueLabel:    "todo",
+		WebhookSecret: "s3cret",
+	}
+	triggers := make(chan string, 4)
+	h := webhookHandler(cfg, triggers)
+
+	deliver := func(event, body, sig string) int {
+		req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body))
+		req.Header.Set("X-GitHub-Event", event)
+		req.Header.Set("X-Hub-Signature-256", sig)
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		return rec.Code
+	}
+
+	labeled := `{"action":"labeled","label":{"name":"todo"},"repository":{"full_name":"owner/repo"}}`
+	if code := deliver("issues", labeled, signBody("s3cret", labeled)); code != http.StatusAccepted {
+		t.Fatalf("status = %d", code)
+	}
+	select {
+	case repo := <-triggers:
+		if repo != "Owner/Repo" {
+			t.Errorf("triggered %q", repo)
+		}
+	default:
+		t.Fatal("todo label should trigger a poll")
+	}
+
+	if code := deliver("issues", labeled, "sha256=00"); code != http.StatusUnauthorized {
+		t.Errorf("bad signature status = %d", code)
+	}
+
+	// The bot's own label changes and unwatched repos must not trigger polls
+	wip := `{"action":"labeled","label":{"name":"in-progress"},"repository":{"full_name":"owner/repo"}}`
+	unwatched := `{"action":"labeled","label":{"name":"todo"},"repository":{"full_name":"someone/else"}}`
+	deliver("issues", wip, signBody("s3cret", wip))
+	deliver("issues", unwatched, signBody("s3cret", unwatched))
+	if len(triggers) != 0 {
+		t.Errorf("unexpected trigger: %q", <-triggers)
+	}
+}
+
+func TestWebhookRelevantReviews(t *testing.T) {
+	cfg := Config{IssueLabel: "todo", Reviews: true, CommandUsers: []string{"alice"}}
+	payload := func(action, comment string) webhookPayload {
+		var p webhookPayload
+		p.Action, p.Comment.Body = action, comment
+		return p
+	}
+	tests := []struct {
+		event string
+		p     webhookPayload
+		want  bool
+	}{
+		{"pull_request_review", payload("submitted", ""), true},
+		{"pull_request_review", payload("dismissed", ""), false},
+		{"pull_request_review_comment", payload("created", "nit: rename"), true},
+		{"pull_request_review_comment", payload("created", "Addressed in abc.\n"+botCommentMarker), false},
+		{"issue_comment", payload("created", "@claude-bot add a test"), true},
+		{"issue_comment", payload("created", "thanks!"), false},
+		{"issue_comment", payload("created", "/bot retry"), true},
+		{"issue_comment", payload("created", "Reply `/bot retry` to go on.\n/bot retry\n"+botCommentMarker), false},
+		{"pull_request", payload("opened", ""), false},
+	}
+	for _, tt := range tests {
+		if got := webhookRelevant(cfg, tt.event, tt.p); got != tt.want {
+			t.Errorf("%s/%s %q = %v, want %v", tt.event, tt.p.Action, tt.p.Comment.Body, got, tt.want)
+		}
+	}
+	cfg.Reviews = false
+	if webhookRelevant(cfg, "pull_request_review", payload("submitted", "")) {
+		t.Error("reviews are off")
+	}
+	// Approvals matter to the policy and to plans even with commands off
+	cfg.CommandUsers = nil
+	if webhookRelevant(cfg, "issue_comment", payload("created", "/bot retry")) {
+		t.Error("commands are off")
+	}
+	if !webhookRelevant(cfg, "issue_comment", payload("created", "looks right\n/bot approve")) {
+		t.Error("an approval should trigger a poll")
+	}
+}